@@ -3,13 +3,79 @@ package badgesmodel
 const (
 	NameMaxLength        = 20
 	DescriptionMaxLength = 120
+	TagMaxLength         = 20
+	MaxTags              = 10
+	MaxCoOwners          = 10
+	DefaultPoints        = 1
+	MaxPoints            = 1000
+
+	FirstVersion = 1
 
 	ImageTypeEmoji       ImageType = "emoji"
 	ImageTypeRelativeURL ImageType = "rel_url"
 	ImageTypeAbsoluteURL ImageType = "abs_url"
+	// ImageTypeUpload badges show an image uploaded to the plugin. Image is the
+	// ID of the uploaded image, and the plugin serves it at /images/{badgeID}.
+	ImageTypeUpload ImageType = "upload"
+
+	FrameShapeCircle  = "circle"
+	FrameShapeSquare  = "square"
+	FrameShapeRounded = "rounded"
+	FrameShapeHexagon = "hexagon"
+	// FrameImagePrefix starts the frames made of an uploaded image, followed
+	// by the image ID.
+	FrameImagePrefix = "image:"
 
 	PluginPath          = "/com.mattermost.badges"
 	PluginAPIPath       = "/papi/v1"
 	PluginAPIPathEnsure = "/ensure"
 	PluginAPIPathGrant  = "/grant"
+	PluginAPIPathRevoke = "/revoke"
+	PluginAPIPathRenew  = "/renew"
+)
+
+const (
+	AuditActionCreateType         AuditAction = "create_type"
+	AuditActionUpdateType         AuditAction = "update_type"
+	AuditActionDeleteType         AuditAction = "delete_type"
+	AuditActionCreateBadge        AuditAction = "create_badge"
+	AuditActionUpdateBadge        AuditAction = "update_badge"
+	AuditActionDeleteBadge        AuditAction = "delete_badge"
+	AuditActionGrant              AuditAction = "grant"
+	AuditActionRevoke             AuditAction = "revoke"
+	AuditActionRenew              AuditAction = "renew"
+	AuditActionExpire             AuditAction = "expire"
+	AuditActionAddSubscription    AuditAction = "add_subscription"
+	AuditActionRemoveSubscription AuditAction = "remove_subscription"
+	AuditActionRestore            AuditAction = "restore"
+)
+
+// Actions checked by the permissions explain endpoint. Create checks creating
+// badges of the type of the badge, and edit type managing that type.
+const (
+	PermissionActionGrant    = "grant"
+	PermissionActionRevoke   = "revoke"
+	PermissionActionCreate   = "create"
+	PermissionActionEdit     = "edit"
+	PermissionActionEditType = "edit_type"
+)
+
+// Rules that decide permission checks, in PermissionExplanation.
+const (
+	PermissionRuleArchived      = "archived"
+	PermissionRuleSystemAdmin   = "system_admin"
+	PermissionRuleBadgeAdmin    = "badge_admin"
+	PermissionRuleTeamAdmin     = "team_admin"
+	PermissionRuleTypeCreator   = "type_creator"
+	PermissionRuleBadgeCreator  = "badge_creator"
+	PermissionRuleBadgeCoOwner  = "badge_co_owner"
+	PermissionRuleBlockList     = "block_list"
+	PermissionRuleBlockGroup    = "block_group"
+	PermissionRuleGroupsUnknown = "groups_unknown"
+	PermissionRuleRole          = "role"
+	PermissionRuleAllowList     = "allow_list"
+	PermissionRuleAllowGroup    = "allow_group"
+	PermissionRuleEveryone      = "everyone"
+	PermissionRuleOutOfScope    = "out_of_scope"
+	PermissionRuleNotAllowed    = "not_allowed"
 )
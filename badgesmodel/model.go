@@ -1,6 +1,7 @@
 package badgesmodel
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -8,11 +9,17 @@ type BadgeType string
 type BadgeID string
 
 type Ownership struct {
+	GrantID   string    `json:"grant_id"`
 	User      string    `json:"user"`
 	GrantedBy string    `json:"granted_by"`
 	Badge     BadgeID   `json:"badge"`
 	Reason    string    `json:"reason"`
 	Time      time.Time `json:"time"`
+	// BadgeVersion is the version of the badge when it was granted. It is 0 on
+	// grants made before versions were recorded.
+	BadgeVersion int `json:"badge_version,omitempty"`
+	// ExpiresAt is only set on grants of badges with a validity.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type OwnershipList []Ownership
@@ -26,6 +33,20 @@ type Badge struct {
 	Multiple    bool      `json:"multiple"`
 	Type        BadgeType `json:"type"`
 	CreatedBy   string    `json:"created_by"`
+	Archived    bool      `json:"archived"`
+	Version     int       `json:"version"`
+	UpdatedBy   string    `json:"updated_by"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	// ValidityDays is how long grants of the badge last. Zero means forever.
+	ValidityDays int `json:"validity_days,omitempty"`
+	// Tags group badges across types, for search. They are lower case.
+	Tags []string `json:"tags,omitempty"`
+	// Points is what each grant of the badge is worth on leaderboards. Nil
+	// means the default points of its type.
+	Points *int `json:"points,omitempty"`
+	// CoOwners are the IDs of the users who can edit, grant and revoke the
+	// badge as its creator does.
+	CoOwners []string `json:"co_owners,omitempty"`
 }
 
 type UserBadge struct {
@@ -50,19 +71,48 @@ type AllBadgesBadge struct {
 }
 
 type BadgeTypeDefinition struct {
-	ID        BadgeType        `json:"id"`
-	Name      string           `json:"name"`
+	ID   BadgeType `json:"id"`
+	Name string    `json:"name"`
+	// Frame is drawn around the images of its badges. It is either a shape and
+	// a colour, like "circle:#1e88e5", or FrameImagePrefix and the ID of an
+	// uploaded image. Empty means no frame.
 	Frame     string           `json:"frame"`
 	CreatedBy string           `json:"created_by"`
 	CanGrant  PermissionScheme `json:"can_grant"`
 	CanCreate PermissionScheme `json:"can_create"`
+	// CanEdit tells who, besides badge admins, can edit the type and every
+	// badge of it.
+	CanEdit   PermissionScheme `json:"can_edit"`
+	Archived  bool             `json:"archived"`
+	Version   int              `json:"version"`
+	UpdatedBy string           `json:"updated_by"`
+	UpdatedAt time.Time        `json:"updated_at"`
+	// DefaultPoints is what its badges are worth when they set no points of
+	// their own, and DefaultPoints of the package when nil.
+	DefaultPoints *int `json:"default_points,omitempty"`
+	// Announceable lets channel and team admins subscribe their channels to the
+	// grants of its badges.
+	Announceable bool `json:"announceable,omitempty"`
+	// TeamID scopes the type to a team: only its members can create, grant and
+	// receive its badges, and the team admins manage it. ChannelID narrows the
+	// scope to the members of a channel, of TeamID unless it is a direct or
+	// group message.
+	TeamID    string `json:"team_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
 }
 
+// PermissionScheme tells who can create, grant or edit the badges of a type. Roles
+// are system roles, or team and channel roles on the team and channel of the
+// type scope. AllowList and BlockList hold user IDs, and AllowGroups and
+// BlockGroups the IDs of user groups. Blocked users and group members are
+// denied even if allowed otherwise.
 type PermissionScheme struct {
-	Everyone  bool            `json:"everyone"`
-	Roles     map[string]bool `json:"roles"`
-	AllowList map[string]bool `json:"allow_list"`
-	BlockList map[string]bool `json:"block_list"`
+	Everyone    bool            `json:"everyone"`
+	Roles       map[string]bool `json:"roles"`
+	AllowList   map[string]bool `json:"allow_list"`
+	BlockList   map[string]bool `json:"block_list"`
+	AllowGroups map[string]bool `json:"allow_groups,omitempty"`
+	BlockGroups map[string]bool `json:"block_groups,omitempty"`
 }
 
 type BadgeTypeList []*BadgeTypeDefinition
@@ -81,6 +131,59 @@ type GrantBadgeRequest struct {
 	Reason  string
 }
 
+type RenewBadgeRequest struct {
+	BadgeID BadgeID
+	UserID  string
+	BotID   string
+}
+
+type RevokeBadgeRequest struct {
+	BadgeID BadgeID
+	UserID  string
+	BotID   string
+	Reason  string
+	All     bool
+	Notify  bool
+}
+
+type AuditAction string
+
+// AuditEntry records a change to the badges database. TargetID is the ID of the
+// type or badge changed, and UserID the user a badge was granted to or revoked
+// from. Before and After hold the changed object, when there is one.
+type AuditEntry struct {
+	ID       string          `json:"id"`
+	Time     time.Time       `json:"time"`
+	ActorID  string          `json:"actor_id"`
+	Action   AuditAction     `json:"action"`
+	TargetID string          `json:"target_id"`
+	UserID   string          `json:"user_id,omitempty"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+}
+
+// LeaderboardEntry is the score of a user on a leaderboard. Badges counts the
+// grants the points come from, and users with the same points share the rank.
+type LeaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Points   int    `json:"points"`
+	Badges   int    `json:"badges"`
+}
+
+// PermissionExplanation tells whether a user can take an action on a badge,
+// and the rule that decided it.
+type PermissionExplanation struct {
+	UserID  string    `json:"user_id"`
+	BadgeID BadgeID   `json:"badge_id"`
+	TypeID  BadgeType `json:"type_id"`
+	Action  string    `json:"action"`
+	Allowed bool      `json:"allowed"`
+	Rule    string    `json:"rule"`
+	Reason  string    `json:"reason"`
+}
+
 type Subscription struct {
 	TypeID    BadgeType
 	ChannelID string
@@ -89,7 +192,55 @@ type Subscription struct {
 func (b Badge) IsValid() bool {
 	return len(b.Name) <= NameMaxLength &&
 		len(b.Description) <= DescriptionMaxLength &&
-		b.Image != ""
+		b.Image != "" &&
+		len(b.Tags) <= MaxTags &&
+		len(b.CoOwners) <= MaxCoOwners &&
+		(b.Points == nil || (*b.Points >= 0 && *b.Points <= MaxPoints))
+}
+
+// GetVersion returns the version of the badge. Badges not edited since versions
+// are recorded are on the first one.
+func (b Badge) GetVersion() int {
+	if b.Version == 0 {
+		return FirstVersion
+	}
+	return b.Version
+}
+
+// GetVersion returns the version of the type. Types not edited since versions
+// are recorded are on the first one.
+func (t BadgeTypeDefinition) GetVersion() int {
+	if t.Version == 0 {
+		return FirstVersion
+	}
+	return t.Version
+}
+
+// GetPoints returns what each grant of the badge is worth. t is the type of the
+// badge, if known.
+func (b Badge) GetPoints(t *BadgeTypeDefinition) int {
+	if b.Points != nil {
+		return *b.Points
+	}
+	if t != nil && t.DefaultPoints != nil {
+		return *t.DefaultPoints
+	}
+	return DefaultPoints
+}
+
+// IsExpired tells whether the grant had a validity that is over at now.
+func (o Ownership) IsExpired(now time.Time) bool {
+	return o.ExpiresAt != nil && !o.ExpiresAt.After(now)
+}
+
+// GetExpiry returns when a grant of the badge made at grantTime expires, or nil
+// if the badge has no validity.
+func (b Badge) GetExpiry(grantTime time.Time) *time.Time {
+	if b.ValidityDays <= 0 {
+		return nil
+	}
+	expiry := grantTime.AddDate(0, 0, b.ValidityDays)
+	return &expiry
 }
 
 func (l OwnershipList) IsOwned(user string, badge BadgeID) bool {
@@ -101,6 +252,29 @@ func (l OwnershipList) IsOwned(user string, badge BadgeID) bool {
 	return false
 }
 
+// IsSameGrant tells whether both ownerships come from the same grant, even if
+// one of them was renewed since. Grants made before grant IDs existed are
+// compared by their fields.
+func (o Ownership) IsSameGrant(other Ownership) bool {
+	if o.GrantID != "" && other.GrantID != "" {
+		return o.GrantID == other.GrantID
+	}
+
+	return o.User == other.User &&
+		o.Badge == other.Badge &&
+		o.GrantedBy == other.GrantedBy &&
+		o.Time.Equal(other.Time)
+}
+
+func (l OwnershipList) Contains(o Ownership) bool {
+	for _, ownership := range l {
+		if ownership.IsSameGrant(o) {
+			return true
+		}
+	}
+	return false
+}
+
 func (l BadgeTypeList) GetType(id BadgeType) *BadgeTypeDefinition {
 	for _, t := range l {
 		if t.ID == id {
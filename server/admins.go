@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// badgeAdminSet tells who the badge admins are: every system admin, and the
+// users and the members of the groups listed on the configuration. The groups
+// of each user are looked up when needed, and kept for a short while, as
+// permission checks run once per badge. When teamAdmins is set, team admins are
+// also badge admins, but only of the types scoped to their team.
+type badgeAdminSet struct {
+	api        plugin.API
+	userIDs    map[string]bool
+	groupIDs   map[string]bool
+	teamAdmins bool
+
+	mu      sync.Mutex
+	checked map[string]badgeAdminCheck
+}
+
+type badgeAdminCheck struct {
+	isAdmin bool
+	at      time.Time
+}
+
+// splitAdminEntries splits a configuration list on commas, spaces and new lines.
+func splitAdminEntries(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// newBadgeAdminSet resolves the users and groups listed on the configuration.
+// Entries that cannot be resolved are logged and skipped, so a typo or a renamed
+// user does not break the plugin.
+func newBadgeAdminSet(api plugin.API, c *configuration) *badgeAdminSet {
+	a := &badgeAdminSet{
+		api:        api,
+		userIDs:    map[string]bool{},
+		groupIDs:   map[string]bool{},
+		teamAdmins: c.TeamAdminsAreBadgesAdmins,
+		checked:    map[string]badgeAdminCheck{},
+	}
+
+	for _, entry := range splitAdminEntries(c.BadgesAdmin) {
+		u, err := getUserByIDOrUsername(api, entry)
+		if err != nil {
+			api.LogWarn("Cannot find badges admin user, skipping it", "user", entry, "err", err.Error())
+			continue
+		}
+		a.userIDs[u.Id] = true
+	}
+
+	for _, entry := range splitAdminEntries(c.BadgesAdminGroups) {
+		g, err := getGroupByIDOrName(api, entry)
+		if err != nil {
+			api.LogWarn("Cannot find badges admin group, skipping it", "group", entry, "err", err.Error())
+			continue
+		}
+		a.groupIDs[g.Id] = true
+	}
+
+	return a
+}
+
+func getUserByIDOrUsername(api plugin.API, entry string) (*model.User, *model.AppError) {
+	entry = strings.TrimPrefix(entry, "@")
+	if model.IsValidId(entry) {
+		if u, err := api.GetUser(entry); err == nil {
+			return u, nil
+		}
+	}
+	return api.GetUserByUsername(entry)
+}
+
+func getGroupByIDOrName(api plugin.API, entry string) (*model.Group, *model.AppError) {
+	entry = strings.TrimPrefix(entry, "@")
+	if model.IsValidId(entry) {
+		if g, err := api.GetGroup(entry); err == nil {
+			return g, nil
+		}
+	}
+	return api.GetGroupByName(entry)
+}
+
+// isAdmin tells whether the user is a badge admin. A nil set holds the system
+// admins only.
+func (a *badgeAdminSet) isAdmin(user *model.User) bool {
+	if user.IsSystemAdmin() {
+		return true
+	}
+	if a == nil {
+		return false
+	}
+	if a.userIDs[user.Id] {
+		return true
+	}
+	if len(a.groupIDs) == 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	check, ok := a.checked[user.Id]
+	a.mu.Unlock()
+	if ok && time.Since(check.at) < BadgesAdminCheckTTL {
+		return check.isAdmin
+	}
+
+	isAdmin := a.isGroupMember(user.Id)
+
+	a.mu.Lock()
+	a.checked[user.Id] = badgeAdminCheck{isAdmin: isAdmin, at: time.Now()}
+	a.mu.Unlock()
+
+	return isAdmin
+}
+
+// isTeamAdmin tells whether the user is a badge admin of the types scoped to
+// the team, for being one of its admins.
+func (a *badgeAdminSet) isTeamAdmin(user *model.User, teamID string) bool {
+	if a == nil || !a.teamAdmins || teamID == "" {
+		return false
+	}
+	return a.api.HasPermissionToTeam(user.Id, teamID, model.PERMISSION_MANAGE_TEAM)
+}
+
+func (a *badgeAdminSet) isGroupMember(userID string) bool {
+	if len(a.groupIDs) == 0 {
+		return false
+	}
+
+	groups, err := a.api.GetGroupsForUser(userID)
+	if err != nil {
+		a.api.LogWarn("Cannot get the groups of the user", "userID", userID, "err", err.Error())
+		return false
+	}
+	for _, g := range groups {
+		if a.groupIDs[g.Id] {
+			return true
+		}
+	}
+	return false
+}
+
+// getBadgeAdmins returns the badge admins of the active configuration.
+func (p *Plugin) getBadgeAdmins() *badgeAdminSet {
+	return p.getConfiguration().badgeAdmins
+}
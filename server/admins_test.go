@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBadgeAdmins(t *testing.T) {
+	p := setupTestPlugin(newMemStore())
+	api := p.API.(*fakeAPI)
+
+	byName := &model.User{Id: model.NewId(), Username: "byname"}
+	byID := &model.User{Id: model.NewId(), Username: "byid"}
+	grouped := &model.User{Id: model.NewId(), Username: "grouped"}
+	teamAdmin := &model.User{Id: model.NewId(), Username: "teamadmin"}
+	user := &model.User{Id: model.NewId(), Username: "user"}
+	sysadmin := &model.User{Id: model.NewId(), Username: "sysadmin", Roles: model.SYSTEM_ADMIN_ROLE_ID}
+	group := &model.Group{Id: model.NewId(), Name: model.NewString("leads")}
+	api.addUser(byID)
+
+	notFound := model.NewAppError("test", "not_found", nil, "", http.StatusNotFound)
+	api.On("GetUserByUsername", "byname").Return(byName, nil)
+	api.On("GetUserByUsername", mock.Anything).Return(nil, notFound)
+	api.On("GetGroupByName", "leads").Return(group, nil)
+	api.On("GetGroupByName", mock.Anything).Return(nil, notFound)
+	api.On("GetGroupsForUser", grouped.Id).Return([]*model.Group{group}, nil)
+	api.On("GetGroupsForUser", mock.Anything).Return([]*model.Group{}, nil)
+	teamID := model.NewId()
+	api.On("HasPermissionToTeam", teamAdmin.Id, teamID, model.PERMISSION_MANAGE_TEAM).Return(true)
+	api.On("HasPermissionToTeam", mock.Anything, mock.Anything, mock.Anything).Return(false)
+
+	t.Run("system admins only without a configuration", func(t *testing.T) {
+		var admins *badgeAdminSet
+		assert.True(t, admins.isAdmin(sysadmin))
+		assert.False(t, admins.isAdmin(byName))
+	})
+
+	t.Run("users and groups", func(t *testing.T) {
+		admins := newBadgeAdminSet(api, &configuration{
+			BadgesAdmin:       "@byname, " + byID.Id + ", typo",
+			BadgesAdminGroups: "leads missing",
+		})
+		assert.Len(t, admins.userIDs, 2)
+		assert.Len(t, admins.groupIDs, 1)
+
+		for _, u := range []*model.User{sysadmin, byName, byID, grouped} {
+			assert.True(t, admins.isAdmin(u), u.Username)
+		}
+		assert.False(t, admins.isAdmin(user))
+	})
+
+	t.Run("team admins are not badge admins", func(t *testing.T) {
+		admins := newBadgeAdminSet(api, &configuration{BadgesAdminGroups: "leads"})
+		assert.False(t, admins.isAdmin(teamAdmin))
+		assert.False(t, admins.isTeamAdmin(teamAdmin, teamID))
+		assert.True(t, admins.isAdmin(grouped))
+	})
+
+	t.Run("team admins are badge admins of their team when enabled", func(t *testing.T) {
+		admins := newBadgeAdminSet(api, &configuration{TeamAdminsAreBadgesAdmins: true})
+		assert.False(t, admins.isAdmin(teamAdmin), "never of every type")
+		assert.True(t, admins.isTeamAdmin(teamAdmin, teamID))
+		assert.False(t, admins.isTeamAdmin(teamAdmin, model.NewId()))
+		assert.False(t, admins.isTeamAdmin(teamAdmin, ""))
+		assert.False(t, admins.isTeamAdmin(user, teamID))
+
+		teamType := &badgesmodel.BadgeTypeDefinition{TeamID: teamID}
+		badge := &badgesmodel.Badge{}
+		decision := decideGrantBadge(teamAdmin, admins, &typeMembership{}, badge, teamType)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, badgesmodel.PermissionRuleTeamAdmin, decision.Rule)
+		assert.False(t, decideGrantBadge(teamAdmin, admins, &typeMembership{}, badge, &badgesmodel.BadgeTypeDefinition{}).Allowed)
+		assert.False(t, decideRevokeBadge(teamAdmin, admins, badge, &badgesmodel.BadgeTypeDefinition{TeamID: model.NewId()}).Allowed)
+	})
+
+	t.Run("unknown entries do not fail the configuration", func(t *testing.T) {
+		api.On("LoadPluginConfiguration", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+			args.Get(0).(*configuration).BadgesAdmin = "typo,byname"
+		})
+		require.NoError(t, p.OnConfigurationChange())
+		assert.True(t, p.getBadgeAdmins().isAdmin(byName))
+		assert.True(t, canEditType(byName, p.getBadgeAdmins(), &typeMembership{}, &badgesmodel.BadgeTypeDefinition{}))
+		assert.False(t, canEditType(user, p.getBadgeAdmins(), &typeMembership{}, &badgesmodel.BadgeTypeDefinition{}))
+	})
+}
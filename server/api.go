@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
@@ -37,6 +39,9 @@ func (p *Plugin) initializeAPI() {
 	p.router = mux.NewRouter()
 	p.router.Use(p.withRecovery)
 
+	p.router.HandleFunc(ImagePath+"/{badgeID}", p.extractUserMiddleWare(p.getBadgeImage, ResponseTypePlain)).Methods(http.MethodGet)
+	p.router.HandleFunc(ImagePath+"/{badgeID}/framed", p.extractUserMiddleWare(p.getFramedBadgeImage, ResponseTypePlain)).Methods(http.MethodGet)
+
 	apiRouter := p.router.PathPrefix("/api/v1").Subrouter()
 	pluginAPIRouter := p.router.PathPrefix(badgesmodel.PluginAPIPath).Subrouter()
 	autocompleteRouter := p.router.PathPrefix(AutocompletePath).Subrouter()
@@ -45,18 +50,31 @@ func (p *Plugin) initializeAPI() {
 	apiRouter.HandleFunc("/getUserBadges/{userID}", p.extractUserMiddleWare(p.getUserBadges, ResponseTypeJSON)).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/getBadgeDetails/{badgeID}", p.extractUserMiddleWare(p.getBadgeDetails, ResponseTypeJSON)).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/getAllBadges", p.extractUserMiddleWare(p.getAllBadges, ResponseTypeJSON)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/badges/{badgeID}/history", p.extractUserMiddleWare(p.getBadgeHistory, ResponseTypeJSON)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/badges/{badgeID}/rollback", p.extractUserMiddleWare(p.rollbackBadgeVersion, ResponseTypeJSON)).Methods(http.MethodPost)
+	apiRouter.HandleFunc("/grants/{grantID}", p.extractUserMiddleWare(p.getGrant, ResponseTypeJSON)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/audit", p.extractUserMiddleWare(p.getAuditLog, ResponseTypeJSON)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/search", p.extractUserMiddleWare(p.searchBadges, ResponseTypeJSON)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/leaderboard", p.extractUserMiddleWare(p.getLeaderboard, ResponseTypeJSON)).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/permissions/explain", p.extractUserMiddleWare(p.explainPermission, ResponseTypeJSON)).Methods(http.MethodGet)
 
 	pluginAPIRouter.HandleFunc(badgesmodel.PluginAPIPathEnsure, checkPluginRequest(p.ensureBadges)).Methods(http.MethodPost)
 	pluginAPIRouter.HandleFunc(badgesmodel.PluginAPIPathGrant, checkPluginRequest(p.grantBadge)).Methods(http.MethodPost)
+	pluginAPIRouter.HandleFunc(badgesmodel.PluginAPIPathRevoke, checkPluginRequest(p.revokeBadge)).Methods(http.MethodPost)
+	pluginAPIRouter.HandleFunc(badgesmodel.PluginAPIPathRenew, checkPluginRequest(p.renewBadge)).Methods(http.MethodPost)
 
 	autocompleteRouter.HandleFunc(AutocompletePathBadgeSuggestions, p.extractUserMiddleWare(p.getBadgeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
 	autocompleteRouter.HandleFunc(AutocompletePathEditBadgeSuggestions, p.extractUserMiddleWare(p.getEditBadgeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
 	autocompleteRouter.HandleFunc(AutocompletePathTypeSuggestions, p.extractUserMiddleWare(p.getBadgeTypeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
 	autocompleteRouter.HandleFunc(AutocompletePathEditTypeSuggestions, p.extractUserMiddleWare(p.getEditBadgeTypeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
+	autocompleteRouter.HandleFunc(AutocompletePathRevokeSuggestions, p.extractUserMiddleWare(p.getRevokeBadgeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
+	autocompleteRouter.HandleFunc(AutocompletePathArchivedBadgeSuggestions, p.extractUserMiddleWare(p.getArchivedBadgeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
+	autocompleteRouter.HandleFunc(AutocompletePathArchivedTypeSuggestions, p.extractUserMiddleWare(p.getArchivedTypeSuggestions, ResponseTypeJSON)).Methods(http.MethodGet)
 
 	dialogRouter.HandleFunc(DialogPathCreateBadge, p.extractUserMiddleWare(p.dialogCreateBadge, ResponseTypeDialog)).Methods(http.MethodPost)
 	dialogRouter.HandleFunc(DialogPathCreateType, p.extractUserMiddleWare(p.dialogCreateType, ResponseTypeDialog)).Methods(http.MethodPost)
 	dialogRouter.HandleFunc(DialogPathGrant, p.extractUserMiddleWare(p.dialogGrant, ResponseTypeDialog)).Methods(http.MethodPost)
+	dialogRouter.HandleFunc(DialogPathRevoke, p.extractUserMiddleWare(p.dialogRevoke, ResponseTypeDialog)).Methods(http.MethodPost)
 	dialogRouter.HandleFunc(DialogPathSelectBadge, p.extractUserMiddleWare(p.dialogSelectBadge, ResponseTypeDialog)).Methods(http.MethodPost)
 	dialogRouter.HandleFunc(DialogPathSelectType, p.extractUserMiddleWare(p.dialogSelectType, ResponseTypeDialog)).Methods(http.MethodPost)
 	dialogRouter.HandleFunc(DialogPathEditBadge, p.extractUserMiddleWare(p.dialogEditBadge, ResponseTypeDialog)).Methods(http.MethodPost)
@@ -107,7 +125,6 @@ func (p *Plugin) dialogCreateBadge(w http.ResponseWriter, r *http.Request, userI
 
 	toCreate := &badgesmodel.Badge{}
 	toCreate.CreatedBy = userID
-	toCreate.ImageType = badgesmodel.ImageTypeEmoji
 	name, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadgeName)
 	if errors != nil {
 		dialogError(w, errText, errors)
@@ -122,20 +139,21 @@ func (p *Plugin) dialogCreateBadge(w http.ResponseWriter, r *http.Request, userI
 	}
 	toCreate.Description = description
 
-	image, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadgeImage)
-	if errors != nil {
-		dialogError(w, errText, errors)
+	tagsStr, _ := req.Submission[DialogFieldBadgeTags].(string)
+	tags, err := parseTags(tagsStr)
+	if err != nil {
+		dialogError(w, "Invalid field", map[string]string{DialogFieldBadgeTags: err.Error()})
 		return
 	}
+	toCreate.Tags = tags
 
-	if length := len(image); length > 1 && image[0] == ':' && image[length-1] == ':' {
-		image = image[1 : len(image)-1]
-	}
-	if image == "" {
-		dialogError(w, "Invalid field", map[string]string{"image": "Empty emoji"})
+	image, imageType, errText, errors := p.getDialogSubmissionImage(req, userID, nil)
+	if errors != nil {
+		dialogError(w, errText, errors)
 		return
 	}
 	toCreate.Image = image
+	toCreate.ImageType = imageType
 
 	badgeTypeStr, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadgeType)
 	if errors != nil {
@@ -146,18 +164,40 @@ func (p *Plugin) dialogCreateBadge(w http.ResponseWriter, r *http.Request, userI
 	toCreate.Type = badgesmodel.BadgeType(badgeTypeStr)
 	toCreate.Multiple = getDialogSubmissionBoolField(req, DialogFieldBadgeMultiple)
 
+	validityDays, errText, errors := getDialogSubmissionDaysField(req, DialogFieldBadgeValidityDays)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	toCreate.ValidityDays = validityDays
+
+	points, errText, errors := getDialogSubmissionPointsField(req, DialogFieldBadgePoints)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	toCreate.Points = points
+
+	coOwnersStr, _ := req.Submission[DialogFieldBadgeCoOwners].(string)
+	coOwners, err := p.parseCoOwners(coOwnersStr, userID)
+	if err != nil {
+		dialogError(w, "Invalid field", map[string]string{DialogFieldBadgeCoOwners: err.Error()})
+		return
+	}
+	toCreate.CoOwners = coOwners
+
 	t, err := p.store.GetType(badgesmodel.BadgeType(badgeTypeStr))
 	if err != nil {
 		dialogError(w, "this type does not exist", nil)
 		return
 	}
 
-	if !canCreateBadge(user, p.badgeAdminUserID, t) {
-		dialogError(w, "you have no permissions to create this badge", nil)
+	if decision := p.decideCreateScopedBadge(user, t); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("you have no permissions to create this badge"), nil)
 		return
 	}
 
-	_, err = p.store.AddBadge(toCreate)
+	_, err = p.storeAs(userID).AddBadge(toCreate)
 	if err != nil {
 		dialogError(w, err.Error(), nil)
 		return
@@ -185,15 +225,13 @@ func (p *Plugin) dialogCreateType(w http.ResponseWriter, r *http.Request, userID
 		return
 	}
 
-	if !canCreateType(u, p.badgeAdminUserID, false) {
+	if !p.canCreateTypeOnTeam(u, req.TeamId) {
 		dialogError(w, "you have no permissions to create a type", nil)
 		return
 	}
 
 	toCreate := &badgesmodel.BadgeTypeDefinition{}
 	toCreate.CreatedBy = userID
-	toCreate.CanCreate.Everyone = getDialogSubmissionBoolField(req, DialogFieldTypeEveryoneCanCreate)
-	toCreate.CanGrant.Everyone = getDialogSubmissionBoolField(req, DialogFieldTypeEveryoneCanGrant)
 	name, errText, errors := getDialogSubmissionTextField(req, DialogFieldTypeName)
 	if errors != nil {
 		dialogError(w, errText, errors)
@@ -201,44 +239,52 @@ func (p *Plugin) dialogCreateType(w http.ResponseWriter, r *http.Request, userID
 	}
 	toCreate.Name = name
 
-	createAllowList, _ := req.Submission[DialogFieldTypeAllowlistCanCreate].(string)
-	grantAllowList, _ := req.Submission[DialogFieldTypeAllowlistCanGrant].(string)
+	frame, errText, errors := p.getDialogSubmissionFrame(req, userID, "")
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	toCreate.Frame = frame
 
-	if createAllowList != "" {
-		toCreate.CanCreate.AllowList = map[string]bool{}
-		usernames := strings.Split(createAllowList, ",")
-		for _, username := range usernames {
-			username = strings.TrimSpace(username)
-			if username == "" {
-				continue
-			}
-			u, err := p.mm.User.GetByUsername(username)
-			if err != nil {
-				dialogError(w, "Cannot find user", map[string]string{DialogFieldTypeAllowlistCanCreate: fmt.Sprintf("Error getting user %s. Error: %v", username, err)})
-				return
-			}
-			toCreate.CanCreate.AllowList[u.Id] = true
-		}
+	defaultPoints, errText, errors := getDialogSubmissionPointsField(req, DialogFieldTypeDefaultPoints)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
 	}
+	toCreate.DefaultPoints = defaultPoints
 
-	if grantAllowList != "" {
-		toCreate.CanGrant.AllowList = map[string]bool{}
-		usernames := strings.Split(createAllowList, ",")
-		for _, username := range usernames {
-			username = strings.TrimSpace(username)
-			if username == "" {
-				continue
-			}
-			u, err := p.mm.User.GetByUsername(username)
-			if err != nil {
-				dialogError(w, "Cannot find user", map[string]string{DialogFieldTypeAllowlistCanGrant: fmt.Sprintf("Error getting user %s. Error: %v", username, err)})
-				return
-			}
-			toCreate.CanGrant.AllowList[u.Id] = true
-		}
+	scope, errText, errors := p.getDialogSubmissionScope(req, u, nil)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	toCreate.TeamID = scope.TeamID
+	toCreate.ChannelID = scope.ChannelID
+
+	canCreate, errText, errors := p.getDialogSubmissionScheme(req, createSchemeFields, scope)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	toCreate.CanCreate = canCreate
+
+	canGrant, errText, errors := p.getDialogSubmissionScheme(req, grantSchemeFields, scope)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	toCreate.CanGrant = canGrant
+
+	canEdit, errText, errors := p.getDialogSubmissionScheme(req, editSchemeFields, scope)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
 	}
+	toCreate.CanEdit = canEdit
 
-	_, err = p.store.AddType(toCreate)
+	toCreate.Announceable = getDialogSubmissionBoolField(req, DialogFieldTypeAnnounceable)
+
+	_, err = p.storeAs(userID).AddType(toCreate)
 	if err != nil {
 		dialogError(w, err.Error(), nil)
 		return
@@ -279,8 +325,8 @@ func (p *Plugin) dialogSelectType(w http.ResponseWriter, r *http.Request, userID
 		return
 	}
 
-	if !canEditType(u, p.badgeAdminUserID, t) {
-		dialogError(w, "You cannot edit this type", nil)
+	if decision := p.decideManageType(u, t); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("You cannot edit this type"), nil)
 		return
 	}
 
@@ -315,20 +361,27 @@ func (p *Plugin) dialogEditType(w http.ResponseWriter, r *http.Request, userID s
 		return
 	}
 
-	if !canEditType(u, p.badgeAdminUserID, originalType) {
-		dialogError(w, "you have no permissions to edit this type", nil)
+	if decision := p.decideManageType(u, originalType); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("you have no permissions to edit this type"), nil)
 		return
 	}
 
-	if getDialogSubmissionBoolField(req, DialogFieldTypeDelete) {
-		err = p.store.DeleteType(badgesmodel.BadgeType(originalTypeID))
+	if getDialogSubmissionBoolField(req, DialogFieldTypeArchive) {
+		originalType.Archived = true
+		originalType.UpdatedBy = userID
+		err = p.storeAs(userID).UpdateType(originalType)
 		if err != nil {
 			dialogError(w, err.Error(), nil)
+			return
 		}
+		p.mm.Post.SendEphemeralPost(userID, &model.Post{
+			UserId:    p.BotUserID,
+			ChannelId: req.ChannelId,
+			Message:   fmt.Sprintf("Type `%s` archived. Run `/badges restore type --type %s` to restore it, or `/badges purge type --type %s` to remove it permanently.", originalType.Name, originalType.ID, originalType.ID),
+		})
+		dialogOK(w)
 		return
 	}
-	originalType.CanCreate.Everyone = getDialogSubmissionBoolField(req, DialogFieldTypeEveryoneCanCreate)
-	originalType.CanGrant.Everyone = getDialogSubmissionBoolField(req, DialogFieldTypeEveryoneCanGrant)
 	name, errText, errors := getDialogSubmissionTextField(req, DialogFieldTypeName)
 	if errors != nil {
 		dialogError(w, errText, errors)
@@ -336,42 +389,53 @@ func (p *Plugin) dialogEditType(w http.ResponseWriter, r *http.Request, userID s
 	}
 	originalType.Name = name
 
-	createAllowList, _ := req.Submission[DialogFieldTypeAllowlistCanCreate].(string)
-	grantAllowList, _ := req.Submission[DialogFieldTypeAllowlistCanGrant].(string)
+	frame, errText, errors := p.getDialogSubmissionFrame(req, userID, originalType.Frame)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	originalType.Frame = frame
 
-	originalType.CanCreate.AllowList = map[string]bool{}
-	usernames := strings.Split(createAllowList, ",")
-	for _, username := range usernames {
-		username = strings.TrimSpace(username)
-		if username == "" {
-			continue
-		}
-		var allowedUser *model.User
-		allowedUser, err = p.mm.User.GetByUsername(username)
-		if err != nil {
-			dialogError(w, "Cannot find user", map[string]string{DialogFieldTypeAllowlistCanCreate: fmt.Sprintf("Error getting user %s. Error: %v", username, err)})
-			return
-		}
-		originalType.CanCreate.AllowList[allowedUser.Id] = true
+	defaultPoints, errText, errors := getDialogSubmissionPointsField(req, DialogFieldTypeDefaultPoints)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
 	}
+	originalType.DefaultPoints = defaultPoints
 
-	originalType.CanGrant.AllowList = map[string]bool{}
-	usernames = strings.Split(grantAllowList, ",")
-	for _, username := range usernames {
-		username = strings.TrimSpace(username)
-		if username == "" {
-			continue
-		}
-		var allowedUser *model.User
-		allowedUser, err = p.mm.User.GetByUsername(username)
-		if err != nil {
-			dialogError(w, "Cannot find user", map[string]string{DialogFieldTypeAllowlistCanGrant: fmt.Sprintf("Error getting user %s. Error: %v", username, err)})
-			return
-		}
-		originalType.CanGrant.AllowList[allowedUser.Id] = true
+	scope, errText, errors := p.getDialogSubmissionScope(req, u, originalType)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	originalType.TeamID = scope.TeamID
+	originalType.ChannelID = scope.ChannelID
+
+	canCreate, errText, errors := p.getDialogSubmissionScheme(req, createSchemeFields, scope)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
 	}
+	originalType.CanCreate = canCreate
 
-	err = p.store.UpdateType(originalType)
+	canGrant, errText, errors := p.getDialogSubmissionScheme(req, grantSchemeFields, scope)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	originalType.CanGrant = canGrant
+
+	canEdit, errText, errors := p.getDialogSubmissionScheme(req, editSchemeFields, scope)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	originalType.CanEdit = canEdit
+
+	originalType.Announceable = getDialogSubmissionBoolField(req, DialogFieldTypeAnnounceable)
+
+	originalType.UpdatedBy = userID
+	err = p.storeAs(userID).UpdateType(originalType)
 	if err != nil {
 		dialogError(w, err.Error(), nil)
 		return
@@ -412,8 +476,8 @@ func (p *Plugin) dialogSelectBadge(w http.ResponseWriter, r *http.Request, userI
 		return
 	}
 
-	if !canEditBadge(u, p.badgeAdminUserID, b) {
-		dialogError(w, "You cannot edit this badge", nil)
+	if decision := p.decideEditStoredBadge(u, b); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("You cannot edit this badge"), nil)
 		return
 	}
 
@@ -448,17 +512,25 @@ func (p *Plugin) dialogEditBadge(w http.ResponseWriter, r *http.Request, userID
 		return
 	}
 
-	if !canEditBadge(u, p.badgeAdminUserID, originalBadge) {
-		dialogError(w, "you have no permissions to edit this type", nil)
+	if decision := p.decideEditStoredBadge(u, originalBadge); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("you have no permissions to edit this badge"), nil)
 		return
 	}
 
-	if getDialogSubmissionBoolField(req, DialogFieldBadgeDelete) {
-		err = p.store.DeleteBadge(badgesmodel.BadgeID(originalBadgeID))
+	if getDialogSubmissionBoolField(req, DialogFieldBadgeArchive) {
+		originalBadge.Archived = true
+		originalBadge.UpdatedBy = userID
+		err = p.storeAs(userID).UpdateBadge(originalBadge)
 		if err != nil {
 			dialogError(w, err.Error(), nil)
 			return
 		}
+		p.mm.Post.SendEphemeralPost(userID, &model.Post{
+			UserId:    p.BotUserID,
+			ChannelId: req.ChannelId,
+			Message:   fmt.Sprintf("Badge `%s` archived. Run `/badges restore badge --id %s` to restore it, or `/badges purge badge --id %s` to remove it permanently.", originalBadge.Name, originalBadge.ID, originalBadge.ID),
+		})
+		dialogOK(w)
 		return
 	}
 	name, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadgeName)
@@ -475,20 +547,21 @@ func (p *Plugin) dialogEditBadge(w http.ResponseWriter, r *http.Request, userID
 	}
 	originalBadge.Description = description
 
-	image, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadgeImage)
-	if errors != nil {
-		dialogError(w, errText, errors)
+	tagsStr, _ := req.Submission[DialogFieldBadgeTags].(string)
+	tags, err := parseTags(tagsStr)
+	if err != nil {
+		dialogError(w, "Invalid field", map[string]string{DialogFieldBadgeTags: err.Error()})
 		return
 	}
+	originalBadge.Tags = tags
 
-	if length := len(image); length > 1 && image[0] == ':' && image[length-1] == ':' {
-		image = image[1 : len(image)-1]
-	}
-	if image == "" {
-		dialogError(w, "Invalid field", map[string]string{"image": "Empty emoji"})
+	image, imageType, errText, errors := p.getDialogSubmissionImage(req, userID, originalBadge)
+	if errors != nil {
+		dialogError(w, errText, errors)
 		return
 	}
 	originalBadge.Image = image
+	originalBadge.ImageType = imageType
 
 	badgeTypeStr, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadgeType)
 	if errors != nil {
@@ -500,7 +573,31 @@ func (p *Plugin) dialogEditBadge(w http.ResponseWriter, r *http.Request, userID
 
 	originalBadge.Multiple = getDialogSubmissionBoolField(req, DialogFieldBadgeMultiple)
 
-	err = p.store.UpdateBadge(originalBadge)
+	validityDays, errText, errors := getDialogSubmissionDaysField(req, DialogFieldBadgeValidityDays)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	originalBadge.ValidityDays = validityDays
+
+	points, errText, errors := getDialogSubmissionPointsField(req, DialogFieldBadgePoints)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+	originalBadge.Points = points
+
+	coOwnersStr, _ := req.Submission[DialogFieldBadgeCoOwners].(string)
+	coOwners, err := p.parseCoOwners(coOwnersStr, originalBadge.CreatedBy)
+	if err != nil {
+		dialogError(w, "Invalid field", map[string]string{DialogFieldBadgeCoOwners: err.Error()})
+		return
+	}
+	originalBadge.CoOwners = coOwners
+
+	originalBadge.UpdatedBy = userID
+
+	err = p.storeAs(userID).UpdateBadge(originalBadge)
 	if err != nil {
 		dialogError(w, err.Error(), nil)
 		return
@@ -548,8 +645,13 @@ func (p *Plugin) dialogGrant(w http.ResponseWriter, r *http.Request, userID stri
 		return
 	}
 
-	if !canGrantBadge(granter, p.badgeAdminUserID, badge, badgeType) {
-		dialogError(w, "you have no permissions to grant this badge", nil)
+	if badge.Archived || badgeType.Archived {
+		dialogError(w, "this badge is archived", nil)
+		return
+	}
+
+	if decision := p.decideGrantScopedBadge(granter, badge, badgeType); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("you have no permissions to grant this badge"), nil)
 		return
 	}
 
@@ -568,9 +670,14 @@ func (p *Plugin) dialogGrant(w http.ResponseWriter, r *http.Request, userID stri
 		return
 	}
 
+	if err = p.checkGrantScope(grantToUser, badgeType); err != nil {
+		dialogError(w, err.Error(), nil)
+		return
+	}
+
 	reason, _ := req.Submission[DialogFieldGrantReason].(string)
 
-	shouldNotify, err := p.store.GrantBadge(badgesmodel.BadgeID(badgeIDStr), grantToID, userID, reason)
+	granted, err := p.storeAs(userID).GrantBadge(badgesmodel.BadgeID(badgeIDStr), grantToID, userID, reason)
 	if err != nil {
 		p.writeAPIError(w, &APIErrorResponse{
 			ID:         "cannot grant badge",
@@ -580,14 +687,94 @@ func (p *Plugin) dialogGrant(w http.ResponseWriter, r *http.Request, userID stri
 		return
 	}
 
-	if shouldNotify {
+	message := fmt.Sprintf("@%s already has the badge `%s`, and it cannot be granted more than once.", grantToUser.Username, badge.Name)
+	if granted != nil {
 		p.notifyGrant(badgesmodel.BadgeID(badgeIDStr), userID, grantToUser, notifyHere, req.ChannelId, reason)
+		message = fmt.Sprintf("Badge `%s` granted to @%s. Grant ID: `%s`.", badge.Name, grantToUser.Username, granted.GrantID)
+	}
+
+	p.mm.Post.SendEphemeralPost(userID, &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: req.ChannelId,
+		Message:   message,
+	})
+
+	dialogOK(w)
+}
+
+func (p *Plugin) dialogRevoke(w http.ResponseWriter, r *http.Request, userID string) {
+	req := model.SubmitDialogRequestFromJson(r.Body)
+	if req == nil {
+		dialogError(w, "could not get the dialog request", nil)
+		return
+	}
+
+	badgeIDStr, errText, errors := getDialogSubmissionTextField(req, DialogFieldBadge)
+	if errors != nil {
+		dialogError(w, errText, errors)
+		return
+	}
+
+	badge, err := p.store.GetBadge(badgesmodel.BadgeID(badgeIDStr))
+	if err != nil {
+		dialogError(w, "badge not found", nil)
+		return
+	}
+
+	revoker, err := p.mm.User.Get(userID)
+	if err != nil {
+		dialogError(w, err.Error(), nil)
+		return
+	}
+
+	badgeType, err := p.store.GetType(badge.Type)
+	if err != nil {
+		dialogError(w, err.Error(), nil)
+		return
+	}
+
+	if decision := decideRevokeBadge(revoker, p.getBadgeAdmins(), badge, badgeType); !decision.Allowed {
+		dialogError(w, decision.deniedMessage("you have no permissions to revoke this badge"), nil)
+		return
+	}
+
+	revokeFromID := req.State
+	if revokeFromID == "" {
+		revokeFromID, errText, errors = getDialogSubmissionTextField(req, DialogFieldUser)
+		if errors != nil {
+			dialogError(w, errText, errors)
+			return
+		}
+	}
+
+	revokeFromUser, err := p.mm.User.Get(revokeFromID)
+	if err != nil {
+		dialogError(w, "user not found", nil)
+		return
+	}
+
+	reason, _ := req.Submission[DialogFieldRevokeReason].(string)
+	all := getDialogSubmissionBoolField(req, DialogFieldRevokeAll)
+
+	removed, err := p.storeAs(userID).RevokeBadge(badge.ID, revokeFromID, all)
+	if err != nil {
+		dialogError(w, err.Error(), nil)
+		return
+	}
+
+	if removed == 0 {
+		dialogError(w, "the user does not own this badge", map[string]string{DialogFieldBadge: "Badge not owned by the user."})
+		return
+	}
+
+	if getDialogSubmissionBoolField(req, DialogFieldNotifyUser) {
+		p.notifyRevoke(badge.ID, userID, revokeFromUser, reason)
 	}
 
 	p.mm.Post.SendEphemeralPost(userID, &model.Post{
 		UserId:    p.BotUserID,
 		ChannelId: req.ChannelId,
-		Message:   fmt.Sprintf("Badge `%s` granted to @%s.", badge.Name, grantToUser.Username),
+		Message:   fmt.Sprintf("Badge `%s` revoked from @%s.", badge.Name, revokeFromUser.Username),
 	})
 
 	dialogOK(w)
@@ -606,7 +793,7 @@ func (p *Plugin) dialogCreateSubscription(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if !canCreateSubscription(u, p.badgeAdminUserID, req.ChannelId) {
+	if !p.canCreateSubscription(u, req.ChannelId) {
 		dialogError(w, "You cannot create a subscription", nil)
 		return
 	}
@@ -617,9 +804,21 @@ func (p *Plugin) dialogCreateSubscription(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = p.store.AddSubscription(badgesmodel.BadgeType(typeIDStr), req.ChannelId)
+	t, err := p.store.GetType(badgesmodel.BadgeType(typeIDStr))
+	if err != nil {
+		dialogError(w, "Cannot get type", map[string]string{DialogFieldBadgeType: "cannot get type"})
+		return
+	}
+
+	if !p.canSubscribeType(u, t, req.ChannelId) {
+		dialogError(w, "You cannot subscribe this channel to this type", nil)
+		return
+	}
+
+	err = p.storeAs(userID).AddSubscription(t.ID, req.ChannelId)
 	if err != nil {
 		dialogError(w, err.Error(), nil)
+		return
 	}
 
 	p.mm.Post.SendEphemeralPost(userID, &model.Post{
@@ -644,7 +843,7 @@ func (p *Plugin) dialogDeleteSubscription(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if !canCreateSubscription(u, p.badgeAdminUserID, req.ChannelId) {
+	if !p.canCreateSubscription(u, req.ChannelId) {
 		dialogError(w, "You cannot delete a subscription", nil)
 		return
 	}
@@ -655,9 +854,10 @@ func (p *Plugin) dialogDeleteSubscription(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = p.store.RemoveSubscriptions(badgesmodel.BadgeType(typeIDStr), req.ChannelId)
+	err = p.storeAs(userID).RemoveSubscriptions(badgesmodel.BadgeType(typeIDStr), req.ChannelId)
 	if err != nil {
 		dialogError(w, err.Error(), nil)
+		return
 	}
 
 	p.mm.Post.SendEphemeralPost(userID, &model.Post{
@@ -679,6 +879,115 @@ func getDialogSubmissionTextField(req *model.SubmitDialogRequest, fieldName stri
 	return value, "", nil
 }
 
+// getDialogSubmissionDaysField reads an optional number of days. An empty field
+// is zero.
+func getDialogSubmissionDaysField(req *model.SubmitDialogRequest, fieldName string) (value int, errText string, errors map[string]string) {
+	str, _ := req.Submission[fieldName].(string)
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, "", nil
+	}
+
+	value, err := strconv.Atoi(str)
+	if err != nil || value < 0 {
+		return 0, "Invalid argument", map[string]string{fieldName: "Must be a whole number of days."}
+	}
+
+	return value, "", nil
+}
+
+// getDialogSubmissionPointsField returns nil when the field is empty, so the
+// points fall back to their default.
+func getDialogSubmissionPointsField(req *model.SubmitDialogRequest, fieldName string) (value *int, errText string, errors map[string]string) {
+	str, _ := req.Submission[fieldName].(string)
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return nil, "", nil
+	}
+
+	points, err := strconv.Atoi(str)
+	if err != nil || points < 0 || points > badgesmodel.MaxPoints {
+		return nil, "Invalid argument", map[string]string{fieldName: fmt.Sprintf("Must be a whole number from 0 to %d.", badgesmodel.MaxPoints)}
+	}
+
+	return &points, "", nil
+}
+
+// getDialogSubmissionImage reads the badge image, either an emoji or a file to
+// upload. current is the badge being edited, which keeps its uploaded image
+// unless it is changed.
+func (p *Plugin) getDialogSubmissionImage(req *model.SubmitDialogRequest, userID string, current *badgesmodel.Badge) (image string, imageType badgesmodel.ImageType, errText string, errors map[string]string) {
+	emoji, _ := req.Submission[DialogFieldBadgeImage].(string)
+	emoji = strings.TrimSpace(emoji)
+	if length := len(emoji); length > 1 && emoji[0] == ':' && emoji[length-1] == ':' {
+		emoji = emoji[1 : length-1]
+	}
+	fileID, _ := req.Submission[DialogFieldBadgeImageFile].(string)
+
+	// Badges ensured by other plugins can have URL images, which are edited
+	// on the same field as emojis.
+	textType := badgesmodel.ImageTypeEmoji
+	if current != nil && (current.ImageType == badgesmodel.ImageTypeAbsoluteURL || current.ImageType == badgesmodel.ImageTypeRelativeURL) {
+		textType = current.ImageType
+	}
+
+	keepsUpload := current != nil && current.ImageType == badgesmodel.ImageTypeUpload && fileID == current.Image
+	switch {
+	case emoji != "" && fileID != "" && !keepsUpload:
+		return "", "", "Invalid field", map[string]string{DialogFieldBadgeImageFile: "Set either an emoji or an image, not both."}
+	case emoji != "":
+		return emoji, textType, "", nil
+	case keepsUpload:
+		return current.Image, badgesmodel.ImageTypeUpload, "", nil
+	case fileID != "":
+		imageID, err := p.saveImageFromFile(fileID, userID)
+		if err != nil {
+			return "", "", "Invalid field", map[string]string{DialogFieldBadgeImageFile: err.Error()}
+		}
+		return imageID, badgesmodel.ImageTypeUpload, "", nil
+	}
+
+	return "", "", "Invalid field", map[string]string{DialogFieldBadgeImage: "Set an emoji, or pick an image."}
+}
+
+// getDialogSubmissionFrame reads the type frame, either a shape and colour or a
+// file to upload. current is the frame of the type being edited, which keeps its
+// uploaded image unless it is changed.
+func (p *Plugin) getDialogSubmissionFrame(req *model.SubmitDialogRequest, userID string, current string) (frame string, errText string, errors map[string]string) {
+	shape, _ := req.Submission[DialogFieldTypeFrameShape].(string)
+	colour, _ := req.Submission[DialogFieldTypeFrameColour].(string)
+	colour = strings.TrimSpace(colour)
+	fileID, _ := req.Submission[DialogFieldTypeFrameImage].(string)
+
+	currentFrame, _ := parseFrame(current)
+	keepsImage := currentFrame != nil && currentFrame.ImageID != "" && fileID == currentFrame.ImageID
+	switch {
+	case shape != "" && fileID != "" && !keepsImage:
+		return "", "Invalid field", map[string]string{DialogFieldTypeFrameImage: errFrameTooMany.Error()}
+	case shape != "":
+		if colour == "" {
+			colour = DefaultFrameColour
+		}
+		f, err := parseFrame(shape + ":" + colour)
+		if err != nil {
+			return "", "Invalid field", map[string]string{DialogFieldTypeFrameColour: err.Error()}
+		}
+		return f.String(), "", nil
+	case colour != "":
+		return "", "Invalid field", map[string]string{DialogFieldTypeFrameShape: "Pick the shape of the frame."}
+	case keepsImage:
+		return current, "", nil
+	case fileID != "":
+		imageID, err := p.saveImageFromFile(fileID, userID)
+		if err != nil {
+			return "", "Invalid field", map[string]string{DialogFieldTypeFrameImage: err.Error()}
+		}
+		return badgesmodel.FrameImagePrefix + imageID, "", nil
+	}
+
+	return "", "", nil
+}
+
 func getDialogSubmissionBoolField(req *model.SubmitDialogRequest, fieldName string) bool {
 	value, _ := req.Submission[fieldName].(bool)
 	return value
@@ -736,16 +1045,45 @@ func (p *Plugin) grantBadge(w http.ResponseWriter, r *http.Request, pluginID str
 		return
 	}
 
-	if !canGrantBadge(granter, p.badgeAdminUserID, badge, badgeType) {
+	if badge.Archived || badgeType.Archived {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot grant badge",
+			Message:    errBadgeArchived.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	if decision := decideGrantBadge(granter, p.getBadgeAdmins(), p.getTypeMembership(granter, badgeType), badge, badgeType); !decision.Allowed {
 		p.writeAPIError(w, &APIErrorResponse{
 			ID:         "cannot grant badge",
-			Message:    "you have no permissions to grant this badge",
+			Message:    decision.deniedMessage("you have no permissions to grant this badge"),
 			StatusCode: http.StatusUnauthorized,
 		})
 		return
 	}
 
-	shouldNotify, err := p.store.GrantBadge(req.BadgeID, req.UserID, req.BotID, req.Reason)
+	// Plugin bots grant on behalf of the plugin, so only the user must be in
+	// the scope of the type.
+	grantTo, err := p.mm.User.Get(req.UserID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot get user",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+	if err = p.checkGrantScope(grantTo, badgeType); err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot grant badge",
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	granted, err := p.storeAs(req.BotID).GrantBadge(req.BadgeID, req.UserID, req.BotID, req.Reason)
 	if err != nil {
 		p.writeAPIError(w, &APIErrorResponse{
 			ID:         "cannot grant badge",
@@ -754,18 +1092,18 @@ func (p *Plugin) grantBadge(w http.ResponseWriter, r *http.Request, pluginID str
 		})
 		return
 	}
-	if shouldNotify {
-		u, err := p.mm.User.Get(req.UserID)
-		if err == nil {
-			p.notifyGrant(req.BadgeID, req.BotID, u, false, "", req.Reason)
-		}
+	if granted == nil {
+		_, _ = w.Write([]byte(`{"sucess": true}`))
+		return
 	}
 
-	_, _ = w.Write([]byte(`{"sucess": true}`))
+	p.notifyGrant(req.BadgeID, req.BotID, grantTo, false, "", req.Reason)
+
+	_, _ = w.Write([]byte(fmt.Sprintf(`{"sucess": true, "grant_id": %q}`, granted.GrantID)))
 }
 
-func (p *Plugin) ensureBadges(w http.ResponseWriter, r *http.Request, pluginID string) {
-	var req *badgesmodel.EnsureBadgesRequest
+func (p *Plugin) revokeBadge(w http.ResponseWriter, r *http.Request, pluginID string) {
+	var req *badgesmodel.RevokeBadgeRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		p.writeAPIError(w, &APIErrorResponse{
@@ -775,36 +1113,210 @@ func (p *Plugin) ensureBadges(w http.ResponseWriter, r *http.Request, pluginID s
 		})
 		return
 	}
+	p.mm.Log.Debug("Revoking badge", "req", req)
+
 	if req == nil {
 		p.writeAPIError(w, &APIErrorResponse{
 			ID:         "missing request",
-			Message:    "Missing ensure request on request body",
+			Message:    "Missing revoke request on request body",
 			StatusCode: http.StatusInternalServerError,
 		})
 		return
 	}
 
-	badges, err := p.store.EnsureBadges(req.Badges, pluginID, req.BotID)
+	revoker, err := p.mm.User.Get(req.BotID)
 	if err != nil {
 		p.writeAPIError(w, &APIErrorResponse{
-			ID:         "cannot ensure",
+			ID:         "cannot get user",
 			Message:    err.Error(),
 			StatusCode: http.StatusInternalServerError,
 		})
 		return
 	}
 
-	b, err := json.Marshal(badges)
+	badge, err := p.store.GetBadge(req.BadgeID)
 	if err != nil {
 		p.writeAPIError(w, &APIErrorResponse{
-			ID:         "cannot marshal",
+			ID:         "cannot get badge",
 			Message:    err.Error(),
 			StatusCode: http.StatusInternalServerError,
 		})
 		return
 	}
 
-	_, _ = w.Write(b)
+	badgeType, err := p.store.GetType(badge.Type)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot get type",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if decision := decideRevokeBadge(revoker, p.getBadgeAdmins(), badge, badgeType); !decision.Allowed {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot revoke badge",
+			Message:    decision.deniedMessage("you have no permissions to revoke this badge"),
+			StatusCode: http.StatusUnauthorized,
+		})
+		return
+	}
+
+	removed, err := p.storeAs(req.BotID).RevokeBadge(req.BadgeID, req.UserID, req.All)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot revoke badge",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if removed > 0 && req.Notify {
+		u, err := p.mm.User.Get(req.UserID)
+		if err == nil {
+			p.notifyRevoke(req.BadgeID, req.BotID, u, req.Reason)
+		}
+	}
+
+	_, _ = w.Write([]byte(fmt.Sprintf(`{"success": true, "revoked": %d}`, removed)))
+}
+
+func (p *Plugin) renewBadge(w http.ResponseWriter, r *http.Request, pluginID string) {
+	var req *badgesmodel.RenewBadgeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot unmarshal request",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+	p.mm.Log.Debug("Renewing badge", "req", req)
+
+	if req == nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "missing request",
+			Message:    "Missing renew request on request body",
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	renewer, err := p.mm.User.Get(req.BotID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot get user",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	badge, err := p.store.GetBadge(req.BadgeID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot get badge",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	badgeType, err := p.store.GetType(badge.Type)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot get type",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if badge.Archived || badgeType.Archived {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot renew badge",
+			Message:    errBadgeArchived.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+
+	if decision := decideGrantBadge(renewer, p.getBadgeAdmins(), p.getTypeMembership(renewer, badgeType), badge, badgeType); !decision.Allowed {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot renew badge",
+			Message:    decision.deniedMessage("you have no permissions to renew this badge"),
+			StatusCode: http.StatusUnauthorized,
+		})
+		return
+	}
+
+	renewed, err := p.storeAs(req.BotID).RenewBadge(req.BadgeID, req.UserID)
+	if err == errBadgeNotTimeLimited || err == errOwnershipNotFound {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot renew badge",
+			Message:    err.Error(),
+			StatusCode: http.StatusBadRequest,
+		})
+		return
+	}
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot renew badge",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	p.notifyRenew(renewed, req.BotID)
+
+	_ = json.NewEncoder(w).Encode(renewed)
+}
+
+func (p *Plugin) ensureBadges(w http.ResponseWriter, r *http.Request, pluginID string) {
+	var req *badgesmodel.EnsureBadgesRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot unmarshal request",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+	if req == nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "missing request",
+			Message:    "Missing ensure request on request body",
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	badges, err := p.storeAs(req.BotID).EnsureBadges(req.Badges, pluginID, req.BotID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot ensure",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	b, err := json.Marshal(badges)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{
+			ID:         "cannot marshal",
+			Message:    err.Error(),
+			StatusCode: http.StatusInternalServerError,
+		})
+		return
+	}
+
+	_, _ = w.Write(b)
 }
 
 func (p *Plugin) getBadgeSuggestions(w http.ResponseWriter, r *http.Request, actingUserID string) {
@@ -863,6 +1375,34 @@ func (p *Plugin) getEditBadgeSuggestions(w http.ResponseWriter, r *http.Request,
 	_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
 }
 
+func (p *Plugin) getRevokeBadgeSuggestions(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	out := []model.AutocompleteListItem{}
+	u, err := p.mm.User.Get(actingUserID)
+	if err != nil {
+		p.mm.Log.Debug("Error getting user", "error", err)
+		_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+		return
+	}
+
+	bb, err := p.filterRevokeBadges(u)
+	if err != nil {
+		p.mm.Log.Debug("Error getting suggestions", "error", err)
+		_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+		return
+	}
+
+	for _, b := range bb {
+		s := model.AutocompleteListItem{
+			Item:     string(b.ID),
+			Hint:     b.Name,
+			HelpText: b.Description,
+		}
+
+		out = append(out, s)
+	}
+	_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+}
+
 func (p *Plugin) getBadgeTypeSuggestions(w http.ResponseWriter, r *http.Request, actingUserID string) {
 	out := []model.AutocompleteListItem{}
 	u, err := p.mm.User.Get(actingUserID)
@@ -917,18 +1457,95 @@ func (p *Plugin) getEditBadgeTypeSuggestions(w http.ResponseWriter, r *http.Requ
 	_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
 }
 
+func (p *Plugin) getArchivedBadgeSuggestions(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	out := []model.AutocompleteListItem{}
+	u, err := p.mm.User.Get(actingUserID)
+	if err != nil {
+		p.mm.Log.Debug("Error getting user", "error", err)
+		_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+		return
+	}
+
+	bb, err := p.filterEditBadges(u)
+	if err != nil {
+		p.mm.Log.Debug("Error getting suggestions", "error", err)
+		_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+		return
+	}
+
+	for _, b := range bb {
+		if !b.Archived {
+			continue
+		}
+		s := model.AutocompleteListItem{
+			Item:     string(b.ID),
+			Hint:     b.Name,
+			HelpText: b.Description,
+		}
+
+		out = append(out, s)
+	}
+	_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+}
+
+func (p *Plugin) getArchivedTypeSuggestions(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	out := []model.AutocompleteListItem{}
+	u, err := p.mm.User.Get(actingUserID)
+	if err != nil {
+		p.mm.Log.Debug("Error getting user", "error", err)
+		_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+		return
+	}
+
+	types, err := p.filterEditTypes(u)
+	if err != nil {
+		p.mm.Log.Debug("Error getting suggestions", "error", err)
+		_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+		return
+	}
+
+	for _, t := range types {
+		if !t.Archived {
+			continue
+		}
+		s := model.AutocompleteListItem{
+			Item: string(t.ID),
+			Hint: t.Name,
+		}
+
+		out = append(out, s)
+	}
+	_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(out))
+}
+
 func (p *Plugin) getUserBadges(w http.ResponseWriter, r *http.Request, actingUserID string) {
 	userID, ok := mux.Vars(r)["userID"]
 	if !ok {
 		userID = actingUserID
 	}
 
-	badges, err := p.store.GetUserBadges(userID)
+	opts, err := parseListOptions(r, ListSortTime)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: err.Error(), StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	var badges []*badgesmodel.UserBadge
+	if opts == nil {
+		badges, err = p.store.GetUserBadges(userID)
+	} else {
+		var next string
+		badges, next, err = p.store.ListUserBadges(userID, *opts)
+		if !p.writeListError(w, err) {
+			return
+		}
+		setNextCursor(w, next)
+	}
 	if err != nil {
 		p.mm.Log.Debug("Error getting the badges for user", "error", err, "user", userID)
 	}
 
-	b, _ := json.Marshal(badges)
+	b, _ := json.Marshal(withUserBadgeImageURLs(badges, p.getTypeFrames()))
 	_, _ = w.Write(b)
 }
 
@@ -944,25 +1561,421 @@ func (p *Plugin) getBadgeDetails(w http.ResponseWriter, r *http.Request, actingU
 
 	badgeID := badgesmodel.BadgeID(badgeIDString)
 
+	opts, err := parseListOptions(r, ListSortTime)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: err.Error(), StatusCode: http.StatusBadRequest})
+		return
+	}
+
 	badge, err := p.store.GetBadgeDetails(badgeID)
 	if err != nil {
 		p.mm.Log.Debug("Cannot get badge details", "badgeID", badgeID, "error", err)
 	}
+	if badge != nil {
+		// The details may be shared with the cache, so they are copied before
+		// being changed.
+		details := *badge
+		details.Badge = withImageURL(badge.Badge, p.getTypeFrames())
+		badge = &details
+	}
+
+	if badge != nil && opts != nil {
+		owners, next, err := p.store.ListBadgeOwners(badgeID, *opts)
+		if !p.writeListError(w, err) {
+			return
+		}
+		if err != nil {
+			p.mm.Log.Debug("Cannot get badge owners", "badgeID", badgeID, "error", err)
+		}
+		badge.Owners = owners
+		setNextCursor(w, next)
+	}
 
 	b, _ := json.Marshal(badge)
 	_, _ = w.Write(b)
 }
 
 func (p *Plugin) getAllBadges(w http.ResponseWriter, r *http.Request, actingUserID string) {
-	badge, err := p.store.GetAllBadges()
+	opts, err := parseListOptions(r, ListSortName)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: err.Error(), StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	var badge []*badgesmodel.AllBadgesBadge
+	if opts == nil {
+		badge, err = p.store.GetAllBadges()
+	} else {
+		var next string
+		badge, next, err = p.store.ListAllBadges(*opts)
+		if !p.writeListError(w, err) {
+			return
+		}
+		setNextCursor(w, next)
+	}
 	if err != nil {
 		p.mm.Log.Debug("Cannot get all badges", "error", err)
 	}
 
+	b, _ := json.Marshal(withAllBadgesImageURLs(badge, p.getTypeFrames()))
+	_, _ = w.Write(b)
+}
+
+// parseListOptions reads the listing parameters of the request. It returns nil
+// when there are none, so the complete listing is returned as before. The order
+// defaults to newest or most granted first, and to alphabetical for names.
+func parseListOptions(r *http.Request, defaultSort ListSort) (*ListOptions, error) {
+	query := r.URL.Query()
+	found := false
+	for _, param := range []string{"per_page", "cursor", "sort", "order", "type", "granted_by", "since", "until"} {
+		if query.Get(param) != "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	opts := &ListOptions{
+		Sort:      ListSort(query.Get("sort")),
+		Cursor:    query.Get("cursor"),
+		TypeID:    badgesmodel.BadgeType(query.Get("type")),
+		GrantedBy: query.Get("granted_by"),
+	}
+	if opts.Sort == "" {
+		opts.Sort = defaultSort
+	}
+
+	switch query.Get("order") {
+	case "":
+		opts.Desc = opts.Sort != ListSortName
+	case "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return nil, fmt.Errorf("order must be asc or desc")
+	}
+
+	if value := query.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("per_page must be a number")
+		}
+		opts.PerPage = perPage
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a time in milliseconds", param)
+		}
+		*dest = time.Unix(0, millis*int64(time.Millisecond))
+	}
+
+	return opts, nil
+}
+
+// writeListError answers bad listing options, and returns whether the handler
+// can go on.
+func (p *Plugin) writeListError(w http.ResponseWriter, err error) bool {
+	if err == errInvalidCursor || err == errInvalidSort {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: err.Error(), StatusCode: http.StatusBadRequest})
+		return false
+	}
+	return true
+}
+
+func setNextCursor(w http.ResponseWriter, next string) {
+	if next != "" {
+		w.Header().Set(HeaderNextCursor, next)
+	}
+}
+
+func (p *Plugin) getBadgeHistory(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	badgeID := badgesmodel.BadgeID(mux.Vars(r)["badgeID"])
+
+	history, err := p.store.GetBadgeHistory(badgeID)
+	if err == errBadgeNotFound {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Badge not found.", StatusCode: http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		p.mm.Log.Debug("Cannot get badge history", "badgeID", badgeID, "error", err)
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot get the badge history.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	b, _ := json.Marshal(withBadgeImageURLs(history, p.getTypeFrames()))
+	_, _ = w.Write(b)
+}
+
+// getGrant returns a single grant, with the badge it grants, like each item of
+// getUserBadges.
+func (p *Plugin) getGrant(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	grantID := mux.Vars(r)["grantID"]
+
+	grant, err := p.store.GetGrant(grantID)
+	if err == errGrantNotFound {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Grant not found.", StatusCode: http.StatusNotFound})
+		return
+	}
+	if err != nil {
+		p.mm.Log.Debug("Cannot get grant", "grantID", grantID, "error", err)
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot get the grant.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	badge, err := p.store.GetBadge(grant.Badge)
+	if err != nil {
+		p.mm.Log.Debug("Cannot get the badge of a grant", "grantID", grantID, "error", err)
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Grant not found.", StatusCode: http.StatusNotFound})
+		return
+	}
+
+	out := &badgesmodel.UserBadge{
+		Badge:             withImageURL(*badge, p.getTypeFrames()),
+		Ownership:         *grant,
+		GrantedByUsername: "unknown",
+		TypeName:          "unknown",
+	}
+
+	badgeType, err := p.store.GetType(badge.Type)
+	if err == nil {
+		out.TypeName = badgeType.Name
+	}
+
+	granter, err := p.mm.User.Get(grant.GrantedBy)
+	conf := p.mm.Configuration.GetConfig()
+	if err == nil && conf != nil && conf.TeamSettings.TeammateNameDisplay != nil {
+		out.GrantedByUsername = granter.GetDisplayName(*conf.TeamSettings.TeammateNameDisplay)
+	}
+
+	b, _ := json.Marshal(out)
+	_, _ = w.Write(b)
+}
+
+type rollbackBadgeRequest struct {
+	Version int `json:"version"`
+}
+
+func (p *Plugin) rollbackBadgeVersion(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	u, err := p.mm.User.Get(actingUserID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot get user.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	if !isBadgesAdmin(u, p.getBadgeAdmins()) {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Only badge admins can roll badges back.", StatusCode: http.StatusForbidden})
+		return
+	}
+
+	var req rollbackBadgeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot decode the request.", StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	badgeID := badgesmodel.BadgeID(mux.Vars(r)["badgeID"])
+	badge, err := p.rollbackBadge(badgeID, req.Version, actingUserID)
+	switch {
+	case err == errBadgeNotFound || err == errVersionNotFound:
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Badge version not found.", StatusCode: http.StatusNotFound})
+		return
+	case err != nil:
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: err.Error(), StatusCode: http.StatusBadRequest})
+		return
+	}
+
 	b, _ := json.Marshal(badge)
 	_, _ = w.Write(b)
 }
 
+// searchBadges returns the badges matching the q and tag parameters, best first,
+// like getAllBadges.
+func (p *Plugin) searchBadges(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	query := r.URL.Query()
+
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "limit must be a number.", StatusCode: http.StatusBadRequest})
+			return
+		}
+	}
+
+	if strings.TrimSpace(query.Get("q")) == "" && strings.TrimSpace(query.Get("tag")) == "" {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Set q or tag.", StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	badges, err := p.store.GetAllBadges()
+	if err != nil {
+		p.mm.Log.Debug("Cannot get the badges to search", "error", err)
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot search the badges.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	found := searchBadges(badges, query.Get("q"), query.Get("tag"), limit)
+	b, _ := json.Marshal(withAllBadgesImageURLs(found, p.getTypeFrames()))
+	_, _ = w.Write(b)
+}
+
+func (p *Plugin) getLeaderboard(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	query := r.URL.Query()
+	opts := leaderboardOptions{
+		TeamID: query.Get("team"),
+		TypeID: badgesmodel.BadgeType(query.Get("type")),
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "limit must be a number.", StatusCode: http.StatusBadRequest})
+			return
+		}
+		opts.Limit = limit
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &opts.Since, "until": &opts.Until} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			p.writeAPIError(w, &APIErrorResponse{ID: "", Message: fmt.Sprintf("%s must be a time in milliseconds.", param), StatusCode: http.StatusBadRequest})
+			return
+		}
+		*dest = time.Unix(0, millis*int64(time.Millisecond))
+	}
+
+	if opts.TeamID != "" && !p.isTeamMember(opts.TeamID, actingUserID) {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "You are not a member of this team.", StatusCode: http.StatusForbidden})
+		return
+	}
+
+	entries, err := p.computeLeaderboard(opts)
+	if err != nil {
+		p.mm.Log.Debug("Cannot build the leaderboard", "error", err)
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot get the leaderboard.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	b, _ := json.Marshal(entries)
+	_, _ = w.Write(b)
+}
+
+func (p *Plugin) explainPermission(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	actingUser, err := p.mm.User.Get(actingUserID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot get user.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	if !isBadgesAdmin(actingUser, p.getBadgeAdmins()) {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Only badge admins can explain permissions.", StatusCode: http.StatusForbidden})
+		return
+	}
+
+	query := r.URL.Query()
+	u, appErr := getUserByIDOrUsername(p.API, query.Get("user"))
+	if appErr != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot find the user.", StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	badge, err := p.store.GetBadge(badgesmodel.BadgeID(query.Get("badge")))
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot find the badge.", StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	badgeType, err := p.store.GetType(badge.Type)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot find the type of the badge.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	action := query.Get("action")
+	decision, ok := p.decideAction(u, badge, badgeType, action)
+	if !ok {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: fmt.Sprintf("Unknown action %q.", action), StatusCode: http.StatusBadRequest})
+		return
+	}
+
+	b, _ := json.Marshal(&badgesmodel.PermissionExplanation{
+		UserID:  u.Id,
+		BadgeID: badge.ID,
+		TypeID:  badgeType.ID,
+		Action:  action,
+		Allowed: decision.Allowed,
+		Rule:    decision.Rule,
+		Reason:  decision.Reason,
+	})
+	_, _ = w.Write(b)
+}
+
+func (p *Plugin) getAuditLog(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	u, err := p.mm.User.Get(actingUserID)
+	if err != nil {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot get user.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	if !isBadgesAdmin(u, p.getBadgeAdmins()) {
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Only badge admins can read the audit log.", StatusCode: http.StatusForbidden})
+		return
+	}
+
+	query := r.URL.Query()
+	q := auditQuery{
+		ActorID:  query.Get("actor_id"),
+		UserID:   query.Get("user_id"),
+		TargetID: query.Get("target_id"),
+		Action:   badgesmodel.AuditAction(query.Get("action")),
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		millis, parseErr := strconv.ParseInt(value, 10, 64)
+		if parseErr != nil {
+			p.writeAPIError(w, &APIErrorResponse{ID: "", Message: param + " must be a time in milliseconds.", StatusCode: http.StatusBadRequest})
+			return
+		}
+		*dest = time.Unix(0, millis*int64(time.Millisecond))
+	}
+
+	if value := query.Get("limit"); value != "" {
+		q.Limit, err = strconv.Atoi(value)
+		if err != nil {
+			p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "limit must be a number.", StatusCode: http.StatusBadRequest})
+			return
+		}
+	}
+
+	entries, err := queryAuditLog(p.API, q)
+	if err != nil {
+		p.mm.Log.Debug("Cannot query the audit log", "error", err)
+		p.writeAPIError(w, &APIErrorResponse{ID: "", Message: "Cannot query the audit log.", StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	b, _ := json.Marshal(entries)
+	_, _ = w.Write(b)
+}
+
 func (p *Plugin) extractUserMiddleWare(handler HTTPHandlerFuncWithUser, responseType ResponseType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Header.Get("Mattermost-User-ID")
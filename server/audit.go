@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const auditDayFormat = "20060102"
+
+// The audit log is kept on the KV store, whatever the store backend is, with one
+// key per entry so concurrent mutations never write the same key. Keys start
+// with the day of the entry and then its time, so they sort by time and old days
+// can be dropped by prefix.
+func getAuditKey(day time.Time) string {
+	return KVKeyAuditPrefix + day.UTC().Format(auditDayFormat)
+}
+
+func getAuditEntryKey(entry *badgesmodel.AuditEntry) string {
+	return fmt.Sprintf("%s_%019d_%s", getAuditKey(entry.Time), entry.Time.UnixNano(), entry.ID)
+}
+
+// getAuditEntries reads the entries of a key. Keys with only the day hold every
+// entry of that day, as they were written before each entry got its own key.
+func getAuditEntries(api plugin.API, key string) ([]*badgesmodel.AuditEntry, error) {
+	data, appErr := api.KVGet(key)
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	if len(key) == len(KVKeyAuditPrefix)+len(auditDayFormat) {
+		entries := []*badgesmodel.AuditEntry{}
+		return entries, json.Unmarshal(data, &entries)
+	}
+
+	entry := &badgesmodel.AuditEntry{}
+	return []*badgesmodel.AuditEntry{entry}, json.Unmarshal(data, entry)
+}
+
+func appendAuditEntry(api plugin.API, entry *badgesmodel.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	appErr := api.KVSet(getAuditEntryKey(entry), data)
+	if appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+type auditQuery struct {
+	ActorID  string
+	UserID   string
+	TargetID string
+	Action   badgesmodel.AuditAction
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func (q auditQuery) matches(entry *badgesmodel.AuditEntry) bool {
+	return (q.ActorID == "" || entry.ActorID == q.ActorID) &&
+		(q.UserID == "" || entry.UserID == q.UserID) &&
+		(q.TargetID == "" || entry.TargetID == q.TargetID) &&
+		(q.Action == "" || entry.Action == q.Action) &&
+		!entry.Time.Before(q.Since) &&
+		!entry.Time.After(q.Until)
+}
+
+// queryAuditLog returns the matching entries, newest first. Since and Until
+// default to the last AuditDefaultQueryDays days, and Limit to AuditDefaultLimit.
+func queryAuditLog(api plugin.API, q auditQuery) ([]*badgesmodel.AuditEntry, error) {
+	if q.Until.IsZero() {
+		q.Until = time.Now()
+	}
+	if q.Since.IsZero() {
+		q.Since = q.Until.AddDate(0, 0, -AuditDefaultQueryDays)
+	}
+	if q.Limit <= 0 || q.Limit > AuditMaxLimit {
+		q.Limit = AuditDefaultLimit
+	}
+
+	keys, err := listKVKeys(api, KVKeyAuditPrefix)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	out := []*badgesmodel.AuditEntry{}
+	firstDay := getAuditKey(q.Since)
+	lastDay := getAuditKey(q.Until)
+	for _, key := range keys {
+		if len(key) < len(lastDay) {
+			continue
+		}
+		day := key[:len(lastDay)]
+		if day > lastDay {
+			continue
+		}
+		if day < firstDay {
+			break
+		}
+
+		entries, err := getAuditEntries(api, key)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !q.matches(entries[i]) {
+				continue
+			}
+			out = append(out, entries[i])
+			if len(out) == q.Limit {
+				return out, nil
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// pruneAuditLog deletes the entries of the days older than the retention. It
+// returns how many keys were deleted.
+func pruneAuditLog(api plugin.API, retentionDays int, now time.Time) (int, error) {
+	keys, err := listKVKeys(api, KVKeyAuditPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	oldestKept := getAuditKey(now.AddDate(0, 0, -retentionDays))
+	deleted := 0
+	for _, key := range keys {
+		if key >= oldestKept {
+			continue
+		}
+		appErr := api.KVDelete(key)
+		if appErr != nil {
+			return deleted, appErr
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (p *Plugin) runAuditRetention() {
+	days := p.getConfiguration().getAuditRetentionDays()
+	if days == 0 {
+		return
+	}
+
+	deleted, err := pruneAuditLog(p.API, days, time.Now())
+	if err != nil {
+		p.mm.Log.Warn("Cannot prune the audit log", "err", err)
+		return
+	}
+
+	if deleted > 0 {
+		p.mm.Log.Info("Pruned the audit log", "keys", deleted)
+	}
+}
+
+// auditChanges describes the top level fields that differ between Before and
+// After, like "name: "old" -> "new"". The version fields are left out, as the
+// entry already tells who and when.
+func auditChanges(entry *badgesmodel.AuditEntry) []string {
+	return jsonChanges(entry.Before, entry.After, versionFields...)
+}
+
+// jsonChanges describes the top level fields that differ between two JSON
+// objects, leaving out the ignored ones.
+func jsonChanges(beforeData, afterData json.RawMessage, ignored ...string) []string {
+	before := map[string]json.RawMessage{}
+	after := map[string]json.RawMessage{}
+	_ = json.Unmarshal(beforeData, &before)
+	_ = json.Unmarshal(afterData, &after)
+
+	fields := map[string]bool{}
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+	for _, field := range ignored {
+		delete(fields, field)
+	}
+
+	sorted := []string{}
+	for field := range fields {
+		sorted = append(sorted, field)
+	}
+	sort.Strings(sorted)
+
+	changes := []string{}
+	for _, field := range sorted {
+		old, hadOld := before[field]
+		current, hasCurrent := after[field]
+		switch {
+		case !hadOld:
+			changes = append(changes, fmt.Sprintf("%s: %s", field, current))
+		case !hasCurrent:
+			changes = append(changes, fmt.Sprintf("%s: %s -> removed", field, old))
+		case !bytes.Equal(old, current):
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", field, old, current))
+		}
+	}
+
+	return changes
+}
+
+func newAuditEntry(actorID string, action badgesmodel.AuditAction, targetID string) *badgesmodel.AuditEntry {
+	return &badgesmodel.AuditEntry{
+		ID:       model.NewId(),
+		Time:     time.Now(),
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditStore(t *testing.T) {
+	api := newFakeAPI()
+	actorID := model.NewId()
+	s := NewAuditStore(NewStore(api), api, actorID)
+
+	badgeType := addTestType(t, s)
+	badge := addTestBadge(t, s, badgeType.ID, false)
+	badge.Description = "new description"
+	require.NoError(t, s.UpdateBadge(badge))
+
+	userID := model.NewId()
+	_, err := s.GrantBadge(badge.ID, userID, actorID, "well done")
+	require.NoError(t, err)
+	granted, err := s.GrantBadge(badge.ID, userID, actorID, "")
+	require.NoError(t, err)
+	require.Nil(t, granted)
+	_, err = s.RevokeBadge(badge.ID, userID, false)
+	require.NoError(t, err)
+
+	entries, err := queryAuditLog(api, auditQuery{})
+	require.NoError(t, err)
+	actions := []badgesmodel.AuditAction{}
+	for _, entry := range entries {
+		assert.Equal(t, actorID, entry.ActorID)
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []badgesmodel.AuditAction{
+		badgesmodel.AuditActionRevoke,
+		badgesmodel.AuditActionGrant,
+		badgesmodel.AuditActionUpdateBadge,
+		badgesmodel.AuditActionCreateBadge,
+		badgesmodel.AuditActionCreateType,
+	}, actions, "the refused grant is not recorded")
+
+	assert.Equal(t, []string{`description: "" -> "new description"`}, auditChanges(entries[2]))
+
+	t.Run("filters", func(t *testing.T) {
+		entries, err := queryAuditLog(api, auditQuery{UserID: userID})
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+
+		entries, err = queryAuditLog(api, auditQuery{TargetID: string(badge.ID), Action: badgesmodel.AuditActionUpdateBadge})
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+
+		entries, err = queryAuditLog(api, auditQuery{ActorID: model.NewId()})
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+
+		entries, err = queryAuditLog(api, auditQuery{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+		assert.Equal(t, badgesmodel.AuditActionRevoke, entries[0].Action)
+	})
+
+	t.Run("ensured badges are only recorded when created", func(t *testing.T) {
+		toEnsure := []*badgesmodel.Badge{{Name: "plugin badge", Image: "smile", ImageType: badgesmodel.ImageTypeEmoji}}
+		botID := model.NewId()
+		_, err := s.EnsureBadges(toEnsure, "plugin", botID)
+		require.NoError(t, err)
+		_, err = s.EnsureBadges(toEnsure, "plugin", botID)
+		require.NoError(t, err)
+
+		entries, err := queryAuditLog(api, auditQuery{Action: badgesmodel.AuditActionCreateBadge})
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+		entries, err = queryAuditLog(api, auditQuery{Action: badgesmodel.AuditActionCreateType})
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+	})
+}
+
+func TestQueryAndPruneAuditLog(t *testing.T) {
+	api := newFakeAPI()
+	now := time.Now()
+	for days := 0; days < 5; days++ {
+		entry := newAuditEntry(model.NewId(), badgesmodel.AuditActionGrant, model.NewId())
+		entry.Time = now.AddDate(0, 0, -days*10)
+		require.NoError(t, appendAuditEntry(api, entry))
+	}
+
+	entries, err := queryAuditLog(api, auditQuery{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "only the last 30 days by default")
+
+	entries, err = queryAuditLog(api, auditQuery{Since: now.AddDate(0, 0, -45), Until: now.AddDate(0, 0, -15)})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.True(t, entries[0].Time.After(entries[1].Time))
+
+	deleted, err := pruneAuditLog(api, 25, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+
+	entries, err = queryAuditLog(api, auditQuery{Since: now.AddDate(0, 0, -100)})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestConcurrentAuditEntries(t *testing.T) {
+	api := newFakeAPI()
+	const count = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entry := newAuditEntry(model.NewId(), badgesmodel.AuditActionGrant, model.NewId())
+			assert.NoError(t, appendAuditEntry(api, entry))
+		}()
+	}
+	wg.Wait()
+
+	entries, err := queryAuditLog(api, auditQuery{Limit: AuditMaxLimit})
+	require.NoError(t, err)
+	require.Len(t, entries, count, "no entry is lost")
+	for i := 1; i < len(entries); i++ {
+		assert.False(t, entries[i].Time.After(entries[i-1].Time), "newest first")
+	}
+}
+
+func TestLegacyAuditDays(t *testing.T) {
+	api := newFakeAPI()
+	now := time.Now()
+	legacy := []*badgesmodel.AuditEntry{
+		newAuditEntry(model.NewId(), badgesmodel.AuditActionGrant, model.NewId()),
+		newAuditEntry(model.NewId(), badgesmodel.AuditActionRevoke, model.NewId()),
+	}
+	legacy[0].Time = now.Add(-2 * time.Second)
+	legacy[1].Time = now.Add(-time.Second)
+	data, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.Nil(t, api.KVSet(getAuditKey(now), data))
+
+	entry := newAuditEntry(model.NewId(), badgesmodel.AuditActionGrant, model.NewId())
+	require.NoError(t, appendAuditEntry(api, entry))
+
+	entries, err := queryAuditLog(api, auditQuery{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, entry.ID, entries[0].ID)
+	assert.Equal(t, legacy[1].ID, entries[1].ID)
+	assert.Equal(t, legacy[0].ID, entries[2].ID)
+}
+
+func TestAuditChanges(t *testing.T) {
+	before, _ := json.Marshal(map[string]interface{}{"name": "old", "multiple": false, "gone": 1})
+	after, _ := json.Marshal(map[string]interface{}{"name": "new", "multiple": false, "added": "x"})
+	changes := auditChanges(&badgesmodel.AuditEntry{Before: before, After: after})
+	assert.Equal(t, []string{
+		`added: "x"`,
+		`gone: 1 -> removed`,
+		`name: "old" -> "new"`,
+	}, changes)
+}
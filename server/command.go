@@ -3,6 +3,8 @@ package main
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
 	commandparser "github.com/larkox/mattermost-plugin-badges/server/command_parser"
@@ -61,12 +63,26 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		handler = p.runClean
 	case "grant":
 		handler = p.runGrant
+	case "revoke":
+		handler = p.runRevoke
+	case "renew":
+		handler = p.runRenew
+	case "search":
+		handler = p.runSearch
+	case "leaderboard":
+		handler = p.runLeaderboard
 	case "edit":
 		handler = p.runEdit
+	case "restore":
+		handler = p.runRestore
+	case "purge":
+		handler = p.runPurge
 	case "create":
 		handler = p.runCreate
 	case "subscription":
 		handler = p.runSubscription
+	case "admin":
+		handler = p.runAdmin
 	default:
 		p.postCommandResponse(args, getHelp())
 		return &model.CommandResponse{}, nil
@@ -96,8 +112,18 @@ func (p *Plugin) runClean(args []string, extra *model.CommandArgs) (bool, *model
 	if !user.IsSystemAdmin() {
 		return false, &model.CommandResponse{Text: "Only a system admin can clean the badges database."}, nil
 	}
-	_ = p.mm.KV.DeleteAll()
-	return false, &model.CommandResponse{Text: "Clean"}, nil
+
+	info, err := takeSnapshot(p.API, p.store, "Clean", SnapshotsToKeep)
+	if err != nil {
+		return commandError("Cannot take a snapshot before cleaning: " + err.Error())
+	}
+
+	err = p.storeAs(extra.UserId).Restore(&StoreDump{})
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	return false, &model.CommandResponse{Text: fmt.Sprintf("Clean. Run `/badges admin snapshots restore %s` to undo.", info.ID)}, nil
 }
 
 func (p *Plugin) runCreate(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
@@ -163,12 +189,22 @@ func (p *Plugin) runCreateBadge(args []string, extra *model.CommandArgs) (bool,
 					Name:        DialogFieldBadgeDescription,
 					MaxLength:   badgesmodel.DescriptionMaxLength,
 				},
+				{
+					DisplayName: "Tags",
+					Type:        "text",
+					Name:        DialogFieldBadgeTags,
+					HelpText:    "Words to find the badge by, separated by commas",
+					Placeholder: "onboarding, sales",
+					Optional:    true,
+				},
 				{
 					DisplayName: "Image",
 					Type:        "text",
 					Name:        DialogFieldBadgeImage,
-					HelpText:    "Insert a emoticon name",
+					HelpText:    "Insert a emoticon name, or leave empty to use an uploaded image",
+					Optional:    true,
 				},
+				p.getImageFileElement(extra, nil),
 				{
 					DisplayName: "Type",
 					Type:        "select",
@@ -182,6 +218,23 @@ func (p *Plugin) runCreateBadge(args []string, extra *model.CommandArgs) (bool,
 					HelpText:    "Whether the badge can be granted multiple times",
 					Optional:    true,
 				},
+				{
+					DisplayName: "Validity (days)",
+					Type:        "text",
+					SubType:     "number",
+					Name:        DialogFieldBadgeValidityDays,
+					HelpText:    "How many days the badge lasts once granted. Leave empty for badges that never expire.",
+					Optional:    true,
+				},
+				{
+					DisplayName: "Points",
+					Type:        "text",
+					SubType:     "number",
+					Name:        DialogFieldBadgePoints,
+					HelpText:    "What each grant of the badge is worth on leaderboards. Leave empty to use the default of the type.",
+					Optional:    true,
+				},
+				getCoOwnersElement(""),
 			},
 		},
 	})
@@ -216,6 +269,140 @@ func (p *Plugin) runEdit(args []string, extra *model.CommandArgs) (bool, *model.
 	return handler(restOfArgs, extra)
 }
 
+func (p *Plugin) runRestore(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	if len(args) == 0 {
+		return false, &model.CommandResponse{Text: "Specify what you want to restore."}, nil
+	}
+
+	switch args[0] {
+	case "badge":
+		return p.runArchiveBadge(args[1:], extra, false)
+	case "type":
+		return p.runArchiveType(args[1:], extra, false)
+	default:
+		return false, &model.CommandResponse{Text: "You can restore either badge or type"}, nil
+	}
+}
+
+func (p *Plugin) runPurge(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	if len(args) == 0 {
+		return false, &model.CommandResponse{Text: "Specify what you want to purge."}, nil
+	}
+
+	switch args[0] {
+	case "badge":
+		return p.runArchiveBadge(args[1:], extra, true)
+	case "type":
+		return p.runArchiveType(args[1:], extra, true)
+	default:
+		return false, &model.CommandResponse{Text: "You can purge either badge or type"}, nil
+	}
+}
+
+// runArchiveBadge restores an archived badge, or removes it permanently if purge
+// is set.
+func (p *Plugin) runArchiveBadge(args []string, extra *model.CommandArgs, purge bool) (bool, *model.CommandResponse, error) {
+	u, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	var badgeIDStr string
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&badgeIDStr, "id", "", "ID of the badge")
+	if err = fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	if badgeIDStr == "" {
+		return commandError("You must set the badge ID")
+	}
+
+	badge, err := p.store.GetBadge(badgesmodel.BadgeID(badgeIDStr))
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if decision := p.decideEditStoredBadge(u, badge); !decision.Allowed {
+		return commandError(decision.deniedMessage("you cannot edit this badge"))
+	}
+
+	if !badge.Archived {
+		return commandError("This badge is not archived. Archive it first from the edit dialog.")
+	}
+
+	if purge {
+		err = p.storeAs(extra.UserId).DeleteBadge(badge.ID)
+		if err != nil {
+			return commandError(err.Error())
+		}
+		p.postCommandResponse(extra, fmt.Sprintf("Badge `%s` removed permanently, along with every grant.", badge.Name))
+		return false, &model.CommandResponse{}, nil
+	}
+
+	badge.Archived = false
+	badge.UpdatedBy = extra.UserId
+	err = p.storeAs(extra.UserId).UpdateBadge(badge)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	p.postCommandResponse(extra, fmt.Sprintf("Badge `%s` restored.", badge.Name))
+	return false, &model.CommandResponse{}, nil
+}
+
+// runArchiveType restores an archived type, or removes it permanently with its
+// badges if purge is set.
+func (p *Plugin) runArchiveType(args []string, extra *model.CommandArgs, purge bool) (bool, *model.CommandResponse, error) {
+	u, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	var typeIDStr string
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&typeIDStr, "type", "", "ID of the type")
+	if err = fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	if typeIDStr == "" {
+		return commandError("You must set the type ID")
+	}
+
+	badgeType, err := p.store.GetType(badgesmodel.BadgeType(typeIDStr))
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if decision := p.decideManageType(u, badgeType); !decision.Allowed {
+		return commandError(decision.deniedMessage("you cannot edit this type"))
+	}
+
+	if !badgeType.Archived {
+		return commandError("This type is not archived. Archive it first from the edit dialog.")
+	}
+
+	if purge {
+		err = p.storeAs(extra.UserId).DeleteType(badgeType.ID)
+		if err != nil {
+			return commandError(err.Error())
+		}
+		p.postCommandResponse(extra, fmt.Sprintf("Type `%s` removed permanently, along with its badges and their grants.", badgeType.Name))
+		return false, &model.CommandResponse{}, nil
+	}
+
+	badgeType.Archived = false
+	badgeType.UpdatedBy = extra.UserId
+	err = p.storeAs(extra.UserId).UpdateType(badgeType)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	p.postCommandResponse(extra, fmt.Sprintf("Type `%s` restored.", badgeType.Name))
+	return false, &model.CommandResponse{}, nil
+}
+
 func (p *Plugin) runEditBadge(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
 	u, err := p.mm.User.Get(extra.UserId)
 	if err != nil {
@@ -238,8 +425,8 @@ func (p *Plugin) runEditBadge(args []string, extra *model.CommandArgs) (bool, *m
 		return commandError(err.Error())
 	}
 
-	if !canEditBadge(u, p.badgeAdminUserID, badge) {
-		return commandError("you cannot edit this badge")
+	if decision := p.decideEditStoredBadge(u, badge); !decision.Allowed {
+		return commandError(decision.deniedMessage("you cannot edit this badge"))
 	}
 
 	typeSuggestions, err := p.filterCreateBadgeTypes(u)
@@ -257,6 +444,11 @@ func (p *Plugin) runEditBadge(args []string, extra *model.CommandArgs) (bool, *m
 		return commandError("You cannot create badges from any type.")
 	}
 
+	imageText := badge.Image
+	if badge.ImageType == badgesmodel.ImageTypeUpload {
+		imageText = ""
+	}
+
 	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
 		TriggerId: extra.TriggerId,
 		URL:       p.getDialogURL() + DialogPathEditBadge,
@@ -279,13 +471,24 @@ func (p *Plugin) runEditBadge(args []string, extra *model.CommandArgs) (bool, *m
 					MaxLength:   badgesmodel.DescriptionMaxLength,
 					Default:     badge.Description,
 				},
+				{
+					DisplayName: "Tags",
+					Type:        "text",
+					Name:        DialogFieldBadgeTags,
+					HelpText:    "Words to find the badge by, separated by commas",
+					Placeholder: "onboarding, sales",
+					Optional:    true,
+					Default:     strings.Join(badge.Tags, ", "),
+				},
 				{
 					DisplayName: "Image",
 					Type:        "text",
 					Name:        DialogFieldBadgeImage,
-					HelpText:    "Insert a emoticon name",
-					Default:     badge.Image,
+					HelpText:    "Insert a emoticon name, or leave empty to use an uploaded image",
+					Optional:    true,
+					Default:     imageText,
 				},
+				p.getImageFileElement(extra, badge),
 				{
 					DisplayName: "Type",
 					Type:        "select",
@@ -302,10 +505,29 @@ func (p *Plugin) runEditBadge(args []string, extra *model.CommandArgs) (bool, *m
 					Default:     getBooleanString(badge.Multiple),
 				},
 				{
-					DisplayName: "Delete badge",
+					DisplayName: "Validity (days)",
+					Type:        "text",
+					SubType:     "number",
+					Name:        DialogFieldBadgeValidityDays,
+					HelpText:    "How many days the badge lasts once granted. Leave empty for badges that never expire. Changes only apply to new grants and renewals.",
+					Optional:    true,
+					Default:     getValidityDaysString(badge.ValidityDays),
+				},
+				{
+					DisplayName: "Points",
+					Type:        "text",
+					SubType:     "number",
+					Name:        DialogFieldBadgePoints,
+					HelpText:    "What each grant of the badge is worth on leaderboards. Leave empty to use the default of the type.",
+					Optional:    true,
+					Default:     getPointsString(badge.Points),
+				},
+				getCoOwnersElement(p.formatCoOwners(badge.CoOwners)),
+				{
+					DisplayName: "Archive badge",
 					Type:        "bool",
-					Name:        DialogFieldBadgeDelete,
-					HelpText:    "WARNING: Checking this will remove this badge permanently.",
+					Name:        DialogFieldBadgeArchive,
+					HelpText:    "Archived badges cannot be granted, but stay on the profiles of the users who have them. They can be restored or purged later.",
 					Optional:    true,
 				},
 			},
@@ -319,16 +541,73 @@ func (p *Plugin) runEditBadge(args []string, extra *model.CommandArgs) (bool, *m
 	return false, &model.CommandResponse{}, nil
 }
 
+// getCoOwnersElement is the badge dialog field to share the badge with other
+// users, who can then edit, grant and revoke it like its creator.
+func getCoOwnersElement(current string) model.DialogElement {
+	return model.DialogElement{
+		DisplayName: "Co-owners",
+		Type:        "text",
+		Name:        DialogFieldBadgeCoOwners,
+		HelpText:    fmt.Sprintf("Usernames separated by comma (,) of up to %d users who can edit, grant and revoke this badge like its creator", badgesmodel.MaxCoOwners),
+		Placeholder: "user-1, user-2",
+		Optional:    true,
+		Default:     current,
+	}
+}
+
+// getImageFileElement is the badge dialog field to pick an uploaded image from
+// the files the user posted on the channel.
+func (p *Plugin) getImageFileElement(extra *model.CommandArgs, badge *badgesmodel.Badge) model.DialogElement {
+	current := ""
+	if badge != nil && badge.ImageType == badgesmodel.ImageTypeUpload {
+		current = badge.Image
+	}
+
+	return model.DialogElement{
+		DisplayName: "Uploaded image",
+		Type:        "select",
+		Name:        DialogFieldBadgeImageFile,
+		HelpText:    fmt.Sprintf("A PNG or SVG file of up to %d KB you posted on this channel. Post it first to see it here.", ImageMaxSize/1024),
+		Options:     p.getImageFileOptions(extra.UserId, extra.ChannelId, current),
+		Optional:    true,
+		Default:     current,
+	}
+}
+
+func getFrameShapeOptions() []*model.PostActionOptions {
+	return []*model.PostActionOptions{
+		{Text: "Circle", Value: badgesmodel.FrameShapeCircle},
+		{Text: "Square", Value: badgesmodel.FrameShapeSquare},
+		{Text: "Rounded square", Value: badgesmodel.FrameShapeRounded},
+		{Text: "Hexagon", Value: badgesmodel.FrameShapeHexagon},
+	}
+}
+
+// getFrameImageElement is the type dialog field to pick an uploaded image as the
+// frame, like getImageFileElement.
+func (p *Plugin) getFrameImageElement(extra *model.CommandArgs, frame *typeFrame) model.DialogElement {
+	current := ""
+	if frame != nil {
+		current = frame.ImageID
+	}
+
+	return model.DialogElement{
+		DisplayName: "Frame image",
+		Type:        "select",
+		Name:        DialogFieldTypeFrameImage,
+		HelpText:    fmt.Sprintf("A PNG or SVG file of up to %d KB you posted on this channel, drawn over the badge images instead of a shape. Leave transparent space in the middle.", ImageMaxSize/1024),
+		Options:     p.getImageFileOptions(extra.UserId, extra.ChannelId, current),
+		Optional:    true,
+		Default:     current,
+	}
+}
+
 func (p *Plugin) runEditType(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
 	u, err := p.mm.User.Get(extra.UserId)
 	if err != nil {
 		return commandError(err.Error())
 	}
 
-	if !canCreateType(u, p.badgeAdminUserID, false) {
-		return commandError("You have no permissions to edit a badge type.")
-	}
-
 	var badgeTypeStr string
 	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
 	fs.StringVar(&badgeTypeStr, "type", "", "ID of the type")
@@ -345,45 +624,79 @@ func (p *Plugin) runEditType(args []string, extra *model.CommandArgs) (bool, *mo
 		return commandError(err.Error())
 	}
 
-	if !canEditType(u, p.badgeAdminUserID, typeDefinition) {
-		return commandError("you cannot edit this type")
+	if decision := p.decideManageType(u, typeDefinition); !decision.Allowed {
+		return commandError(decision.deniedMessage("you cannot edit this type"))
 	}
 
-	canGrantAllowList := ""
-	for uID, allowed := range typeDefinition.CanGrant.AllowList {
-		if !allowed {
-			continue
-		}
-		var allowedUser *model.User
-		allowedUser, err = p.mm.User.Get(uID)
-		if err != nil {
-			continue
-		}
-		if canGrantAllowList == "" {
-			canGrantAllowList += allowedUser.Username
-			continue
-		}
-
-		canGrantAllowList += ", " + allowedUser.Username
+	frame, _ := parseFrame(typeDefinition.Frame)
+	frameShape, frameColour := "", ""
+	if frame != nil && frame.Shape != "" {
+		frameShape, frameColour = frame.Shape, frame.Colour
 	}
 
-	canCreateAllowList := ""
-	for uID, allowed := range typeDefinition.CanCreate.AllowList {
-		if !allowed {
-			continue
-		}
-		var allowedUser *model.User
-		allowedUser, err = p.mm.User.Get(uID)
-		if err != nil {
-			continue
-		}
-		if canCreateAllowList == "" {
-			canCreateAllowList += allowedUser.Username
-			continue
-		}
-
-		canCreateAllowList += ", " + allowedUser.Username
+	elements := []model.DialogElement{
+		{
+			DisplayName: "Name",
+			Type:        "text",
+			Name:        DialogFieldTypeName,
+			MaxLength:   badgesmodel.NameMaxLength,
+			Default:     typeDefinition.Name,
+		},
+		{
+			DisplayName: "Frame shape",
+			Type:        "select",
+			Name:        DialogFieldTypeFrameShape,
+			HelpText:    "The shape of the frame drawn around the images of the badges of this type",
+			Options:     getFrameShapeOptions(),
+			Optional:    true,
+			Default:     frameShape,
+		},
+		{
+			DisplayName: "Frame colour",
+			Type:        "text",
+			Name:        DialogFieldTypeFrameColour,
+			HelpText:    "The colour of the frame, like " + DefaultFrameColour,
+			Placeholder: DefaultFrameColour,
+			Optional:    true,
+			Default:     frameColour,
+		},
+		p.getFrameImageElement(extra, frame),
+		{
+			DisplayName: "Default points",
+			Type:        "text",
+			SubType:     "number",
+			Name:        DialogFieldTypeDefaultPoints,
+			HelpText:    fmt.Sprintf("What each grant of a badge of this type is worth on leaderboards, unless the badge sets its own points. Leave empty for %d.", badgesmodel.DefaultPoints),
+			Optional:    true,
+			Default:     getPointsString(typeDefinition.DefaultPoints),
+		},
+		{
+			DisplayName: "Scope",
+			Type:        "select",
+			Name:        DialogFieldTypeScope,
+			HelpText:    "Who can create, grant and receive badges of this type",
+			Options:     getTypeScopeOptions(p.getTypeScopes(u, extra.TeamId, extra.ChannelId, typeDefinition)),
+			Default:     getTypeScopeValue(typeDefinition),
+		},
+		{
+			DisplayName: "Announceable",
+			Type:        "bool",
+			Name:        DialogFieldTypeAnnounceable,
+			HelpText:    "Whether channel and team admins can subscribe their channels to the grants of badges of this type",
+			Optional:    true,
+			Default:     getBooleanString(typeDefinition.Announceable),
+		},
 	}
+	elements = append(elements, p.getSchemeDialogElements(createSchemeFields, &typeDefinition.CanCreate)...)
+	elements = append(elements, p.getSchemeDialogElements(grantSchemeFields, &typeDefinition.CanGrant)...)
+	elements = append(elements, p.getSchemeDialogElements(editSchemeFields, &typeDefinition.CanEdit)...)
+	elements = append(elements, model.DialogElement{
+		DisplayName: "Archive type",
+		Type:        "bool",
+		Name:        DialogFieldTypeArchive,
+		HelpText:    "Badges of archived types cannot be created nor granted, but stay on the profiles of the users who have them. The type can be restored or purged later.",
+		Optional:    true,
+	})
 
 	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
 		TriggerId: extra.TriggerId,
@@ -392,56 +705,7 @@ func (p *Plugin) runEditType(args []string, extra *model.CommandArgs) (bool, *mo
 			Title:       "Edit type",
 			SubmitLabel: "Edit",
 			State:       badgeTypeStr,
-			Elements: []model.DialogElement{
-				{
-					DisplayName: "Name",
-					Type:        "text",
-					Name:        DialogFieldTypeName,
-					MaxLength:   badgesmodel.NameMaxLength,
-					Default:     typeDefinition.Name,
-				},
-				{
-					DisplayName: "Everyone can create badge",
-					Type:        "bool",
-					Name:        DialogFieldTypeEveryoneCanCreate,
-					HelpText:    "Whether any user can create a badge of this type",
-					Optional:    true,
-					Default:     getBooleanString(typeDefinition.CanCreate.Everyone),
-				},
-				{
-					DisplayName: "Can create allowlist",
-					Type:        "text",
-					Name:        DialogFieldTypeAllowlistCanCreate,
-					HelpText:    "Fill the usernames separated by comma (,) of the people that can create badges of this type.",
-					Placeholder: "user-1, user-2, user-3",
-					Optional:    true,
-					Default:     canCreateAllowList,
-				},
-				{
-					DisplayName: "Everyone can grant badge",
-					Type:        "bool",
-					Name:        DialogFieldTypeEveryoneCanGrant,
-					HelpText:    "Whether any user can grant a badge of this type",
-					Optional:    true,
-					Default:     getBooleanString(typeDefinition.CanGrant.Everyone),
-				},
-				{
-					DisplayName: "Can grant allowlist",
-					Type:        "text",
-					Name:        DialogFieldTypeAllowlistCanGrant,
-					HelpText:    "Fill the usernames separated by comma (,) of the people that can grant badges of this type.",
-					Placeholder: "user-1, user-2, user-3",
-					Optional:    true,
-					Default:     canGrantAllowList,
-				},
-				{
-					DisplayName: "Remove type",
-					Type:        "bool",
-					Name:        DialogFieldTypeDelete,
-					HelpText:    "WARNING: checking this will remove this type and all associated badges permanently.",
-					Optional:    true,
-				},
-			},
+			Elements:    elements,
 		},
 	})
 
@@ -458,54 +722,74 @@ func (p *Plugin) runCreateType(args []string, extra *model.CommandArgs) (bool, *
 		return commandError(err.Error())
 	}
 
-	if !canCreateType(u, p.badgeAdminUserID, false) {
+	if !p.canCreateTypeOnTeam(u, extra.TeamId) {
+		return commandError("You have no permissions to create a badge type.")
+	}
+
+	scopeOptions := getTypeScopeOptions(p.getTypeScopes(u, extra.TeamId, extra.ChannelId, nil))
+	if len(scopeOptions) == 0 {
 		return commandError("You have no permissions to create a badge type.")
 	}
 
+	elements := []model.DialogElement{
+		{
+			DisplayName: "Name",
+			Type:        "text",
+			Name:        DialogFieldTypeName,
+			MaxLength:   badgesmodel.NameMaxLength,
+		},
+		{
+			DisplayName: "Frame shape",
+			Type:        "select",
+			Name:        DialogFieldTypeFrameShape,
+			HelpText:    "The shape of the frame drawn around the images of the badges of this type",
+			Options:     getFrameShapeOptions(),
+			Optional:    true,
+		},
+		{
+			DisplayName: "Frame colour",
+			Type:        "text",
+			Name:        DialogFieldTypeFrameColour,
+			HelpText:    "The colour of the frame, like " + DefaultFrameColour,
+			Placeholder: DefaultFrameColour,
+			Optional:    true,
+		},
+		p.getFrameImageElement(extra, nil),
+		{
+			DisplayName: "Default points",
+			Type:        "text",
+			SubType:     "number",
+			Name:        DialogFieldTypeDefaultPoints,
+			HelpText:    fmt.Sprintf("What each grant of a badge of this type is worth on leaderboards, unless the badge sets its own points. Leave empty for %d.", badgesmodel.DefaultPoints),
+			Optional:    true,
+		},
+		{
+			DisplayName: "Scope",
+			Type:        "select",
+			Name:        DialogFieldTypeScope,
+			HelpText:    "Who can create, grant and receive badges of this type",
+			Options:     scopeOptions,
+			Default:     scopeOptions[0].Value,
+		},
+		{
+			DisplayName: "Announceable",
+			Type:        "bool",
+			Name:        DialogFieldTypeAnnounceable,
+			HelpText:    "Whether channel and team admins can subscribe their channels to the grants of badges of this type",
+			Optional:    true,
+		},
+	}
+	elements = append(elements, p.getSchemeDialogElements(createSchemeFields, nil)...)
+	elements = append(elements, p.getSchemeDialogElements(grantSchemeFields, nil)...)
+	elements = append(elements, p.getSchemeDialogElements(editSchemeFields, nil)...)
+
 	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
 		TriggerId: extra.TriggerId,
 		URL:       p.getDialogURL() + DialogPathCreateType,
 		Dialog: model.Dialog{
 			Title:       "Create type",
 			SubmitLabel: "Create",
-			Elements: []model.DialogElement{
-				{
-					DisplayName: "Name",
-					Type:        "text",
-					Name:        DialogFieldTypeName,
-					MaxLength:   badgesmodel.NameMaxLength,
-				},
-				{
-					DisplayName: "Everyone can create badge",
-					Type:        "bool",
-					Name:        DialogFieldTypeEveryoneCanCreate,
-					HelpText:    "Whether any user can create a badge of this type",
-					Optional:    true,
-				},
-				{
-					DisplayName: "Can create allowlist",
-					Type:        "text",
-					Name:        DialogFieldTypeAllowlistCanCreate,
-					HelpText:    "Fill the usernames separated by comma (,) of the people that can create badges of this type.",
-					Placeholder: "user-1, user-2, user-3",
-					Optional:    true,
-				},
-				{
-					DisplayName: "Everyone can grant badge",
-					Type:        "bool",
-					Name:        DialogFieldTypeEveryoneCanGrant,
-					HelpText:    "Whether any user can grant a badge of this type",
-					Optional:    true,
-				},
-				{
-					DisplayName: "Can grant allowlist",
-					Type:        "text",
-					Name:        DialogFieldTypeAllowlistCanGrant,
-					HelpText:    "Fill the usernames separated by comma (,) of the people that can grant badges of this type.",
-					Placeholder: "user-1, user-2, user-3",
-					Optional:    true,
-				},
-			},
+			Elements:    elements,
 		},
 	})
 
@@ -546,8 +830,12 @@ func (p *Plugin) runGrant(args []string, extra *model.CommandArgs) (bool, *model
 			return commandError(err.Error())
 		}
 
-		if !canGrantBadge(granter, p.badgeAdminUserID, badge, badgeType) {
-			return commandError("you have no permissions to grant this badge")
+		if badge.Archived || badgeType.Archived {
+			return commandError("this badge is archived")
+		}
+
+		if decision := p.decideGrantScopedBadge(granter, badge, badgeType); !decision.Allowed {
+			return commandError(decision.deniedMessage("you have no permissions to grant this badge"))
 		}
 
 		user, err := p.mm.User.GetByUsername(username)
@@ -555,16 +843,22 @@ func (p *Plugin) runGrant(args []string, extra *model.CommandArgs) (bool, *model
 			return commandError(err.Error())
 		}
 
-		shouldNotify, err := p.store.GrantBadge(badgesmodel.BadgeID(badgeStr), user.Id, extra.UserId, "")
+		if err = p.checkGrantScope(user, badgeType); err != nil {
+			return commandError(err.Error())
+		}
+
+		granted, err := p.storeAs(extra.UserId).GrantBadge(badgesmodel.BadgeID(badgeStr), user.Id, extra.UserId, "")
 		if err != nil {
 			return commandError(err.Error())
 		}
 
-		if shouldNotify {
-			p.notifyGrant(badgesmodel.BadgeID(badgeStr), extra.UserId, user, false, "", "")
+		if granted == nil {
+			p.postCommandResponse(extra, "The user already has this badge")
+			return false, &model.CommandResponse{}, nil
 		}
 
-		p.postCommandResponse(extra, "Granted")
+		p.notifyGrant(badgesmodel.BadgeID(badgeStr), extra.UserId, user, false, "", "")
+		p.postCommandResponse(extra, fmt.Sprintf("Granted. Grant ID: `%s`", granted.GrantID))
 		return false, &model.CommandResponse{}, nil
 	}
 
@@ -669,160 +963,1086 @@ func (p *Plugin) runGrant(args []string, extra *model.CommandArgs) (bool, *model
 	return false, &model.CommandResponse{}, nil
 }
 
-func (p *Plugin) runSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
-	lengthOfArgs := len(args)
-	restOfArgs := []string{}
-	var handler func([]string, *model.CommandArgs) (bool, *model.CommandResponse, error)
-	if lengthOfArgs == 0 {
-		return false, &model.CommandResponse{Text: "Specify what you want to do."}, nil
+func (p *Plugin) runRenew(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	badgeStr := ""
+	username := ""
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&badgeStr, "badge", "", "ID of the badge")
+	fs.StringVar(&username, "user", "", "Username to renew the badge of")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
 	}
+
+	if username == "" || badgeStr == "" {
+		return commandError("both --user and --badge are required")
+	}
+
+	if username[0] == '@' {
+		username = username[1:]
+	}
+
+	renewer, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	badge, err := p.store.GetBadge(badgesmodel.BadgeID(badgeStr))
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	badgeType, err := p.store.GetType(badge.Type)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if badge.Archived || badgeType.Archived {
+		return commandError("this badge is archived")
+	}
+
+	if decision := p.decideGrantScopedBadge(renewer, badge, badgeType); !decision.Allowed {
+		return commandError(decision.deniedMessage("you have no permissions to renew this badge"))
+	}
+
+	user, err := p.mm.User.GetByUsername(username)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	renewed, err := p.storeAs(extra.UserId).RenewBadge(badge.ID, user.Id)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	p.notifyRenew(renewed, extra.UserId)
+
+	p.postCommandResponse(extra, fmt.Sprintf("Renewed until %s", renewed.ExpiresAt.Format(ExpiryDateFormat)))
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runSearch(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	tag := ""
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&tag, "tag", "", "Tag the badges must have")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	query := strings.Join(fs.Args(), " ")
+	if strings.TrimSpace(query) == "" && tag == "" {
+		return commandError("Set what to search for, or --tag")
+	}
+
+	badges, err := p.store.GetAllBadges()
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	found := searchBadges(badges, query, tag, SearchDefaultLimit)
+	if len(found) == 0 {
+		p.postCommandResponse(extra, "No badges found.")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	text := fmt.Sprintf("Found %d badges:\n", len(found))
+	if len(found) == SearchDefaultLimit {
+		text = fmt.Sprintf("Best %d badges found:\n", len(found))
+	}
+	for _, b := range found {
+		text += fmt.Sprintf("- %s**%s** (`%s`, %s)", p.getBadgeImageText(&b.Badge), b.Name, b.ID, b.TypeName)
+		if b.Description != "" {
+			text += ": " + b.Description
+		}
+		if len(b.Tags) > 0 {
+			text += " _#" + strings.Join(b.Tags, " #") + "_"
+		}
+		text += "\n"
+	}
+
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runLeaderboard(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	var typeStr string
+	var days, limit int
+	var allTeams bool
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&typeStr, "type", "", "Only badges of this type")
+	fs.IntVar(&days, "days", 0, "Only grants of the last days")
+	fs.IntVar(&limit, "limit", LeaderboardDefaultLimit, "How many users to show")
+	fs.BoolVar(&allTeams, "all-teams", false, "Count the users of every team")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	if days < 0 {
+		return commandError("--days must be a positive number")
+	}
+
+	opts := leaderboardOptions{Limit: limit}
+	title := "Badges leaderboard"
+
+	if !allTeams && extra.TeamId != "" {
+		opts.TeamID = extra.TeamId
+		if team, err := p.mm.Team.Get(extra.TeamId); err == nil {
+			title += " of " + team.DisplayName
+		}
+	}
+
+	if typeStr != "" {
+		types, err := p.store.GetRawTypes()
+		if err != nil {
+			return commandError(err.Error())
+		}
+		t := findType(types, typeStr)
+		if t == nil {
+			return commandError("Cannot find type " + typeStr)
+		}
+		opts.TypeID = t.ID
+		title += ", " + t.Name + " badges"
+	}
+
+	if days > 0 {
+		opts.Since = time.Now().AddDate(0, 0, -days)
+		title += fmt.Sprintf(", last %d days", days)
+	}
+
+	if !p.API.HasPermissionToChannel(extra.UserId, extra.ChannelId, model.PERMISSION_CREATE_POST) {
+		return commandError("You don't have permissions to post on this channel.")
+	}
+
+	entries, err := p.computeLeaderboard(opts)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if len(entries) == 0 {
+		p.postCommandResponse(extra, "Nobody has points yet.")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	err = p.mm.Post.CreatePost(&model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: extra.ChannelId,
+		Message:   formatLeaderboard(title, entries),
+	})
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runRevoke(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	badgeStr := ""
+	username := ""
+	reason := ""
+	all := false
+	notify := false
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&badgeStr, "badge", "", "ID of the badge")
+	fs.StringVar(&username, "user", "", "Username to revoke from")
+	fs.StringVar(&reason, "reason", "", "Reason of the revocation")
+	fs.BoolVar(&all, "all", false, "Revoke every grant of the badge instead of only the last one")
+	fs.BoolVar(&notify, "notify", false, "Notify the user about the revocation")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	if username != "" && username[0] == '@' {
+		username = username[1:]
+	}
+
+	if username != "" && badgeStr != "" {
+		revoker, err := p.mm.User.Get(extra.UserId)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		badge, err := p.store.GetBadge(badgesmodel.BadgeID(badgeStr))
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		badgeType, err := p.store.GetType(badge.Type)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		if decision := decideRevokeBadge(revoker, p.getBadgeAdmins(), badge, badgeType); !decision.Allowed {
+			return commandError(decision.deniedMessage("you have no permissions to revoke this badge"))
+		}
+
+		user, err := p.mm.User.GetByUsername(username)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		removed, err := p.storeAs(extra.UserId).RevokeBadge(badge.ID, user.Id, all)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		if removed == 0 {
+			return commandError("the user does not own this badge")
+		}
+
+		if notify {
+			p.notifyRevoke(badge.ID, extra.UserId, user, reason)
+		}
+
+		p.postCommandResponse(extra, "Revoked")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	elements := []model.DialogElement{}
+
+	stateText := ""
+	introductionText := ""
+	if username != "" {
+		user, err := p.mm.User.GetByUsername(username)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		introductionText = "Revoke badge from @" + username
+		stateText = user.Id
+	}
+
+	if stateText == "" {
+		elements = append(elements, model.DialogElement{
+			DisplayName: "User",
+			Type:        "select",
+			Name:        DialogFieldUser,
+			DataSource:  "users",
+		})
+	}
+
+	actingUser, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	options := []*model.PostActionOptions{}
+	revocableBadges, err := p.filterRevokeBadges(actingUser)
+	if err != nil {
+		return commandError(err.Error())
+	}
+	for _, badge := range revocableBadges {
+		options = append(options, &model.PostActionOptions{Text: badge.Name, Value: string(badge.ID)})
+	}
+
+	if len(options) == 0 {
+		return commandError("You cannot revoke any badge.")
+	}
+
+	badgeElement := model.DialogElement{
+		DisplayName: "Badge",
+		Type:        "select",
+		Name:        DialogFieldBadge,
+		Options:     options,
+	}
+
+	if badgeStr != "" {
+		found := false
+		for _, badge := range revocableBadges {
+			if badgeStr == string(badge.ID) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return commandError("You cannot revoke that badge")
+		}
+
+		badgeElement.Default = badgeStr
+	}
+
+	elements = append(elements, badgeElement)
+
+	elements = append(elements, model.DialogElement{
+		DisplayName: "Revoke all grants",
+		Name:        DialogFieldRevokeAll,
+		Type:        "bool",
+		HelpText:    "If the badge was granted several times, remove every grant instead of only the last one.",
+		Optional:    true,
+		Default:     getBooleanString(all),
+	})
+
+	elements = append(elements, model.DialogElement{
+		DisplayName: "Notify the user",
+		Name:        DialogFieldNotifyUser,
+		Type:        "bool",
+		HelpText:    "If you mark this, the bot will send a direct message to the user explaining the revocation.",
+		Optional:    true,
+		Default:     getBooleanString(notify),
+	})
+
+	elements = append(elements, model.DialogElement{
+		DisplayName: "Reason",
+		Name:        DialogFieldRevokeReason,
+		Optional:    true,
+		HelpText:    "Reason why you are revoking this badge. It will only be shown to the user if they are notified.",
+		Type:        "text",
+		Default:     reason,
+	})
+
+	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: extra.TriggerId,
+		URL:       p.getDialogURL() + DialogPathRevoke,
+		Dialog: model.Dialog{
+			Title:            "Revoke badge",
+			IntroductionText: introductionText,
+			SubmitLabel:      "Revoke",
+			Elements:         elements,
+			State:            stateText,
+		},
+	})
+
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	lengthOfArgs := len(args)
+	restOfArgs := []string{}
+	var handler func([]string, *model.CommandArgs) (bool, *model.CommandResponse, error)
+	if lengthOfArgs == 0 {
+		return false, &model.CommandResponse{Text: "Specify what you want to do."}, nil
+	}
+	command := args[0]
+	if lengthOfArgs > 1 {
+		restOfArgs = args[1:]
+	}
+	switch command {
+	case "create":
+		handler = p.runCreateSubscription
+	case "remove":
+		handler = p.runDeleteSubscription
+	case "list":
+		handler = p.runListSubscription
+	default:
+		return false, &model.CommandResponse{Text: "You can create, list or remove subscriptions"}, nil
+	}
+
+	return handler(restOfArgs, extra)
+}
+
+func (p *Plugin) runCreateSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	typeStr := ""
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&typeStr, "type", "", "ID of the badge")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	actingUser, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if !p.canCreateSubscription(actingUser, extra.ChannelId) {
+		return commandError("You cannot create subscriptions on this channel")
+	}
+
+	if typeStr != "" {
+		var t *badgesmodel.BadgeTypeDefinition
+		t, err = p.store.GetType(badgesmodel.BadgeType(typeStr))
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		if !p.canSubscribeType(actingUser, t, extra.ChannelId) {
+			return commandError("You cannot subscribe this channel to this type")
+		}
+
+		err = p.storeAs(extra.UserId).AddSubscription(badgesmodel.BadgeType(typeStr), extra.ChannelId)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		p.postCommandResponse(extra, "Granted")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	options := []*model.PostActionOptions{}
+	typesDefinitions, err := p.filterSubscribableTypes(actingUser, extra.ChannelId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+	for _, typeDefinition := range typesDefinitions {
+		options = append(options, &model.PostActionOptions{Text: typeDefinition.Name, Value: string(typeDefinition.ID)})
+	}
+
+	if len(options) == 0 {
+		return commandError("There are no types you can subscribe this channel to.")
+	}
+
+	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: extra.TriggerId,
+		URL:       p.getDialogURL() + DialogPathCreateSubscription,
+		Dialog: model.Dialog{
+			Title:            "Create subscription",
+			IntroductionText: "Introduce the badge type you want to subscribe to this channel.",
+			SubmitLabel:      "Add",
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Type",
+					Type:        "select",
+					Name:        DialogFieldBadgeType,
+					Options:     options,
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	return false, &model.CommandResponse{}, nil
+}
+
+// runListSubscription shows the types whose grants are posted on the channel.
+func (p *Plugin) runListSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	typesDefinitions, err := p.store.GetChannelSubscriptions(extra.ChannelId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if len(typesDefinitions) == 0 {
+		p.postCommandResponse(extra, "This channel has no subscriptions.")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	text := "#### Subscriptions of this channel\n| Type | ID | Scope |\n|---|---|---|\n"
+	for _, t := range typesDefinitions {
+		name := t.Name
+		if t.Archived {
+			name += " (archived)"
+		}
+		text += fmt.Sprintf("| %s | `%s` | %s |\n", name, t.ID, p.getTypeScopeName(t))
+	}
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runDeleteSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	typeStr := ""
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&typeStr, "type", "", "ID of the badge")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	actingUser, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if !p.canCreateSubscription(actingUser, extra.ChannelId) {
+		return commandError("You cannot remove subscriptions on this channel")
+	}
+
+	if typeStr != "" {
+		err = p.storeAs(extra.UserId).RemoveSubscriptions(badgesmodel.BadgeType(typeStr), extra.ChannelId)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		p.postCommandResponse(extra, "Removed")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	options := []*model.PostActionOptions{}
+	typesDefinitions, err := p.store.GetChannelSubscriptions(extra.ChannelId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+	for _, typeDefinition := range typesDefinitions {
+		options = append(options, &model.PostActionOptions{Text: typeDefinition.Name, Value: string(typeDefinition.ID)})
+	}
+
+	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: extra.TriggerId,
+		URL:       p.getDialogURL() + DialogPathDeleteSubscription,
+		Dialog: model.Dialog{
+			Title:            "Delete subscription",
+			IntroductionText: "Introduce the badge type you want to remove from this channel.",
+			SubmitLabel:      "Remove",
+			Elements: []model.DialogElement{
+				{
+					DisplayName: "Type",
+					Type:        "select",
+					Name:        DialogFieldBadgeType,
+					Options:     options,
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runAdmin(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	lengthOfArgs := len(args)
+	restOfArgs := []string{}
+	var handler func([]string, *model.CommandArgs) (bool, *model.CommandResponse, error)
+	if lengthOfArgs == 0 {
+		return false, &model.CommandResponse{Text: "Specify what you want to do."}, nil
+	}
+
+	u, err := p.mm.User.Get(extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	if !isBadgesAdmin(u, p.getBadgeAdmins()) {
+		return commandError("Only badge admins can run admin commands.")
+	}
+
 	command := args[0]
 	if lengthOfArgs > 1 {
 		restOfArgs = args[1:]
 	}
 	switch command {
-	case "create":
-		handler = p.runCreateSubscription
-	case "remove":
-		handler = p.runDeleteSubscription
+	case "migrations":
+		handler = p.runAdminMigrations
+	case "copy-to-sql":
+		handler = p.runAdminCopyToSQL
+	case "export":
+		handler = p.runAdminExport
+	case "import":
+		handler = p.runAdminImport
+	case "snapshots":
+		handler = p.runAdminSnapshots
+	case "fsck":
+		handler = p.runAdminFsck
+	case "audit":
+		handler = p.runAdminAudit
+	case "history":
+		handler = p.runAdminHistory
+	case "rollback":
+		handler = p.runAdminRollback
 	default:
-		return false, &model.CommandResponse{Text: "You can either create or delete subscriptions"}, nil
+		return false, &model.CommandResponse{Text: "Unknown admin command"}, nil
 	}
 
 	return handler(restOfArgs, extra)
 }
 
-func (p *Plugin) runCreateSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
-	typeStr := ""
+func (p *Plugin) runAdminMigrations(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	dryRun := false
 	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
-	fs.StringVar(&typeStr, "type", "", "ID of the badge")
+	fs.BoolVar(&dryRun, "dry-run", false, "Run the pending migrations without saving any change")
 	if err := fs.Parse(args); err != nil {
 		return commandError(err.Error())
 	}
 
-	actingUser, err := p.mm.User.Get(extra.UserId)
+	if dryRun {
+		records, err := runMigrations(p.API, true)
+		if len(records) == 0 && err == nil {
+			p.postCommandResponse(extra, "There are no pending migrations.")
+			return false, &model.CommandResponse{}, nil
+		}
+
+		text := "#### Pending migrations (dry run)\n"
+		for _, record := range records {
+			text += fmt.Sprintf("- **%d** %s: %d changes", record.Version, record.Name, record.Changes)
+			if record.Error != "" {
+				text += fmt.Sprintf(" (fails: %s)", record.Error)
+			}
+			text += "\n"
+			for i, change := range record.ChangeList {
+				if i == DryRunMaxChangesShown {
+					text += fmt.Sprintf("  - ... and %d more\n", len(record.ChangeList)-DryRunMaxChangesShown)
+					break
+				}
+				text += "  - `" + change + "`\n"
+			}
+		}
+		p.postCommandResponse(extra, text)
+		return false, &model.CommandResponse{}, nil
+	}
+
+	status, err := getMigrationStatus(p.API)
 	if err != nil {
 		return commandError(err.Error())
 	}
 
-	if !canCreateSubscription(actingUser, p.badgeAdminUserID, extra.ChannelId) {
-		return commandError("You cannot create subscriptions")
+	applied := map[int]migrationRecord{}
+	failed := map[int]migrationRecord{}
+	for _, record := range status.Records {
+		if record.Error != "" {
+			failed[record.Version] = record
+			continue
+		}
+		applied[record.Version] = record
+	}
+
+	text := fmt.Sprintf("#### Badges database\nSchema version: **%d** (latest: %d)\n\n", status.SchemaVersion, migrations[len(migrations)-1].Version)
+	text += "| Version | Name | Status |\n|---|---|---|\n"
+	for _, mig := range migrations {
+		state := "Pending"
+		if record, ok := applied[mig.Version]; ok {
+			state = fmt.Sprintf("Applied on %s (%d changes)", record.RanAt.Format(time.RFC1123), record.Changes)
+		} else if mig.Version <= status.SchemaVersion {
+			state = "Applied"
+		} else if record, ok := failed[mig.Version]; ok {
+			state = fmt.Sprintf("Failed on %s: %s", record.RanAt.Format(time.RFC1123), record.Error)
+		}
+		text += fmt.Sprintf("| %d | %s | %s |\n", mig.Version, mig.Name, state)
 	}
 
-	if typeStr != "" {
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
 
-		err = p.store.AddSubscription(badgesmodel.BadgeType(typeStr), extra.ChannelId)
-		if err != nil {
-			return commandError(err.Error())
+func (p *Plugin) runAdminCopyToSQL(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	force := false
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.BoolVar(&force, "force", false, "Copy even if the database tables are the active store")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	dump, err := NewStore(p.API).Dump()
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	var target Store
+	if p.getConfiguration().StoreBackend == StoreBackendSQL {
+		// The tables hold the live data, and the key-value data stopped being
+		// updated when the backend was switched.
+		if !force {
+			return commandError("The database tables are the active store, and copying would replace them with the older key-value data. Use --force to copy anyway.")
 		}
 
-		p.postCommandResponse(extra, "Granted")
-		return false, &model.CommandResponse{}, nil
+		_, err = takeSnapshot(p.API, p.store, "Copy to SQL", SnapshotsToKeep)
+		if err != nil {
+			return commandError("Cannot take a snapshot before copying: " + err.Error())
+		}
+		target = p.storeAs(extra.UserId)
+	} else {
+		sqlStore, sqlErr := p.newSQLStore()
+		if sqlErr != nil {
+			return commandError(sqlErr.Error())
+		}
+		target = NewAuditStore(sqlStore, p.API, extra.UserId)
 	}
 
-	options := []*model.PostActionOptions{}
-	typesDefinitions, err := p.filterEditTypes(actingUser)
+	err = target.Restore(dump)
 	if err != nil {
 		return commandError(err.Error())
 	}
-	for _, typeDefinition := range typesDefinitions {
-		options = append(options, &model.PostActionOptions{Text: typeDefinition.Name, Value: string(typeDefinition.ID)})
-	}
 
-	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
-		TriggerId: extra.TriggerId,
-		URL:       p.getDialogURL() + DialogPathCreateSubscription,
-		Dialog: model.Dialog{
-			Title:            "Create subscription",
-			IntroductionText: "Introduce the badge type you want to subscribe to this channel.",
-			SubmitLabel:      "Add",
-			Elements: []model.DialogElement{
-				{
-					DisplayName: "Type",
-					Type:        "select",
-					Name:        DialogFieldBadgeType,
-					Options:     options,
-				},
-			},
-		},
-	})
+	p.postCommandResponse(extra, fmt.Sprintf(
+		"Copied %d types, %d badges, %d grants and %d subscriptions to the database tables. Any previous content of the tables was replaced.",
+		len(dump.Types),
+		len(dump.Badges),
+		len(dump.Ownerships),
+		len(dump.Subscriptions),
+	))
+	return false, &model.CommandResponse{}, nil
+}
 
+func (p *Plugin) runAdminExport(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	archive, err := p.exportBadges()
 	if err != nil {
 		return commandError(err.Error())
 	}
 
+	err = p.sendExport(extra.UserId, archive)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	p.postCommandResponse(extra, "The export was sent to you by direct message.")
 	return false, &model.CommandResponse{}, nil
 }
 
-func (p *Plugin) runDeleteSubscription(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
-	typeStr := ""
+func (p *Plugin) runAdminImport(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	mode := ImportModeMerge
+	fileID := ""
 	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
-	fs.StringVar(&typeStr, "type", "", "ID of the badge")
+	fs.StringVar(&mode, "mode", ImportModeMerge, "merge or replace")
+	fs.StringVar(&fileID, "file", "", "ID of the export file")
 	if err := fs.Parse(args); err != nil {
 		return commandError(err.Error())
 	}
 
-	actingUser, err := p.mm.User.Get(extra.UserId)
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return commandError("The mode must be either merge or replace.")
+	}
+
+	fileID, err := p.findImportFile(fileID, extra.UserId, extra.ChannelId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	archive, err := p.readImportFile(fileID)
 	if err != nil {
 		return commandError(err.Error())
 	}
 
-	if !canCreateSubscription(actingUser, p.badgeAdminUserID, extra.ChannelId) {
-		return commandError("You cannot create subscriptions")
+	_, err = takeSnapshot(p.API, p.store, "Import in "+mode+" mode", SnapshotsToKeep)
+	if err != nil {
+		return commandError("Cannot take a snapshot before importing: " + err.Error())
 	}
 
-	if typeStr != "" {
-		err = p.store.RemoveSubscriptions(badgesmodel.BadgeType(typeStr), extra.ChannelId)
+	result, err := p.importBadges(archive, mode, extra.UserId)
+	if err != nil {
+		return commandError(err.Error())
+	}
+
+	verb := "Added"
+	if mode == ImportModeReplace {
+		verb = "Replaced the badges database with"
+	}
+	text := fmt.Sprintf("%s %d types, %d badges, %d grants and %d subscriptions.", verb, result.Types, result.Badges, result.Ownerships, result.Subscriptions)
+	if result.SkippedOwnerships > 0 {
+		text += fmt.Sprintf("\n%d grants were skipped because their user or badge could not be found.", result.SkippedOwnerships)
+	}
+	if result.SkippedSubscriptions > 0 {
+		text += fmt.Sprintf("\n%d subscriptions were skipped because their channel does not exist on this server.", result.SkippedSubscriptions)
+	}
+	if len(result.UnmatchedUsers) > 0 {
+		text += "\nUsers not found on this server: " + strings.Join(result.UnmatchedUsers, ", ")
+	}
+
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runAdminSnapshots(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	if len(args) == 0 {
+		return commandError("Specify list or restore.")
+	}
+
+	switch args[0] {
+	case "list":
+		snapshots, err := listSnapshots(p.API)
 		if err != nil {
 			return commandError(err.Error())
 		}
 
-		p.postCommandResponse(extra, "Removed")
+		if len(snapshots) == 0 {
+			p.postCommandResponse(extra, "There are no snapshots.")
+			return false, &model.CommandResponse{}, nil
+		}
+
+		text := "#### Badges snapshots\n| ID | Taken | Before | Types | Badges | Grants | Subscriptions |\n|---|---|---|---|---|---|---|\n"
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			s := snapshots[i]
+			text += fmt.Sprintf("| `%s` | %s | %s | %d | %d | %d | %d |\n", s.ID, s.CreatedAt.Format(time.RFC1123), s.Reason, s.Types, s.Badges, s.Ownerships, s.Subscriptions)
+		}
+		p.postCommandResponse(extra, text)
+		return false, &model.CommandResponse{}, nil
+	case "restore":
+		if len(args) < 2 {
+			return commandError("Specify the ID of the snapshot to restore.")
+		}
+
+		snap, err := getSnapshot(p.API, args[1])
+		if err == errSnapshotNotFound {
+			return commandError("Snapshot not found.")
+		}
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		info, err := takeSnapshot(p.API, p.store, "Restore snapshot "+snap.ID, SnapshotsToKeep)
+		if err != nil {
+			return commandError("Cannot take a snapshot before restoring: " + err.Error())
+		}
+
+		err = p.storeAs(extra.UserId).Restore(snap.Data)
+		if err != nil {
+			return commandError(err.Error())
+		}
+
+		p.postCommandResponse(extra, fmt.Sprintf(
+			"Restored the snapshot taken on %s. The previous state was saved as snapshot `%s`.",
+			snap.CreatedAt.Format(time.RFC1123),
+			info.ID,
+		))
 		return false, &model.CommandResponse{}, nil
+	default:
+		return commandError("Specify list or restore.")
 	}
+}
 
-	options := []*model.PostActionOptions{}
-	typesDefinitions, err := p.store.GetChannelSubscriptions(extra.ChannelId)
+func (p *Plugin) runAdminFsck(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	repair := false
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.BoolVar(&repair, "repair", false, "Fix the inconsistencies found")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	report, info, err := p.fsck(repair, extra.UserId)
 	if err != nil {
 		return commandError(err.Error())
 	}
-	for _, typeDefinition := range typesDefinitions {
-		options = append(options, &model.PostActionOptions{Text: typeDefinition.Name, Value: string(typeDefinition.ID)})
+
+	if report.IsClean() {
+		p.postCommandResponse(extra, "No inconsistencies found.")
+		return false, &model.CommandResponse{}, nil
 	}
 
-	err = p.mm.Frontend.OpenInteractiveDialog(model.OpenDialogRequest{
-		TriggerId: extra.TriggerId,
-		URL:       p.getDialogURL() + DialogPathDeleteSubscription,
-		Dialog: model.Dialog{
-			Title:            "Delete subscription",
-			IntroductionText: "Introduce the badge type you want to remove from this channel.",
-			SubmitLabel:      "Remove",
-			Elements: []model.DialogElement{
-				{
-					DisplayName: "Type",
-					Type:        "select",
-					Name:        DialogFieldBadgeType,
-					Options:     options,
-				},
-			},
-		},
-	})
+	section := func(title string, lines []string) string {
+		if len(lines) == 0 {
+			return ""
+		}
+		text := fmt.Sprintf("**%s: %d**\n", title, len(lines))
+		for i, line := range lines {
+			if i == FsckMaxIssuesShown {
+				text += fmt.Sprintf("- ... and %d more\n", len(lines)-FsckMaxIssuesShown)
+				break
+			}
+			text += "- " + line + "\n"
+		}
+		return text
+	}
+	ownershipLines := func(l badgesmodel.OwnershipList) []string {
+		lines := []string{}
+		for _, o := range l {
+			lines = append(lines, fmt.Sprintf("badge `%s` granted to `%s` on %s", o.Badge, o.User, o.Time.Format(time.RFC1123)))
+		}
+		return lines
+	}
+
+	badgeLines := []string{}
+	for _, b := range report.BadgesWithoutType {
+		badgeLines = append(badgeLines, fmt.Sprintf("`%s` (`%s`) of type `%s`", b.Name, b.ID, b.Type))
+	}
+	subscriptionLines := []string{}
+	for _, sub := range report.OrphanSubscriptions {
+		subscriptionLines = append(subscriptionLines, fmt.Sprintf("type `%s` on channel `%s`", sub.TypeID, sub.ChannelID))
+	}
+
+	text := "#### Badges database check\n"
+	text += section("Badges with a missing type", badgeLines)
+	text += section("Grants of missing badges", ownershipLines(report.OrphanOwnerships))
+	text += section("Subscriptions to missing types", subscriptionLines)
+	text += section("Grants to deleted users", ownershipLines(report.DeletedOwners))
+	text += section("Grants to deactivated users", ownershipLines(report.DeactivatedOwners))
+
+	if repair {
+		text += fmt.Sprintf("\nRepaired. Grants to deactivated users were kept. The previous state was saved as snapshot `%s`.", info.ID)
+	} else {
+		text += "\nRun `/badges admin fsck --repair` to remove the badges with a missing type, and the grants and subscriptions that point to missing badges, types or users."
+	}
+
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runAdminAudit(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	var actor, user, target, action string
+	var days, limit int
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&actor, "actor", "", "Only changes made by this user")
+	fs.StringVar(&user, "user", "", "Only grants and revokes of this user")
+	fs.StringVar(&target, "target", "", "Only changes of this badge or type")
+	fs.StringVar(&action, "action", "", "Only changes of this kind")
+	fs.IntVar(&days, "days", AuditDefaultQueryDays, "How many days back to look")
+	fs.IntVar(&limit, "limit", AuditCommandDefaultLimit, "How many changes to show")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	q := auditQuery{
+		TargetID: target,
+		Action:   badgesmodel.AuditAction(action),
+		Since:    time.Now().AddDate(0, 0, -days),
+		Limit:    limit,
+	}
+	if actor != "" {
+		u, err := p.mm.User.GetByUsername(strings.TrimPrefix(actor, "@"))
+		if err != nil {
+			return commandError("Cannot find user " + actor)
+		}
+		q.ActorID = u.Id
+	}
+	if user != "" {
+		u, err := p.mm.User.GetByUsername(strings.TrimPrefix(user, "@"))
+		if err != nil {
+			return commandError("Cannot find user " + user)
+		}
+		q.UserID = u.Id
+	}
+
+	entries, err := queryAuditLog(p.API, q)
+	if err != nil {
+		return commandError(err.Error())
+	}
 
+	if len(entries) == 0 {
+		p.postCommandResponse(extra, "No changes found.")
+		return false, &model.CommandResponse{}, nil
+	}
+
+	usernames := map[string]string{}
+	username := func(userID string) string {
+		if userID == "" {
+			return ""
+		}
+		if name, ok := usernames[userID]; ok {
+			return name
+		}
+		name := userID
+		if u, err := p.mm.User.Get(userID); err == nil {
+			name = "@" + u.Username
+		}
+		usernames[userID] = name
+		return name
+	}
+
+	text := "#### Badges audit log\n| Time | Actor | Action | Target | User | Changes |\n|---|---|---|---|---|---|\n"
+	for _, entry := range entries {
+		changes := strings.ReplaceAll(strings.Join(auditChanges(entry), ", "), "|", "\\|")
+		text += fmt.Sprintf("| %s | %s | %s | `%s` | %s | %s |\n", entry.Time.Format(time.RFC1123), username(entry.ActorID), entry.Action, entry.TargetID, username(entry.UserID), changes)
+	}
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runAdminHistory(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	var badgeID, typeID string
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&badgeID, "badge", "", "Badge to show the versions of")
+	fs.StringVar(&typeID, "type", "", "Type to show the versions of")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	type version struct {
+		number    int
+		updatedBy string
+		updatedAt time.Time
+		changes   []string
+	}
+	versions := []version{}
+	var title string
+	switch {
+	case badgeID != "":
+		history, err := p.store.GetBadgeHistory(badgesmodel.BadgeID(badgeID))
+		if err != nil {
+			return commandError(err.Error())
+		}
+		title = "Versions of badge " + history[len(history)-1].Name
+		for i, b := range history {
+			var previous interface{}
+			if i > 0 {
+				previous = history[i-1]
+			}
+			versions = append(versions, version{b.Version, b.UpdatedBy, b.UpdatedAt, versionChanges(previous, b)})
+		}
+	case typeID != "":
+		history, err := p.store.GetTypeHistory(badgesmodel.BadgeType(typeID))
+		if err != nil {
+			return commandError(err.Error())
+		}
+		title = "Versions of type " + history[len(history)-1].Name
+		for i, t := range history {
+			var previous interface{}
+			if i > 0 {
+				previous = history[i-1]
+			}
+			versions = append(versions, version{t.Version, t.UpdatedBy, t.UpdatedAt, versionChanges(previous, t)})
+		}
+	default:
+		return commandError("Specify a badge or a type.")
+	}
+
+	text := fmt.Sprintf("#### %s\n| Version | Updated | By | Changes |\n|---|---|---|---|\n", title)
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		updated := "-"
+		if !v.updatedAt.IsZero() {
+			updated = v.updatedAt.Format(time.RFC1123)
+		}
+		by := v.updatedBy
+		if u, err := p.mm.User.Get(v.updatedBy); err == nil {
+			by = "@" + u.Username
+		}
+		changes := "created"
+		if i > 0 {
+			changes = strings.ReplaceAll(strings.Join(v.changes, ", "), "|", "\\|")
+		}
+		text += fmt.Sprintf("| %d | %s | %s | %s |\n", v.number, updated, by, changes)
+	}
+	p.postCommandResponse(extra, text)
+	return false, &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runAdminRollback(args []string, extra *model.CommandArgs) (bool, *model.CommandResponse, error) {
+	var badgeID string
+	var version int
+	fs := pflag.NewFlagSet("", pflag.ContinueOnError)
+	fs.StringVar(&badgeID, "badge", "", "Badge to roll back")
+	fs.IntVar(&version, "version", 0, "Version to roll back to")
+	if err := fs.Parse(args); err != nil {
+		return commandError(err.Error())
+	}
+
+	if badgeID == "" || version == 0 {
+		return commandError("Specify the badge and the version to roll back to.")
+	}
+
+	badge, err := p.rollbackBadge(badgesmodel.BadgeID(badgeID), version, extra.UserId)
 	if err != nil {
 		return commandError(err.Error())
 	}
 
+	p.postCommandResponse(extra, fmt.Sprintf("Badge %s rolled back to version %d, saved as version %d.", badge.Name, version, badge.Version))
 	return false, &model.CommandResponse{}, nil
 }
 
 func (p *Plugin) getAutocompleteData() *model.AutocompleteData {
-	badges := model.NewAutocompleteData("badges", "[command]", "Available commands: grant")
+	badges := model.NewAutocompleteData("badges", "[command]", "Available commands: grant, revoke, renew, search, leaderboard")
 
 	grant := model.NewAutocompleteData("grant", "--user @username --badge id", "Grant a badge to a user")
 	grant.AddNamedDynamicListArgument("badge", "--badge badgeID", getAutocompletePath(AutocompletePathBadgeSuggestions), true)
 	grant.AddNamedTextArgument("user", "User to grant the badge to", "--user @username", "", true)
 	badges.AddCommand(grant)
 
+	// Autocomplete arguments always take a value, so the on/off flags --all and
+	// --notify are only shown on the hint.
+	revoke := model.NewAutocompleteData("revoke", "--user @username --badge id [--all] [--notify]", "Revoke a badge from a user. --all revokes every grant of the badge, --notify sends a direct message to the user")
+	revoke.AddNamedDynamicListArgument("badge", "--badge badgeID", getAutocompletePath(AutocompletePathRevokeSuggestions), true)
+	revoke.AddNamedTextArgument("user", "User to revoke the badge from", "--user @username", "", true)
+	revoke.AddNamedTextArgument("reason", "Reason of the revocation", "--reason \"text\"", "", false)
+	badges.AddCommand(revoke)
+
+	renew := model.NewAutocompleteData("renew", "--user @username --badge id", "Extend the expiry of a time-limited badge a user has")
+	renew.AddNamedDynamicListArgument("badge", "--badge badgeID", getAutocompletePath(AutocompletePathBadgeSuggestions), true)
+	renew.AddNamedTextArgument("user", "User to renew the badge of", "--user @username", "", true)
+	badges.AddCommand(renew)
+
+	search := model.NewAutocompleteData("search", "[query] --tag tag", "Find badges by name, description or tag")
+	search.AddTextArgument("Words to search for", "[query]", "")
+	search.AddNamedTextArgument("tag", "Only badges with this tag", "--tag tag", "", false)
+	badges.AddCommand(search)
+
+	leaderboard := model.NewAutocompleteData("leaderboard", "[--type type] [--days N] [--limit N] [--all-teams]", "Post the users with the most badge points on this channel")
+	leaderboard.AddNamedTextArgument("type", "Only badges of this type, by ID or name", "--type type", "", false)
+	leaderboard.AddNamedTextArgument("days", "Only grants of the last days", "--days N", "", false)
+	leaderboard.AddNamedTextArgument("limit", "How many users to show", "--limit N", "", false)
+	leaderboard.AddNamedTextArgument("all-teams", "Count the users of every team, not only this one", "--all-teams", "", false)
+	badges.AddCommand(leaderboard)
+
 	create := model.NewAutocompleteData("create", "badge | type", "Create a badge or a type")
 
 	badge := model.NewAutocompleteData(
@@ -861,7 +2081,25 @@ func (p *Plugin) getAutocompleteData() *model.AutocompleteData {
 
 	badges.AddCommand(edit)
 
-	subscription := model.NewAutocompleteData("subscription", "create | remove", "Manage this channel subscriptions")
+	restore := model.NewAutocompleteData("restore", "badge | type", "Restore an archived badge or type")
+	restoreBadge := model.NewAutocompleteData("badge", "", "Restore an archived badge")
+	restoreBadge.AddNamedDynamicListArgument("id", "--id badgeID", getAutocompletePath(AutocompletePathArchivedBadgeSuggestions), true)
+	restore.AddCommand(restoreBadge)
+	restoreType := model.NewAutocompleteData("type", "", "Restore an archived type")
+	restoreType.AddNamedDynamicListArgument("type", "--type typeID", getAutocompletePath(AutocompletePathArchivedTypeSuggestions), true)
+	restore.AddCommand(restoreType)
+	badges.AddCommand(restore)
+
+	purge := model.NewAutocompleteData("purge", "badge | type", "Remove an archived badge or type permanently")
+	purgeBadge := model.NewAutocompleteData("badge", "", "Remove an archived badge and its grants permanently")
+	purgeBadge.AddNamedDynamicListArgument("id", "--id badgeID", getAutocompletePath(AutocompletePathArchivedBadgeSuggestions), true)
+	purge.AddCommand(purgeBadge)
+	purgeType := model.NewAutocompleteData("type", "", "Remove an archived type, its badges and their grants permanently")
+	purgeType.AddNamedDynamicListArgument("type", "--type typeID", getAutocompletePath(AutocompletePathArchivedTypeSuggestions), true)
+	purge.AddCommand(purgeType)
+	badges.AddCommand(purge)
+
+	subscription := model.NewAutocompleteData("subscription", "create | list | remove", "Manage this channel subscriptions")
 
 	createSubscription := model.NewAutocompleteData(
 		"create",
@@ -877,8 +2115,95 @@ func (p *Plugin) getAutocompleteData() *model.AutocompleteData {
 	)
 	subscription.AddCommand(deleteSubscription)
 
+	listSubscription := model.NewAutocompleteData(
+		"list",
+		"",
+		"List the subscriptions of this channel",
+	)
+	subscription.AddCommand(listSubscription)
+
 	badges.AddCommand(subscription)
 
+	admin := model.NewAutocompleteData("admin", "migrations | copy-to-sql | export | import | snapshots | fsck | audit | history | rollback", "Badges administration commands")
+
+	adminMigrations := model.NewAutocompleteData(
+		"migrations",
+		"[--dry-run]",
+		"Show the status of the badges database migrations",
+	)
+	adminMigrations.AddNamedTextArgument("dry-run", "Run the pending migrations without saving any change", "--dry-run", "", false)
+	admin.AddCommand(adminMigrations)
+
+	adminCopyToSQL := model.NewAutocompleteData(
+		"copy-to-sql",
+		"[--force]",
+		"Copy the badges from the key-value store to the database tables. --force copies even if the tables are the active store",
+	)
+	admin.AddCommand(adminCopyToSQL)
+
+	adminExport := model.NewAutocompleteData(
+		"export",
+		"",
+		"Send yourself a file with every type, badge, grant and subscription",
+	)
+	admin.AddCommand(adminExport)
+
+	adminImport := model.NewAutocompleteData(
+		"import",
+		"[--mode merge|replace] [--file fileID]",
+		"Import an export file. By default, the latest JSON file you posted on this channel",
+	)
+	adminImport.AddNamedStaticListArgument("mode", "merge adds to the existing badges, replace removes them first", false, []model.AutocompleteListItem{
+		{Item: ImportModeMerge, HelpText: "Add the imported badges to the existing ones"},
+		{Item: ImportModeReplace, HelpText: "Remove every existing badge before importing"},
+	})
+	adminImport.AddNamedTextArgument("file", "ID of the export file", "--file fileID", "", false)
+	admin.AddCommand(adminImport)
+
+	adminSnapshots := model.NewAutocompleteData("snapshots", "list | restore", "Manage the snapshots taken before destructive operations")
+	adminSnapshots.AddCommand(model.NewAutocompleteData("list", "", "List the snapshots, newest first"))
+	adminSnapshotsRestore := model.NewAutocompleteData("restore", "[snapshotID]", "Restore a snapshot")
+	adminSnapshotsRestore.AddTextArgument("ID of the snapshot, as shown by list", "[snapshotID]", "")
+	adminSnapshots.AddCommand(adminSnapshotsRestore)
+	admin.AddCommand(adminSnapshots)
+
+	adminFsck := model.NewAutocompleteData("fsck", "[--repair]", "Check the badges database for inconsistencies")
+	adminFsck.AddNamedTextArgument("repair", "Fix the inconsistencies found", "--repair", "", false)
+	admin.AddCommand(adminFsck)
+
+	adminAudit := model.NewAutocompleteData("audit", "[--actor @username] [--user @username] [--target id] [--action action] [--days N] [--limit N]", "Show the latest changes to badges, types, grants and subscriptions")
+	adminAudit.AddNamedTextArgument("actor", "Only changes made by this user", "--actor @username", "", false)
+	adminAudit.AddNamedTextArgument("user", "Only grants and revokes of this user", "--user @username", "", false)
+	adminAudit.AddNamedTextArgument("target", "Only changes of this badge or type", "--target id", "", false)
+	adminAudit.AddNamedStaticListArgument("action", "Only changes of this kind", false, []model.AutocompleteListItem{
+		{Item: string(badgesmodel.AuditActionCreateType)},
+		{Item: string(badgesmodel.AuditActionUpdateType)},
+		{Item: string(badgesmodel.AuditActionDeleteType)},
+		{Item: string(badgesmodel.AuditActionCreateBadge)},
+		{Item: string(badgesmodel.AuditActionUpdateBadge)},
+		{Item: string(badgesmodel.AuditActionDeleteBadge)},
+		{Item: string(badgesmodel.AuditActionGrant)},
+		{Item: string(badgesmodel.AuditActionRevoke)},
+		{Item: string(badgesmodel.AuditActionAddSubscription)},
+		{Item: string(badgesmodel.AuditActionRemoveSubscription)},
+		{Item: string(badgesmodel.AuditActionRestore)},
+	})
+	adminAudit.AddNamedTextArgument("days", "How many days back to look", "--days N", "", false)
+	adminAudit.AddNamedTextArgument("limit", "How many changes to show", "--limit N", "", false)
+	admin.AddCommand(adminAudit)
+
+	adminHistory := model.NewAutocompleteData("history", "--badge badgeID | --type typeID", "Show the versions of a badge or a type")
+	adminHistory.AddNamedDynamicListArgument("badge", "--badge badgeID", getAutocompletePath(AutocompletePathEditBadgeSuggestions), false)
+	adminHistory.AddNamedDynamicListArgument("type", "--type typeID", getAutocompletePath(AutocompletePathEditTypeSuggestions), false)
+	admin.AddCommand(adminHistory)
+
+	adminRollback := model.NewAutocompleteData("rollback", "--badge badgeID --version N", "Make an earlier version of a badge the current one")
+	adminRollback.AddNamedDynamicListArgument("badge", "--badge badgeID", getAutocompletePath(AutocompletePathEditBadgeSuggestions), true)
+	adminRollback.AddNamedTextArgument("version", "Version to roll back to, as shown by history", "--version N", "", true)
+	admin.AddCommand(adminRollback)
+
+	badges.AddCommand(admin)
+
 	return badges
 }
 
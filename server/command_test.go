@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutocompleteData(t *testing.T) {
+	p := setupTestPlugin(newMemStore())
+	data := p.getAutocompleteData()
+	require.NoError(t, data.IsValid())
+
+	for _, command := range data.SubCommands {
+		if command.Trigger != "revoke" {
+			continue
+		}
+		for _, arg := range command.Arguments {
+			assert.NotContains(t, []string{"all", "notify"}, arg.Name, "flags without a value are not arguments")
+		}
+		assert.Contains(t, command.Hint, "[--all] [--notify]")
+	}
+}
+
+func TestAdminCopyToSQLOnActiveTables(t *testing.T) {
+	s := newMemStore()
+	badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+	p := setupTestPlugin(s)
+	p.setConfiguration(&configuration{StoreBackend: StoreBackendSQL})
+	api := p.API.(*fakeAPI)
+	api.On("SendEphemeralPost", mock.Anything, mock.Anything).Return(&model.Post{})
+	extra := &model.CommandArgs{UserId: model.NewId(), ChannelId: model.NewId()}
+
+	_, _, err := p.runAdminCopyToSQL(nil, extra)
+	require.Error(t, err, "the live tables are not replaced without --force")
+	_, err = s.GetBadge(badge.ID)
+	require.NoError(t, err)
+
+	_, _, err = p.runAdminCopyToSQL([]string{"--force"}, extra)
+	require.NoError(t, err)
+	_, err = s.GetBadge(badge.ID)
+	assert.Error(t, err, "the empty key-value data replaced the tables")
+
+	snapshots, err := listSnapshots(api)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	entries, err := queryAuditLog(api, auditQuery{ActorID: extra.UserId})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, badgesmodel.AuditActionRestore, entries[0].Action)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -18,7 +20,17 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
-	BadgesAdmin string
+	BadgesAdmin               string
+	BadgesAdminGroups         string
+	TeamAdminsAreBadgesAdmins bool
+	StoreBackend              string
+	AuditRetentionDays        string
+	ExpiryWarningDays         string
+	RemoveExpiredBadges       bool
+
+	// badgeAdmins is resolved from BadgesAdmin, BadgesAdminGroups and
+	// TeamAdminsAreBadgesAdmins, and shared by the clones.
+	badgeAdmins *badgeAdminSet
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -28,6 +40,26 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// getAuditRetentionDays returns how many days of audit log to keep. Zero means
+// forever.
+func (c *configuration) getAuditRetentionDays() int {
+	days, err := strconv.Atoi(strings.TrimSpace(c.AuditRetentionDays))
+	if err != nil || days < 0 {
+		return DefaultAuditRetentionDays
+	}
+	return days
+}
+
+// getExpiryWarningDays returns how many days before a badge expires its owner
+// is warned. Zero means no warning.
+func (c *configuration) getExpiryWarningDays() int {
+	days, err := strconv.Atoi(strings.TrimSpace(c.ExpiryWarningDays))
+	if err != nil || days < 0 {
+		return DefaultExpiryWarningDays
+	}
+	return days
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -78,14 +110,7 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
-	p.badgeAdminUserID = ""
-	if configuration.BadgesAdmin != "" {
-		u, err := p.API.GetUserByUsername(configuration.BadgesAdmin)
-		if err != nil {
-			return errors.Wrap(err, "cannot get badge admin user")
-		}
-		p.badgeAdminUserID = u.Id
-	}
+	configuration.badgeAdmins = newBadgeAdminSet(p.API, configuration)
 
 	p.setConfiguration(configuration)
 
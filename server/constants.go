@@ -1,16 +1,36 @@
 package main
 
+import "time"
+
 const (
 	KVKeyBadges        = "badges"
 	KVKeyOwnership     = "ownership"
 	KVKeyTypes         = "types"
 	KVKeySubscriptions = "subs"
 
-	AutocompletePath                     = "/autocomplete"
-	AutocompletePathBadgeSuggestions     = "/getBadgeSuggestions"
-	AutocompletePathTypeSuggestions      = "/getBadgeTypeSuggestions"
-	AutocompletePathEditBadgeSuggestions = "/getEditBadgeSuggestions"
-	AutocompletePathEditTypeSuggestions  = "/getEditTypeSuggestions"
+	KVKeyUserOwnershipPrefix  = "ownership_user_"
+	KVKeyBadgeOwnershipPrefix = "ownership_badge_"
+
+	KVKeySchemaVersion   = "schema_version"
+	KVKeyMigrations      = "migrations"
+	KVKeyCacheGeneration = "cache_generation"
+	KVKeySnapshots       = "snapshots"
+	KVKeySnapshotPrefix  = "snapshot_"
+	KVKeyAuditPrefix     = "audit_log_"
+	KVKeyExpiryLastRun   = "expiry_last_run"
+	KVKeyImagePrefix     = "image_"
+
+	KVKeyBadgeHistoryPrefix = "history_badge_"
+	KVKeyTypeHistoryPrefix  = "history_type_"
+
+	AutocompletePath                         = "/autocomplete"
+	AutocompletePathBadgeSuggestions         = "/getBadgeSuggestions"
+	AutocompletePathTypeSuggestions          = "/getBadgeTypeSuggestions"
+	AutocompletePathEditBadgeSuggestions     = "/getEditBadgeSuggestions"
+	AutocompletePathEditTypeSuggestions      = "/getEditTypeSuggestions"
+	AutocompletePathRevokeSuggestions        = "/getRevokeBadgeSuggestions"
+	AutocompletePathArchivedBadgeSuggestions = "/getArchivedBadgeSuggestions"
+	AutocompletePathArchivedTypeSuggestions  = "/getArchivedTypeSuggestions"
 
 	DialogPath                   = "/dialog"
 	DialogPathCreateBadge        = "/createBadge"
@@ -18,6 +38,7 @@ const (
 	DialogPathCreateType         = "/createType"
 	DialogPathEditType           = "/editType"
 	DialogPathGrant              = "/grant"
+	DialogPathRevoke             = "/revoke"
 	DialogPathSelectBadge        = "/selectBadge"
 	DialogPathEditBadge          = "/editBadge"
 	DialogPathCreateSubscription = "/createSubscription"
@@ -28,17 +49,83 @@ const (
 	DialogFieldBadgeDescription       = "description"
 	DialogFieldBadgeType              = "type"
 	DialogFieldBadgeImage             = "image"
-	DialogFieldBadgeDelete            = "delete"
+	DialogFieldBadgeArchive           = "archive"
+	DialogFieldBadgeValidityDays      = "validity_days"
+	DialogFieldBadgeImageFile         = "image_file"
+	DialogFieldBadgeTags              = "tags"
+	DialogFieldBadgePoints            = "points"
+	DialogFieldBadgeCoOwners          = "co_owners"
 	DialogFieldTypeName               = "name"
 	DialogFieldTypeEveryoneCanGrant   = "everyoneCanGrant"
 	DialogFieldTypeAllowlistCanGrant  = "whitelistCanGrant"
 	DialogFieldTypeEveryoneCanCreate  = "everyoneCanCreate"
 	DialogFieldTypeAllowlistCanCreate = "whitelistCanCreate"
-	DialogFieldTypeDelete             = "delete"
+	DialogFieldTypeRolesCanGrant      = "rolesCanGrant"
+	DialogFieldTypeRolesCanCreate     = "rolesCanCreate"
+	DialogFieldTypeBlocklistCanGrant  = "blocklistCanGrant"
+	DialogFieldTypeBlocklistCanCreate = "blocklistCanCreate"
+	DialogFieldTypeEveryoneCanEdit    = "everyoneCanEdit"
+	DialogFieldTypeRolesCanEdit       = "rolesCanEdit"
+	DialogFieldTypeAllowlistCanEdit   = "allowlistCanEdit"
+	DialogFieldTypeBlocklistCanEdit   = "blocklistCanEdit"
+	DialogFieldTypeArchive            = "archive"
+	DialogFieldTypeFrameShape         = "frame_shape"
+	DialogFieldTypeFrameColour        = "frame_colour"
+	DialogFieldTypeFrameImage         = "frame_image"
+	DialogFieldTypeDefaultPoints      = "default_points"
+	DialogFieldTypeScope              = "scope"
+	DialogFieldTypeAnnounceable       = "announceable"
 	DialogFieldUser                   = "user"
 	DialogFieldBadge                  = "badge"
 	DialogFieldNotifyHere             = "notify_here"
 	DialogFieldGrantReason            = "reason"
+	DialogFieldRevokeReason           = "reason"
+	DialogFieldRevokeAll              = "all"
+	DialogFieldNotifyUser             = "notify_user"
+
+	DryRunMaxChangesShown = 20
+
+	StoreBackendKV  = "kv"
+	StoreBackendSQL = "sql"
+
+	ImportModeMerge       = "merge"
+	ImportModeReplace     = "replace"
+	ImportFileSearchPosts = 20
+
+	ImagePath            = "/images"
+	ImageMaxSize         = 256 * 1024
+	ImageFileSearchPosts = 50
+	ImageCacheMaxAge     = 7 * 24 * 60 * 60
+	DefaultFrameColour   = "#1e88e5"
+
+	SearchDefaultLimit = 20
+	SearchMaxLimit     = 100
+
+	LeaderboardDefaultLimit = 10
+	LeaderboardMaxLimit     = 100
+
+	SnapshotsToKeep = 10
+	// SnapshotChunkSize is the most bytes of a snapshot kept on a single key.
+	SnapshotChunkSize = 512 * 1024
+
+	FsckMaxIssuesShown = 20
+
+	AuditRetentionJobKey      = "audit_retention"
+	DefaultAuditRetentionDays = 90
+	AuditDefaultQueryDays     = 30
+	AuditDefaultLimit         = 100
+	AuditMaxLimit             = 1000
+	AuditCommandDefaultLimit  = 20
+
+	ListDefaultPerPage = 50
+	ListMaxPerPage     = 200
+	HeaderNextCursor   = "X-Next-Cursor"
+
+	ExpiryJobKey             = "badge_expiry"
+	DefaultExpiryWarningDays = 7
+	ExpiryDateFormat         = "January 2, 2006"
+
+	BadgesAdminCheckTTL = time.Minute
 
 	TrueString  = "true"
 	FalseString = "false"
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// getExpiryLastRun returns when the expiry job last ran. On the first run it
+// pretends the job ran an hour ago, so grants that expired long ago are not
+// notified all at once.
+func (p *Plugin) getExpiryLastRun(now time.Time) (time.Time, error) {
+	data, appErr := p.API.KVGet(KVKeyExpiryLastRun)
+	if appErr != nil {
+		return time.Time{}, appErr
+	}
+
+	if data == nil {
+		return now.Add(-time.Hour), nil
+	}
+
+	var lastRun time.Time
+	err := json.Unmarshal(data, &lastRun)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lastRun, nil
+}
+
+func (p *Plugin) setExpiryLastRun(lastRun time.Time) error {
+	data, err := json.Marshal(lastRun)
+	if err != nil {
+		return err
+	}
+
+	appErr := p.API.KVSet(KVKeyExpiryLastRun, data)
+	if appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// selectExpiryNotices picks, from the grants expiring before now+warning, the
+// ones to warn about and the ones to notify as expired. Each run only takes the
+// grants that entered either window since the last run, so every owner is
+// notified once.
+func selectExpiryNotices(expiring badgesmodel.OwnershipList, lastRun, now time.Time, warning time.Duration) (warn, expired badgesmodel.OwnershipList) {
+	warn = badgesmodel.OwnershipList{}
+	expired = badgesmodel.OwnershipList{}
+	for _, o := range expiring {
+		if o.ExpiresAt == nil {
+			continue
+		}
+
+		switch {
+		case o.IsExpired(now):
+			if o.ExpiresAt.After(lastRun) {
+				expired = append(expired, o)
+			}
+		case warning > 0 && o.ExpiresAt.After(lastRun.Add(warning)):
+			warn = append(warn, o)
+		}
+	}
+
+	return warn, expired
+}
+
+// runExpiry warns the owners of badges about to expire, notifies the ones whose
+// badges expired, and removes expired grants if configured to. It runs on a
+// single server of the cluster.
+func (p *Plugin) runExpiry() {
+	now := time.Now()
+	lastRun, err := p.getExpiryLastRun(now)
+	if err != nil {
+		p.mm.Log.Warn("Cannot read the last badge expiry run", "err", err)
+		return
+	}
+
+	config := p.getConfiguration()
+	warning := time.Duration(config.getExpiryWarningDays()) * 24 * time.Hour
+
+	expiring, err := p.store.GetExpiringOwnerships(now.Add(warning))
+	if err != nil {
+		p.mm.Log.Warn("Cannot read the expiring badges", "err", err)
+		return
+	}
+
+	warn, expired := selectExpiryNotices(expiring, lastRun, now, warning)
+
+	if config.RemoveExpiredBadges {
+		_, err = p.storeAs(p.BotUserID).ExpireOwnerships(now)
+		if err != nil {
+			p.mm.Log.Warn("Cannot remove the expired badges", "err", err)
+			return
+		}
+	}
+
+	for _, o := range warn {
+		p.notifyExpiry(o, false)
+	}
+	for _, o := range expired {
+		p.notifyExpiry(o, true)
+	}
+
+	err = p.setExpiryLastRun(now)
+	if err != nil {
+		p.mm.Log.Warn("Cannot save the last badge expiry run", "err", err)
+	}
+}
+
+func (p *Plugin) notifyExpiry(o badgesmodel.Ownership, expired bool) {
+	b, err := p.store.GetBadge(o.Badge)
+	if err != nil {
+		p.mm.Log.Debug("badge error", "err", err)
+		return
+	}
+
+	image := p.getBadgeImageText(b)
+	dmPost := &model.Post{}
+	dmAttachment := model.SlackAttachment{
+		Title: fmt.Sprintf("%sbadge expiring soon", image),
+		Text:  fmt.Sprintf("Your %s`%s` badge expires on %s. Ask whoever granted it to renew it if you should keep it.", image, b.Name, o.ExpiresAt.Format(ExpiryDateFormat)),
+	}
+	if expired {
+		dmAttachment.Title = fmt.Sprintf("%sbadge expired", image)
+		dmAttachment.Text = fmt.Sprintf("Your %s`%s` badge expired on %s.", image, b.Name, o.ExpiresAt.Format(ExpiryDateFormat))
+	}
+	model.ParseSlackAttachment(dmPost, []*model.SlackAttachment{&dmAttachment})
+	err = p.mm.Post.DM(p.BotUserID, o.User, dmPost)
+	if err != nil {
+		p.mm.Log.Debug("dm error", "err", err)
+	}
+}
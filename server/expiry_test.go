@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectExpiryNotices(t *testing.T) {
+	now := time.Now()
+	lastRun := now.Add(-time.Hour)
+	warning := 7 * 24 * time.Hour
+	ownership := func(expiresIn time.Duration) badgesmodel.Ownership {
+		expiresAt := now.Add(expiresIn)
+		return badgesmodel.Ownership{Badge: badgesmodel.BadgeID(expiresIn.String()), ExpiresAt: &expiresAt}
+	}
+
+	expiredLongAgo := ownership(-2 * time.Hour)
+	expiredNow := ownership(-time.Minute)
+	enteredWarning := ownership(warning - time.Minute)
+	alreadyWarned := ownership(warning - 2*time.Hour)
+
+	warn, expired := selectExpiryNotices(badgesmodel.OwnershipList{expiredLongAgo, expiredNow, enteredWarning, alreadyWarned}, lastRun, now, warning)
+	assert.Equal(t, badgesmodel.OwnershipList{enteredWarning}, warn)
+	assert.Equal(t, badgesmodel.OwnershipList{expiredNow}, expired)
+
+	warn, expired = selectExpiryNotices(badgesmodel.OwnershipList{expiredNow, enteredWarning}, lastRun, now, 0)
+	assert.Empty(t, warn, "no warnings when disabled")
+	assert.Equal(t, badgesmodel.OwnershipList{expiredNow}, expired)
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// exportArchiveVersion must be increased whenever the archive format changes in
+// a way older versions of the plugin cannot read.
+const exportArchiveVersion = 1
+
+// exportArchive is the file produced by /badges admin export. Users maps every
+// user ID found in the data to its username, so users can be matched on servers
+// where their IDs are different.
+type exportArchive struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Users      map[string]string `json:"users"`
+	Data       *StoreDump        `json:"data"`
+}
+
+type importResult struct {
+	Types                int
+	Badges               int
+	Ownerships           int
+	Subscriptions        int
+	SkippedOwnerships    int
+	SkippedSubscriptions int
+	UnmatchedUsers       []string
+}
+
+func dumpUserIDs(dump *StoreDump) map[string]bool {
+	ids := map[string]bool{}
+	for _, t := range dump.Types {
+		ids[t.CreatedBy] = true
+		for _, scheme := range getSchemes(t) {
+			for id := range scheme.AllowList {
+				ids[id] = true
+			}
+			for id := range scheme.BlockList {
+				ids[id] = true
+			}
+		}
+	}
+	for _, b := range dump.Badges {
+		ids[b.CreatedBy] = true
+		for _, id := range b.CoOwners {
+			ids[id] = true
+		}
+	}
+	for _, o := range dump.Ownerships {
+		ids[o.User] = true
+		ids[o.GrantedBy] = true
+	}
+	delete(ids, "")
+	return ids
+}
+
+func (p *Plugin) exportBadges() (*exportArchive, error) {
+	dump, err := p.store.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]string{}
+	for id := range dumpUserIDs(dump) {
+		u, err := p.mm.User.Get(id)
+		if err != nil {
+			p.mm.Log.Debug("Cannot get user for export", "userID", id, "err", err)
+			continue
+		}
+		users[id] = u.Username
+	}
+
+	return &exportArchive{
+		Version:    exportArchiveVersion,
+		ExportedAt: time.Now(),
+		Users:      users,
+		Data:       dump,
+	}, nil
+}
+
+func (p *Plugin) sendExport(userID string, archive *exportArchive) error {
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	channel, err := p.mm.Channel.GetDirect(userID, p.BotUserID)
+	if err != nil {
+		return err
+	}
+
+	fileName := fmt.Sprintf("badges-export-%s.json", archive.ExportedAt.Format("2006-01-02-150405"))
+	info, err := p.mm.File.Upload(bytes.NewReader(data), fileName, channel.Id)
+	if err != nil {
+		return err
+	}
+
+	post := &model.Post{
+		Message: fmt.Sprintf(
+			"Badges export with %d types, %d badges, %d grants and %d subscriptions.",
+			len(archive.Data.Types),
+			len(archive.Data.Badges),
+			len(archive.Data.Ownerships),
+			len(archive.Data.Subscriptions),
+		),
+		FileIds: []string{info.Id},
+	}
+	return p.mm.Post.DM(p.BotUserID, userID, post)
+}
+
+// findImportFile returns the given file, or the latest JSON file the user posted
+// on the channel.
+func (p *Plugin) findImportFile(fileID, userID, channelID string) (string, error) {
+	if fileID != "" {
+		return fileID, nil
+	}
+
+	posts, err := p.mm.Post.GetPostsForChannel(channelID, 0, ImportFileSearchPosts)
+	if err != nil {
+		return "", err
+	}
+
+	for _, postID := range posts.Order {
+		post := posts.Posts[postID]
+		if post.UserId != userID {
+			continue
+		}
+		for _, id := range post.FileIds {
+			info, err := p.mm.File.GetInfo(id)
+			if err != nil {
+				continue
+			}
+			if strings.EqualFold(info.Extension, "json") {
+				return id, nil
+			}
+		}
+	}
+
+	return "", errors.New("no JSON file found. Post the export file on this channel, or use --file")
+}
+
+func (p *Plugin) readImportFile(fileID string) (*exportArchive, error) {
+	reader, err := p.mm.File.Get(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &exportArchive{}
+	err = json.Unmarshal(data, archive)
+	if err != nil {
+		return nil, errors.New("the file is not a badges export")
+	}
+
+	if archive.Version < 1 || archive.Data == nil {
+		return nil, errors.New("the file is not a badges export")
+	}
+
+	if archive.Version > exportArchiveVersion {
+		return nil, fmt.Errorf("the export version %d is newer than the supported version %d. Update the plugin first", archive.Version, exportArchiveVersion)
+	}
+
+	return archive, nil
+}
+
+// matchUsers maps the archive user IDs to the IDs on this server. Users are
+// matched by ID first, and then by username.
+func (p *Plugin) matchUsers(archive *exportArchive) (map[string]string, []string) {
+	mapping := map[string]string{}
+	unmatched := []string{}
+	for id := range dumpUserIDs(archive.Data) {
+		if _, err := p.mm.User.Get(id); err == nil {
+			mapping[id] = id
+			continue
+		}
+
+		username, ok := archive.Users[id]
+		if ok {
+			if u, err := p.mm.User.GetByUsername(username); err == nil {
+				mapping[id] = u.Id
+				continue
+			}
+		} else {
+			username = id
+		}
+		unmatched = append(unmatched, username)
+	}
+
+	return mapping, unmatched
+}
+
+// remapUsers rewrites every user ID on the dump. Grants to users without a match
+// are dropped, as are unmatched users on allow and block lists and unmatched
+// co-owners. Creators and granters without a match are kept, and show as
+// unknown.
+func remapUsers(dump *StoreDump, mapping map[string]string) (*StoreDump, int) {
+	mapID := func(id string) string {
+		if mapped, ok := mapping[id]; ok {
+			return mapped
+		}
+		return id
+	}
+	mapList := func(in map[string]bool) map[string]bool {
+		if in == nil {
+			return nil
+		}
+		out := map[string]bool{}
+		for id, v := range in {
+			if mapped, ok := mapping[id]; ok {
+				out[mapped] = v
+			}
+		}
+		return out
+	}
+
+	out := &StoreDump{
+		Types:         badgesmodel.BadgeTypeList{},
+		Badges:        []*badgesmodel.Badge{},
+		Ownerships:    badgesmodel.OwnershipList{},
+		Subscriptions: append([]badgesmodel.Subscription{}, dump.Subscriptions...),
+	}
+
+	for _, t := range dump.Types {
+		remapped := *t
+		remapped.CreatedBy = mapID(t.CreatedBy)
+		remapped.CanCreate.AllowList = mapList(t.CanCreate.AllowList)
+		remapped.CanCreate.BlockList = mapList(t.CanCreate.BlockList)
+		remapped.CanGrant.AllowList = mapList(t.CanGrant.AllowList)
+		remapped.CanGrant.BlockList = mapList(t.CanGrant.BlockList)
+		remapped.CanEdit.AllowList = mapList(t.CanEdit.AllowList)
+		remapped.CanEdit.BlockList = mapList(t.CanEdit.BlockList)
+		out.Types = append(out.Types, &remapped)
+	}
+
+	for _, b := range dump.Badges {
+		remapped := *b
+		remapped.CreatedBy = mapID(b.CreatedBy)
+		remapped.CoOwners = nil
+		for _, id := range b.CoOwners {
+			if mapped, ok := mapping[id]; ok {
+				remapped.CoOwners = append(remapped.CoOwners, mapped)
+			}
+		}
+		out.Badges = append(out.Badges, &remapped)
+	}
+
+	skipped := 0
+	for _, o := range dump.Ownerships {
+		userID, ok := mapping[o.User]
+		if !ok {
+			skipped++
+			continue
+		}
+		o.User = userID
+		o.GrantedBy = mapID(o.GrantedBy)
+		out.Ownerships = append(out.Ownerships, o)
+	}
+
+	return out, skipped
+}
+
+// mergeDumps adds the imported data to the current one. Imported types and
+// badges are matched to existing ones by ID, and then by name. Anything without a
+// match gets a new ID, so it never overwrites existing data.
+func mergeDumps(current, imported *StoreDump) (*StoreDump, *importResult) {
+	result := &importResult{}
+	merged := &StoreDump{
+		Types:         append(badgesmodel.BadgeTypeList{}, current.Types...),
+		Badges:        append([]*badgesmodel.Badge{}, current.Badges...),
+		Ownerships:    append(badgesmodel.OwnershipList{}, current.Ownerships...),
+		Subscriptions: append([]badgesmodel.Subscription{}, current.Subscriptions...),
+	}
+
+	typeIDs := map[badgesmodel.BadgeType]badgesmodel.BadgeType{}
+	for _, t := range imported.Types {
+		var match *badgesmodel.BadgeTypeDefinition
+		for _, existing := range current.Types {
+			if existing.ID == t.ID {
+				match = existing
+				break
+			}
+		}
+		if match == nil {
+			for _, existing := range current.Types {
+				if existing.Name == t.Name {
+					match = existing
+					break
+				}
+			}
+		}
+		if match != nil {
+			typeIDs[t.ID] = match.ID
+			continue
+		}
+
+		added := *t
+		added.ID = badgesmodel.BadgeType(model.NewId())
+		typeIDs[t.ID] = added.ID
+		merged.Types = append(merged.Types, &added)
+		result.Types++
+	}
+
+	badgeIDs := map[badgesmodel.BadgeID]badgesmodel.BadgeID{}
+	for _, b := range imported.Badges {
+		typeID, ok := typeIDs[b.Type]
+		if !ok {
+			continue
+		}
+
+		var match *badgesmodel.Badge
+		for _, existing := range current.Badges {
+			if existing.ID == b.ID {
+				match = existing
+				break
+			}
+		}
+		if match == nil {
+			for _, existing := range current.Badges {
+				if existing.Name == b.Name && existing.Type == typeID {
+					match = existing
+					break
+				}
+			}
+		}
+		if match != nil {
+			badgeIDs[b.ID] = match.ID
+			continue
+		}
+
+		added := *b
+		added.ID = badgesmodel.BadgeID(model.NewId())
+		added.Type = typeID
+		badgeIDs[b.ID] = added.ID
+		merged.Badges = append(merged.Badges, &added)
+		result.Badges++
+	}
+
+	// Badges that cannot be granted more than once are only granted to users
+	// who do not have them yet.
+	single := map[badgesmodel.BadgeID]bool{}
+	for _, b := range merged.Badges {
+		single[b.ID] = !b.Multiple
+	}
+	owned := map[string]bool{}
+	ownedKey := func(o badgesmodel.Ownership) string {
+		return o.User + "/" + string(o.Badge)
+	}
+	for _, o := range merged.Ownerships {
+		owned[ownedKey(o)] = true
+	}
+
+	for _, o := range imported.Ownerships {
+		badgeID, ok := badgeIDs[o.Badge]
+		if !ok {
+			result.SkippedOwnerships++
+			continue
+		}
+		o.Badge = badgeID
+		if merged.Ownerships.Contains(o) {
+			continue
+		}
+		if single[badgeID] && owned[ownedKey(o)] {
+			continue
+		}
+		merged.Ownerships = append(merged.Ownerships, o)
+		owned[ownedKey(o)] = true
+		result.Ownerships++
+	}
+
+	for _, sub := range imported.Subscriptions {
+		typeID, ok := typeIDs[sub.TypeID]
+		if !ok {
+			continue
+		}
+		sub.TypeID = typeID
+
+		found := false
+		for _, existing := range merged.Subscriptions {
+			if existing == sub {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		merged.Subscriptions = append(merged.Subscriptions, sub)
+		result.Subscriptions++
+	}
+
+	return merged, result
+}
+
+func (p *Plugin) importBadges(archive *exportArchive, mode, actorID string) (*importResult, error) {
+	mapping, unmatched := p.matchUsers(archive)
+	imported, skippedOwnerships := remapUsers(archive.Data, mapping)
+
+	// Channels are not part of the export, so subscriptions only survive when the
+	// channel exists on this server.
+	subs := []badgesmodel.Subscription{}
+	skippedSubscriptions := 0
+	for _, sub := range imported.Subscriptions {
+		if _, err := p.mm.Channel.Get(sub.ChannelID); err != nil {
+			skippedSubscriptions++
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	imported.Subscriptions = subs
+
+	var result *importResult
+	var toRestore *StoreDump
+	switch mode {
+	case ImportModeReplace:
+		toRestore = imported
+		result = &importResult{
+			Types:         len(imported.Types),
+			Badges:        len(imported.Badges),
+			Ownerships:    len(imported.Ownerships),
+			Subscriptions: len(imported.Subscriptions),
+		}
+	case ImportModeMerge:
+		current, err := p.store.Dump()
+		if err != nil {
+			return nil, err
+		}
+		toRestore, result = mergeDumps(current, imported)
+	default:
+		return nil, fmt.Errorf("unknown import mode %s", mode)
+	}
+
+	err := p.storeAs(actorID).Restore(toRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	result.SkippedOwnerships += skippedOwnerships
+	result.SkippedSubscriptions += skippedSubscriptions
+	result.UnmatchedUsers = unmatched
+	return result, nil
+}
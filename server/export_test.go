@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemapUsers(t *testing.T) {
+	now := time.Now()
+	dump := &StoreDump{
+		Types: badgesmodel.BadgeTypeList{{
+			ID:        "type",
+			CreatedBy: "creator",
+			CanGrant:  badgesmodel.PermissionScheme{AllowList: map[string]bool{"granter": true, "gone": true}},
+		}},
+		Badges: []*badgesmodel.Badge{{ID: "badge", Type: "type", CreatedBy: "gone"}},
+		Ownerships: badgesmodel.OwnershipList{
+			{User: "user", GrantedBy: "granter", Badge: "badge", Time: now},
+			{User: "gone", GrantedBy: "granter", Badge: "badge", Time: now},
+		},
+	}
+	mapping := map[string]string{"creator": "creator", "granter": "new-granter", "user": "new-user"}
+
+	remapped, skipped := remapUsers(dump, mapping)
+
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, map[string]bool{"new-granter": true}, remapped.Types[0].CanGrant.AllowList)
+	assert.Equal(t, "gone", remapped.Badges[0].CreatedBy)
+	require.Len(t, remapped.Ownerships, 1)
+	assert.Equal(t, "new-user", remapped.Ownerships[0].User)
+	assert.Equal(t, "new-granter", remapped.Ownerships[0].GrantedBy)
+
+	assert.Equal(t, "granter", dump.Ownerships[0].GrantedBy, "the original dump must not change")
+	assert.True(t, dump.Types[0].CanGrant.AllowList["gone"])
+}
+
+func TestMergeDumps(t *testing.T) {
+	now := time.Now()
+	current := &StoreDump{
+		Types: badgesmodel.BadgeTypeList{{ID: "type", Name: "Kudos"}},
+		Badges: []*badgesmodel.Badge{
+			{ID: "badge", Name: "Helper", Type: "type"},
+			{ID: "multiple", Name: "Thanks", Type: "type", Multiple: true},
+		},
+		Ownerships: badgesmodel.OwnershipList{
+			{User: "user", Badge: "badge", Time: now},
+			{User: "user", Badge: "multiple", Time: now},
+		},
+		Subscriptions: []badgesmodel.Subscription{{TypeID: "type", ChannelID: "channel"}},
+	}
+	imported := &StoreDump{
+		Types: badgesmodel.BadgeTypeList{
+			{ID: "other-type", Name: "Kudos"},
+			{ID: "new-type", Name: "Events"},
+		},
+		Badges: []*badgesmodel.Badge{
+			{ID: "other-badge", Name: "Helper", Type: "other-type"},
+			{ID: "new-badge", Name: "Speaker", Type: "new-type"},
+			{ID: "other-multiple", Name: "Thanks", Type: "other-type", Multiple: true},
+		},
+		Ownerships: badgesmodel.OwnershipList{
+			{User: "user", Badge: "other-badge", Time: now},
+			{User: "user", Badge: "other-badge", GrantedBy: "other", Time: now.Add(-time.Hour)},
+			{User: "user", Badge: "other-multiple", GrantedBy: "other", Time: now.Add(-time.Hour)},
+			{User: "user", Badge: "new-badge", Time: now},
+			{User: "user", Badge: "missing", Time: now},
+		},
+		Subscriptions: []badgesmodel.Subscription{
+			{TypeID: "other-type", ChannelID: "channel"},
+			{TypeID: "new-type", ChannelID: "channel"},
+		},
+	}
+
+	merged, result := mergeDumps(current, imported)
+
+	assert.Equal(t, 1, result.Types)
+	assert.Equal(t, 1, result.Badges)
+	assert.Equal(t, 2, result.Ownerships, "single badges are not granted twice")
+	assert.Equal(t, 1, result.Subscriptions)
+	assert.Equal(t, 1, result.SkippedOwnerships)
+
+	require.Len(t, merged.Types, 2)
+	newType := merged.Types[1]
+	assert.Equal(t, "Events", newType.Name)
+	assert.NotEqual(t, badgesmodel.BadgeType("new-type"), newType.ID, "new types get a new ID")
+
+	require.Len(t, merged.Badges, 3)
+	newBadge := merged.Badges[2]
+	assert.Equal(t, newType.ID, newBadge.Type)
+
+	require.Len(t, merged.Ownerships, 4)
+	assert.Equal(t, badgesmodel.BadgeID("multiple"), merged.Ownerships[2].Badge, "multiple badges can be granted again")
+	assert.Equal(t, newBadge.ID, merged.Ownerships[3].Badge)
+	assert.Equal(t, badgesmodel.Subscription{TypeID: newType.ID, ChannelID: "channel"}, merged.Subscriptions[1])
+
+	assert.Len(t, current.Types, 1, "the current dump must not change")
+}
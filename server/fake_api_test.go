@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+)
+
+// fakeAPI keeps the plugin KV store in memory. Anything not overridden here falls
+// through to the embedded mock, so tests can still set expectations on it.
+type fakeAPI struct {
+	plugintest.API
+
+	mu    sync.Mutex
+	kv    map[string][]byte
+	users map[string]*model.User
+
+	// beforeCompareAndSet runs before every compare and set, without the lock
+	// held, so tests can write a competing value and force a conflict.
+	beforeCompareAndSet func(key string)
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{kv: map[string][]byte{}, users: map[string]*model.User{}}
+}
+
+func (a *fakeAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.kv[key], nil
+}
+
+func (a *fakeAPI) KVSet(key string, value []byte) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if value == nil {
+		delete(a.kv, key)
+		return nil
+	}
+	a.kv[key] = value
+	return nil
+}
+
+func (a *fakeAPI) KVDelete(key string) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *fakeAPI) KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+	if a.beforeCompareAndSet != nil {
+		a.beforeCompareAndSet(key)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	current, ok := a.kv[key]
+	if oldValue == nil && ok {
+		return false, nil
+	}
+	if oldValue != nil && !bytes.Equal(oldValue, current) {
+		return false, nil
+	}
+
+	a.kv[key] = newValue
+	return true, nil
+}
+
+func (a *fakeAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := []string{}
+	for key := range a.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start := page * perPage
+	if start >= len(keys) {
+		return []string{}, nil
+	}
+	end := start + perPage
+	if end > len(keys) {
+		end = len(keys)
+	}
+	return keys[start:end], nil
+}
+
+// addUser makes the user found by GetUser. Any other user is not found.
+func (a *fakeAPI) addUser(u *model.User) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.users[u.Id] = u
+}
+
+func (a *fakeAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if u, ok := a.users[userID]; ok {
+		return u, nil
+	}
+	return nil, model.NewAppError("GetUser", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) GetConfig() *model.Config {
+	config := &model.Config{}
+	config.SetDefaults()
+	return config
+}
+
+func (a *fakeAPI) LogDebug(msg string, keyValuePairs ...interface{}) {}
+func (a *fakeAPI) LogInfo(msg string, keyValuePairs ...interface{})  {}
+func (a *fakeAPI) LogWarn(msg string, keyValuePairs ...interface{})  {}
+func (a *fakeAPI) LogError(msg string, keyValuePairs ...interface{}) {}
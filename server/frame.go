@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	framedImageSize  = 128
+	frameStrokeWidth = 8
+	// frameInset is the space around the badge image, inside the frame.
+	frameInset = 20
+)
+
+var (
+	frameColourPattern  = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	frameImageIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+	errFrameShape   = errors.New("the frame shape must be circle, square, rounded or hexagon")
+	errFrameColour  = errors.New("the frame colour must be like #1e88e5")
+	errFrameImage   = errors.New("unknown frame image")
+	errFrameTooMany = errors.New("pick either a frame shape or a frame image, not both")
+)
+
+// typeFrame is the parsed frame of a type.
+type typeFrame struct {
+	Shape   string
+	Colour  string
+	ImageID string
+}
+
+func parseFrame(frame string) (*typeFrame, error) {
+	if frame == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(frame, badgesmodel.FrameImagePrefix) {
+		imageID := strings.TrimPrefix(frame, badgesmodel.FrameImagePrefix)
+		if !frameImageIDPattern.MatchString(imageID) {
+			return nil, errFrameImage
+		}
+		return &typeFrame{ImageID: imageID}, nil
+	}
+
+	parts := strings.SplitN(frame, ":", 2)
+	f := &typeFrame{Shape: parts[0], Colour: DefaultFrameColour}
+	if len(parts) == 2 {
+		f.Colour = parts[1]
+	}
+
+	switch f.Shape {
+	case badgesmodel.FrameShapeCircle, badgesmodel.FrameShapeSquare, badgesmodel.FrameShapeRounded, badgesmodel.FrameShapeHexagon:
+	default:
+		return nil, errFrameShape
+	}
+	if !frameColourPattern.MatchString(f.Colour) {
+		return nil, errFrameColour
+	}
+
+	return f, nil
+}
+
+func (f *typeFrame) String() string {
+	if f == nil {
+		return ""
+	}
+	if f.ImageID != "" {
+		return badgesmodel.FrameImagePrefix + f.ImageID
+	}
+	return f.Shape + ":" + f.Colour
+}
+
+// canFrame tells whether the framed image of the badge can be rendered. Images
+// at other URLs cannot be embedded, so those badges are never framed.
+func canFrame(b *badgesmodel.Badge) bool {
+	return b.ImageType == badgesmodel.ImageTypeEmoji || b.ImageType == badgesmodel.ImageTypeUpload
+}
+
+// getFramedImageVersion changes whenever the badge image or its frame change.
+func getFramedImageVersion(b *badgesmodel.Badge, frame string) string {
+	hash := sha256.Sum256([]byte(string(b.ImageType) + "\n" + b.Image + "\n" + frame))
+	return hex.EncodeToString(hash[:8])
+}
+
+// getFramedImagePath is where the plugin serves the badge image inside the frame
+// of its type, relative to the plugin URL.
+func getFramedImagePath(b *badgesmodel.Badge, frame string) string {
+	return ImagePath + "/" + string(b.ID) + "/framed?v=" + getFramedImageVersion(b, frame)
+}
+
+// getTypeFrames returns the frame of every type that has one, archived or not,
+// to frame many badges at once.
+func (p *Plugin) getTypeFrames() map[badgesmodel.BadgeType]string {
+	frames := map[badgesmodel.BadgeType]string{}
+	types, err := p.store.GetRawTypes()
+	if err != nil {
+		p.mm.Log.Debug("Cannot get the type frames", "err", err)
+		return frames
+	}
+
+	for _, t := range types {
+		if t.Frame != "" {
+			frames[t.ID] = t.Frame
+		}
+	}
+	return frames
+}
+
+// badgeContent is the image of a badge, as drawn inside its frame. It is either
+// an embedded image or a text.
+type badgeContent struct {
+	ContentType string
+	Data        []byte
+	Text        string
+}
+
+func (p *Plugin) getBadgeContent(b *badgesmodel.Badge) (*badgeContent, error) {
+	switch b.ImageType {
+	case badgesmodel.ImageTypeUpload:
+		image, err := getImage(p.API, b.Image)
+		if err != nil {
+			return nil, err
+		}
+		return &badgeContent{ContentType: image.ContentType, Data: image.Data}, nil
+	case badgesmodel.ImageTypeEmoji:
+		if code, ok := model.SystemEmojis[b.Image]; ok {
+			return &badgeContent{Text: emojiText(code)}, nil
+		}
+
+		emoji, err := p.mm.Emoji.GetByName(b.Image)
+		if err != nil {
+			return nil, err
+		}
+		reader, format, err := p.mm.Emoji.GetImage(emoji.Id)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		return &badgeContent{ContentType: "image/" + format, Data: data}, nil
+	}
+
+	return nil, fmt.Errorf("cannot frame %s images", b.ImageType)
+}
+
+// emojiText turns a system emoji code, like "1f44d-1f3fb", into its characters.
+func emojiText(code string) string {
+	out := strings.Builder{}
+	for _, point := range strings.Split(code, "-") {
+		r, err := strconv.ParseUint(point, 16, 32)
+		if err != nil {
+			continue
+		}
+		out.WriteRune(rune(r))
+	}
+	return out.String()
+}
+
+func dataURI(contentType string, data []byte) string {
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// frameShapeSVG is the outline of the shape, centered on the image.
+func frameShapeSVG(shape string, attributes string) string {
+	const c = framedImageSize / 2
+	const r = c - frameStrokeWidth/2
+	switch shape {
+	case badgesmodel.FrameShapeCircle:
+		return fmt.Sprintf(`<circle cx="%d" cy="%d" r="%d" %s/>`, c, c, r, attributes)
+	case badgesmodel.FrameShapeRounded:
+		return fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" rx="%d" %s/>`, c-r, c-r, 2*r, 2*r, r/3, attributes)
+	case badgesmodel.FrameShapeHexagon:
+		h := r * 866 / 1000
+		return fmt.Sprintf(`<polygon points="%d,%d %d,%d %d,%d %d,%d %d,%d %d,%d" %s/>`,
+			c, c-r, c+h, c-r/2, c+h, c+r/2, c, c+r, c-h, c+r/2, c-h, c-r/2, attributes)
+	}
+	return fmt.Sprintf(`<rect x="%d" y="%d" width="%d" height="%d" %s/>`, c-r, c-r, 2*r, 2*r, attributes)
+}
+
+// renderFramedImage draws the badge content inside the frame, as an SVG image.
+// Every image is embedded, as SVG images shown on an img tag cannot load
+// anything else.
+func renderFramedImage(content *badgeContent, frame *typeFrame, frameImage *badgeImage) []byte {
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`,
+		framedImageSize, framedImageSize, framedImageSize, framedImageSize)
+
+	if frame.Shape != "" {
+		out.WriteString(frameShapeSVG(frame.Shape, fmt.Sprintf(`fill="%s" fill-opacity="0.15"`, frame.Colour)))
+	}
+
+	inner := framedImageSize - 2*frameInset
+	switch {
+	case content.Data != nil:
+		fmt.Fprintf(out, `<image x="%d" y="%d" width="%d" height="%d" href="%s"/>`,
+			frameInset, frameInset, inner, inner, dataURI(content.ContentType, content.Data))
+	case content.Text != "":
+		fmt.Fprintf(out, `<text x="%d" y="%d" font-size="%d" text-anchor="middle" dominant-baseline="central">`,
+			framedImageSize/2, framedImageSize/2, inner*3/4)
+		_ = xml.EscapeText(out, []byte(content.Text))
+		out.WriteString(`</text>`)
+	}
+
+	if frame.Shape != "" {
+		out.WriteString(frameShapeSVG(frame.Shape, fmt.Sprintf(`fill="none" stroke="%s" stroke-width="%d"`, frame.Colour, frameStrokeWidth)))
+	}
+	if frameImage != nil {
+		fmt.Fprintf(out, `<image x="0" y="0" width="%d" height="%d" href="%s"/>`,
+			framedImageSize, framedImageSize, dataURI(frameImage.ContentType, frameImage.Data))
+	}
+
+	out.WriteString(`</svg>`)
+	return out.Bytes()
+}
+
+func (p *Plugin) getFramedBadgeImage(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	badgeID := badgesmodel.BadgeID(mux.Vars(r)["badgeID"])
+
+	badge, err := p.store.GetBadge(badgeID)
+	if err != nil || !canFrame(badge) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	t, err := p.store.GetType(badge.Type)
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	frame, err := parseFrame(t.Frame)
+	if err != nil || frame == nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	etag := strconv.Quote(getFramedImageVersion(badge, t.Frame))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", ImageCacheMaxAge))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	content, err := p.getBadgeContent(badge)
+	if err != nil {
+		p.mm.Log.Debug("Cannot get the badge image to frame", "badgeID", badgeID, "err", err)
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	var frameImage *badgeImage
+	if frame.ImageID != "" {
+		frameImage, err = getImage(p.API, frame.ImageID)
+		if err != nil {
+			p.mm.Log.Debug("Cannot get the frame image", "typeID", t.ID, "err", err)
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", imageContentTypeSVG)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(renderFramedImage(content, frame, frameImage))
+}
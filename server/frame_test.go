@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFrame(t *testing.T) {
+	imageID := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	for name, tc := range map[string]struct {
+		frame    string
+		expected *typeFrame
+		err      error
+	}{
+		"no frame":       {"", nil, nil},
+		"shape":          {"circle:#abc", &typeFrame{Shape: "circle", Colour: "#abc"}, nil},
+		"default colour": {"hexagon", &typeFrame{Shape: "hexagon", Colour: DefaultFrameColour}, nil},
+		"image":          {"image:" + imageID, &typeFrame{ImageID: imageID}, nil},
+		"unknown shape":  {"star:#abcdef", nil, errFrameShape},
+		"bad colour":     {"square:red\"", nil, errFrameColour},
+		"bad image":      {"image:../badges", nil, errFrameImage},
+	} {
+		t.Run(name, func(t *testing.T) {
+			frame, err := parseFrame(tc.frame)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.expected, frame)
+			if tc.expected != nil {
+				again, err := parseFrame(frame.String())
+				require.NoError(t, err)
+				assert.Equal(t, frame, again)
+			}
+		})
+	}
+}
+
+func TestRenderFramedImage(t *testing.T) {
+	t.Run("emoji in a shape", func(t *testing.T) {
+		out := string(renderFramedImage(&badgeContent{Text: emojiText(model.SystemEmojis["+1"])}, &typeFrame{Shape: badgesmodel.FrameShapeCircle, Colour: "#123456"}, nil))
+		assert.Contains(t, out, "<circle")
+		assert.Contains(t, out, `stroke="#123456"`)
+		assert.Contains(t, out, "👍")
+	})
+
+	t.Run("text is escaped", func(t *testing.T) {
+		out := string(renderFramedImage(&badgeContent{Text: "<script>"}, &typeFrame{Shape: badgesmodel.FrameShapeSquare, Colour: "#123456"}, nil))
+		assert.NotContains(t, out, "<script>")
+	})
+
+	t.Run("image in an image frame", func(t *testing.T) {
+		out := string(renderFramedImage(
+			&badgeContent{ContentType: imageContentTypePNG, Data: testPNG},
+			&typeFrame{ImageID: "frame"},
+			&badgeImage{ContentType: imageContentTypeSVG, Data: []byte("<svg/>")},
+		))
+		assert.Contains(t, out, "data:image/png;base64,")
+		assert.Contains(t, out, "data:image/svg+xml;base64,")
+		assert.NotContains(t, out, "stroke")
+	})
+}
+
+func TestGetFramedBadgeImage(t *testing.T) {
+	s := newMemStore()
+	framed := addTestType(t, s)
+	framed.Frame = "rounded:#00ff00"
+	require.NoError(t, s.UpdateType(framed))
+	plain := addTestType(t, s)
+
+	badge := addTestBadge(t, s, framed.ID, false)
+	unframed := addTestBadge(t, s, plain.ID, false)
+
+	p := setupTestPlugin(s)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Mattermost-User-ID", model.NewId())
+		p.ServeHTTP(&plugin.Context{}, w, r)
+		return w
+	}
+
+	t.Run("framed badge", func(t *testing.T) {
+		w := get("/images/" + string(badge.ID) + "/framed")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, imageContentTypeSVG, w.Header().Get("Content-Type"))
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+		assert.Contains(t, w.Body.String(), `stroke="#00ff00"`)
+	})
+
+	t.Run("type without frame", func(t *testing.T) {
+		w := get("/images/" + string(unframed.ID) + "/framed")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("payload", func(t *testing.T) {
+		w := get("/api/v1/getAllBadges")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "/plugins/"+manifest.Id+getFramedImagePath(badge, framed.Frame))
+		assert.Contains(t, w.Body.String(), `"image":"`+unframed.Image+`"`)
+	})
+
+	t.Run("the URL changes with the frame", func(t *testing.T) {
+		assert.NotEqual(t, getFramedImagePath(badge, framed.Frame), getFramedImagePath(badge, "rounded:#0000ff"))
+	})
+}
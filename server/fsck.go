@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/pkg/errors"
+)
+
+const fsckMutexKey = "fsck"
+
+type userState int
+
+const (
+	userStateActive userState = iota
+	userStateDeactivated
+	userStateDeleted
+)
+
+// fsckReport lists every inconsistency found on a dump of the store.
+type fsckReport struct {
+	// BadgesWithoutType point to a type that does not exist.
+	BadgesWithoutType []*badgesmodel.Badge
+	// OrphanOwnerships are grants of badges that do not exist, or that are on
+	// BadgesWithoutType.
+	OrphanOwnerships badgesmodel.OwnershipList
+	// OrphanSubscriptions are subscriptions to types that do not exist.
+	OrphanSubscriptions []badgesmodel.Subscription
+	// DeletedOwners and DeactivatedOwners are grants to users that no longer
+	// exist, or that are deactivated.
+	DeletedOwners     badgesmodel.OwnershipList
+	DeactivatedOwners badgesmodel.OwnershipList
+}
+
+func (r *fsckReport) IsClean() bool {
+	return len(r.BadgesWithoutType) == 0 &&
+		len(r.OrphanOwnerships) == 0 &&
+		len(r.OrphanSubscriptions) == 0 &&
+		len(r.DeletedOwners) == 0 &&
+		len(r.DeactivatedOwners) == 0
+}
+
+// checkDump finds the inconsistencies of a dump. getUserState is called once per
+// distinct owner.
+func checkDump(dump *StoreDump, getUserState func(userID string) (userState, error)) (*fsckReport, error) {
+	report := &fsckReport{
+		BadgesWithoutType:   []*badgesmodel.Badge{},
+		OrphanOwnerships:    badgesmodel.OwnershipList{},
+		OrphanSubscriptions: []badgesmodel.Subscription{},
+		DeletedOwners:       badgesmodel.OwnershipList{},
+		DeactivatedOwners:   badgesmodel.OwnershipList{},
+	}
+
+	validBadges := map[badgesmodel.BadgeID]bool{}
+	for _, b := range dump.Badges {
+		if dump.Types.GetType(b.Type) == nil {
+			report.BadgesWithoutType = append(report.BadgesWithoutType, b)
+			continue
+		}
+		validBadges[b.ID] = true
+	}
+
+	for _, sub := range dump.Subscriptions {
+		if dump.Types.GetType(sub.TypeID) == nil {
+			report.OrphanSubscriptions = append(report.OrphanSubscriptions, sub)
+		}
+	}
+
+	states := map[string]userState{}
+	for _, o := range dump.Ownerships {
+		if !validBadges[o.Badge] {
+			report.OrphanOwnerships = append(report.OrphanOwnerships, o)
+			continue
+		}
+
+		state, ok := states[o.User]
+		if !ok {
+			var err error
+			state, err = getUserState(o.User)
+			if err != nil {
+				return nil, err
+			}
+			states[o.User] = state
+		}
+
+		switch state {
+		case userStateDeleted:
+			report.DeletedOwners = append(report.DeletedOwners, o)
+		case userStateDeactivated:
+			report.DeactivatedOwners = append(report.DeactivatedOwners, o)
+		}
+	}
+
+	return report, nil
+}
+
+// repairStore removes the inconsistencies of the report from the store, one
+// at a time, so changes made since the report was taken are kept. Grants to
+// deactivated users are kept, as those users can be activated again.
+func repairStore(s Store, report *fsckReport) error {
+	badges := []badgesmodel.BadgeID{}
+	for _, b := range report.BadgesWithoutType {
+		badges = append(badges, b.ID)
+	}
+
+	ownerships := append(badgesmodel.OwnershipList{}, report.OrphanOwnerships...)
+	ownerships = append(ownerships, report.DeletedOwners...)
+
+	return s.RemoveOrphans(badges, ownerships, report.OrphanSubscriptions)
+}
+
+func (p *Plugin) getUserState(userID string) (userState, error) {
+	u, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return userStateDeleted, nil
+		}
+		return userStateActive, appErr
+	}
+
+	if u.DeleteAt != 0 {
+		return userStateDeactivated, nil
+	}
+
+	return userStateActive, nil
+}
+
+// fsck checks the store, and if repair is set, removes what the check found
+// after taking a snapshot, in the name of actorID. Only one fsck runs at a time
+// on the cluster.
+func (p *Plugin) fsck(repair bool, actorID string) (*fsckReport, *snapshotInfo, error) {
+	m, err := cluster.NewMutex(p.API, fsckMutexKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	dump, err := p.store.Dump()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	report, err := checkDump(dump, p.getUserState)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !repair || report.IsClean() {
+		return report, nil, nil
+	}
+
+	info, err := takeSnapshot(p.API, p.store, "Repair", SnapshotsToKeep)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot take a snapshot before repairing")
+	}
+
+	err = repairStore(p.storeAs(actorID), report)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return report, info, nil
+}
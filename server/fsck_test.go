@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckAndRepairStore(t *testing.T) {
+	api := newFakeAPI()
+	s := NewStore(api).(*store)
+
+	kept := addTestType(t, s)
+	deleted := addTestType(t, s)
+	deletedBadge := addTestBadge(t, s, kept.ID, false)
+	keptBadge := addTestBadge(t, s, kept.ID, true)
+	typelessBadge := addTestBadge(t, s, deleted.ID, false)
+
+	active, gone, deactivated := model.NewId(), model.NewId(), model.NewId()
+	grant := func(badgeID badgesmodel.BadgeID, userID string) {
+		_, err := s.GrantBadge(badgeID, userID, model.NewId(), "")
+		require.NoError(t, err)
+	}
+	grant(deletedBadge.ID, active)
+	grant(keptBadge.ID, active)
+	grant(keptBadge.ID, gone)
+	grant(keptBadge.ID, deactivated)
+	grant(typelessBadge.ID, active)
+	require.NoError(t, s.AddSubscription(deleted.ID, model.NewId()))
+
+	// Leave the store as an interrupted DeleteType and DeleteBadge would.
+	require.NoError(t, s.doAtomic(func() (bool, error) { return s.atomicDeleteType(deleted.ID) }))
+	require.NoError(t, s.doAtomic(func() (bool, error) { return s.atomicRemoveBadge(deletedBadge.ID) }))
+
+	getUserState := func(userID string) (userState, error) {
+		switch userID {
+		case gone:
+			return userStateDeleted, nil
+		case deactivated:
+			return userStateDeactivated, nil
+		}
+		return userStateActive, nil
+	}
+
+	dump, err := s.Dump()
+	require.NoError(t, err)
+	report, err := checkDump(dump, getUserState)
+	require.NoError(t, err)
+
+	require.Len(t, report.BadgesWithoutType, 1)
+	assert.Equal(t, typelessBadge.ID, report.BadgesWithoutType[0].ID)
+	assert.Len(t, report.OrphanOwnerships, 2)
+	assert.Len(t, report.OrphanSubscriptions, 1)
+	require.Len(t, report.DeletedOwners, 1)
+	assert.Equal(t, gone, report.DeletedOwners[0].User)
+	require.Len(t, report.DeactivatedOwners, 1)
+	assert.False(t, report.IsClean())
+
+	// Changes made after the check are kept by the repair.
+	late, err := s.GrantBadge(keptBadge.ID, active, model.NewId(), "")
+	require.NoError(t, err)
+	require.NoError(t, s.AddSubscription(kept.ID, model.NewId()))
+
+	require.NoError(t, repairStore(s, report))
+
+	dump, err = s.Dump()
+	require.NoError(t, err)
+	report, err = checkDump(dump, getUserState)
+	require.NoError(t, err)
+	assert.Empty(t, report.BadgesWithoutType)
+	assert.Empty(t, report.OrphanOwnerships)
+	assert.Empty(t, report.OrphanSubscriptions)
+	assert.Empty(t, report.DeletedOwners)
+	assert.Len(t, report.DeactivatedOwners, 1, "grants to deactivated users are kept")
+
+	assert.Len(t, dump.Subscriptions, 1)
+
+	userOwnership, _, err := s.getUserOwnershipList(active)
+	require.NoError(t, err)
+	require.Len(t, userOwnership, 2)
+	assert.Equal(t, keptBadge.ID, userOwnership[0].Badge)
+	assert.Equal(t, late.GrantID, userOwnership[1].GrantID)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+)
+
+var errVersionNotFound = errors.New("version not found")
+
+// versionFields change on every version, so they are left out of the changes
+// between versions.
+var versionFields = []string{"version", "updated_by", "updated_at"}
+
+func setFirstBadgeVersion(b *badgesmodel.Badge) {
+	b.Version = badgesmodel.FirstVersion
+	b.UpdatedAt = time.Now()
+	if b.UpdatedBy == "" {
+		b.UpdatedBy = b.CreatedBy
+	}
+}
+
+func setFirstTypeVersion(t *badgesmodel.BadgeTypeDefinition) {
+	t.Version = badgesmodel.FirstVersion
+	t.UpdatedAt = time.Now()
+	if t.UpdatedBy == "" {
+		t.UpdatedBy = t.CreatedBy
+	}
+}
+
+func setNextBadgeVersion(b, previous *badgesmodel.Badge) {
+	b.Version = previous.GetVersion() + 1
+	b.UpdatedAt = time.Now()
+}
+
+func setNextTypeVersion(t, previous *badgesmodel.BadgeTypeDefinition) {
+	t.Version = previous.GetVersion() + 1
+	t.UpdatedAt = time.Now()
+}
+
+// badgeHistory appends the current badge to its previous versions. Versions not
+// older than the current one are left over from restoring an older state, and
+// are dropped.
+func badgeHistory(versions []*badgesmodel.Badge, current *badgesmodel.Badge) []*badgesmodel.Badge {
+	out := []*badgesmodel.Badge{}
+	for _, v := range versions {
+		if v.GetVersion() < current.GetVersion() {
+			v.Version = v.GetVersion()
+			out = append(out, v)
+		}
+	}
+	current.Version = current.GetVersion()
+	return append(out, current)
+}
+
+// typeHistory appends the current type to its previous versions, like
+// badgeHistory.
+func typeHistory(versions badgesmodel.BadgeTypeList, current *badgesmodel.BadgeTypeDefinition) badgesmodel.BadgeTypeList {
+	out := badgesmodel.BadgeTypeList{}
+	for _, v := range versions {
+		if v.GetVersion() < current.GetVersion() {
+			v.Version = v.GetVersion()
+			out = append(out, v)
+		}
+	}
+	current.Version = current.GetVersion()
+	return append(out, current)
+}
+
+// versionChanges describes what changed from previous to current, which can be
+// badges or types. previous is nil for the first version.
+func versionChanges(previous, current interface{}) []string {
+	var before, after json.RawMessage
+	if previous != nil {
+		before, _ = json.Marshal(previous)
+	}
+	after, _ = json.Marshal(current)
+
+	return jsonChanges(before, after, versionFields...)
+}
+
+// restoreBadgeContent copies what describes the badge from version to b. The
+// state of the badge, like whether it is archived or who co-owns it, is left as
+// it is, so a rollback does not undo those changes.
+func restoreBadgeContent(b, version *badgesmodel.Badge) {
+	b.Name = version.Name
+	b.Description = version.Description
+	b.Image = version.Image
+	b.ImageType = version.ImageType
+	b.Multiple = version.Multiple
+	b.Type = version.Type
+	b.ValidityDays = version.ValidityDays
+	b.Tags = version.Tags
+	b.Points = version.Points
+}
+
+// rollbackBadge makes the content of an earlier version the current definition
+// of the badge. The rollback is saved as a new version, so it can be undone the
+// same way.
+func (p *Plugin) rollbackBadge(badgeID badgesmodel.BadgeID, version int, actorID string) (*badgesmodel.Badge, error) {
+	history, err := p.store.GetBadgeHistory(badgeID)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *badgesmodel.Badge
+	for _, v := range history {
+		if v.Version == version {
+			target = v
+			break
+		}
+	}
+	if target == nil {
+		return nil, errVersionNotFound
+	}
+	if target == history[len(history)-1] {
+		return nil, fmt.Errorf("version %d is the current version", version)
+	}
+
+	_, err = p.store.GetType(target.Type)
+	if err != nil {
+		return nil, fmt.Errorf("the type of version %d no longer exists", version)
+	}
+
+	toSave := *history[len(history)-1]
+	restoreBadgeContent(&toSave, target)
+	toSave.UpdatedBy = actorID
+	err = p.storeAs(actorID).UpdateBadge(&toSave)
+	if err != nil {
+		return nil, err
+	}
+
+	return &toSave, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollbackBadge(t *testing.T) {
+	s := newMemStore()
+	badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+	originalName := badge.Name
+	badge.Name = "renamed"
+	badge.Description = "new description"
+	require.NoError(t, s.UpdateBadge(badge))
+
+	p := setupTestPlugin(s)
+	adminID := model.NewId()
+
+	rolledBack, err := p.rollbackBadge(badge.ID, 1, adminID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rolledBack.Version)
+
+	current, err := s.GetBadge(badge.ID)
+	require.NoError(t, err)
+	assert.Equal(t, originalName, current.Name)
+	assert.Empty(t, current.Description)
+	assert.Equal(t, adminID, current.UpdatedBy)
+
+	_, err = p.rollbackBadge(badge.ID, 3, adminID)
+	assert.Error(t, err, "the current version cannot be rolled back to")
+	_, err = p.rollbackBadge(badge.ID, 7, adminID)
+	assert.Equal(t, errVersionNotFound, err)
+
+	history, err := s.GetBadgeHistory(badge.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []string{`description: "" -> "new description"`, `name: "` + originalName + `" -> "renamed"`}, versionChanges(history[0], history[1]))
+
+	t.Run("state is kept", func(t *testing.T) {
+		coOwnerID := model.NewId()
+		current.Archived = true
+		current.CoOwners = []string{coOwnerID}
+		require.NoError(t, s.UpdateBadge(current))
+
+		rolledBack, err := p.rollbackBadge(badge.ID, 2, adminID)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", rolledBack.Name)
+		assert.True(t, rolledBack.Archived, "a rollback must not unarchive the badge")
+		assert.Equal(t, []string{coOwnerID}, rolledBack.CoOwners)
+	})
+}
+
+func TestLegacyVersions(t *testing.T) {
+	api := newFakeAPI()
+	s := NewStore(api).(*store)
+	badgeType := addTestType(t, s)
+	badge := addTestBadge(t, s, badgeType.ID, false)
+
+	// Badges saved before versions were recorded have no version.
+	bb, data, err := s.getAllBadges()
+	require.NoError(t, err)
+	bb[0].Version = 0
+	_, err = s.compareAndSet(KVKeyBadges, data, bb)
+	require.NoError(t, err)
+
+	_, err = s.GrantBadge(badge.ID, model.NewId(), model.NewId(), "")
+	require.NoError(t, err)
+	ownership, _, err := s.getBadgeOwnershipList(badge.ID)
+	require.NoError(t, err)
+	assert.Equal(t, badgesmodel.FirstVersion, ownership[0].BadgeVersion)
+
+	badge.Name = "renamed"
+	require.NoError(t, s.UpdateBadge(badge))
+	history, err := s.GetBadgeHistory(badge.ID)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 1, history[0].Version)
+	assert.Equal(t, 2, history[1].Version)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	imageContentTypePNG = "image/png"
+	imageContentTypeSVG = "image/svg+xml"
+)
+
+var (
+	errImageTooLarge   = fmt.Errorf("the image is larger than %d KB", ImageMaxSize/1024)
+	errImageType       = errors.New("the image must be a PNG or SVG file")
+	errImageNotFound   = errors.New("image not found")
+	errImageNotOwnFile = errors.New("you can only use images you posted")
+)
+
+// badgeImage is an uploaded image, as kept on the KV store.
+type badgeImage struct {
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+// Images are stored by the hash of their content, so the same image uploaded
+// twice is stored once, and badge versions and snapshots keep rendering after
+// the badge image changes. They are never deleted for that reason.
+func getImageKey(imageID string) string {
+	return KVKeyImagePrefix + imageID
+}
+
+// imageContentType checks the image by its extension and content, and returns
+// the content type to serve it with.
+func imageContentType(extension string, data []byte) (string, error) {
+	if len(data) > ImageMaxSize {
+		return "", errImageTooLarge
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(extension, ".")) {
+	case "png":
+		if http.DetectContentType(data) != imageContentTypePNG {
+			return "", errImageType
+		}
+		return imageContentTypePNG, nil
+	case "svg":
+		if !bytes.Contains(data, []byte("<svg")) {
+			return "", errImageType
+		}
+		return imageContentTypeSVG, nil
+	}
+
+	return "", errImageType
+}
+
+// saveImage validates and stores the image, and returns its ID.
+func saveImage(api plugin.API, extension string, data []byte) (string, error) {
+	contentType, err := imageContentType(extension, data)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	imageID := hex.EncodeToString(hash[:])
+
+	stored, err := json.Marshal(&badgeImage{ContentType: contentType, Data: data})
+	if err != nil {
+		return "", err
+	}
+
+	appErr := api.KVSet(getImageKey(imageID), stored)
+	if appErr != nil {
+		return "", appErr
+	}
+
+	return imageID, nil
+}
+
+func getImage(api plugin.API, imageID string) (*badgeImage, error) {
+	data, appErr := api.KVGet(getImageKey(imageID))
+	if appErr != nil {
+		return nil, appErr
+	}
+	if data == nil {
+		return nil, errImageNotFound
+	}
+
+	image := &badgeImage{}
+	err := json.Unmarshal(data, image)
+	if err != nil {
+		return nil, err
+	}
+
+	return image, nil
+}
+
+// saveImageFromFile stores a file the user posted as a badge image, and returns
+// the image ID.
+func (p *Plugin) saveImageFromFile(fileID, userID string) (string, error) {
+	info, err := p.mm.File.GetInfo(fileID)
+	if err != nil {
+		return "", err
+	}
+	if info.CreatorId != userID {
+		return "", errImageNotOwnFile
+	}
+	if info.Size > ImageMaxSize {
+		return "", errImageTooLarge
+	}
+
+	reader, err := p.mm.File.Get(fileID)
+	if err != nil {
+		return "", err
+	}
+
+	// Read one byte over the limit, so larger files are not taken as valid
+	// when the file info is wrong.
+	data, err := ioutil.ReadAll(io.LimitReader(reader, ImageMaxSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	return saveImage(p.API, info.Extension, data)
+}
+
+// getImageFileOptions lists the PNG and SVG files the user posted lately on the
+// channel, to pick the badge image from. current is the image of the badge
+// being edited, if it has one.
+func (p *Plugin) getImageFileOptions(userID, channelID, current string) []*model.PostActionOptions {
+	options := []*model.PostActionOptions{}
+	if current != "" {
+		options = append(options, &model.PostActionOptions{Text: "Current image", Value: current})
+	}
+
+	posts, err := p.mm.Post.GetPostsForChannel(channelID, 0, ImageFileSearchPosts)
+	if err != nil {
+		p.mm.Log.Debug("Cannot get the posts to find images", "channelID", channelID, "err", err)
+		return options
+	}
+
+	for _, postID := range posts.Order {
+		post := posts.Posts[postID]
+		if post.UserId != userID {
+			continue
+		}
+		for _, id := range post.FileIds {
+			info, err := p.mm.File.GetInfo(id)
+			if err != nil {
+				continue
+			}
+			switch strings.ToLower(info.Extension) {
+			case "png", "svg":
+				options = append(options, &model.PostActionOptions{Text: info.Name, Value: id})
+			}
+		}
+	}
+
+	return options
+}
+
+// getBadgeImagePath is where the plugin serves the image of an uploaded image
+// badge, relative to the plugin URL. The image ID changes the path whenever the
+// image changes, so clients can cache it.
+func getBadgeImagePath(b *badgesmodel.Badge) string {
+	return ImagePath + "/" + string(b.ID) + "?v=" + b.Image
+}
+
+// withImageURL returns the badge as sent to clients, with uploaded and framed
+// images as URLs relative to the site URL. frames is the frame of each type, as
+// returned by getTypeFrames.
+func withImageURL(b badgesmodel.Badge, frames map[badgesmodel.BadgeType]string) badgesmodel.Badge {
+	if frame := frames[b.Type]; frame != "" && canFrame(&b) {
+		b.Image = "/plugins/" + manifest.Id + getFramedImagePath(&b, frame)
+		b.ImageType = badgesmodel.ImageTypeRelativeURL
+		return b
+	}
+	if b.ImageType == badgesmodel.ImageTypeUpload {
+		b.Image = "/plugins/" + manifest.Id + getBadgeImagePath(&b)
+		b.ImageType = badgesmodel.ImageTypeRelativeURL
+	}
+	return b
+}
+
+// The views below may be shared with the cache, so they are copied before the
+// image URLs are set.
+
+func withUserBadgeImageURLs(in []*badgesmodel.UserBadge, frames map[badgesmodel.BadgeType]string) []*badgesmodel.UserBadge {
+	if in == nil {
+		return nil
+	}
+	out := make([]*badgesmodel.UserBadge, len(in))
+	for i, b := range in {
+		badge := *b
+		badge.Badge = withImageURL(b.Badge, frames)
+		out[i] = &badge
+	}
+	return out
+}
+
+func withAllBadgesImageURLs(in []*badgesmodel.AllBadgesBadge, frames map[badgesmodel.BadgeType]string) []*badgesmodel.AllBadgesBadge {
+	if in == nil {
+		return nil
+	}
+	out := make([]*badgesmodel.AllBadgesBadge, len(in))
+	for i, b := range in {
+		badge := *b
+		badge.Badge = withImageURL(b.Badge, frames)
+		out[i] = &badge
+	}
+	return out
+}
+
+func withBadgeImageURLs(in []*badgesmodel.Badge, frames map[badgesmodel.BadgeType]string) []*badgesmodel.Badge {
+	if in == nil {
+		return nil
+	}
+	out := make([]*badgesmodel.Badge, len(in))
+	for i, b := range in {
+		badge := withImageURL(*b, frames)
+		out[i] = &badge
+	}
+	return out
+}
+
+// isBadgeImageVersion tells whether an earlier version of the badge had the
+// image, so the history of the badge shows the images it had.
+func (p *Plugin) isBadgeImageVersion(badgeID badgesmodel.BadgeID, imageID string) bool {
+	history, err := p.store.GetBadgeHistory(badgeID)
+	if err != nil {
+		return false
+	}
+	for _, v := range history {
+		if v.ImageType == badgesmodel.ImageTypeUpload && v.Image == imageID {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plugin) getBadgeImage(w http.ResponseWriter, r *http.Request, actingUserID string) {
+	badgeID := badgesmodel.BadgeID(mux.Vars(r)["badgeID"])
+
+	badge, err := p.store.GetBadge(badgeID)
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	imageID := ""
+	if badge.ImageType == badgesmodel.ImageTypeUpload {
+		imageID = badge.Image
+	}
+	if v := r.URL.Query().Get("v"); v != "" && v != imageID {
+		if !p.isBadgeImageVersion(badgeID, v) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		imageID = v
+	}
+	if imageID == "" {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	etag := strconv.Quote(imageID)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", ImageCacheMaxAge))
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	image, err := getImage(p.API, imageID)
+	if err != nil {
+		p.mm.Log.Debug("Cannot get badge image", "badgeID", badgeID, "err", err)
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	// SVG files can hold scripts, which must not run if the image is opened
+	// directly.
+	w.Header().Set("Content-Type", image.ContentType)
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(image.Data)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestImageContentType(t *testing.T) {
+	for name, tc := range map[string]struct {
+		extension   string
+		data        []byte
+		contentType string
+		err         error
+	}{
+		"png":                  {"png", testPNG, imageContentTypePNG, nil},
+		"upper case extension": {".PNG", testPNG, imageContentTypePNG, nil},
+		"svg":                  {"svg", []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), imageContentTypeSVG, nil},
+		"png named svg":        {"svg", testPNG, "", errImageType},
+		"text named png":       {"png", []byte("not an image"), "", errImageType},
+		"other extension":      {"gif", []byte("GIF89a"), "", errImageType},
+		"too large":            {"png", append(testPNG, make([]byte, ImageMaxSize)...), "", errImageTooLarge},
+	} {
+		t.Run(name, func(t *testing.T) {
+			contentType, err := imageContentType(tc.extension, tc.data)
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.contentType, contentType)
+		})
+	}
+}
+
+func TestGetBadgeImage(t *testing.T) {
+	s := newMemStore()
+	badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+	emojiBadge := addTestBadge(t, s, badge.Type, false)
+
+	p := setupTestPlugin(s)
+
+	firstImage, err := saveImage(p.API, "png", testPNG)
+	require.NoError(t, err)
+	again, err := saveImage(p.API, "png", testPNG)
+	require.NoError(t, err)
+	assert.Equal(t, firstImage, again, "images are stored by content")
+
+	badge.ImageType = badgesmodel.ImageTypeUpload
+	badge.Image = firstImage
+	require.NoError(t, s.UpdateBadge(badge))
+
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	secondImage, err := saveImage(p.API, "svg", svg)
+	require.NoError(t, err)
+	badge.Image = secondImage
+	require.NoError(t, s.UpdateBadge(badge))
+
+	get := func(path string, header http.Header) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Mattermost-User-ID", model.NewId())
+		for key := range header {
+			r.Header.Set(key, header.Get(key))
+		}
+		p.ServeHTTP(&plugin.Context{}, w, r)
+		return w.Result()
+	}
+
+	t.Run("current image", func(t *testing.T) {
+		result := get("/images/"+string(badge.ID), nil)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, imageContentTypeSVG, result.Header.Get("Content-Type"))
+		assert.NotEmpty(t, result.Header.Get("Cache-Control"))
+		assert.Contains(t, result.Header.Get("Content-Security-Policy"), "sandbox")
+		data, err := ioutil.ReadAll(result.Body)
+		require.NoError(t, err)
+		assert.Equal(t, svg, data)
+	})
+
+	t.Run("earlier version", func(t *testing.T) {
+		result := get("/images/"+string(badge.ID)+"?v="+firstImage, nil)
+		defer result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, imageContentTypePNG, result.Header.Get("Content-Type"))
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		result := get("/images/"+string(badge.ID), http.Header{"If-None-Match": {`"` + secondImage + `"`}})
+		defer result.Body.Close()
+		assert.Equal(t, http.StatusNotModified, result.StatusCode)
+	})
+
+	t.Run("image of another badge", func(t *testing.T) {
+		result := get("/images/"+string(emojiBadge.ID)+"?v="+firstImage, nil)
+		defer result.Body.Close()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+
+	t.Run("emoji badge", func(t *testing.T) {
+		result := get("/images/"+string(emojiBadge.ID), nil)
+		defer result.Body.Close()
+		assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	})
+
+	t.Run("payload", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/getBadgeDetails/"+string(badge.ID), nil)
+		r.Header.Set("Mattermost-User-ID", model.NewId())
+		p.ServeHTTP(&plugin.Context{}, w, r)
+
+		body := w.Body.String()
+		assert.Contains(t, body, `"image_type":"rel_url"`)
+		assert.Contains(t, body, "/plugins/"+manifest.Id+"/images/"+string(badge.ID)+"?v="+secondImage)
+
+		stored, err := s.GetBadge(badge.ID)
+		require.NoError(t, err)
+		assert.Equal(t, badgesmodel.ImageTypeUpload, stored.ImageType, "the stored badge is left as is")
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+)
+
+// leaderboardOptions select the grants a leaderboard counts. Since is inclusive
+// and Until exclusive, both compared to the grant time. An empty TeamID counts
+// the users of every team.
+type leaderboardOptions struct {
+	TeamID string
+	TypeID badgesmodel.BadgeType
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (o leaderboardOptions) limit() int {
+	if o.Limit <= 0 || o.Limit > LeaderboardMaxLimit {
+		return LeaderboardDefaultLimit
+	}
+	return o.Limit
+}
+
+// buildLeaderboard adds up the points of the grants of each user, best first.
+// Grants expired at now are not counted. Users are checked with counts from the
+// top, until the leaderboard is full, so only the users shown are looked up.
+func buildLeaderboard(ownerships badgesmodel.OwnershipList, badges []*badgesmodel.Badge, types badgesmodel.BadgeTypeList, opts leaderboardOptions, now time.Time, counts func(userID string) bool) []*badgesmodel.LeaderboardEntry {
+	points := map[badgesmodel.BadgeID]int{}
+	for _, b := range badges {
+		if opts.TypeID != "" && b.Type != opts.TypeID {
+			continue
+		}
+		points[b.ID] = b.GetPoints(types.GetType(b.Type))
+	}
+
+	byUser := map[string]*badgesmodel.LeaderboardEntry{}
+	for _, o := range ownerships {
+		p, ok := points[o.Badge]
+		if !ok || o.IsExpired(now) ||
+			(!opts.Since.IsZero() && o.Time.Before(opts.Since)) ||
+			(!opts.Until.IsZero() && !o.Time.Before(opts.Until)) {
+			continue
+		}
+
+		entry, ok := byUser[o.User]
+		if !ok {
+			entry = &badgesmodel.LeaderboardEntry{UserID: o.User}
+			byUser[o.User] = entry
+		}
+		entry.Points += p
+		entry.Badges++
+	}
+
+	entries := []*badgesmodel.LeaderboardEntry{}
+	for _, entry := range byUser {
+		if entry.Points > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Points != entries[j].Points {
+			return entries[i].Points > entries[j].Points
+		}
+		if entries[i].Badges != entries[j].Badges {
+			return entries[i].Badges > entries[j].Badges
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+
+	out := []*badgesmodel.LeaderboardEntry{}
+	for _, entry := range entries {
+		if len(out) == opts.limit() {
+			break
+		}
+		if !counts(entry.UserID) {
+			continue
+		}
+
+		entry.Rank = len(out) + 1
+		if len(out) > 0 && out[len(out)-1].Points == entry.Points {
+			entry.Rank = out[len(out)-1].Rank
+		}
+		out = append(out, entry)
+	}
+
+	return out
+}
+
+// computeLeaderboard counts the active users only, and with a team set, only its
+// members.
+func (p *Plugin) computeLeaderboard(opts leaderboardOptions) ([]*badgesmodel.LeaderboardEntry, error) {
+	ownerships, err := p.store.GetOwnerships(opts.Since)
+	if err != nil {
+		return nil, err
+	}
+
+	badges, err := p.store.GetRawBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := p.store.GetRawTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := map[string]string{}
+	counts := func(userID string) bool {
+		u, err := p.mm.User.Get(userID)
+		if err != nil || u.DeleteAt != 0 {
+			return false
+		}
+		if opts.TeamID != "" && !p.isTeamMember(opts.TeamID, userID) {
+			return false
+		}
+		usernames[userID] = u.Username
+		return true
+	}
+
+	entries := buildLeaderboard(ownerships, badges, types, opts, time.Now(), counts)
+	for _, entry := range entries {
+		entry.Username = usernames[entry.UserID]
+	}
+
+	return entries, nil
+}
+
+// findType finds a type by ID, or else by name, ignoring case.
+func findType(types badgesmodel.BadgeTypeList, idOrName string) *badgesmodel.BadgeTypeDefinition {
+	if t := types.GetType(badgesmodel.BadgeType(idOrName)); t != nil {
+		return t
+	}
+	for _, t := range types {
+		if strings.EqualFold(t.Name, idOrName) {
+			return t
+		}
+	}
+	return nil
+}
+
+// formatLeaderboard renders the leaderboard as a markdown table.
+func formatLeaderboard(title string, entries []*badgesmodel.LeaderboardEntry) string {
+	text := "#### " + title + "\n\n"
+	text += "| Rank | User | Points | Badges |\n"
+	text += "|---:|:---|---:|---:|\n"
+	for _, entry := range entries {
+		text += fmt.Sprintf("| %d | @%s | %d | %d |\n", entry.Rank, entry.Username, entry.Points, entry.Badges)
+	}
+	return text
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildLeaderboard(t *testing.T) {
+	now := time.Now()
+	five, ten := 5, 10
+	types := badgesmodel.BadgeTypeList{
+		{ID: "plain"},
+		{ID: "valued", DefaultPoints: &ten},
+	}
+	badges := []*badgesmodel.Badge{
+		{ID: "default", Type: "plain"},
+		{ID: "typed", Type: "valued"},
+		{ID: "own", Type: "valued", Points: &five},
+		{ID: "zero", Type: "valued", Points: new(int)},
+	}
+	grant := func(user string, badge badgesmodel.BadgeID, daysAgo int) badgesmodel.Ownership {
+		return badgesmodel.Ownership{User: user, Badge: badge, Time: now.AddDate(0, 0, -daysAgo)}
+	}
+	expired := grant("carol", "typed", 1)
+	expiredAt := now.Add(-time.Hour)
+	expired.ExpiresAt = &expiredAt
+	ownerships := badgesmodel.OwnershipList{
+		grant("alice", "typed", 30),
+		grant("alice", "default", 1),
+		grant("bob", "own", 2),
+		grant("bob", "own", 1),
+		grant("bob", "default", 1),
+		grant("carol", "zero", 1),
+		expired,
+		grant("dave", "missing", 1),
+	}
+	everyone := func(string) bool { return true }
+
+	summary := func(entries []*badgesmodel.LeaderboardEntry) []badgesmodel.LeaderboardEntry {
+		out := []badgesmodel.LeaderboardEntry{}
+		for _, e := range entries {
+			out = append(out, *e)
+		}
+		return out
+	}
+
+	t.Run("points default to the type", func(t *testing.T) {
+		entries := buildLeaderboard(ownerships, badges, types, leaderboardOptions{}, now, everyone)
+		assert.Equal(t, []badgesmodel.LeaderboardEntry{
+			{Rank: 1, UserID: "bob", Points: 11, Badges: 3},
+			{Rank: 1, UserID: "alice", Points: 11, Badges: 2},
+		}, summary(entries), "users with the same points share the rank")
+	})
+
+	t.Run("type filter", func(t *testing.T) {
+		entries := buildLeaderboard(ownerships, badges, types, leaderboardOptions{TypeID: "plain"}, now, everyone)
+		require.Len(t, entries, 2)
+		assert.Equal(t, 1, entries[0].Points)
+		assert.Equal(t, 1, entries[1].Points)
+	})
+
+	t.Run("time window", func(t *testing.T) {
+		entries := buildLeaderboard(ownerships, badges, types, leaderboardOptions{Since: now.AddDate(0, 0, -7), Until: now.AddDate(0, 0, -1).Add(-time.Minute)}, now, everyone)
+		assert.Equal(t, []badgesmodel.LeaderboardEntry{{Rank: 1, UserID: "bob", Points: 5, Badges: 1}}, summary(entries))
+	})
+
+	t.Run("users not counted leave no gap", func(t *testing.T) {
+		entries := buildLeaderboard(ownerships, badges, types, leaderboardOptions{Limit: 1}, now, func(userID string) bool { return userID != "bob" })
+		assert.Equal(t, []badgesmodel.LeaderboardEntry{{Rank: 1, UserID: "alice", Points: 11, Badges: 2}}, summary(entries))
+	})
+}
+
+func TestGetLeaderboard(t *testing.T) {
+	s := newMemStore()
+	badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+
+	active := &model.User{Id: model.NewId(), Username: "active"}
+	deactivated := &model.User{Id: model.NewId(), Username: "gone", DeleteAt: model.GetMillis()}
+	api.addUser(active)
+	api.addUser(deactivated)
+	for _, userID := range []string{active.Id, active.Id, deactivated.Id} {
+		_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Mattermost-User-ID", model.NewId())
+		p.ServeHTTP(&plugin.Context{}, w, r)
+		return w
+	}
+
+	t.Run("active users only", func(t *testing.T) {
+		w := get("/api/v1/leaderboard")
+		require.Equal(t, http.StatusOK, w.Code)
+		entries := []*badgesmodel.LeaderboardEntry{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+		require.Len(t, entries, 1)
+		assert.Equal(t, "active", entries[0].Username)
+		assert.Equal(t, 2*badgesmodel.DefaultPoints, entries[0].Points)
+	})
+
+	t.Run("bad time", func(t *testing.T) {
+		w := get("/api/v1/leaderboard?since=yesterday")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -38,11 +38,69 @@ const manifestStr = `
     "settings": [
       {
         "key": "BadgesAdmin",
-        "display_name": "Badges admin:",
+        "display_name": "Badges admins:",
         "type": "text",
-        "help_text": "This user will be considered as an admin for the badges plugin. They can create types, and modify and grant any badge.",
+        "help_text": "Usernames or user IDs of the badges admins, separated by commas. They can create types, and modify and grant any badge. Users that cannot be found are skipped, and logged as a warning.",
         "placeholder": "",
         "default": null
+      },
+      {
+        "key": "BadgesAdminGroups",
+        "display_name": "Badges admin groups:",
+        "type": "text",
+        "help_text": "Names or IDs of user groups, separated by commas. Their members are badges admins. Groups that cannot be found are skipped, and logged as a warning.",
+        "placeholder": "",
+        "default": null
+      },
+      {
+        "key": "TeamAdminsAreBadgesAdmins",
+        "display_name": "Team admins are badges admins:",
+        "type": "bool",
+        "help_text": "When true, the admins of a team are badges admins of the types scoped to that team and of their badges.",
+        "placeholder": "",
+        "default": false
+      },
+      {
+        "key": "StoreBackend",
+        "display_name": "Storage backend:",
+        "type": "dropdown",
+        "help_text": "Where badges, types, grants and subscriptions are stored. Database tables scale better with many grants. Use /badges admin copy-to-sql to copy the existing data before switching. Changes take effect when the plugin is restarted.",
+        "placeholder": "",
+        "default": "kv",
+        "options": [
+          {
+            "display_name": "Key-value store",
+            "value": "kv"
+          },
+          {
+            "display_name": "Database tables",
+            "value": "sql"
+          }
+        ]
+      },
+      {
+        "key": "AuditRetentionDays",
+        "display_name": "Audit log retention (days):",
+        "type": "text",
+        "help_text": "How many days the audit log of changes to badges, types, grants and subscriptions is kept. Use 0 to keep it forever.",
+        "placeholder": "",
+        "default": "90"
+      },
+      {
+        "key": "ExpiryWarningDays",
+        "display_name": "Badge expiry warning (days):",
+        "type": "text",
+        "help_text": "How many days before a time-limited badge expires its owner gets a direct message about it. Use 0 to disable the warning.",
+        "placeholder": "",
+        "default": "7"
+      },
+      {
+        "key": "RemoveExpiredBadges",
+        "display_name": "Remove expired badges:",
+        "type": "bool",
+        "help_text": "When true, expired badges are removed from their owners. When false, they are kept and shown greyed out as past badges.",
+        "placeholder": "",
+        "default": false
       }
     ]
   }
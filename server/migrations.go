@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const migrationsMutexKey = "migrations"
+
+type migration struct {
+	Version int
+	Name    string
+	Run     func(tx *migrationTx) error
+}
+
+// migrations must be kept in ascending version order. Never change or remove a
+// migration that has been released; add a new one instead.
+var migrations = []migration{
+	{Version: 1, Name: "Shard ownership into per user and per badge keys", Run: migrateShardOwnership},
+	{Version: 2, Name: "Add grant IDs to ownerships", Run: migrateGrantIDs},
+}
+
+type migrationRecord struct {
+	Version int       `json:"version"`
+	Name    string    `json:"name"`
+	RanAt   time.Time `json:"ran_at"`
+	Changes int       `json:"changes"`
+	DryRun  bool      `json:"dry_run"`
+	Error   string    `json:"error,omitempty"`
+
+	// ChangeList is only filled on dry runs, and never persisted.
+	ChangeList []string `json:"-"`
+}
+
+type migrationStatus struct {
+	SchemaVersion int
+	Records       []migrationRecord
+}
+
+// migrationTx buffers every write done by a migration, so nothing reaches the KV
+// store until the migration finishes successfully.
+type migrationTx struct {
+	api     plugin.API
+	pending map[string][]byte
+	deleted map[string]bool
+	order   []string
+
+	// previous is the transaction of the migration before this one on a dry
+	// run. Its changes are never committed, so reads go through it to see the
+	// store as the earlier migrations would have left it.
+	previous *migrationTx
+}
+
+func newMigrationTx(api plugin.API, previous *migrationTx) *migrationTx {
+	return &migrationTx{
+		api:      api,
+		pending:  map[string][]byte{},
+		deleted:  map[string]bool{},
+		previous: previous,
+	}
+}
+
+func (tx *migrationTx) get(key string, out interface{}) (bool, error) {
+	var data []byte
+	switch {
+	case tx.deleted[key]:
+		return false, nil
+	case tx.pending[key] != nil:
+		data = tx.pending[key]
+	case tx.previous != nil:
+		return tx.previous.get(key, out)
+	default:
+		stored, appErr := tx.api.KVGet(key)
+		if appErr != nil {
+			return false, appErr
+		}
+		data = stored
+	}
+
+	if data == nil {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(data, out)
+}
+
+// keys lists the keys starting with prefix as the migration sees them: the
+// stored ones, plus the ones set and minus the ones deleted by this transaction
+// and the earlier ones of a dry run.
+func (tx *migrationTx) keys(prefix string) ([]string, error) {
+	var keys []string
+	var err error
+	if tx.previous != nil {
+		keys, err = tx.previous.keys(prefix)
+	} else {
+		keys, err = listKVKeys(tx.api, prefix)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if !tx.deleted[key] {
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	for _, key := range tx.order {
+		if tx.pending[key] != nil && !seen[key] && strings.HasPrefix(key, prefix) {
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+
+	return out, nil
+}
+
+func (tx *migrationTx) set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	tx.touch(key)
+	delete(tx.deleted, key)
+	tx.pending[key] = data
+	return nil
+}
+
+func (tx *migrationTx) delete(key string) {
+	tx.touch(key)
+	delete(tx.pending, key)
+	tx.deleted[key] = true
+}
+
+func (tx *migrationTx) touch(key string) {
+	if tx.pending[key] == nil && !tx.deleted[key] {
+		tx.order = append(tx.order, key)
+	}
+}
+
+func (tx *migrationTx) changes() []string {
+	out := []string{}
+	for _, key := range tx.order {
+		if tx.deleted[key] {
+			out = append(out, "delete "+key)
+			continue
+		}
+		out = append(out, "set "+key)
+	}
+	return out
+}
+
+// commit writes every buffered change. If any write fails, the keys already
+// written are restored to their previous values.
+func (tx *migrationTx) commit() error {
+	originals := map[string][]byte{}
+	written := []string{}
+
+	rollback := func() {
+		for _, key := range written {
+			var appErr *model.AppError
+			if originals[key] == nil {
+				appErr = tx.api.KVDelete(key)
+			} else {
+				appErr = tx.api.KVSet(key, originals[key])
+			}
+			if appErr != nil {
+				tx.api.LogError("Cannot roll back migration change", "key", key, "err", appErr)
+			}
+		}
+	}
+
+	for _, key := range tx.order {
+		original, appErr := tx.api.KVGet(key)
+		if appErr != nil {
+			rollback()
+			return appErr
+		}
+		originals[key] = original
+
+		if tx.deleted[key] {
+			appErr = tx.api.KVDelete(key)
+		} else {
+			appErr = tx.api.KVSet(key, tx.pending[key])
+		}
+		if appErr != nil {
+			rollback()
+			return appErr
+		}
+		written = append(written, key)
+	}
+
+	return nil
+}
+
+func getSchemaVersion(api plugin.API) (int, error) {
+	data, appErr := api.KVGet(KVKeySchemaVersion)
+	if appErr != nil {
+		return 0, appErr
+	}
+
+	version := 0
+	if data != nil {
+		err := json.Unmarshal(data, &version)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
+func getMigrationRecords(api plugin.API) ([]migrationRecord, error) {
+	data, appErr := api.KVGet(KVKeyMigrations)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	records := []migrationRecord{}
+	if data != nil {
+		err := json.Unmarshal(data, &records)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+func saveMigrationRecord(api plugin.API, record migrationRecord) error {
+	records, err := getMigrationRecords(api)
+	if err != nil {
+		return err
+	}
+
+	records = append(records, record)
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	appErr := api.KVSet(KVKeyMigrations, data)
+	if appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+func getMigrationStatus(api plugin.API) (*migrationStatus, error) {
+	version, err := getSchemaVersion(api)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := getMigrationRecords(api)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].RanAt.Before(records[j].RanAt) })
+
+	return &migrationStatus{SchemaVersion: version, Records: records}, nil
+}
+
+// runMigrations runs every pending migration in order under a cluster mutex. On a
+// dry run, the migrations are executed but their changes are discarded and only
+// reported back. Each migration of a dry run still sees the changes of the ones
+// before it.
+func runMigrations(api plugin.API, dryRun bool) ([]migrationRecord, error) {
+	m, err := cluster.NewMutex(api, migrationsMutexKey)
+	if err != nil {
+		return nil, err
+	}
+	m.Lock()
+	defer m.Unlock()
+
+	version, err := getSchemaVersion(api)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []migrationRecord{}
+	var previous *migrationTx
+	for _, mig := range migrations {
+		if mig.Version <= version {
+			continue
+		}
+
+		tx := newMigrationTx(api, previous)
+		if dryRun {
+			previous = tx
+		}
+		record := migrationRecord{
+			Version: mig.Version,
+			Name:    mig.Name,
+			RanAt:   time.Now(),
+			DryRun:  dryRun,
+		}
+
+		err = mig.Run(tx)
+		if err == nil && !dryRun {
+			err = tx.commit()
+		}
+
+		record.Changes = len(tx.order)
+		if dryRun {
+			record.ChangeList = tx.changes()
+		}
+
+		if err != nil {
+			record.Error = err.Error()
+			if !dryRun {
+				if saveErr := saveMigrationRecord(api, record); saveErr != nil {
+					api.LogError("Cannot save migration record", "version", mig.Version, "err", saveErr)
+				}
+			}
+			out = append(out, record)
+			return out, errors.Wrap(err, fmt.Sprintf("migration %d (%s) failed", mig.Version, mig.Name))
+		}
+
+		out = append(out, record)
+		if dryRun {
+			continue
+		}
+
+		data, err := json.Marshal(mig.Version)
+		if err != nil {
+			return out, err
+		}
+		appErr := api.KVSet(KVKeySchemaVersion, data)
+		if appErr != nil {
+			return out, appErr
+		}
+
+		err = saveMigrationRecord(api, record)
+		if err != nil {
+			return out, err
+		}
+
+		api.LogInfo("Migration applied", "version", mig.Version, "name", mig.Name, "changes", record.Changes)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// failingKVAPI fails every write to failKey.
+type failingKVAPI struct {
+	*fakeAPI
+	failKey string
+}
+
+func (a *failingKVAPI) KVSet(key string, value []byte) *model.AppError {
+	if key == a.failKey {
+		return model.NewAppError("KVSet", "test.failed", nil, "", 500)
+	}
+	return a.fakeAPI.KVSet(key, value)
+}
+
+func TestMigrationTx(t *testing.T) {
+	t.Run("commit", func(t *testing.T) {
+		api := newFakeAPI()
+		require.Nil(t, api.KVSet("kept", []byte(`"kept"`)))
+		require.Nil(t, api.KVSet("deleted", []byte(`"deleted"`)))
+
+		tx := newMigrationTx(api, nil)
+		require.NoError(t, tx.set("new", "new"))
+		tx.delete("deleted")
+
+		var value string
+		found, err := tx.get("new", &value)
+		require.NoError(t, err)
+		assert.True(t, found)
+		found, err = tx.get("deleted", &value)
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		data, _ := api.KVGet("new")
+		assert.Nil(t, data, "nothing is written before the commit")
+
+		require.NoError(t, tx.commit())
+		assert.Equal(t, []string{"set new", "delete deleted"}, tx.changes())
+		data, _ = api.KVGet("new")
+		assert.Equal(t, `"new"`, string(data))
+		data, _ = api.KVGet("deleted")
+		assert.Nil(t, data)
+		data, _ = api.KVGet("kept")
+		assert.Equal(t, `"kept"`, string(data))
+	})
+
+	t.Run("a failed commit is rolled back", func(t *testing.T) {
+		api := &failingKVAPI{fakeAPI: newFakeAPI(), failKey: "third"}
+		require.Nil(t, api.KVSet("first", []byte(`"before"`)))
+
+		tx := newMigrationTx(api, nil)
+		require.NoError(t, tx.set("first", "after"))
+		require.NoError(t, tx.set("second", "after"))
+		require.NoError(t, tx.set("third", "after"))
+
+		assert.Error(t, tx.commit())
+		data, _ := api.KVGet("first")
+		assert.Equal(t, `"before"`, string(data))
+		data, _ = api.KVGet("second")
+		assert.Nil(t, data)
+	})
+}
+
+func TestRunMigrations(t *testing.T) {
+	original := migrations
+	defer func() { migrations = original }()
+	migrations = []migration{
+		{Version: 1, Name: "first", Run: func(tx *migrationTx) error {
+			return tx.set("first", 1)
+		}},
+		{Version: 2, Name: "second", Run: func(tx *migrationTx) error {
+			var first int
+			found, err := tx.get("first", &first)
+			if err != nil {
+				return err
+			}
+			if !found {
+				return errors.New("the first migration did not run")
+			}
+			return tx.set("second", first+1)
+		}},
+	}
+
+	api := newFakeAPI()
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+
+	t.Run("dry run", func(t *testing.T) {
+		records, err := runMigrations(api, true)
+		require.NoError(t, err, "later migrations see the changes of earlier ones")
+		require.Len(t, records, 2)
+		assert.True(t, records[1].DryRun)
+		assert.Equal(t, []string{"set second"}, records[1].ChangeList)
+
+		data, _ := api.KVGet("first")
+		assert.Nil(t, data, "a dry run writes nothing")
+		version, err := getSchemaVersion(api)
+		require.NoError(t, err)
+		assert.Equal(t, 0, version)
+		saved, err := getMigrationRecords(api)
+		require.NoError(t, err)
+		assert.Empty(t, saved)
+	})
+
+	t.Run("run", func(t *testing.T) {
+		records, err := runMigrations(api, false)
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		var second int
+		data, _ := api.KVGet("second")
+		require.NoError(t, json.Unmarshal(data, &second))
+		assert.Equal(t, 2, second)
+		version, err := getSchemaVersion(api)
+		require.NoError(t, err)
+		assert.Equal(t, 2, version)
+
+		records, err = runMigrations(api, true)
+		require.NoError(t, err)
+		assert.Empty(t, records, "nothing is pending")
+	})
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Roles that can be picked on the permission schemes of a type. Team roles are
+// checked on the team of the type scope, and channel roles on its channel.
+var (
+	schemeSystemRoles  = []string{model.SYSTEM_USER_ROLE_ID, model.SYSTEM_GUEST_ROLE_ID}
+	schemeTeamRoles    = []string{model.TEAM_USER_ROLE_ID, model.TEAM_GUEST_ROLE_ID, model.TEAM_ADMIN_ROLE_ID}
+	schemeChannelRoles = []string{model.CHANNEL_USER_ROLE_ID, model.CHANNEL_GUEST_ROLE_ID, model.CHANNEL_ADMIN_ROLE_ID}
+)
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func getSchemes(t *badgesmodel.BadgeTypeDefinition) []badgesmodel.PermissionScheme {
+	return []badgesmodel.PermissionScheme{t.CanCreate, t.CanGrant, t.CanEdit}
+}
+
+func schemesHaveRole(t *badgesmodel.BadgeTypeDefinition, roles []string) bool {
+	for _, scheme := range getSchemes(t) {
+		for role, b := range scheme.Roles {
+			if b && containsString(roles, role) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func schemesHaveGroups(t *badgesmodel.BadgeTypeDefinition) bool {
+	for _, scheme := range getSchemes(t) {
+		if len(scheme.AllowGroups)+len(scheme.BlockGroups) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getTypeMembership looks up what the permission schemes of the type check.
+// The team and channel roles and the groups are only looked up when the schemes
+// use them.
+func (p *Plugin) getTypeMembership(user *model.User, t *badgesmodel.BadgeTypeDefinition) *typeMembership {
+	membership := &typeMembership{Roles: user.GetRoles()}
+
+	if t.TeamID != "" && schemesHaveRole(t, schemeTeamRoles) {
+		if member, err := p.mm.Team.GetMember(t.TeamID, user.Id); err == nil && member.DeleteAt == 0 {
+			membership.Roles = append(membership.Roles, member.GetRoles()...)
+			if member.SchemeAdmin {
+				membership.Roles = append(membership.Roles, model.TEAM_ADMIN_ROLE_ID)
+			}
+		}
+	}
+
+	if t.ChannelID != "" && schemesHaveRole(t, schemeChannelRoles) {
+		if member, err := p.mm.Channel.GetMember(t.ChannelID, user.Id); err == nil {
+			membership.Roles = append(membership.Roles, member.GetRoles()...)
+			if member.SchemeAdmin {
+				membership.Roles = append(membership.Roles, model.CHANNEL_ADMIN_ROLE_ID)
+			}
+		}
+	}
+
+	if schemesHaveGroups(t) {
+		groups, err := p.mm.Group.ListForUser(user.Id)
+		if err != nil {
+			p.mm.Log.Warn("Cannot get the groups of the user", "userID", user.Id, "err", err.Error())
+			membership.GroupsUnknown = true
+			return membership
+		}
+		membership.Groups = map[string]bool{}
+		for _, g := range groups {
+			membership.Groups[g.Id] = true
+		}
+	}
+
+	return membership
+}
+
+// parseSchemeRoles reads roles separated by commas or spaces. Team roles need a
+// type scoped to a team, and channel roles a type scoped to a channel.
+func parseSchemeRoles(input string, scope *typeScope) (map[string]bool, error) {
+	fields := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	roles := map[string]bool{}
+	for _, role := range fields {
+		switch {
+		case containsString(schemeSystemRoles, role):
+		case containsString(schemeTeamRoles, role):
+			if scope.TeamID == "" {
+				return nil, fmt.Errorf("%s is a team role. Scope the type to a team or to one of its channels to use it", role)
+			}
+		case containsString(schemeChannelRoles, role):
+			if scope.ChannelID == "" {
+				return nil, fmt.Errorf("%s is a channel role. Scope the type to a channel to use it", role)
+			}
+		default:
+			return nil, fmt.Errorf("unknown role %s. Use one of %s", role, strings.Join(getSchemeRoleNames(), ", "))
+		}
+		roles[role] = true
+	}
+
+	if len(roles) == 0 {
+		return nil, nil
+	}
+	return roles, nil
+}
+
+func getSchemeRoleNames() []string {
+	names := append([]string{}, schemeSystemRoles...)
+	names = append(names, schemeTeamRoles...)
+	return append(names, schemeChannelRoles...)
+}
+
+// parseUsersAndGroups reads usernames and group names separated by commas.
+// Usernames are looked up first, as group names cannot be taken by a user.
+func (p *Plugin) parseUsersAndGroups(input string) (users, groups map[string]bool, err error) {
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if name == "" {
+			continue
+		}
+
+		if u, userErr := p.mm.User.GetByUsername(name); userErr == nil {
+			if users == nil {
+				users = map[string]bool{}
+			}
+			users[u.Id] = true
+			continue
+		}
+
+		g, groupErr := p.mm.Group.GetByName(name)
+		if groupErr != nil {
+			return nil, nil, fmt.Errorf("cannot find a user or group named %s", name)
+		}
+		if groups == nil {
+			groups = map[string]bool{}
+		}
+		groups[g.Id] = true
+	}
+
+	return users, groups, nil
+}
+
+// parseCoOwners reads the usernames of the co-owners of a badge, separated by
+// commas. The creator of the badge is left out, as it owns the badge already.
+func (p *Plugin) parseCoOwners(input, creatorID string) ([]string, error) {
+	coOwners := []string{}
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+		if name == "" {
+			continue
+		}
+
+		u, err := p.mm.User.GetByUsername(name)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find a user named %s", name)
+		}
+		if u.Id == creatorID || containsString(coOwners, u.Id) {
+			continue
+		}
+		coOwners = append(coOwners, u.Id)
+	}
+
+	if len(coOwners) > badgesmodel.MaxCoOwners {
+		return nil, fmt.Errorf("a badge can have up to %d co-owners", badgesmodel.MaxCoOwners)
+	}
+	if len(coOwners) == 0 {
+		return nil, nil
+	}
+	return coOwners, nil
+}
+
+// formatCoOwners lists the usernames of the co-owners, for the dialog defaults.
+// Users no longer found are left out.
+func (p *Plugin) formatCoOwners(coOwners []string) string {
+	out := []string{}
+	for _, id := range coOwners {
+		if u, err := p.mm.User.Get(id); err == nil {
+			out = append(out, u.Username)
+		}
+	}
+	return strings.Join(out, ", ")
+}
+
+// formatSchemeRoles lists the roles of the scheme, for the dialog defaults.
+func formatSchemeRoles(roles map[string]bool) string {
+	out := []string{}
+	for role, b := range roles {
+		if b {
+			out = append(out, role)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, ", ")
+}
+
+// formatUsersAndGroups lists the usernames and group names, for the dialog
+// defaults. Users and groups no longer found are left out.
+func (p *Plugin) formatUsersAndGroups(users, groups map[string]bool) string {
+	out := []string{}
+	for id, b := range users {
+		if !b {
+			continue
+		}
+		if u, err := p.mm.User.Get(id); err == nil {
+			out = append(out, u.Username)
+		}
+	}
+	for id, b := range groups {
+		if !b {
+			continue
+		}
+		if g, err := p.mm.Group.Get(id); err == nil && g.Name != nil {
+			out = append(out, *g.Name)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, ", ")
+}
+
+// schemeDialogFields names the dialog fields of a permission scheme. Object is
+// what the scheme lets users act on, for the labels and help texts.
+type schemeDialogFields struct {
+	Verb      string
+	Object    string
+	Everyone  string
+	Roles     string
+	AllowList string
+	BlockList string
+}
+
+var (
+	createSchemeFields = schemeDialogFields{
+		Verb:      "create",
+		Object:    "badges of this type",
+		Everyone:  DialogFieldTypeEveryoneCanCreate,
+		Roles:     DialogFieldTypeRolesCanCreate,
+		AllowList: DialogFieldTypeAllowlistCanCreate,
+		BlockList: DialogFieldTypeBlocklistCanCreate,
+	}
+	grantSchemeFields = schemeDialogFields{
+		Verb:      "grant",
+		Object:    "badges of this type",
+		Everyone:  DialogFieldTypeEveryoneCanGrant,
+		Roles:     DialogFieldTypeRolesCanGrant,
+		AllowList: DialogFieldTypeAllowlistCanGrant,
+		BlockList: DialogFieldTypeBlocklistCanGrant,
+	}
+	editSchemeFields = schemeDialogFields{
+		Verb:      "edit",
+		Object:    "this type and its badges",
+		Everyone:  DialogFieldTypeEveryoneCanEdit,
+		Roles:     DialogFieldTypeRolesCanEdit,
+		AllowList: DialogFieldTypeAllowlistCanEdit,
+		BlockList: DialogFieldTypeBlocklistCanEdit,
+	}
+)
+
+// getDialogSubmissionScheme reads the permission scheme fields of the type
+// dialogs. The roles are checked against the scope picked on the dialog.
+func (p *Plugin) getDialogSubmissionScheme(req *model.SubmitDialogRequest, fields schemeDialogFields, scope *typeScope) (scheme badgesmodel.PermissionScheme, errText string, errors map[string]string) {
+	scheme.Everyone = getDialogSubmissionBoolField(req, fields.Everyone)
+
+	roles, _ := req.Submission[fields.Roles].(string)
+	var err error
+	scheme.Roles, err = parseSchemeRoles(roles, scope)
+	if err != nil {
+		return scheme, "Invalid field", map[string]string{fields.Roles: err.Error()}
+	}
+
+	allowList, _ := req.Submission[fields.AllowList].(string)
+	scheme.AllowList, scheme.AllowGroups, err = p.parseUsersAndGroups(allowList)
+	if err != nil {
+		return scheme, "Cannot find user", map[string]string{fields.AllowList: err.Error()}
+	}
+
+	blockList, _ := req.Submission[fields.BlockList].(string)
+	scheme.BlockList, scheme.BlockGroups, err = p.parseUsersAndGroups(blockList)
+	if err != nil {
+		return scheme, "Cannot find user", map[string]string{fields.BlockList: err.Error()}
+	}
+
+	return scheme, "", nil
+}
+
+// getSchemeDialogElements builds the dialog fields of a permission scheme,
+// filled with the scheme when editing a type.
+func (p *Plugin) getSchemeDialogElements(fields schemeDialogFields, scheme *badgesmodel.PermissionScheme) []model.DialogElement {
+	everyone, roles, allowList, blockList := "", "", "", ""
+	if scheme != nil {
+		everyone = getBooleanString(scheme.Everyone)
+		roles = formatSchemeRoles(scheme.Roles)
+		allowList = p.formatUsersAndGroups(scheme.AllowList, scheme.AllowGroups)
+		blockList = p.formatUsersAndGroups(scheme.BlockList, scheme.BlockGroups)
+	}
+
+	return []model.DialogElement{
+		{
+			DisplayName: "Everyone can " + fields.Verb + " " + fields.Object,
+			Type:        "bool",
+			Name:        fields.Everyone,
+			HelpText:    "Whether any user can " + fields.Verb + " " + fields.Object,
+			Optional:    true,
+			Default:     everyone,
+		},
+		{
+			DisplayName: "Can " + fields.Verb + " roles",
+			Type:        "text",
+			Name:        fields.Roles,
+			HelpText:    "Roles separated by comma (,) that can " + fields.Verb + " " + fields.Object + ". Team roles apply on the team of the scope, and channel roles on its channel. One of: " + strings.Join(getSchemeRoleNames(), ", "),
+			Placeholder: model.TEAM_ADMIN_ROLE_ID + ", " + model.CHANNEL_ADMIN_ROLE_ID,
+			Optional:    true,
+			Default:     roles,
+		},
+		{
+			DisplayName: "Can " + fields.Verb + " allowlist",
+			Type:        "text",
+			Name:        fields.AllowList,
+			HelpText:    "Fill the usernames or group names separated by comma (,) of the people that can " + fields.Verb + " " + fields.Object + ".",
+			Placeholder: "user-1, user-2, group-1",
+			Optional:    true,
+			Default:     allowList,
+		},
+		{
+			DisplayName: "Can " + fields.Verb + " blocklist",
+			Type:        "text",
+			Name:        fields.BlockList,
+			HelpText:    "Fill the usernames or group names separated by comma (,) of the people that cannot " + fields.Verb + " " + fields.Object + ", even if allowed otherwise.",
+			Placeholder: "user-1, user-2, group-1",
+			Optional:    true,
+			Default:     blockList,
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionSchemes(t *testing.T) {
+	s := newMemStore()
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+
+	teamID := model.NewId()
+	lead := &model.User{Id: model.NewId(), Username: "lead", Roles: model.SYSTEM_USER_ROLE_ID}
+	contractor := &model.User{Id: model.NewId(), Username: "contractor", Roles: model.SYSTEM_USER_ROLE_ID}
+	member := &model.User{Id: model.NewId(), Username: "member", Roles: model.SYSTEM_USER_ROLE_ID}
+	contractors := &model.Group{Id: model.NewId(), Name: model.NewString("contractors")}
+
+	notFound := model.NewAppError("test", "not_found", nil, "", http.StatusNotFound)
+	api.On("GetTeamMember", teamID, lead.Id).Return(&model.TeamMember{TeamId: teamID, UserId: lead.Id, SchemeUser: true, SchemeAdmin: true}, nil)
+	api.On("GetTeamMember", teamID, contractor.Id).Return(&model.TeamMember{TeamId: teamID, UserId: contractor.Id, Roles: model.TEAM_USER_ROLE_ID + " " + model.TEAM_ADMIN_ROLE_ID}, nil)
+	api.On("GetTeamMember", teamID, member.Id).Return(&model.TeamMember{TeamId: teamID, UserId: member.Id, Roles: model.TEAM_USER_ROLE_ID}, nil)
+	api.On("GetGroupsForUser", contractor.Id).Return([]*model.Group{contractors}, nil)
+	api.On("GetGroupsForUser", mock.Anything).Return([]*model.Group{}, nil)
+	api.On("GetUserByUsername", "member").Return(member, nil)
+	api.On("GetUserByUsername", mock.Anything).Return(nil, notFound)
+	api.On("GetGroupByName", "contractors").Return(contractors, nil)
+	api.On("GetGroupByName", mock.Anything).Return(nil, notFound)
+
+	t.Run("team admins can grant, except contractors", func(t *testing.T) {
+		badgeType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{
+			Name:   "engineering",
+			TeamID: teamID,
+			CanGrant: badgesmodel.PermissionScheme{
+				Roles:       map[string]bool{model.TEAM_ADMIN_ROLE_ID: true},
+				BlockGroups: map[string]bool{contractors.Id: true},
+			},
+		})
+		require.NoError(t, err)
+		badge := addTestBadge(t, s, badgeType.ID, false)
+
+		assert.True(t, p.canGrantScopedBadge(lead, badge, badgeType))
+		assert.False(t, p.canGrantScopedBadge(contractor, badge, badgeType))
+		assert.False(t, p.canGrantScopedBadge(member, badge, badgeType))
+	})
+
+	t.Run("unknown groups deny when groups are blocked", func(t *testing.T) {
+		scheme := badgesmodel.PermissionScheme{Everyone: true, BlockGroups: map[string]bool{contractors.Id: true}}
+		assert.True(t, decideScheme(scheme, member.Id, &typeMembership{Groups: map[string]bool{}}).Allowed)
+		assert.False(t, decideScheme(scheme, member.Id, &typeMembership{GroupsUnknown: true}).Allowed)
+	})
+
+	t.Run("allowed groups", func(t *testing.T) {
+		scheme := badgesmodel.PermissionScheme{AllowGroups: map[string]bool{contractors.Id: true}, BlockList: map[string]bool{member.Id: true}}
+		assert.True(t, decideScheme(scheme, contractor.Id, &typeMembership{Groups: map[string]bool{contractors.Id: true}}).Allowed)
+		assert.False(t, decideScheme(scheme, member.Id, &typeMembership{Groups: map[string]bool{contractors.Id: true}}).Allowed)
+	})
+
+	t.Run("roles must fit the scope", func(t *testing.T) {
+		_, err := parseSchemeRoles("team_admin", &typeScope{})
+		assert.Error(t, err)
+		_, err = parseSchemeRoles("channel_admin", &typeScope{TeamID: teamID})
+		assert.Error(t, err)
+		_, err = parseSchemeRoles("superuser", &typeScope{})
+		assert.Error(t, err)
+
+		roles, err := parseSchemeRoles("team_admin, channel_admin system_user", &typeScope{TeamID: teamID, ChannelID: model.NewId()})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{model.TEAM_ADMIN_ROLE_ID: true, model.CHANNEL_ADMIN_ROLE_ID: true, model.SYSTEM_USER_ROLE_ID: true}, roles)
+	})
+
+	t.Run("dialog lists take users and groups", func(t *testing.T) {
+		req := &model.SubmitDialogRequest{Submission: map[string]interface{}{
+			DialogFieldTypeEveryoneCanGrant:  true,
+			DialogFieldTypeRolesCanGrant:     "team_admin",
+			DialogFieldTypeAllowlistCanGrant: "@member",
+			DialogFieldTypeBlocklistCanGrant: "contractors",
+		}}
+		scheme, _, errors := p.getDialogSubmissionScheme(req, grantSchemeFields, &typeScope{TeamID: teamID})
+		require.Nil(t, errors)
+		assert.True(t, scheme.Everyone)
+		assert.Equal(t, map[string]bool{model.TEAM_ADMIN_ROLE_ID: true}, scheme.Roles)
+		assert.Equal(t, map[string]bool{member.Id: true}, scheme.AllowList)
+		assert.Equal(t, map[string]bool{contractors.Id: true}, scheme.BlockGroups)
+		assert.Empty(t, scheme.BlockList)
+
+		req.Submission[DialogFieldTypeBlocklistCanGrant] = "nobody"
+		_, _, errors = p.getDialogSubmissionScheme(req, grantSchemeFields, &typeScope{TeamID: teamID})
+		assert.Contains(t, errors, DialogFieldTypeBlocklistCanGrant)
+	})
+
+	t.Run("labels name what the scheme covers", func(t *testing.T) {
+		elements := p.getSchemeDialogElements(editSchemeFields, nil)
+		assert.Equal(t, "Everyone can edit this type and its badges", elements[0].DisplayName)
+	})
+}
@@ -3,9 +3,11 @@ package main
 import (
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-plugin-api/cluster"
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/pkg/errors"
@@ -22,11 +24,12 @@ type Plugin struct {
 	// setConfiguration for usage.
 	configuration *configuration
 
-	mm               *pluginapi.Client
-	BotUserID        string
-	store            Store
-	router           *mux.Router
-	badgeAdminUserID string
+	mm        *pluginapi.Client
+	BotUserID string
+	store     Store
+	router    *mux.Router
+	auditJob  *cluster.Job
+	expiryJob *cluster.Job
 }
 
 // ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
@@ -49,8 +52,73 @@ func (p *Plugin) OnActivate() error {
 		return errors.Wrap(err, "failed to ensure badges bot")
 	}
 	p.BotUserID = botID
-	p.store = NewStore(p.API)
+
+	_, err = runMigrations(p.API, false)
+	if err != nil {
+		return errors.Wrap(err, "failed to migrate the badges database")
+	}
+
+	err = p.initStore()
+	if err != nil {
+		return errors.Wrap(err, "failed to initialize the badges store")
+	}
 	p.initializeAPI()
 
+	p.auditJob, err = cluster.Schedule(p.API, AuditRetentionJobKey, cluster.MakeWaitForRoundedInterval(24*time.Hour), p.runAuditRetention)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule the audit log retention job")
+	}
+
+	p.expiryJob, err = cluster.Schedule(p.API, ExpiryJobKey, cluster.MakeWaitForRoundedInterval(time.Hour), p.runExpiry)
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule the badge expiry job")
+	}
+
 	return p.mm.SlashCommand.Register(p.getCommand())
 }
+
+func (p *Plugin) OnDeactivate() error {
+	for _, job := range []*cluster.Job{p.auditJob, p.expiryJob} {
+		if job == nil {
+			continue
+		}
+		err := job.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) newSQLStore() (Store, error) {
+	db, err := p.mm.Store.GetMasterDB()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSQLStore(db, p.mm.Store.DriverName(), p.API)
+}
+
+func (p *Plugin) initStore() error {
+	var s Store
+	switch p.getConfiguration().StoreBackend {
+	case StoreBackendSQL:
+		var err error
+		s, err = p.newSQLStore()
+		if err != nil {
+			return err
+		}
+	default:
+		s = NewStore(p.API)
+	}
+
+	p.store = NewCachedStore(NewSnapshotStore(s, p.API, SnapshotsToKeep), p.API)
+	return nil
+}
+
+// storeAs returns the store to use for changes made by actorID, so they are
+// recorded on the audit log.
+func (p *Plugin) storeAs(actorID string) Store {
+	return NewAuditStore(p.store, p.API, actorID)
+}
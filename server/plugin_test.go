@@ -1,28 +1,125 @@
 package main
 
 import (
-	"io/ioutil"
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
+func setupTestPlugin(s Store) *Plugin {
+	p := &Plugin{store: s}
+	p.SetAPI(newFakeAPI())
+	p.mm = pluginapi.NewClient(p.API)
+	p.initializeAPI()
+	return p
+}
+
 func TestServeHTTP(t *testing.T) {
-	assert := assert.New(t)
-	plugin := Plugin{}
-	w := httptest.NewRecorder()
-	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	s := newMemStore()
+	badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+	userID := model.NewId()
+	_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+	require.NoError(t, err)
+
+	p := setupTestPlugin(s)
+
+	t.Run("unauthorized", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/getUserBadges/"+userID, nil)
+
+		p.ServeHTTP(&plugin.Context{}, w, r)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	})
+
+	t.Run("user badges", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/getUserBadges/"+userID, nil)
+		r.Header.Set("Mattermost-User-ID", model.NewId())
+
+		p.ServeHTTP(&plugin.Context{}, w, r)
+
+		result := w.Result()
+		defer result.Body.Close()
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, "application/json", result.Header.Get("Content-Type"))
+
+		badges := []*badgesmodel.UserBadge{}
+		require.NoError(t, json.NewDecoder(result.Body).Decode(&badges))
+		require.Len(t, badges, 1)
+		assert.Equal(t, badge.ID, badges[0].ID)
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/unknown", nil)
+
+		p.ServeHTTP(&plugin.Context{}, w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+}
+
+func TestDialogRevoke(t *testing.T) {
+	s := newMemStore()
+	badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+	revoker := &model.User{Id: badge.CreatedBy, Username: "revoker", Roles: model.SYSTEM_USER_ROLE_ID}
+	owner := &model.User{Id: model.NewId(), Username: "owner", Roles: model.SYSTEM_USER_ROLE_ID}
+	stranger := &model.User{Id: model.NewId(), Username: "stranger", Roles: model.SYSTEM_USER_ROLE_ID}
+	for range []int{1, 2, 3} {
+		_, err := s.GrantBadge(badge.ID, owner.Id, revoker.Id, "")
+		require.NoError(t, err)
+	}
+
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+	api.addUser(revoker)
+	api.addUser(owner)
+	api.addUser(stranger)
+	api.On("SendEphemeralPost", revoker.Id, mock.Anything).Return(&model.Post{})
+
+	submit := func(userID string, submission map[string]interface{}) *model.SubmitDialogResponse {
+		submission[DialogFieldBadge] = string(badge.ID)
+		submission[DialogFieldUser] = owner.Id
+		req := &model.SubmitDialogRequest{ChannelId: model.NewId(), Submission: submission}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, DialogPath+DialogPathRevoke, bytes.NewReader(req.ToJson()))
+		r.Header.Set("Mattermost-User-ID", userID)
+
+		p.ServeHTTP(&plugin.Context{}, w, r)
+
+		resp := model.SubmitDialogResponseFromJson(w.Result().Body)
+		require.NotNil(t, resp)
+		return resp
+	}
+	owned := func() int {
+		details, err := s.GetBadgeDetails(badge.ID)
+		require.NoError(t, err)
+		return len(details.Owners)
+	}
+
+	resp := submit(stranger.Id, map[string]interface{}{})
+	assert.NotEmpty(t, resp.Error, "only who can revoke the badge can submit the dialog")
+	assert.Equal(t, 3, owned())
 
-	plugin.ServeHTTP(nil, w, r)
+	resp = submit(revoker.Id, map[string]interface{}{})
+	assert.Empty(t, resp.Error)
+	assert.Equal(t, 2, owned(), "only the last grant is revoked")
 
-	result := w.Result()
-	assert.NotNil(result)
-	defer result.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(result.Body)
-	assert.Nil(err)
-	bodyString := string(bodyBytes)
+	resp = submit(revoker.Id, map[string]interface{}{DialogFieldRevokeAll: true})
+	assert.Empty(t, resp.Error)
+	assert.Equal(t, 0, owned())
 
-	assert.Equal("Hello, world!", bodyString)
+	resp = submit(revoker.Id, map[string]interface{}{})
+	assert.NotEmpty(t, resp.Errors[DialogFieldBadge], "the badge is no longer owned")
 }
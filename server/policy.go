@@ -0,0 +1,205 @@
+package main
+
+import (
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// permissionDecision is the outcome of a permission check, and the rule that
+// decided it. Reason describes the rule for people, without naming the user,
+// so it fits both on the explain endpoint and on the errors shown to the user.
+type permissionDecision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+}
+
+func allowBy(rule, reason string) permissionDecision {
+	return permissionDecision{Allowed: true, Rule: rule, Reason: reason}
+}
+
+func denyBy(rule, reason string) permissionDecision {
+	return permissionDecision{Allowed: false, Rule: rule, Reason: reason}
+}
+
+// deniedMessage adds the reason of the decision to an error message.
+func (d permissionDecision) deniedMessage(message string) string {
+	return message + ". Reason: " + d.Reason
+}
+
+// decideAdmin allows system admins and badge admins, and the team admins that
+// are badge admins of the team of the type. ok is false when the user is none
+// of them, and the other rules must be checked.
+func decideAdmin(user *model.User, admins *badgeAdminSet, badgeType *badgesmodel.BadgeTypeDefinition) (decision permissionDecision, ok bool) {
+	if user.IsSystemAdmin() {
+		return allowBy(badgesmodel.PermissionRuleSystemAdmin, "system admin"), true
+	}
+	if admins.isAdmin(user) {
+		return allowBy(badgesmodel.PermissionRuleBadgeAdmin, "badge admin"), true
+	}
+	if admins.isTeamAdmin(user, badgeType.TeamID) {
+		return allowBy(badgesmodel.PermissionRuleTeamAdmin, "admin of the team of the type"), true
+	}
+	return permissionDecision{}, false
+}
+
+// decideScheme checks the lists and roles of the scheme. Blocked users and
+// members of blocked groups are denied first, and so is everyone when blocked
+// groups are set but the groups of the user are unknown.
+func decideScheme(scheme badgesmodel.PermissionScheme, userID string, membership *typeMembership) permissionDecision {
+	if scheme.BlockList[userID] {
+		return denyBy(badgesmodel.PermissionRuleBlockList, "on the blocklist of the type")
+	}
+
+	if len(scheme.BlockGroups) > 0 {
+		if membership.GroupsUnknown {
+			return denyBy(badgesmodel.PermissionRuleGroupsUnknown, "the groups could not be checked against the blocklist of the type")
+		}
+		if isAnyGroupListed(scheme.BlockGroups, membership.Groups) {
+			return denyBy(badgesmodel.PermissionRuleBlockGroup, "in a group on the blocklist of the type")
+		}
+	}
+
+	if role := matchRole(membership.Roles, scheme.Roles); role != "" {
+		return allowBy(badgesmodel.PermissionRuleRole, "has the role "+role+", allowed by the type")
+	}
+
+	if scheme.AllowList[userID] {
+		return allowBy(badgesmodel.PermissionRuleAllowList, "on the allowlist of the type")
+	}
+
+	if isAnyGroupListed(scheme.AllowGroups, membership.Groups) {
+		return allowBy(badgesmodel.PermissionRuleAllowGroup, "in a group on the allowlist of the type")
+	}
+
+	if scheme.Everyone {
+		return allowBy(badgesmodel.PermissionRuleEveryone, "the type allows everyone")
+	}
+
+	return denyBy(badgesmodel.PermissionRuleNotAllowed, "not allowed by the roles or lists of the type")
+}
+
+func decideGrantBadge(user *model.User, admins *badgeAdminSet, membership *typeMembership, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	if badge.Archived || badgeType.Archived {
+		return denyBy(badgesmodel.PermissionRuleArchived, "the badge or its type is archived")
+	}
+
+	if decision, ok := decideAdmin(user, admins, badgeType); ok {
+		return decision
+	}
+
+	if badgeType.CreatedBy == user.Id {
+		return allowBy(badgesmodel.PermissionRuleTypeCreator, "creator of the type")
+	}
+
+	if badge.CreatedBy == user.Id {
+		return allowBy(badgesmodel.PermissionRuleBadgeCreator, "creator of the badge")
+	}
+
+	if isBadgeCoOwner(badge, user.Id) {
+		return allowBy(badgesmodel.PermissionRuleBadgeCoOwner, "co-owner of the badge")
+	}
+
+	return decideScheme(badgeType.CanGrant, user.Id, membership)
+}
+
+func decideRevokeBadge(user *model.User, admins *badgeAdminSet, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	if decision, ok := decideAdmin(user, admins, badgeType); ok {
+		return decision
+	}
+
+	if badgeType.CreatedBy == user.Id {
+		return allowBy(badgesmodel.PermissionRuleTypeCreator, "creator of the type")
+	}
+
+	if badge.CreatedBy == user.Id {
+		return allowBy(badgesmodel.PermissionRuleBadgeCreator, "creator of the badge")
+	}
+
+	if isBadgeCoOwner(badge, user.Id) {
+		return allowBy(badgesmodel.PermissionRuleBadgeCoOwner, "co-owner of the badge")
+	}
+
+	return denyBy(badgesmodel.PermissionRuleNotAllowed, "only badge admins and the creators of the badge and its type can revoke it")
+}
+
+func decideCreateBadge(user *model.User, admins *badgeAdminSet, membership *typeMembership, badgeType *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	if badgeType.Archived {
+		return denyBy(badgesmodel.PermissionRuleArchived, "the type is archived")
+	}
+
+	if decision, ok := decideAdmin(user, admins, badgeType); ok {
+		return decision
+	}
+
+	if badgeType.CreatedBy == user.Id {
+		return allowBy(badgesmodel.PermissionRuleTypeCreator, "creator of the type")
+	}
+
+	return decideScheme(badgeType.CanCreate, user.Id, membership)
+}
+
+// decideEditBadge lets the owners of the badge edit it, and the users the type
+// allows to edit its badges.
+func decideEditBadge(user *model.User, admins *badgeAdminSet, membership *typeMembership, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	if decision, ok := decideAdmin(user, admins, badgeType); ok {
+		return decision
+	}
+
+	if badge.CreatedBy == user.Id {
+		return allowBy(badgesmodel.PermissionRuleBadgeCreator, "creator of the badge")
+	}
+
+	if isBadgeCoOwner(badge, user.Id) {
+		return allowBy(badgesmodel.PermissionRuleBadgeCoOwner, "co-owner of the badge")
+	}
+
+	return decideScheme(badgeType.CanEdit, user.Id, membership)
+}
+
+func decideEditType(user *model.User, admins *badgeAdminSet, membership *typeMembership, badgeType *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	if decision, ok := decideAdmin(user, admins, badgeType); ok {
+		return decision
+	}
+
+	return decideScheme(badgeType.CanEdit, user.Id, membership)
+}
+
+func isBadgeCoOwner(badge *badgesmodel.Badge, userID string) bool {
+	for _, id := range badge.CoOwners {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// isDecidedByScheme tells whether the decision comes from a permission scheme
+// of the type, rather than from the user being an admin or an owner.
+func isDecidedByScheme(decision permissionDecision) bool {
+	switch decision.Rule {
+	case badgesmodel.PermissionRuleBlockList, badgesmodel.PermissionRuleBlockGroup, badgesmodel.PermissionRuleGroupsUnknown,
+		badgesmodel.PermissionRuleRole, badgesmodel.PermissionRuleAllowList, badgesmodel.PermissionRuleAllowGroup,
+		badgesmodel.PermissionRuleEveryone, badgesmodel.PermissionRuleNotAllowed:
+		return true
+	}
+	return false
+}
+
+// decideAction runs the check the plugin does before the user takes the action
+// on the badge, scope included. ok is false when the action is unknown.
+func (p *Plugin) decideAction(user *model.User, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition, action string) (decision permissionDecision, ok bool) {
+	switch action {
+	case badgesmodel.PermissionActionGrant:
+		return p.decideGrantScopedBadge(user, badge, badgeType), true
+	case badgesmodel.PermissionActionRevoke:
+		return decideRevokeBadge(user, p.getBadgeAdmins(), badge, badgeType), true
+	case badgesmodel.PermissionActionCreate:
+		return p.decideCreateScopedBadge(user, badgeType), true
+	case badgesmodel.PermissionActionEdit:
+		return p.decideEditScopedBadge(user, badge, badgeType), true
+	case badgesmodel.PermissionActionEditType:
+		return p.decideManageType(user, badgeType), true
+	}
+	return permissionDecision{}, false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecideGrantBadge(t *testing.T) {
+	user := &model.User{Id: model.NewId(), Roles: model.SYSTEM_USER_ROLE_ID}
+	sysadmin := &model.User{Id: model.NewId(), Roles: model.SYSTEM_ADMIN_ROLE_ID}
+	admins := &badgeAdminSet{userIDs: map[string]bool{"admin": true}}
+	membership := &typeMembership{Roles: user.GetRoles()}
+
+	for name, tc := range map[string]struct {
+		user    *model.User
+		badge   badgesmodel.Badge
+		scheme  badgesmodel.PermissionScheme
+		creator string
+		rule    string
+		allowed bool
+	}{
+		"archived first":        {user: sysadmin, badge: badgesmodel.Badge{Archived: true}, rule: badgesmodel.PermissionRuleArchived},
+		"system admin":          {user: sysadmin, rule: badgesmodel.PermissionRuleSystemAdmin, allowed: true},
+		"badge admin":           {user: &model.User{Id: "admin"}, rule: badgesmodel.PermissionRuleBadgeAdmin, allowed: true},
+		"type creator":          {user: user, creator: user.Id, scheme: badgesmodel.PermissionScheme{BlockList: map[string]bool{user.Id: true}}, rule: badgesmodel.PermissionRuleTypeCreator, allowed: true},
+		"badge creator":         {user: user, badge: badgesmodel.Badge{CreatedBy: user.Id}, rule: badgesmodel.PermissionRuleBadgeCreator, allowed: true},
+		"blocklist over roles":  {user: user, scheme: badgesmodel.PermissionScheme{Everyone: true, Roles: map[string]bool{model.SYSTEM_USER_ROLE_ID: true}, BlockList: map[string]bool{user.Id: true}}, rule: badgesmodel.PermissionRuleBlockList},
+		"role over allowlist":   {user: user, scheme: badgesmodel.PermissionScheme{Roles: map[string]bool{model.SYSTEM_USER_ROLE_ID: true}, AllowList: map[string]bool{user.Id: true}}, rule: badgesmodel.PermissionRuleRole, allowed: true},
+		"allowlist":             {user: user, scheme: badgesmodel.PermissionScheme{Everyone: true, AllowList: map[string]bool{user.Id: true}}, rule: badgesmodel.PermissionRuleAllowList, allowed: true},
+		"everyone":              {user: user, scheme: badgesmodel.PermissionScheme{Everyone: true}, rule: badgesmodel.PermissionRuleEveryone, allowed: true},
+		"nothing matches":       {user: user, rule: badgesmodel.PermissionRuleNotAllowed},
+		"other users allowlist": {user: user, scheme: badgesmodel.PermissionScheme{AllowList: map[string]bool{"other": true}}, rule: badgesmodel.PermissionRuleNotAllowed},
+	} {
+		badge := tc.badge
+		badgeType := &badgesmodel.BadgeTypeDefinition{CreatedBy: tc.creator, CanGrant: tc.scheme}
+		decision := decideGrantBadge(tc.user, admins, membership, &badge, badgeType)
+		assert.Equal(t, tc.allowed, decision.Allowed, name)
+		assert.Equal(t, tc.rule, decision.Rule, name)
+		assert.NotEmpty(t, decision.Reason, name)
+	}
+}
+
+func TestExplainPermission(t *testing.T) {
+	s := newMemStore()
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+
+	admin := &model.User{Id: model.NewId(), Username: "admin", Roles: model.SYSTEM_ADMIN_ROLE_ID}
+	user := &model.User{Id: model.NewId(), Username: "user", Roles: model.SYSTEM_USER_ROLE_ID}
+	api.addUser(admin)
+	api.addUser(user)
+	api.On("GetUserByUsername", "user").Return(user, nil)
+
+	badgeType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "type", CanGrant: badgesmodel.PermissionScheme{Everyone: true, BlockList: map[string]bool{user.Id: true}}})
+	require.NoError(t, err)
+	badge := addTestBadge(t, s, badgeType.ID, false)
+
+	get := func(actingUserID, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/permissions/explain?"+query, nil)
+		r.Header.Set("Mattermost-User-ID", actingUserID)
+		p.ServeHTTP(&plugin.Context{}, w, r)
+		return w
+	}
+
+	t.Run("badge admins only", func(t *testing.T) {
+		w := get(user.Id, "user=user&action=grant&badge="+string(badge.ID))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("explains the rule", func(t *testing.T) {
+		w := get(admin.Id, "user=user&action=grant&badge="+string(badge.ID))
+		require.Equal(t, http.StatusOK, w.Code)
+		explanation := badgesmodel.PermissionExplanation{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+		assert.Equal(t, user.Id, explanation.UserID)
+		assert.False(t, explanation.Allowed)
+		assert.Equal(t, badgesmodel.PermissionRuleBlockList, explanation.Rule)
+
+		w = get(admin.Id, "user="+user.Id+"&action=create&badge="+string(badge.ID))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &explanation))
+		assert.Equal(t, badgesmodel.PermissionRuleNotAllowed, explanation.Rule)
+	})
+
+	t.Run("unknown action", func(t *testing.T) {
+		w := get(admin.Id, "user=user&action=fly&badge="+string(badge.ID))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("errors tell the reason", func(t *testing.T) {
+		_, _, err := p.runGrant([]string{"--badge", string(badge.ID), "--user", "user"}, &model.CommandArgs{UserId: user.Id})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "on the blocklist of the type")
+	})
+}
+
+func TestEditPermissions(t *testing.T) {
+	s := newMemStore()
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+
+	creator := &model.User{Id: model.NewId(), Username: "creator", Roles: model.SYSTEM_USER_ROLE_ID}
+	coOwner := &model.User{Id: model.NewId(), Username: "coowner", Roles: model.SYSTEM_USER_ROLE_ID}
+	maintainer := &model.User{Id: model.NewId(), Username: "maintainer", Roles: model.SYSTEM_USER_ROLE_ID}
+	user := &model.User{Id: model.NewId(), Username: "user", Roles: model.SYSTEM_USER_ROLE_ID}
+	api.On("GetUserByUsername", "coowner").Return(coOwner, nil)
+	api.On("GetUserByUsername", "creator").Return(creator, nil)
+	api.On("GetUserByUsername", "nobody").Return(nil, model.NewAppError("test", "not_found", nil, "", http.StatusNotFound))
+
+	badgeType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "type", CanEdit: badgesmodel.PermissionScheme{AllowList: map[string]bool{maintainer.Id: true}}})
+	require.NoError(t, err)
+	otherType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "other"})
+	require.NoError(t, err)
+	badge, err := s.AddBadge(&badgesmodel.Badge{Name: "badge", Image: "smile", Type: badgeType.ID, CreatedBy: creator.Id, CoOwners: []string{coOwner.Id}})
+	require.NoError(t, err)
+	other, err := s.AddBadge(&badgesmodel.Badge{Name: "other", Image: "smile", Type: otherType.ID, CreatedBy: creator.Id})
+	require.NoError(t, err)
+
+	t.Run("co-owners edit, grant and revoke", func(t *testing.T) {
+		decision := p.decideEditScopedBadge(coOwner, badge, badgeType)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, badgesmodel.PermissionRuleBadgeCoOwner, decision.Rule)
+		assert.True(t, p.canGrantScopedBadge(coOwner, badge, badgeType))
+		assert.Equal(t, badgesmodel.PermissionRuleBadgeCoOwner, decideRevokeBadge(coOwner, nil, badge, badgeType).Rule)
+		assert.False(t, p.canEditScopedBadge(coOwner, other, otherType))
+	})
+
+	t.Run("the type scheme allows editing the type and its badges", func(t *testing.T) {
+		assert.True(t, p.canEditScopedBadge(maintainer, badge, badgeType))
+		assert.True(t, p.canManageType(maintainer, badgeType))
+		assert.False(t, p.canManageType(maintainer, otherType))
+		assert.False(t, p.canEditScopedBadge(user, badge, badgeType))
+		assert.False(t, p.canManageType(creator, badgeType))
+	})
+
+	t.Run("edit autocomplete", func(t *testing.T) {
+		bb, err := p.filterEditBadges(maintainer)
+		require.NoError(t, err)
+		require.Len(t, bb, 1)
+		assert.Equal(t, badge.ID, bb[0].ID)
+
+		bb, err = p.filterEditBadges(creator)
+		require.NoError(t, err)
+		assert.Len(t, bb, 2)
+
+		types, err := p.filterEditTypes(maintainer)
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Equal(t, badgeType.ID, types[0].ID)
+
+		types, err = p.filterEditTypes(user)
+		require.NoError(t, err)
+		assert.Empty(t, types)
+	})
+
+	t.Run("co-owners on the dialog", func(t *testing.T) {
+		coOwners, err := p.parseCoOwners("@coowner, creator, coowner", creator.Id)
+		require.NoError(t, err)
+		assert.Equal(t, []string{coOwner.Id}, coOwners)
+
+		_, err = p.parseCoOwners("nobody", creator.Id)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// Values of the scope field of the type dialogs. Team and channel scopes are
+// followed by the team or channel ID.
+const (
+	scopeEveryone      = "everyone"
+	scopeTeamPrefix    = "team:"
+	scopeChannelPrefix = "channel:"
+)
+
+// typeScope is a scope a type can be given, as offered on the type dialogs.
+type typeScope struct {
+	Name      string
+	TeamID    string
+	ChannelID string
+}
+
+func (s typeScope) value() string {
+	switch {
+	case s.ChannelID != "":
+		return scopeChannelPrefix + s.ChannelID
+	case s.TeamID != "":
+		return scopeTeamPrefix + s.TeamID
+	}
+	return scopeEveryone
+}
+
+func getTypeScopeValue(t *badgesmodel.BadgeTypeDefinition) string {
+	return typeScope{TeamID: t.TeamID, ChannelID: t.ChannelID}.value()
+}
+
+// isTeamMember tells whether the user is on the team, and has not left it.
+func (p *Plugin) isTeamMember(teamID, userID string) bool {
+	member, err := p.mm.Team.GetMember(teamID, userID)
+	return err == nil && member.DeleteAt == 0
+}
+
+func (p *Plugin) isChannelMember(channelID, userID string) bool {
+	_, err := p.mm.Channel.GetMember(channelID, userID)
+	return err == nil
+}
+
+func (p *Plugin) isTeamAdmin(userID, teamID string) bool {
+	return teamID != "" && p.API.HasPermissionToTeam(userID, teamID, model.PERMISSION_MANAGE_TEAM)
+}
+
+// isInTypeScope tells whether the user is a member of the team or channel the
+// type is scoped to. Every user is in the scope of types without one.
+func (p *Plugin) isInTypeScope(userID string, t *badgesmodel.BadgeTypeDefinition) bool {
+	if t.ChannelID != "" {
+		return p.isChannelMember(t.ChannelID, userID)
+	}
+	if t.TeamID != "" {
+		return p.isTeamMember(t.TeamID, userID)
+	}
+	return true
+}
+
+// isChannelInTypeScope tells whether grants of badges of the type can be posted
+// on the channel.
+func (p *Plugin) isChannelInTypeScope(channelID string, t *badgesmodel.BadgeTypeDefinition) bool {
+	if t.ChannelID != "" {
+		return channelID == t.ChannelID
+	}
+	if t.TeamID != "" {
+		channel, err := p.mm.Channel.Get(channelID)
+		return err == nil && channel.TeamId == t.TeamID
+	}
+	return true
+}
+
+// getTypeScopeName describes the scope of the type, for messages.
+func (p *Plugin) getTypeScopeName(t *badgesmodel.BadgeTypeDefinition) string {
+	if t.ChannelID != "" {
+		channel, err := p.mm.Channel.Get(t.ChannelID)
+		if err != nil || channel.DisplayName == "" {
+			return "its channel"
+		}
+		return "channel " + channel.DisplayName
+	}
+	if t.TeamID != "" {
+		team, err := p.mm.Team.Get(t.TeamID)
+		if err != nil {
+			return "its team"
+		}
+		return "team " + team.DisplayName
+	}
+	return "everyone"
+}
+
+// canManageType tells whether the user can edit, archive, restore and purge the
+// type. Besides badge admins, team admins manage the types scoped to their team
+// or to one of its channels, and the users the type allows to edit it manage it
+// from inside its scope.
+func (p *Plugin) canManageType(user *model.User, t *badgesmodel.BadgeTypeDefinition) bool {
+	return p.decideManageType(user, t).Allowed
+}
+
+func (p *Plugin) decideManageType(user *model.User, t *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	decision := decideEditType(user, p.getBadgeAdmins(), p.getTypeMembership(user, t), t)
+	if !isDecidedByScheme(decision) {
+		return decision
+	}
+	if p.isTeamAdmin(user.Id, t.TeamID) {
+		return allowBy(badgesmodel.PermissionRuleTeamAdmin, "admin of the team of the type")
+	}
+	return p.decideInTypeScope(user, t, decision)
+}
+
+// canEditScopedBadge adds the type scope to canEditBadge for the users the type
+// allows to edit its badges. Admins and the owners of the badge edit it from
+// anywhere.
+func (p *Plugin) canEditScopedBadge(user *model.User, b *badgesmodel.Badge, t *badgesmodel.BadgeTypeDefinition) bool {
+	return p.decideEditScopedBadge(user, b, t).Allowed
+}
+
+func (p *Plugin) decideEditScopedBadge(user *model.User, b *badgesmodel.Badge, t *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	decision := decideEditBadge(user, p.getBadgeAdmins(), p.getTypeMembership(user, t), b, t)
+	if !isDecidedByScheme(decision) {
+		return decision
+	}
+	return p.decideInTypeScope(user, t, decision)
+}
+
+// decideEditStoredBadge is decideEditScopedBadge with the type of the badge
+// taken from the store. Badges whose type is gone can still be edited by admins
+// and by their owners.
+func (p *Plugin) decideEditStoredBadge(user *model.User, b *badgesmodel.Badge) permissionDecision {
+	t, err := p.store.GetType(b.Type)
+	if err != nil {
+		t = &badgesmodel.BadgeTypeDefinition{ID: b.Type}
+	}
+	return p.decideEditScopedBadge(user, b, t)
+}
+
+// canCreateTypeOnTeam tells whether the user can create types from the team.
+// Team admins can, but only scoped to the team.
+func (p *Plugin) canCreateTypeOnTeam(user *model.User, teamID string) bool {
+	return canCreateType(user, p.getBadgeAdmins(), false) || p.isTeamAdmin(user.Id, teamID)
+}
+
+// canGrantScopedBadge adds the type scope to canGrantBadge: only the members of
+// the scope can grant its badges.
+func (p *Plugin) canGrantScopedBadge(user *model.User, b *badgesmodel.Badge, t *badgesmodel.BadgeTypeDefinition) bool {
+	return p.decideGrantScopedBadge(user, b, t).Allowed
+}
+
+func (p *Plugin) decideGrantScopedBadge(user *model.User, b *badgesmodel.Badge, t *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	return p.decideInTypeScope(user, t, decideGrantBadge(user, p.getBadgeAdmins(), p.getTypeMembership(user, t), b, t))
+}
+
+// canCreateScopedBadge adds the type scope to canCreateBadge.
+func (p *Plugin) canCreateScopedBadge(user *model.User, t *badgesmodel.BadgeTypeDefinition) bool {
+	return p.decideCreateScopedBadge(user, t).Allowed
+}
+
+func (p *Plugin) decideCreateScopedBadge(user *model.User, t *badgesmodel.BadgeTypeDefinition) permissionDecision {
+	return p.decideInTypeScope(user, t, decideCreateBadge(user, p.getBadgeAdmins(), p.getTypeMembership(user, t), t))
+}
+
+// decideInTypeScope denies what the decision allows when the user is not in the
+// scope of the type.
+func (p *Plugin) decideInTypeScope(user *model.User, t *badgesmodel.BadgeTypeDefinition, decision permissionDecision) permissionDecision {
+	if decision.Allowed && !p.isInTypeScope(user.Id, t) {
+		return denyBy(badgesmodel.PermissionRuleOutOfScope, "only members of "+p.getTypeScopeName(t)+" can use badges of the type")
+	}
+	return decision
+}
+
+// checkGrantScope returns the error to show when the user cannot receive badges
+// of the type, or nil.
+func (p *Plugin) checkGrantScope(user *model.User, t *badgesmodel.BadgeTypeDefinition) error {
+	if p.isInTypeScope(user.Id, t) {
+		return nil
+	}
+	return fmt.Errorf("@%s cannot receive this badge, as only members of %s can", user.Username, p.getTypeScopeName(t))
+}
+
+// getTypeScopes lists the scopes the user can give a type from the team and
+// channel the dialog was opened on. Badge admins can pick any of them, and team
+// admins the team and its channels. current is the type being edited, which
+// can always keep its scope.
+func (p *Plugin) getTypeScopes(user *model.User, teamID, channelID string, current *badgesmodel.BadgeTypeDefinition) []typeScope {
+	isAdmin := canCreateType(user, p.getBadgeAdmins(), false)
+
+	scopes := []typeScope{}
+	if isAdmin {
+		scopes = append(scopes, typeScope{Name: "Everyone"})
+	}
+
+	if teamID != "" && (isAdmin || p.isTeamAdmin(user.Id, teamID)) {
+		if team, err := p.mm.Team.Get(teamID); err == nil {
+			scopes = append(scopes, typeScope{Name: "Team " + team.DisplayName, TeamID: team.Id})
+		}
+	}
+
+	if channelID != "" {
+		channel, err := p.mm.Channel.Get(channelID)
+		if err == nil && (isAdmin || p.isTeamAdmin(user.Id, channel.TeamId)) {
+			name := "This conversation"
+			if channel.Type == model.CHANNEL_OPEN || channel.Type == model.CHANNEL_PRIVATE {
+				name = "Channel " + channel.DisplayName
+			}
+			scopes = append(scopes, typeScope{Name: name, TeamID: channel.TeamId, ChannelID: channel.Id})
+		}
+	}
+
+	if current != nil {
+		kept := typeScope{Name: "Current scope: " + p.getTypeScopeName(current), TeamID: current.TeamID, ChannelID: current.ChannelID}
+		found := false
+		for _, s := range scopes {
+			if s.value() == kept.value() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			scopes = append(scopes, kept)
+		}
+	}
+
+	return scopes
+}
+
+func getTypeScopeOptions(scopes []typeScope) []*model.PostActionOptions {
+	options := []*model.PostActionOptions{}
+	for _, s := range scopes {
+		options = append(options, &model.PostActionOptions{Text: s.Name, Value: s.value()})
+	}
+	return options
+}
+
+// getDialogSubmissionScope reads the type scope. Only the scopes offered on the
+// dialog are taken.
+func (p *Plugin) getDialogSubmissionScope(req *model.SubmitDialogRequest, user *model.User, current *badgesmodel.BadgeTypeDefinition) (scope *typeScope, errText string, errors map[string]string) {
+	value, _ := req.Submission[DialogFieldTypeScope].(string)
+	value = strings.TrimSpace(value)
+	if value == "" && current != nil {
+		value = getTypeScopeValue(current)
+	}
+
+	scopes := p.getTypeScopes(user, req.TeamId, req.ChannelId, current)
+	for _, s := range scopes {
+		if s.value() == value {
+			return &s, "", nil
+		}
+	}
+
+	if len(scopes) == 0 {
+		return nil, "Invalid field", map[string]string{DialogFieldTypeScope: "You cannot create types here."}
+	}
+	return nil, "Invalid field", map[string]string{DialogFieldTypeScope: "Pick one of the scopes listed."}
+}
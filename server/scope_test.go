@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypeScopes(t *testing.T) {
+	s := newMemStore()
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+
+	teamID, otherTeamID, channelID := model.NewId(), model.NewId(), model.NewId()
+	member := &model.User{Id: model.NewId(), Username: "member", Roles: model.SYSTEM_USER_ROLE_ID}
+	outsider := &model.User{Id: model.NewId(), Username: "outsider", Roles: model.SYSTEM_USER_ROLE_ID}
+	teamAdmin := &model.User{Id: model.NewId(), Username: "teamadmin", Roles: model.SYSTEM_USER_ROLE_ID}
+	sysadmin := &model.User{Id: model.NewId(), Username: "sysadmin", Roles: model.SYSTEM_ADMIN_ROLE_ID + " " + model.SYSTEM_USER_ROLE_ID}
+
+	notFound := model.NewAppError("test", "not_found", nil, "", http.StatusNotFound)
+	api.On("GetTeamMember", teamID, member.Id).Return(&model.TeamMember{TeamId: teamID, UserId: member.Id}, nil)
+	api.On("GetTeamMember", teamID, teamAdmin.Id).Return(&model.TeamMember{TeamId: teamID, UserId: teamAdmin.Id}, nil)
+	api.On("GetTeamMember", mock.Anything, mock.Anything).Return(nil, notFound)
+	api.On("GetChannelMember", channelID, member.Id).Return(&model.ChannelMember{ChannelId: channelID, UserId: member.Id}, nil)
+	api.On("GetChannelMember", mock.Anything, mock.Anything).Return(nil, notFound)
+	api.On("HasPermissionToTeam", teamAdmin.Id, teamID, model.PERMISSION_MANAGE_TEAM).Return(true)
+	api.On("HasPermissionToTeam", mock.Anything, mock.Anything, mock.Anything).Return(false)
+	api.On("GetTeam", teamID).Return(&model.Team{Id: teamID, DisplayName: "Sales"}, nil)
+	api.On("GetTeam", otherTeamID).Return(&model.Team{Id: otherTeamID, DisplayName: "Support"}, nil)
+	api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, TeamId: teamID, Type: model.CHANNEL_OPEN, DisplayName: "Town Square"}, nil)
+
+	global, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "global", CanGrant: badgesmodel.PermissionScheme{Everyone: true}, CanCreate: badgesmodel.PermissionScheme{Everyone: true}})
+	require.NoError(t, err)
+	teamType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "team", TeamID: teamID, CanGrant: badgesmodel.PermissionScheme{Everyone: true}, CanCreate: badgesmodel.PermissionScheme{Everyone: true}})
+	require.NoError(t, err)
+	channelType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "channel", TeamID: teamID, ChannelID: channelID, CanGrant: badgesmodel.PermissionScheme{Everyone: true}})
+	require.NoError(t, err)
+	globalBadge := addTestBadge(t, s, global.ID, false)
+	teamBadge := addTestBadge(t, s, teamType.ID, false)
+	channelBadge := addTestBadge(t, s, channelType.ID, false)
+
+	badgeIDs := func(badges []*badgesmodel.Badge) []badgesmodel.BadgeID {
+		out := []badgesmodel.BadgeID{}
+		for _, b := range badges {
+			out = append(out, b.ID)
+		}
+		return out
+	}
+
+	t.Run("only members grant badges of scoped types", func(t *testing.T) {
+		badges, err := p.filterGrantBadges(member)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []badgesmodel.BadgeID{globalBadge.ID, teamBadge.ID, channelBadge.ID}, badgeIDs(badges))
+
+		badges, err = p.filterGrantBadges(teamAdmin)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []badgesmodel.BadgeID{globalBadge.ID, teamBadge.ID}, badgeIDs(badges))
+
+		badges, err = p.filterGrantBadges(outsider)
+		require.NoError(t, err)
+		assert.Equal(t, []badgesmodel.BadgeID{globalBadge.ID}, badgeIDs(badges))
+	})
+
+	t.Run("only members create badges of scoped types", func(t *testing.T) {
+		types, err := p.filterCreateBadgeTypes(outsider)
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Equal(t, global.ID, types[0].ID)
+	})
+
+	t.Run("only members receive badges of scoped types", func(t *testing.T) {
+		assert.NoError(t, p.checkGrantScope(member, channelType))
+		err := p.checkGrantScope(outsider, teamType)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "team Sales")
+	})
+
+	t.Run("team admins manage the types of their team", func(t *testing.T) {
+		assert.True(t, p.canManageType(teamAdmin, teamType))
+		assert.True(t, p.canManageType(teamAdmin, channelType))
+		assert.False(t, p.canManageType(teamAdmin, global))
+		assert.True(t, p.canManageType(sysadmin, teamType))
+		assert.False(t, p.canManageType(member, teamType))
+	})
+
+	t.Run("scopes offered on the dialogs", func(t *testing.T) {
+		values := func(scopes []typeScope) []string {
+			out := []string{}
+			for _, s := range scopes {
+				out = append(out, s.value())
+			}
+			return out
+		}
+
+		assert.Equal(t, []string{scopeEveryone, scopeTeamPrefix + teamID, scopeChannelPrefix + channelID}, values(p.getTypeScopes(sysadmin, teamID, channelID, nil)))
+		assert.Equal(t, []string{scopeTeamPrefix + teamID, scopeChannelPrefix + channelID}, values(p.getTypeScopes(teamAdmin, teamID, channelID, nil)))
+		assert.Empty(t, p.getTypeScopes(teamAdmin, otherTeamID, "", nil))
+		assert.Empty(t, p.getTypeScopes(member, teamID, channelID, nil))
+		assert.Equal(t, []string{scopeEveryone, scopeTeamPrefix + otherTeamID, scopeChannelPrefix + channelID}, values(p.getTypeScopes(sysadmin, otherTeamID, "", channelType)))
+	})
+
+	t.Run("only offered scopes are taken", func(t *testing.T) {
+		submit := func(user *model.User, value string) (*typeScope, map[string]string) {
+			req := &model.SubmitDialogRequest{TeamId: teamID, ChannelId: channelID, Submission: map[string]interface{}{DialogFieldTypeScope: value}}
+			scope, _, errors := p.getDialogSubmissionScope(req, user, nil)
+			return scope, errors
+		}
+
+		scope, errors := submit(teamAdmin, scopeChannelPrefix+channelID)
+		require.Nil(t, errors)
+		assert.Equal(t, teamID, scope.TeamID)
+		assert.Equal(t, channelID, scope.ChannelID)
+
+		_, errors = submit(teamAdmin, scopeEveryone)
+		assert.NotNil(t, errors)
+		_, errors = submit(teamAdmin, scopeTeamPrefix+otherTeamID)
+		assert.NotNil(t, errors)
+
+		scope, errors = submit(sysadmin, scopeEveryone)
+		require.Nil(t, errors)
+		assert.Equal(t, typeScope{Name: "Everyone"}, *scope)
+	})
+}
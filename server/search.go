@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+)
+
+// Points a search term scores on each part of a badge. A term scores once, on
+// the best part it matches.
+const (
+	searchScoreNameExact   = 100
+	searchScoreTagExact    = 60
+	searchScoreNamePrefix  = 50
+	searchScoreTagPrefix   = 30
+	searchScoreNameContain = 20
+	searchScoreDescription = 10
+)
+
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+
+// parseTags reads tags separated by commas or spaces. Tags are made lower case,
+// and repeated ones dropped.
+func parseTags(input string) ([]string, error) {
+	fields := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range fields {
+		tag = strings.TrimPrefix(tag, "#")
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > badgesmodel.TagMaxLength || !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q. Tags can have up to %d letters, numbers, - and _", tag, badgesmodel.TagMaxLength)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	if len(tags) > badgesmodel.MaxTags {
+		return nil, fmt.Errorf("a badge can have up to %d tags", badgesmodel.MaxTags)
+	}
+	if len(tags) == 0 {
+		return nil, nil
+	}
+
+	return tags, nil
+}
+
+func hasTag(b *badgesmodel.Badge, tag string) bool {
+	for _, t := range b.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTermScore is what the term scores on the badge, or 0 if it does not
+// match. term must be lower case.
+func searchTermScore(b *badgesmodel.Badge, term string) int {
+	name := strings.ToLower(b.Name)
+	best := 0
+	score := func(matches bool, points int) {
+		if matches && points > best {
+			best = points
+		}
+	}
+
+	score(name == term, searchScoreNameExact)
+	score(strings.HasPrefix(name, term), searchScoreNamePrefix)
+	for _, word := range strings.Fields(name) {
+		score(strings.HasPrefix(word, term), searchScoreNamePrefix)
+	}
+	score(strings.Contains(name, term), searchScoreNameContain)
+	for _, tag := range b.Tags {
+		score(tag == term, searchScoreTagExact)
+		score(strings.HasPrefix(tag, term), searchScoreTagPrefix)
+	}
+	score(strings.Contains(strings.ToLower(b.Description), term), searchScoreDescription)
+
+	return best
+}
+
+// searchBadges returns the badges matching every term of the query, and the tag
+// if set, best first. Archived badges are left out. An empty query matches every
+// badge with the tag, by name.
+func searchBadges(badges []*badgesmodel.AllBadgesBadge, query, tag string, limit int) []*badgesmodel.AllBadgesBadge {
+	terms := strings.Fields(strings.ToLower(query))
+	tag = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(tag)), "#")
+
+	type result struct {
+		badge *badgesmodel.AllBadgesBadge
+		score int
+	}
+	results := []result{}
+	for _, b := range badges {
+		if b.Archived || (tag != "" && !hasTag(&b.Badge, tag)) {
+			continue
+		}
+
+		total := 0
+		for _, term := range terms {
+			score := searchTermScore(&b.Badge, term)
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total == 0 && len(terms) > 0 {
+			continue
+		}
+
+		results = append(results, result{badge: b, score: total})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		if results[i].badge.GrantedTimes != results[j].badge.GrantedTimes {
+			return results[i].badge.GrantedTimes > results[j].badge.GrantedTimes
+		}
+		return strings.ToLower(results[i].badge.Name) < strings.ToLower(results[j].badge.Name)
+	})
+
+	if limit <= 0 || limit > SearchMaxLimit {
+		limit = SearchDefaultLimit
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	out := []*badgesmodel.AllBadgesBadge{}
+	for _, r := range results {
+		out = append(out, r.badge)
+	}
+	return out
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags(" Sales, #onboarding sales\tq3-2026 ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sales", "onboarding", "q3-2026"}, tags)
+
+	tags, err = parseTags(" , ")
+	require.NoError(t, err)
+	assert.Nil(t, tags)
+
+	_, err = parseTags("not/valid")
+	assert.Error(t, err)
+
+	_, err = parseTags("a b c d e f g h i j k")
+	assert.Error(t, err, "too many tags")
+}
+
+func TestSearchBadges(t *testing.T) {
+	newBadge := func(name, description string, granted int, tags ...string) *badgesmodel.AllBadgesBadge {
+		return &badgesmodel.AllBadgesBadge{
+			Badge:        badgesmodel.Badge{ID: badgesmodel.BadgeID(name), Name: name, Description: description, Tags: tags},
+			GrantedTimes: granted,
+		}
+	}
+	helper := newBadge("Helper", "Helped a teammate", 1, "kudos")
+	superHelper := newBadge("Super helper", "Helped many teammates", 5, "kudos")
+	thanks := newBadge("Thanks", "For helping out", 3, "kudos", "helpers")
+	closer := newBadge("Closer", "Closed a deal", 2, "sales")
+	archived := newBadge("Helper old", "", 9, "kudos")
+	archived.Archived = true
+	badges := []*badgesmodel.AllBadgesBadge{closer, thanks, archived, superHelper, helper}
+
+	names := func(found []*badgesmodel.AllBadgesBadge) []string {
+		out := []string{}
+		for _, b := range found {
+			out = append(out, b.Name)
+		}
+		return out
+	}
+
+	t.Run("ranked by where the term matches", func(t *testing.T) {
+		found := searchBadges(badges, "helper", "", 0)
+		assert.Equal(t, []string{"Helper", "Super helper", "Thanks"}, names(found))
+	})
+
+	t.Run("every term must match", func(t *testing.T) {
+		found := searchBadges(badges, "help many", "", 0)
+		assert.Equal(t, []string{"Super helper"}, names(found))
+	})
+
+	t.Run("tag filter", func(t *testing.T) {
+		found := searchBadges(badges, "", "#Sales", 0)
+		assert.Equal(t, []string{"Closer"}, names(found))
+
+		found = searchBadges(badges, "help", "kudos", 0)
+		assert.Equal(t, []string{"Super helper", "Helper", "Thanks"}, names(found), "name prefixes tie, and go to the most granted")
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		found := searchBadges(badges, "", "kudos", 2)
+		assert.Equal(t, []string{"Super helper", "Thanks"}, names(found), "ties go to the most granted")
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		assert.Empty(t, searchBadges(badges, "nothing", "", 0))
+	})
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
@@ -12,12 +14,22 @@ import (
 
 var errInvalidBadge = errors.New("invalid badge")
 var errBadgeNotFound = errors.New("badge not found")
+var errBadgeArchived = errors.New("badge is archived")
+var errBadgeNotTimeLimited = errors.New("badge does not expire")
+var errOwnershipNotFound = errors.New("the user does not have this badge")
+var errGrantNotFound = errors.New("grant not found")
 
 type Store interface {
 	// Interface
 	GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error)
 	GetAllBadges() ([]*badgesmodel.AllBadgesBadge, error)
 	GetBadgeDetails(badgeID badgesmodel.BadgeID) (*badgesmodel.BadgeDetails, error)
+	GetGrant(grantID string) (*badgesmodel.Ownership, error)
+	// Listing returns one page of the views above, with the cursor of the next
+	// page, or "" on the last one.
+	ListUserBadges(userID string, opts ListOptions) ([]*badgesmodel.UserBadge, string, error)
+	ListAllBadges(opts ListOptions) ([]*badgesmodel.AllBadgesBadge, string, error)
+	ListBadgeOwners(badgeID badgesmodel.BadgeID, opts ListOptions) (badgesmodel.OwnershipList, string, error)
 
 	// Autocomplete
 	GetRawBadges() ([]*badgesmodel.Badge, error)
@@ -25,7 +37,13 @@ type Store interface {
 
 	// API
 	AddBadge(badge *badgesmodel.Badge) (*badgesmodel.Badge, error)
-	GrantBadge(badgeID badgesmodel.BadgeID, userID string, grantedBy string, reason string) (bool, error)
+	// GrantBadge returns the new grant, or nil if the user already has the badge
+	// and it cannot be granted more than once.
+	GrantBadge(badgeID badgesmodel.BadgeID, userID string, grantedBy string, reason string) (*badgesmodel.Ownership, error)
+	RevokeBadge(badgeID badgesmodel.BadgeID, userID string, all bool) (int, error)
+	// RenewBadge extends the latest grant of a badge with a validity, so it
+	// expires after a whole validity from now.
+	RenewBadge(badgeID badgesmodel.BadgeID, userID string) (*badgesmodel.Ownership, error)
 	AddType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeTypeDefinition, error)
 	GetType(tID badgesmodel.BadgeType) (*badgesmodel.BadgeTypeDefinition, error)
 	GetBadge(badgeID badgesmodel.BadgeID) (*badgesmodel.Badge, error)
@@ -39,8 +57,38 @@ type Store interface {
 	GetTypeSubscriptions(tID badgesmodel.BadgeType) ([]string, error)
 	GetChannelSubscriptions(cID string) ([]*badgesmodel.BadgeTypeDefinition, error)
 
+	// History returns every version, oldest first, ending with the current one.
+	GetBadgeHistory(badgeID badgesmodel.BadgeID) ([]*badgesmodel.Badge, error)
+	GetTypeHistory(tID badgesmodel.BadgeType) (badgesmodel.BadgeTypeList, error)
+
+	// Expiry returns or removes the grants expired at before, sorted by expiry.
+	GetExpiringOwnerships(before time.Time) (badgesmodel.OwnershipList, error)
+	ExpireOwnerships(before time.Time) (badgesmodel.OwnershipList, error)
+
+	// Leaderboards
+	// GetOwnerships returns the grants of existing badges made at since or
+	// later, oldest first. A zero since returns every grant.
+	GetOwnerships(since time.Time) (badgesmodel.OwnershipList, error)
+
 	// PAPI
 	EnsureBadges(badges []*badgesmodel.Badge, pluginID, botID string) ([]*badgesmodel.Badge, error)
+
+	// Admin
+	Dump() (*StoreDump, error)
+	Restore(dump *StoreDump) error
+	// RemoveOrphans removes the given badges with their grants, the given grants
+	// and the given subscriptions one at a time, and leaves everything else
+	// untouched. Anything already gone is skipped.
+	RemoveOrphans(badges []badgesmodel.BadgeID, ownerships badgesmodel.OwnershipList, subscriptions []badgesmodel.Subscription) error
+}
+
+// StoreDump holds the whole content of a store, independent of the backend.
+// Ownerships are sorted by grant time.
+type StoreDump struct {
+	Types         badgesmodel.BadgeTypeList  `json:"types"`
+	Badges        []*badgesmodel.Badge       `json:"badges"`
+	Ownerships    badgesmodel.OwnershipList  `json:"ownerships"`
+	Subscriptions []badgesmodel.Subscription `json:"subscriptions"`
 }
 
 type store struct {
@@ -122,6 +170,7 @@ func (s *store) AddBadge(b *badgesmodel.Badge) (*badgesmodel.Badge, error) {
 	}
 
 	b.ID = badgesmodel.BadgeID(model.NewId())
+	setFirstBadgeVersion(b)
 	err = s.doAtomic(func() (bool, error) { return s.atomicAddBadge(b) })
 	if err != nil {
 		return nil, err
@@ -136,6 +185,7 @@ func (s *store) AddType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeT
 
 func (s *store) addType(t *badgesmodel.BadgeTypeDefinition, isPlugin bool) (*badgesmodel.BadgeTypeDefinition, error) {
 	t.ID = badgesmodel.BadgeType(model.NewId())
+	setFirstTypeVersion(t)
 	err := s.doAtomic(func() (bool, error) { return s.atomicAddType(t) })
 	if err != nil {
 		return nil, err
@@ -150,21 +200,23 @@ func (s *store) GetAllBadges() ([]*badgesmodel.AllBadgesBadge, error) {
 		return nil, err
 	}
 
-	ownership, _, err := s.getOwnershipList()
+	types, _, err := s.getAllTypes()
 	if err != nil {
 		return nil, err
 	}
 
 	out := []*badgesmodel.AllBadgesBadge{}
 	for _, b := range badges {
+		ownership, _, err := s.getBadgeOwnershipList(b.ID)
+		if err != nil {
+			return nil, err
+		}
+
 		badge := &badgesmodel.AllBadgesBadge{
 			Badge: *b,
 		}
 		grantedTo := map[string]bool{}
 		for _, o := range ownership {
-			if o.Badge != badge.ID {
-				continue
-			}
 			badge.GrantedTimes++
 
 			if !grantedTo[o.User] {
@@ -174,8 +226,8 @@ func (s *store) GetAllBadges() ([]*badgesmodel.AllBadgesBadge, error) {
 		}
 
 		badge.TypeName = "unknown"
-		t, err := s.GetType(badge.Type)
-		if err == nil {
+		t := types.GetType(badge.Type)
+		if t != nil {
 			badge.TypeName = t.Name
 		}
 		out = append(out, badge)
@@ -273,8 +325,16 @@ func (s *store) GetBadgeDetails(id badgesmodel.BadgeID) (*badgesmodel.BadgeDetai
 	}, nil
 }
 
-func (s *store) getOwnershipList() (badgesmodel.OwnershipList, []byte, error) {
-	data, appErr := s.api.KVGet(KVKeyOwnership)
+func getUserOwnershipKey(userID string) string {
+	return KVKeyUserOwnershipPrefix + userID
+}
+
+func getBadgeOwnershipKey(badgeID badgesmodel.BadgeID) string {
+	return KVKeyBadgeOwnershipPrefix + string(badgeID)
+}
+
+func (s *store) getOwnershipList(key string) (badgesmodel.OwnershipList, []byte, error) {
+	data, appErr := s.api.KVGet(key)
 	if appErr != nil {
 		return nil, nil, appErr
 	}
@@ -290,46 +350,313 @@ func (s *store) getOwnershipList() (badgesmodel.OwnershipList, []byte, error) {
 	return ownership, data, nil
 }
 
-func (s *store) GrantBadge(id badgesmodel.BadgeID, userID string, grantedBy string, reason string) (bool, error) {
+func (s *store) getUserOwnershipList(userID string) (badgesmodel.OwnershipList, []byte, error) {
+	return s.getOwnershipList(getUserOwnershipKey(userID))
+}
+
+func (s *store) getBadgeOwnershipList(badgeID badgesmodel.BadgeID) (badgesmodel.OwnershipList, []byte, error) {
+	return s.getOwnershipList(getBadgeOwnershipKey(badgeID))
+}
+
+func (s *store) GrantBadge(id badgesmodel.BadgeID, userID string, grantedBy string, reason string) (*badgesmodel.Ownership, error) {
 	badge, err := s.getBadge(id)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	types, _, err := s.getAllTypes()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
 	badgeType := types.GetType(badge.Type)
 	if badgeType == nil {
-		return false, errors.New("badge type not found")
+		return nil, errors.New("badge type not found")
+	}
+
+	if badge.Archived || badgeType.Archived {
+		return nil, errBadgeArchived
 	}
 
+	now := time.Now()
 	ownership := badgesmodel.Ownership{
-		User:      userID,
-		Badge:     badge.ID,
-		Time:      time.Now(),
-		Reason:    reason,
-		GrantedBy: grantedBy,
+		GrantID:      model.NewId(),
+		User:         userID,
+		Badge:        badge.ID,
+		Time:         now,
+		Reason:       reason,
+		GrantedBy:    grantedBy,
+		BadgeVersion: badge.GetVersion(),
+		ExpiresAt:    badge.GetExpiry(now),
 	}
 
-	shouldNotify := false
+	granted := false
 	err = s.doAtomic(func() (bool, error) {
 		var done bool
 		var err error
-		shouldNotify, done, err = s.atomicAddBadgeToOwnership(ownership, badge.Multiple)
+		granted, done, err = s.atomicAddBadgeToOwnership(ownership, badge.Multiple)
 		return done, err
 	})
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	if !granted {
+		return nil, nil
+	}
+
+	err = s.doAtomic(func() (bool, error) { return s.atomicAddUserOwnership(ownership) })
+	if err != nil {
+		// Undo the badge side of the grant so both keys stay consistent.
+		undoErr := s.doAtomic(func() (bool, error) {
+			return s.atomicRemoveBadgeOwnerships(badge.ID, badgesmodel.OwnershipList{ownership})
+		})
+		if undoErr != nil {
+			s.api.LogWarn("Cannot undo partial grant", "badgeID", badge.ID, "userID", userID, "err", undoErr)
+		}
+		return nil, err
+	}
+
+	return &ownership, nil
+}
+
+func (s *store) RenewBadge(id badgesmodel.BadgeID, userID string) (*badgesmodel.Ownership, error) {
+	badge, err := s.getBadge(id)
+	if err != nil {
+		return nil, err
+	}
+
+	types, _, err := s.getAllTypes()
+	if err != nil {
+		return nil, err
 	}
 
-	return shouldNotify, nil
+	badgeType := types.GetType(badge.Type)
+	if badgeType == nil {
+		return nil, errors.New("badge type not found")
+	}
+
+	if badge.Archived || badgeType.Archived {
+		return nil, errBadgeArchived
+	}
+
+	expiresAt := badge.GetExpiry(time.Now())
+	if expiresAt == nil {
+		return nil, errBadgeNotTimeLimited
+	}
+
+	var renewed *badgesmodel.Ownership
+	err = s.doAtomic(func() (bool, error) {
+		var done bool
+		var err error
+		renewed, done, err = s.atomicRenewUserOwnership(id, userID, expiresAt)
+		return done, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if renewed == nil {
+		return nil, errOwnershipNotFound
+	}
+
+	err = s.doAtomic(func() (bool, error) { return s.atomicReplaceBadgeOwnership(*renewed) })
+	if err != nil {
+		return nil, err
+	}
+
+	return renewed, nil
+}
+
+func (s *store) GetExpiringOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	badges, _, err := s.getAllBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	out := badgesmodel.OwnershipList{}
+	for _, b := range badges {
+		ownership, _, err := s.getBadgeOwnershipList(b.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range ownership {
+			if o.IsExpired(before) {
+				out = append(out, o)
+			}
+		}
+	}
+
+	sortByExpiry(out)
+	return out, nil
+}
+
+func (s *store) GetOwnerships(since time.Time) (badgesmodel.OwnershipList, error) {
+	badges, _, err := s.getAllBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	out := badgesmodel.OwnershipList{}
+	for _, b := range badges {
+		ownership, _, err := s.getBadgeOwnershipList(b.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range ownership {
+			if !o.Time.Before(since) {
+				out = append(out, o)
+			}
+		}
+	}
+
+	sortByGrantTime(out)
+	return out, nil
+}
+
+func (s *store) ExpireOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	expiring, err := s.GetExpiringOwnerships(before)
+	if err != nil {
+		return nil, err
+	}
+
+	userKeys := map[string]bool{}
+	badgeKeys := map[string]bool{}
+	for _, o := range expiring {
+		userKeys[getUserOwnershipKey(o.User)] = true
+		badgeKeys[getBadgeOwnershipKey(o.Badge)] = true
+	}
+
+	// The lists are filtered again when saving, so grants renewed meanwhile are
+	// kept.
+	expired := badgesmodel.OwnershipList{}
+	for key := range userKeys {
+		var removed badgesmodel.OwnershipList
+		err = s.doAtomic(func() (bool, error) {
+			var done bool
+			var err error
+			removed, done, err = s.atomicRemoveExpiredOwnerships(key, before)
+			return done, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, removed...)
+	}
+
+	for key := range badgeKeys {
+		err = s.doAtomic(func() (bool, error) {
+			_, done, err := s.atomicRemoveExpiredOwnerships(key, before)
+			return done, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sortByExpiry(expired)
+	return expired, nil
+}
+
+func sortByExpiry(l badgesmodel.OwnershipList) {
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].ExpiresAt.Before(*l[j].ExpiresAt)
+	})
+}
+
+func sortByGrantTime(l badgesmodel.OwnershipList) {
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].Time.Before(l[j].Time)
+	})
+}
+
+func (s *store) RevokeBadge(id badgesmodel.BadgeID, userID string, all bool) (int, error) {
+	_, err := s.getBadge(id)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := badgesmodel.OwnershipList{}
+	err = s.doAtomic(func() (bool, error) {
+		var done bool
+		var err error
+		removed, done, err = s.atomicRemoveUserOwnership(id, userID, all)
+		return done, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(removed) == 0 {
+		return 0, nil
+	}
+
+	err = s.doAtomic(func() (bool, error) { return s.atomicRemoveBadgeOwnerships(id, removed) })
+	if err != nil {
+		return 0, err
+	}
+
+	return len(removed), nil
+}
+
+// GetGrant looks for the grant on the ownership list of every badge, as the KV
+// store has no index by grant ID.
+func (s *store) GetGrant(grantID string) (*badgesmodel.Ownership, error) {
+	badges, _, err := s.getAllBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range badges {
+		ownership, _, err := s.getBadgeOwnershipList(b.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range ownership {
+			if o.GrantID == grantID {
+				found := o
+				return &found, nil
+			}
+		}
+	}
+
+	return nil, errGrantNotFound
+}
+
+func (s *store) ListUserBadges(userID string, opts ListOptions) ([]*badgesmodel.UserBadge, string, error) {
+	all, err := s.GetUserBadges(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listUserBadges(all, opts)
+}
+
+func (s *store) ListAllBadges(opts ListOptions) ([]*badgesmodel.AllBadgesBadge, string, error) {
+	all, err := s.GetAllBadges()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listAllBadges(all, opts)
+}
+
+func (s *store) ListBadgeOwners(badgeID badgesmodel.BadgeID, opts ListOptions) (badgesmodel.OwnershipList, string, error) {
+	_, err := s.getBadge(badgeID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	owners, _, err := s.getBadgeOwnershipList(badgeID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listOwners(owners, opts)
 }
 
 func (s *store) GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error) {
-	ownership, _, err := s.getOwnershipList()
+	ownership, _, err := s.getUserOwnershipList(userID)
 	if err != nil {
 		return nil, err
 	}
@@ -339,6 +666,18 @@ func (s *store) GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error) {
 		return nil, err
 	}
 
+	types, _, err := s.getAllTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var format *string
+	conf := s.api.GetConfig()
+	if conf != nil {
+		format = conf.TeamSettings.TeammateNameDisplay
+	}
+
+	names := map[string]string{}
 	out := []*badgesmodel.UserBadge{}
 	for _, o := range ownership {
 		if o.User == userID {
@@ -348,21 +687,19 @@ func (s *store) GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error) {
 				continue
 			}
 
-			grantedByName := "unknown"
-			u, appErr := s.api.GetUser(o.GrantedBy)
-			if appErr == nil {
-				conf := s.api.GetConfig()
-				if conf != nil {
-					format := conf.TeamSettings.TeammateNameDisplay
-					if format != nil {
-						grantedByName = u.GetDisplayName(*format)
-					}
+			grantedByName, ok := names[o.GrantedBy]
+			if !ok {
+				grantedByName = "unknown"
+				u, appErr := s.api.GetUser(o.GrantedBy)
+				if appErr == nil && format != nil {
+					grantedByName = u.GetDisplayName(*format)
 				}
+				names[o.GrantedBy] = grantedByName
 			}
 
 			typeName := "unknown"
-			t, err := s.GetType(badge.Type)
-			if err == nil {
+			t := types.GetType(badge.Type)
+			if t != nil {
 				typeName = t.Name
 			}
 
@@ -393,11 +730,105 @@ func (s *store) GetBadge(badgeID badgesmodel.BadgeID) (*badgesmodel.Badge, error
 }
 
 func (s *store) UpdateType(t *badgesmodel.BadgeTypeDefinition) error {
-	return s.doAtomic(func() (bool, error) { return s.atomicUpdateType(t) })
+	var previous *badgesmodel.BadgeTypeDefinition
+	err := s.doAtomic(func() (bool, error) {
+		var done bool
+		var err error
+		previous, done, err = s.atomicUpdateType(t)
+		return done, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.doAtomic(func() (bool, error) { return s.atomicAddTypeVersion(previous) })
 }
 
 func (s *store) UpdateBadge(b *badgesmodel.Badge) error {
-	return s.doAtomic(func() (bool, error) { return s.atomicUpdateBadge(b) })
+	var previous *badgesmodel.Badge
+	err := s.doAtomic(func() (bool, error) {
+		var done bool
+		var err error
+		previous, done, err = s.atomicUpdateBadge(b)
+		return done, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.doAtomic(func() (bool, error) { return s.atomicAddBadgeVersion(previous) })
+}
+
+func getBadgeHistoryKey(badgeID badgesmodel.BadgeID) string {
+	return KVKeyBadgeHistoryPrefix + string(badgeID)
+}
+
+func getTypeHistoryKey(tID badgesmodel.BadgeType) string {
+	return KVKeyTypeHistoryPrefix + string(tID)
+}
+
+// getBadgeVersions returns the previous versions of a badge, oldest first.
+func (s *store) getBadgeVersions(badgeID badgesmodel.BadgeID) ([]*badgesmodel.Badge, []byte, error) {
+	data, appErr := s.api.KVGet(getBadgeHistoryKey(badgeID))
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+
+	versions := []*badgesmodel.Badge{}
+	if data != nil {
+		err := json.Unmarshal(data, &versions)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return versions, data, nil
+}
+
+// getTypeVersions returns the previous versions of a type, oldest first.
+func (s *store) getTypeVersions(tID badgesmodel.BadgeType) (badgesmodel.BadgeTypeList, []byte, error) {
+	data, appErr := s.api.KVGet(getTypeHistoryKey(tID))
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+
+	versions := badgesmodel.BadgeTypeList{}
+	if data != nil {
+		err := json.Unmarshal(data, &versions)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return versions, data, nil
+}
+
+func (s *store) GetBadgeHistory(badgeID badgesmodel.BadgeID) ([]*badgesmodel.Badge, error) {
+	versions, _, err := s.getBadgeVersions(badgeID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.getBadge(badgeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return badgeHistory(versions, current), nil
+}
+
+func (s *store) GetTypeHistory(tID badgesmodel.BadgeType) (badgesmodel.BadgeTypeList, error) {
+	versions, _, err := s.getTypeVersions(tID)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := s.GetType(tID)
+	if err != nil {
+		return nil, err
+	}
+
+	return typeHistory(versions, current), nil
 }
 
 func (s *store) atomicDeleteType(tID badgesmodel.BadgeType) (bool, error) {
@@ -417,7 +848,10 @@ func (s *store) atomicDeleteType(tID badgesmodel.BadgeType) (bool, error) {
 }
 
 func (s *store) DeleteType(tID badgesmodel.BadgeType) error {
-	s.doAtomic(func() (bool, error) { return s.atomicDeleteType(tID) })
+	err := s.doAtomic(func() (bool, error) { return s.atomicDeleteType(tID) })
+	if err != nil {
+		return err
+	}
 
 	bb, _, err := s.getAllBadges()
 	if err != nil {
@@ -443,11 +877,29 @@ func (s *store) DeleteBadge(bID badgesmodel.BadgeID) error {
 		return err
 	}
 
-	err = s.doAtomic(func() (bool, error) { return s.atomicRemoveBadgeFromOwnership(bID) })
+	ownership, _, err := s.getBadgeOwnershipList(bID)
 	if err != nil {
 		return err
 	}
 
+	cleaned := map[string]bool{}
+	for _, o := range ownership {
+		if cleaned[o.User] {
+			continue
+		}
+		userID := o.User
+		err = s.doAtomic(func() (bool, error) { return s.atomicRemoveBadgeFromUserOwnership(userID, bID) })
+		if err != nil {
+			return err
+		}
+		cleaned[userID] = true
+	}
+
+	appErr := s.api.KVDelete(getBadgeOwnershipKey(bID))
+	if appErr != nil {
+		return appErr
+	}
+
 	return nil
 }
 
@@ -500,12 +952,17 @@ func (s *store) GetChannelSubscriptions(cID string) ([]*badgesmodel.BadgeTypeDef
 		return nil, err
 	}
 
+	types, _, err := s.getAllTypes()
+	if err != nil {
+		return nil, err
+	}
+
 	out := []*badgesmodel.BadgeTypeDefinition{}
 	for _, sub := range subs {
 		if sub.ChannelID == cID {
-			t, err := s.GetType(sub.TypeID)
-			if err != nil {
-				s.api.LogDebug("cannot get type", "err", err)
+			t := types.GetType(sub.TypeID)
+			if t == nil {
+				s.api.LogDebug("cannot get type", "typeID", sub.TypeID)
 				continue
 			}
 			out = append(out, t)
@@ -530,17 +987,224 @@ func (s *store) getBadgeUsers(badgeID badgesmodel.BadgeID) (badgesmodel.Ownershi
 		return nil, errBadgeNotFound
 	}
 
-	ownership, _, err := s.getOwnershipList()
+	ownership, _, err := s.getBadgeOwnershipList(badgeID)
 	if err != nil {
 		return nil, err
 	}
 
-	out := badgesmodel.OwnershipList{}
+	return ownership, nil
+}
+
+func (s *store) Dump() (*StoreDump, error) {
+	dump := &StoreDump{Ownerships: badgesmodel.OwnershipList{}}
+	var err error
+
+	dump.Types, _, err = s.getAllTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	dump.Badges, _, err = s.getAllBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	dump.Subscriptions, _, err = s.getAllSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every ownership key is read, not only the ones of existing badges, so the
+	// dump also has the rows left behind by failed deletes.
+	keys, err := s.listKeys(KVKeyUserOwnershipPrefix, KVKeyBadgeOwnershipPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	type ownershipKey struct {
+		user      string
+		badge     badgesmodel.BadgeID
+		grantedBy string
+		time      int64
+	}
+	seen := map[ownershipKey]bool{}
+	for _, key := range keys {
+		ownership, _, err := s.getOwnershipList(key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range ownership {
+			k := ownershipKey{o.User, o.Badge, o.GrantedBy, o.Time.UnixNano()}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			dump.Ownerships = append(dump.Ownerships, o)
+		}
+	}
+
+	sort.SliceStable(dump.Ownerships, func(i, j int) bool { return dump.Ownerships[i].Time.Before(dump.Ownerships[j].Time) })
+
+	return dump, nil
+}
+
+func (s *store) listKeys(prefixes ...string) ([]string, error) {
+	return listKVKeys(s.api, prefixes...)
+}
+
+// listKVKeys returns every plugin KV key starting with any of the prefixes.
+func listKVKeys(api plugin.API, prefixes ...string) ([]string, error) {
+	const perPage = 1000
+
+	out := []string{}
+	for page := 0; ; page++ {
+		keys, appErr := api.KVList(page, perPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+
+		for _, key := range keys {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(key, prefix) {
+					out = append(out, key)
+					break
+				}
+			}
+		}
+
+		if len(keys) < perPage {
+			return out, nil
+		}
+	}
+}
+
+// withGrantIDs returns a copy of the list where every grant has an ID, giving a
+// new one to grants made before grant IDs existed.
+func withGrantIDs(l badgesmodel.OwnershipList) badgesmodel.OwnershipList {
+	out := append(badgesmodel.OwnershipList{}, l...)
+	for i := range out {
+		if out[i].GrantID == "" {
+			out[i].GrantID = model.NewId()
+		}
+	}
+	return out
+}
+
+func (s *store) RemoveOrphans(badges []badgesmodel.BadgeID, ownerships badgesmodel.OwnershipList, subscriptions []badgesmodel.Subscription) error {
+	byKey := map[string]badgesmodel.OwnershipList{}
+	for _, o := range ownerships {
+		userKey := getUserOwnershipKey(o.User)
+		badgeKey := getBadgeOwnershipKey(o.Badge)
+		byKey[userKey] = append(byKey[userKey], o)
+		byKey[badgeKey] = append(byKey[badgeKey], o)
+	}
+
+	for key, toRemove := range byKey {
+		key, toRemove := key, toRemove
+		err := s.doAtomic(func() (bool, error) { return s.atomicRemoveOwnerships(key, toRemove) })
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, bID := range badges {
+		err := s.DeleteBadge(bID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range subscriptions {
+		toRemove := sub
+		err := s.doAtomic(func() (bool, error) { return s.atomicRemoveSubscription(toRemove) })
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore replaces the content of the store with the dump. Other plugin keys,
+// like the schema version, are left untouched.
+func (s *store) Restore(dump *StoreDump) error {
+	keys, err := s.listKeys(KVKeyUserOwnershipPrefix, KVKeyBadgeOwnershipPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		appErr := s.api.KVDelete(key)
+		if appErr != nil {
+			return appErr
+		}
+	}
+
+	set := func(key string, value interface{}) error {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		appErr := s.api.KVSet(key, data)
+		if appErr != nil {
+			return appErr
+		}
+
+		return nil
+	}
+
+	types := dump.Types
+	if types == nil {
+		types = badgesmodel.BadgeTypeList{}
+	}
+	err = set(KVKeyTypes, types)
+	if err != nil {
+		return err
+	}
+
+	badges := dump.Badges
+	if badges == nil {
+		badges = []*badgesmodel.Badge{}
+	}
+	err = set(KVKeyBadges, badges)
+	if err != nil {
+		return err
+	}
+
+	subs := dump.Subscriptions
+	if subs == nil {
+		subs = []badgesmodel.Subscription{}
+	}
+	err = set(KVKeySubscriptions, subs)
+	if err != nil {
+		return err
+	}
+
+	ownership := withGrantIDs(dump.Ownerships)
+	sort.SliceStable(ownership, func(i, j int) bool { return ownership[i].Time.Before(ownership[j].Time) })
+
+	byUser := map[string]badgesmodel.OwnershipList{}
+	byBadge := map[badgesmodel.BadgeID]badgesmodel.OwnershipList{}
 	for _, o := range ownership {
-		if o.Badge == badgeID {
-			out = append(out, o)
+		byUser[o.User] = append(byUser[o.User], o)
+		byBadge[o.Badge] = append(byBadge[o.Badge], o)
+	}
+
+	for badgeID, list := range byBadge {
+		err = set(getBadgeOwnershipKey(badgeID), list)
+		if err != nil {
+			return err
 		}
 	}
 
-	return out, nil
+	for userID, list := range byUser {
+		err = set(getUserOwnershipKey(userID), list)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
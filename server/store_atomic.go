@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"time"
 
 	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
 )
@@ -58,8 +59,8 @@ func (s *store) atomicRemoveBadge(bID badgesmodel.BadgeID) (bool, error) {
 	return s.compareAndSet(KVKeyBadges, data, bb)
 }
 
-func (s *store) atomicRemoveBadgeFromOwnership(bID badgesmodel.BadgeID) (bool, error) {
-	ownership, data, err := s.getOwnershipList()
+func (s *store) atomicRemoveBadgeFromUserOwnership(userID string, bID badgesmodel.BadgeID) (bool, error) {
+	ownership, data, err := s.getUserOwnershipList(userID)
 	if err != nil {
 		return false, err
 	}
@@ -71,11 +72,15 @@ func (s *store) atomicRemoveBadgeFromOwnership(bID badgesmodel.BadgeID) (bool, e
 		}
 	}
 
+	if len(toDelete) == 0 {
+		return true, nil
+	}
+
 	for _, index := range toDelete {
 		ownership = append(ownership[:index], ownership[index+1:]...)
 	}
 
-	return s.compareAndSet(KVKeyOwnership, data, ownership)
+	return s.compareAndSet(getUserOwnershipKey(userID), data, ownership)
 }
 
 func (s *store) atomicAddBadge(b *badgesmodel.Badge) (bool, error) {
@@ -101,7 +106,7 @@ func (s *store) atomicAddType(t *badgesmodel.BadgeTypeDefinition) (bool, error)
 }
 
 func (s *store) atomicAddBadgeToOwnership(o badgesmodel.Ownership, isMultiple bool) (shouldNotify bool, done bool, err error) {
-	ownership, data, err := s.getOwnershipList()
+	ownership, data, err := s.getBadgeOwnershipList(o.Badge)
 	if err != nil {
 		return false, false, err
 	}
@@ -112,51 +117,218 @@ func (s *store) atomicAddBadgeToOwnership(o badgesmodel.Ownership, isMultiple bo
 
 	ownership = append(ownership, o)
 
-	done, err = s.compareAndSet(KVKeyOwnership, data, ownership)
+	done, err = s.compareAndSet(getBadgeOwnershipKey(o.Badge), data, ownership)
 	return done, done, err
 }
 
-func (s *store) atomicUpdateType(t *badgesmodel.BadgeTypeDefinition) (bool, error) {
-	tt, data, err := s.getAllTypes()
+func (s *store) atomicAddUserOwnership(o badgesmodel.Ownership) (bool, error) {
+	ownership, data, err := s.getUserOwnershipList(o.User)
 	if err != nil {
 		return false, err
 	}
 
-	found := false
+	ownership = append(ownership, o)
+
+	return s.compareAndSet(getUserOwnershipKey(o.User), data, ownership)
+}
+
+func (s *store) atomicRemoveUserOwnership(bID badgesmodel.BadgeID, userID string, all bool) (removed badgesmodel.OwnershipList, done bool, err error) {
+	ownership, data, err := s.getUserOwnershipList(userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	removed = badgesmodel.OwnershipList{}
+	// Ownership is stored in grant order, so walking backwards removes the latest grant first.
+	for i := len(ownership) - 1; i >= 0; i-- {
+		o := ownership[i]
+		if o.Badge != bID {
+			continue
+		}
+		ownership = append(ownership[:i], ownership[i+1:]...)
+		removed = append(removed, o)
+		if !all {
+			break
+		}
+	}
+
+	if len(removed) == 0 {
+		return removed, true, nil
+	}
+
+	done, err = s.compareAndSet(getUserOwnershipKey(userID), data, ownership)
+	return removed, done, err
+}
+
+func (s *store) atomicRemoveBadgeOwnerships(bID badgesmodel.BadgeID, toRemove badgesmodel.OwnershipList) (bool, error) {
+	return s.atomicRemoveOwnerships(getBadgeOwnershipKey(bID), toRemove)
+}
+
+// atomicRemoveOwnerships removes the given grants from the ownership list on
+// key, which is either the list of a user or the list of a badge.
+func (s *store) atomicRemoveOwnerships(key string, toRemove badgesmodel.OwnershipList) (bool, error) {
+	ownership, data, err := s.getOwnershipList(key)
+	if err != nil {
+		return false, err
+	}
+
+	out := badgesmodel.OwnershipList{}
+	for _, o := range ownership {
+		if !toRemove.Contains(o) {
+			out = append(out, o)
+		}
+	}
+
+	if len(out) == len(ownership) {
+		return true, nil
+	}
+
+	return s.compareAndSet(key, data, out)
+}
+
+// atomicRenewUserOwnership sets the expiry of the latest grant of the badge to
+// the user, and returns the renewed grant.
+func (s *store) atomicRenewUserOwnership(bID badgesmodel.BadgeID, userID string, expiresAt *time.Time) (*badgesmodel.Ownership, bool, error) {
+	ownership, data, err := s.getUserOwnershipList(userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for i := len(ownership) - 1; i >= 0; i-- {
+		if ownership[i].Badge != bID {
+			continue
+		}
+		ownership[i].ExpiresAt = expiresAt
+		renewed := ownership[i]
+
+		done, err := s.compareAndSet(getUserOwnershipKey(userID), data, ownership)
+		return &renewed, done, err
+	}
+
+	return nil, true, nil
+}
+
+func (s *store) atomicReplaceBadgeOwnership(o badgesmodel.Ownership) (bool, error) {
+	ownership, data, err := s.getBadgeOwnershipList(o.Badge)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range ownership {
+		if ownership[i].IsSameGrant(o) {
+			ownership[i] = o
+			return s.compareAndSet(getBadgeOwnershipKey(o.Badge), data, ownership)
+		}
+	}
+
+	return true, nil
+}
+
+func (s *store) atomicRemoveExpiredOwnerships(key string, before time.Time) (removed badgesmodel.OwnershipList, done bool, err error) {
+	ownership, data, err := s.getOwnershipList(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	kept := badgesmodel.OwnershipList{}
+	removed = badgesmodel.OwnershipList{}
+	for _, o := range ownership {
+		if o.IsExpired(before) {
+			removed = append(removed, o)
+			continue
+		}
+		kept = append(kept, o)
+	}
+
+	if len(removed) == 0 {
+		return removed, true, nil
+	}
+
+	done, err = s.compareAndSet(key, data, kept)
+	return removed, done, err
+}
+
+// atomicUpdateType returns the version of the type it replaced.
+func (s *store) atomicUpdateType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeTypeDefinition, bool, error) {
+	tt, data, err := s.getAllTypes()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var previous *badgesmodel.BadgeTypeDefinition
 	for i, tOld := range tt {
 		if tOld.ID == t.ID {
+			previous = tOld
+			setNextTypeVersion(t, previous)
 			tt[i] = t
-			found = true
 			break
 		}
 	}
 
-	if !found {
-		return false, errors.New("not found")
+	if previous == nil {
+		return nil, false, errors.New("not found")
 	}
 
-	return s.compareAndSet(KVKeyTypes, data, tt)
+	done, err := s.compareAndSet(KVKeyTypes, data, tt)
+	return previous, done, err
 }
 
-func (s *store) atomicUpdateBadge(b *badgesmodel.Badge) (bool, error) {
+// atomicUpdateBadge returns the version of the badge it replaced.
+func (s *store) atomicUpdateBadge(b *badgesmodel.Badge) (*badgesmodel.Badge, bool, error) {
 	bb, data, err := s.getAllBadges()
 	if err != nil {
-		return false, err
+		return nil, false, err
 	}
 
-	found := false
+	var previous *badgesmodel.Badge
 	for i, bOld := range bb {
 		if bOld.ID == b.ID {
+			previous = bOld
+			setNextBadgeVersion(b, previous)
 			bb[i] = b
-			found = true
 			break
 		}
 	}
-	if !found {
-		return false, errors.New("not found")
+	if previous == nil {
+		return nil, false, errors.New("not found")
 	}
 
-	return s.compareAndSet(KVKeyBadges, data, bb)
+	done, err := s.compareAndSet(KVKeyBadges, data, bb)
+	return previous, done, err
+}
+
+func (s *store) atomicAddTypeVersion(t *badgesmodel.BadgeTypeDefinition) (bool, error) {
+	versions, data, err := s.getTypeVersions(t.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// Versions left over from restoring an older state are replaced.
+	kept := badgesmodel.BadgeTypeList{}
+	for _, v := range versions {
+		if v.GetVersion() < t.GetVersion() {
+			kept = append(kept, v)
+		}
+	}
+
+	return s.compareAndSet(getTypeHistoryKey(t.ID), data, append(kept, t))
+}
+
+func (s *store) atomicAddBadgeVersion(b *badgesmodel.Badge) (bool, error) {
+	versions, data, err := s.getBadgeVersions(b.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// Versions left over from restoring an older state are replaced.
+	kept := []*badgesmodel.Badge{}
+	for _, v := range versions {
+		if v.GetVersion() < b.GetVersion() {
+			kept = append(kept, v)
+		}
+	}
+
+	return s.compareAndSet(getBadgeHistoryKey(b.ID), data, append(kept, b))
 }
 
 func (s *store) atomicAddSubscription(toAdd badgesmodel.Subscription) (bool, error) {
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// auditStore records every mutation on the audit log, in the name of actorID. It
+// is built for each request with Plugin.storeAs. Any new mutating Store method
+// must be overridden here, or its changes will not be audited.
+type auditStore struct {
+	Store
+	api     plugin.API
+	actorID string
+}
+
+func NewAuditStore(s Store, api plugin.API, actorID string) Store {
+	return &auditStore{
+		Store:   s,
+		api:     api,
+		actorID: actorID,
+	}
+}
+
+// record never fails the mutation: the change is already done, so a failure is
+// logged as an error instead.
+func (s *auditStore) record(entry *badgesmodel.AuditEntry, before, after interface{}) {
+	var err error
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			s.api.LogWarn("Cannot encode audit entry", "action", entry.Action, "err", err)
+		}
+	}
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			s.api.LogWarn("Cannot encode audit entry", "action", entry.Action, "err", err)
+		}
+	}
+
+	err = appendAuditEntry(s.api, entry)
+	if err != nil {
+		s.api.LogError("Cannot write audit entry", "action", entry.Action, "target", entry.TargetID, "err", err)
+	}
+}
+
+func (s *auditStore) AddType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeTypeDefinition, error) {
+	added, err := s.Store.AddType(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionCreateType, string(added.ID)), nil, added)
+	return added, nil
+}
+
+func (s *auditStore) UpdateType(t *badgesmodel.BadgeTypeDefinition) error {
+	before, _ := s.Store.GetType(t.ID)
+	err := s.Store.UpdateType(t)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionUpdateType, string(t.ID)), before, t)
+	return nil
+}
+
+func (s *auditStore) DeleteType(tID badgesmodel.BadgeType) error {
+	before, _ := s.Store.GetType(tID)
+	err := s.Store.DeleteType(tID)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionDeleteType, string(tID)), before, nil)
+	return nil
+}
+
+func (s *auditStore) AddBadge(b *badgesmodel.Badge) (*badgesmodel.Badge, error) {
+	added, err := s.Store.AddBadge(b)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionCreateBadge, string(added.ID)), nil, added)
+	return added, nil
+}
+
+func (s *auditStore) UpdateBadge(b *badgesmodel.Badge) error {
+	before, _ := s.Store.GetBadge(b.ID)
+	err := s.Store.UpdateBadge(b)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionUpdateBadge, string(b.ID)), before, b)
+	return nil
+}
+
+func (s *auditStore) DeleteBadge(bID badgesmodel.BadgeID) error {
+	before, _ := s.Store.GetBadge(bID)
+	err := s.Store.DeleteBadge(bID)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionDeleteBadge, string(bID)), before, nil)
+	return nil
+}
+
+func (s *auditStore) GrantBadge(badgeID badgesmodel.BadgeID, userID string, grantedBy string, reason string) (*badgesmodel.Ownership, error) {
+	granted, err := s.Store.GrantBadge(badgeID, userID, grantedBy, reason)
+	if err != nil || granted == nil {
+		return granted, err
+	}
+
+	entry := newAuditEntry(s.actorID, badgesmodel.AuditActionGrant, string(badgeID))
+	entry.UserID = userID
+	s.record(entry, nil, map[string]string{"grant_id": granted.GrantID, "granted_by": grantedBy, "reason": reason})
+	return granted, nil
+}
+
+func (s *auditStore) RevokeBadge(badgeID badgesmodel.BadgeID, userID string, all bool) (int, error) {
+	removed, err := s.Store.RevokeBadge(badgeID, userID, all)
+	if err != nil || removed == 0 {
+		return removed, err
+	}
+
+	entry := newAuditEntry(s.actorID, badgesmodel.AuditActionRevoke, string(badgeID))
+	entry.UserID = userID
+	s.record(entry, map[string]int{"grants": removed}, nil)
+	return removed, nil
+}
+
+func (s *auditStore) RenewBadge(badgeID badgesmodel.BadgeID, userID string) (*badgesmodel.Ownership, error) {
+	renewed, err := s.Store.RenewBadge(badgeID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := newAuditEntry(s.actorID, badgesmodel.AuditActionRenew, string(badgeID))
+	entry.UserID = userID
+	s.record(entry, nil, map[string]interface{}{"expires_at": renewed.ExpiresAt})
+	return renewed, nil
+}
+
+// ExpireOwnerships records one entry per expired grant, so the log of a user
+// tells when each of their badges expired.
+func (s *auditStore) ExpireOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	expired, err := s.Store.ExpireOwnerships(before)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range expired {
+		entry := newAuditEntry(s.actorID, badgesmodel.AuditActionExpire, string(o.Badge))
+		entry.UserID = o.User
+		s.record(entry, map[string]interface{}{"granted_at": o.Time, "expires_at": o.ExpiresAt}, nil)
+	}
+	return expired, nil
+}
+
+func (s *auditStore) AddSubscription(tID badgesmodel.BadgeType, cID string) error {
+	err := s.Store.AddSubscription(tID, cID)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionAddSubscription, string(tID)), nil, map[string]string{"channel_id": cID})
+	return nil
+}
+
+func (s *auditStore) RemoveSubscriptions(tID badgesmodel.BadgeType, cID string) error {
+	err := s.Store.RemoveSubscriptions(tID, cID)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionRemoveSubscription, string(tID)), map[string]string{"channel_id": cID}, nil)
+	return nil
+}
+
+// EnsureBadges only records the badges and type it created, so plugins ensuring
+// their badges on every start do not fill the log.
+func (s *auditStore) EnsureBadges(badges []*badgesmodel.Badge, pluginID, botID string) ([]*badgesmodel.Badge, error) {
+	typesBefore, err := s.Store.GetRawTypes()
+	if err != nil {
+		return nil, err
+	}
+	badgesBefore, err := s.Store.GetRawBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	ensured, err := s.Store.EnsureBadges(badges, pluginID, botID)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := s.Store.GetRawTypes()
+	if err != nil {
+		s.api.LogWarn("Cannot audit ensured badges", "err", err)
+		return ensured, nil
+	}
+	for _, t := range types {
+		if typesBefore.GetType(t.ID) == nil {
+			s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionCreateType, string(t.ID)), nil, t)
+		}
+	}
+
+	existed := map[badgesmodel.BadgeID]bool{}
+	for _, b := range badgesBefore {
+		existed[b.ID] = true
+	}
+	for _, b := range ensured {
+		if !existed[b.ID] {
+			s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionCreateBadge, string(b.ID)), nil, b)
+		}
+	}
+
+	return ensured, nil
+}
+
+// RemoveOrphans records each removal on its own, like the operations it stands
+// for.
+func (s *auditStore) RemoveOrphans(badges []badgesmodel.BadgeID, ownerships badgesmodel.OwnershipList, subscriptions []badgesmodel.Subscription) error {
+	before := map[badgesmodel.BadgeID]*badgesmodel.Badge{}
+	for _, bID := range badges {
+		before[bID], _ = s.Store.GetBadge(bID)
+	}
+
+	err := s.Store.RemoveOrphans(badges, ownerships, subscriptions)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range ownerships {
+		entry := newAuditEntry(s.actorID, badgesmodel.AuditActionRevoke, string(o.Badge))
+		entry.UserID = o.User
+		s.record(entry, map[string]interface{}{"grant_id": o.GrantID, "granted_at": o.Time}, nil)
+	}
+	for _, bID := range badges {
+		s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionDeleteBadge, string(bID)), before[bID], nil)
+	}
+	for _, sub := range subscriptions {
+		s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionRemoveSubscription, string(sub.TypeID)), map[string]string{"channel_id": sub.ChannelID}, nil)
+	}
+	return nil
+}
+
+func (s *auditStore) Restore(dump *StoreDump) error {
+	err := s.Store.Restore(dump)
+	if err != nil {
+		return err
+	}
+
+	s.record(newAuditEntry(s.actorID, badgesmodel.AuditActionRestore, ""), nil, map[string]int{
+		"types":         len(dump.Types),
+		"badges":        len(dump.Badges),
+		"ownerships":    len(dump.Ownerships),
+		"subscriptions": len(dump.Subscriptions),
+	})
+	return nil
+}
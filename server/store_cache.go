@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	// cacheGenerationCheckInterval is how long a node trusts its cache before
+	// checking whether another node wrote to the store.
+	cacheGenerationCheckInterval = 2 * time.Second
+	// cacheTTL bounds how stale derived data (like display names) can get.
+	cacheTTL = 5 * time.Minute
+)
+
+// cachedStore keeps the decoded badges, types and subscriptions, and the views
+// built from them, in memory. Any write clears the whole cache.
+//
+// The server API this plugin builds against has no plugin cluster events, so
+// nodes share a generation token in the KV store: every write sets a new token,
+// and every node drops its cache when it sees a token it did not write.
+type cachedStore struct {
+	store Store
+	api   plugin.API
+
+	mu           sync.Mutex
+	generation   string
+	lastCheck    time.Time
+	loadedAt     time.Time
+	badges       []*badgesmodel.Badge
+	types        badgesmodel.BadgeTypeList
+	allBadges    []*badgesmodel.AllBadgesBadge
+	ownerships   badgesmodel.OwnershipList
+	userBadges   map[string][]*badgesmodel.UserBadge
+	badgeDetails map[badgesmodel.BadgeID]*badgesmodel.BadgeDetails
+	typeSubs     map[badgesmodel.BadgeType][]string
+	channelSubs  map[string][]*badgesmodel.BadgeTypeDefinition
+}
+
+func NewCachedStore(s Store, api plugin.API) Store {
+	c := &cachedStore{
+		store: s,
+		api:   api,
+	}
+	c.clear()
+	return c
+}
+
+func (c *cachedStore) clear() {
+	c.loadedAt = time.Now()
+	c.badges = nil
+	c.types = nil
+	c.allBadges = nil
+	c.ownerships = nil
+	c.userBadges = map[string][]*badgesmodel.UserBadge{}
+	c.badgeDetails = map[badgesmodel.BadgeID]*badgesmodel.BadgeDetails{}
+	c.typeSubs = map[badgesmodel.BadgeType][]string{}
+	c.channelSubs = map[string][]*badgesmodel.BadgeTypeDefinition{}
+}
+
+// refresh must be called with the lock held. It drops the cache if it expired or
+// if another node changed the store since the last check.
+func (c *cachedStore) refresh() {
+	now := time.Now()
+	if now.Sub(c.loadedAt) > cacheTTL {
+		c.clear()
+	}
+
+	if now.Sub(c.lastCheck) < cacheGenerationCheckInterval {
+		return
+	}
+	c.lastCheck = now
+
+	data, appErr := c.api.KVGet(KVKeyCacheGeneration)
+	if appErr != nil {
+		c.api.LogDebug("Cannot check cache generation", "err", appErr)
+		c.clear()
+		return
+	}
+
+	if string(data) != c.generation {
+		c.generation = string(data)
+		c.clear()
+	}
+}
+
+// invalidate drops the local cache and tells the other nodes to do the same.
+func (c *cachedStore) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clear()
+	c.generation = model.NewId()
+	c.lastCheck = time.Now()
+	appErr := c.api.KVSet(KVKeyCacheGeneration, []byte(c.generation))
+	if appErr != nil {
+		c.api.LogWarn("Cannot publish cache invalidation", "err", appErr)
+	}
+}
+
+// cloneJSON deep copies cached values, so callers can modify what they get
+// without corrupting the cache.
+func cloneJSON(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func (c *cachedStore) getBadges() ([]*badgesmodel.Badge, error) {
+	c.refresh()
+	if c.badges == nil {
+		badges, err := c.store.GetRawBadges()
+		if err != nil {
+			return nil, err
+		}
+		c.badges = badges
+	}
+
+	return c.badges, nil
+}
+
+func (c *cachedStore) getTypes() (badgesmodel.BadgeTypeList, error) {
+	c.refresh()
+	if c.types == nil {
+		types, err := c.store.GetRawTypes()
+		if err != nil {
+			return nil, err
+		}
+		c.types = types
+	}
+
+	return c.types, nil
+}
+
+func (c *cachedStore) GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	badges, ok := c.userBadges[userID]
+	if !ok {
+		var err error
+		badges, err = c.store.GetUserBadges(userID)
+		if err != nil {
+			return nil, err
+		}
+		c.userBadges[userID] = badges
+	}
+
+	out := []*badgesmodel.UserBadge{}
+	return out, cloneJSON(badges, &out)
+}
+
+func (c *cachedStore) GetAllBadges() ([]*badgesmodel.AllBadgesBadge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	if c.allBadges == nil {
+		badges, err := c.store.GetAllBadges()
+		if err != nil {
+			return nil, err
+		}
+		c.allBadges = badges
+	}
+
+	out := []*badgesmodel.AllBadgesBadge{}
+	return out, cloneJSON(c.allBadges, &out)
+}
+
+// GetOwnerships keeps every grant, and filters them on each call, so the
+// leaderboards of any time window share the cache.
+func (c *cachedStore) GetOwnerships(since time.Time) (badgesmodel.OwnershipList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	if c.ownerships == nil {
+		ownerships, err := c.store.GetOwnerships(time.Time{})
+		if err != nil {
+			return nil, err
+		}
+		c.ownerships = ownerships
+	}
+
+	found := badgesmodel.OwnershipList{}
+	for _, o := range c.ownerships {
+		if !o.Time.Before(since) {
+			found = append(found, o)
+		}
+	}
+
+	out := badgesmodel.OwnershipList{}
+	return out, cloneJSON(found, &out)
+}
+
+func (c *cachedStore) GetBadgeDetails(badgeID badgesmodel.BadgeID) (*badgesmodel.BadgeDetails, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	details, ok := c.badgeDetails[badgeID]
+	if !ok {
+		var err error
+		details, err = c.store.GetBadgeDetails(badgeID)
+		if err != nil {
+			return nil, err
+		}
+		c.badgeDetails[badgeID] = details
+	}
+
+	out := &badgesmodel.BadgeDetails{}
+	return out, cloneJSON(details, out)
+}
+
+func (c *cachedStore) GetRawBadges() ([]*badgesmodel.Badge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	badges, err := c.getBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*badgesmodel.Badge{}
+	return out, cloneJSON(badges, &out)
+}
+
+func (c *cachedStore) GetRawTypes() (badgesmodel.BadgeTypeList, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	types, err := c.getTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := badgesmodel.BadgeTypeList{}
+	return out, cloneJSON(types, &out)
+}
+
+func (c *cachedStore) GetType(tID badgesmodel.BadgeType) (*badgesmodel.BadgeTypeDefinition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	types, err := c.getTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	t := types.GetType(tID)
+	if t == nil {
+		return nil, errors.New("not found")
+	}
+
+	out := &badgesmodel.BadgeTypeDefinition{}
+	return out, cloneJSON(t, out)
+}
+
+func (c *cachedStore) GetBadge(badgeID badgesmodel.BadgeID) (*badgesmodel.Badge, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	badges, err := c.getBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range badges {
+		if b.ID == badgeID {
+			out := &badgesmodel.Badge{}
+			return out, cloneJSON(b, out)
+		}
+	}
+
+	return nil, errBadgeNotFound
+}
+
+func (c *cachedStore) GetTypeSubscriptions(tID badgesmodel.BadgeType) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	subs, ok := c.typeSubs[tID]
+	if !ok {
+		var err error
+		subs, err = c.store.GetTypeSubscriptions(tID)
+		if err != nil {
+			return nil, err
+		}
+		c.typeSubs[tID] = subs
+	}
+
+	return append([]string{}, subs...), nil
+}
+
+func (c *cachedStore) GetChannelSubscriptions(cID string) ([]*badgesmodel.BadgeTypeDefinition, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+	types, ok := c.channelSubs[cID]
+	if !ok {
+		var err error
+		types, err = c.store.GetChannelSubscriptions(cID)
+		if err != nil {
+			return nil, err
+		}
+		c.channelSubs[cID] = types
+	}
+
+	out := []*badgesmodel.BadgeTypeDefinition{}
+	return out, cloneJSON(types, &out)
+}
+
+func (c *cachedStore) AddBadge(badge *badgesmodel.Badge) (*badgesmodel.Badge, error) {
+	defer c.invalidate()
+	return c.store.AddBadge(badge)
+}
+
+func (c *cachedStore) GrantBadge(badgeID badgesmodel.BadgeID, userID string, grantedBy string, reason string) (*badgesmodel.Ownership, error) {
+	defer c.invalidate()
+	return c.store.GrantBadge(badgeID, userID, grantedBy, reason)
+}
+
+func (c *cachedStore) RevokeBadge(badgeID badgesmodel.BadgeID, userID string, all bool) (int, error) {
+	defer c.invalidate()
+	return c.store.RevokeBadge(badgeID, userID, all)
+}
+
+// The pages are not cached, as each set of options would need its own entry.
+// Handlers use the cached full views when no listing options are given.
+func (c *cachedStore) ListUserBadges(userID string, opts ListOptions) ([]*badgesmodel.UserBadge, string, error) {
+	return c.store.ListUserBadges(userID, opts)
+}
+
+func (c *cachedStore) ListAllBadges(opts ListOptions) ([]*badgesmodel.AllBadgesBadge, string, error) {
+	return c.store.ListAllBadges(opts)
+}
+
+func (c *cachedStore) ListBadgeOwners(badgeID badgesmodel.BadgeID, opts ListOptions) (badgesmodel.OwnershipList, string, error) {
+	return c.store.ListBadgeOwners(badgeID, opts)
+}
+
+func (c *cachedStore) GetGrant(grantID string) (*badgesmodel.Ownership, error) {
+	return c.store.GetGrant(grantID)
+}
+
+func (c *cachedStore) RenewBadge(badgeID badgesmodel.BadgeID, userID string) (*badgesmodel.Ownership, error) {
+	defer c.invalidate()
+	return c.store.RenewBadge(badgeID, userID)
+}
+
+func (c *cachedStore) GetExpiringOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	return c.store.GetExpiringOwnerships(before)
+}
+
+func (c *cachedStore) ExpireOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	defer c.invalidate()
+	return c.store.ExpireOwnerships(before)
+}
+
+func (c *cachedStore) AddType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeTypeDefinition, error) {
+	defer c.invalidate()
+	return c.store.AddType(t)
+}
+
+func (c *cachedStore) UpdateType(t *badgesmodel.BadgeTypeDefinition) error {
+	defer c.invalidate()
+	return c.store.UpdateType(t)
+}
+
+func (c *cachedStore) UpdateBadge(b *badgesmodel.Badge) error {
+	defer c.invalidate()
+	return c.store.UpdateBadge(b)
+}
+
+func (c *cachedStore) DeleteType(tID badgesmodel.BadgeType) error {
+	defer c.invalidate()
+	return c.store.DeleteType(tID)
+}
+
+func (c *cachedStore) DeleteBadge(bID badgesmodel.BadgeID) error {
+	defer c.invalidate()
+	return c.store.DeleteBadge(bID)
+}
+
+func (c *cachedStore) AddSubscription(tID badgesmodel.BadgeType, cID string) error {
+	defer c.invalidate()
+	return c.store.AddSubscription(tID, cID)
+}
+
+func (c *cachedStore) RemoveSubscriptions(tID badgesmodel.BadgeType, cID string) error {
+	defer c.invalidate()
+	return c.store.RemoveSubscriptions(tID, cID)
+}
+
+func (c *cachedStore) EnsureBadges(badges []*badgesmodel.Badge, pluginID, botID string) ([]*badgesmodel.Badge, error) {
+	defer c.invalidate()
+	return c.store.EnsureBadges(badges, pluginID, botID)
+}
+
+func (c *cachedStore) GetBadgeHistory(badgeID badgesmodel.BadgeID) ([]*badgesmodel.Badge, error) {
+	return c.store.GetBadgeHistory(badgeID)
+}
+
+func (c *cachedStore) GetTypeHistory(tID badgesmodel.BadgeType) (badgesmodel.BadgeTypeList, error) {
+	return c.store.GetTypeHistory(tID)
+}
+
+func (c *cachedStore) Dump() (*StoreDump, error) {
+	return c.store.Dump()
+}
+
+func (c *cachedStore) RemoveOrphans(badges []badgesmodel.BadgeID, ownerships badgesmodel.OwnershipList, subscriptions []badgesmodel.Subscription) error {
+	defer c.invalidate()
+	return c.store.RemoveOrphans(badges, ownerships, subscriptions)
+}
+
+func (c *cachedStore) Restore(dump *StoreDump) error {
+	defer c.invalidate()
+	return c.store.Restore(dump)
+}
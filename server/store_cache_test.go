@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedStore(t *testing.T) {
+	api := newFakeAPI()
+	raw := NewStore(api)
+	node1 := NewCachedStore(raw, api).(*cachedStore)
+	node2 := NewCachedStore(raw, api).(*cachedStore)
+
+	badgeType := addTestType(t, node1)
+	badge := addTestBadge(t, node1, badgeType.ID, false)
+
+	t.Run("writes on another node are seen after the generation check", func(t *testing.T) {
+		cached, err := node2.GetBadge(badge.ID)
+		require.NoError(t, err)
+		require.Equal(t, badge.Name, cached.Name)
+
+		badge.Name = "renamed"
+		require.NoError(t, node1.UpdateBadge(badge))
+
+		cached, err = node2.GetBadge(badge.ID)
+		require.NoError(t, err)
+		assert.NotEqual(t, "renamed", cached.Name, "the generation is not checked on every read")
+
+		node2.lastCheck = time.Now().Add(-cacheGenerationCheckInterval)
+		cached, err = node2.GetBadge(badge.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", cached.Name)
+
+		cached, err = node1.GetBadge(badge.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", cached.Name, "the writing node sees its own writes at once")
+	})
+
+	t.Run("expired entries are fetched again", func(t *testing.T) {
+		userID := model.NewId()
+		userBadges, err := node1.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Empty(t, userBadges)
+
+		// Writes on the store below do not change the generation, so only the
+		// TTL makes the cache fetch them.
+		_, err = raw.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		node1.lastCheck = time.Now()
+
+		userBadges, err = node1.GetUserBadges(userID)
+		require.NoError(t, err)
+		assert.Empty(t, userBadges)
+
+		node1.loadedAt = time.Now().Add(-cacheTTL - time.Second)
+		userBadges, err = node1.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1)
+		assert.Equal(t, badge.ID, userBadges[0].ID)
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+var errInvalidSort = errors.New("invalid sort for this listing")
+
+type ListSort string
+
+const (
+	ListSortTime       ListSort = "time"
+	ListSortName       ListSort = "name"
+	ListSortPopularity ListSort = "popularity"
+)
+
+// ListOptions selects one page of a listing. Grant listings sort by time by
+// default and also take name; the badge listing sorts by name by default and
+// also takes popularity. Filters that do not apply to a listing are ignored.
+type ListOptions struct {
+	Sort    ListSort
+	Desc    bool
+	Cursor  string
+	PerPage int
+
+	TypeID    badgesmodel.BadgeType
+	GrantedBy string
+	// Since is inclusive and Until exclusive. Both are compared to the grant time.
+	Since time.Time
+	Until time.Time
+}
+
+func (o ListOptions) perPage() int {
+	if o.PerPage <= 0 || o.PerPage > ListMaxPerPage {
+		return ListDefaultPerPage
+	}
+	return o.PerPage
+}
+
+func (o ListOptions) matchesGrant(g badgesmodel.Ownership) bool {
+	return (o.GrantedBy == "" || g.GrantedBy == o.GrantedBy) &&
+		(o.Since.IsZero() || !g.Time.Before(o.Since)) &&
+		(o.Until.IsZero() || g.Time.Before(o.Until))
+}
+
+// listKey is the position of an item on a sorted listing. Depending on the
+// sort, either Num or Str holds the sorted value, and ID breaks the ties so the
+// order is total and a cursor points to a single place.
+type listKey struct {
+	Num int64  `json:"n,omitempty"`
+	Str string `json:"s,omitempty"`
+	ID  string `json:"id"`
+}
+
+func (k listKey) less(other listKey) bool {
+	if k.Num != other.Num {
+		return k.Num < other.Num
+	}
+	if k.Str != other.Str {
+		return k.Str < other.Str
+	}
+	return k.ID < other.ID
+}
+
+func grantKey(o badgesmodel.Ownership) listKey {
+	return listKey{Num: toMillis(o.Time), ID: o.GrantID}
+}
+
+func nameKey(name, id string) listKey {
+	return listKey{Str: strings.ToLower(name), ID: id}
+}
+
+// The cursor is the key of the last item of the page, so inserting or removing
+// items does not shift the next pages.
+func encodeCursor(k listKey) string {
+	data, _ := json.Marshal(k)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*listKey, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	k := &listKey{}
+	err = json.Unmarshal(data, k)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	return k, nil
+}
+
+// pageKeys sorts the items by their keys and returns the indexes of the page
+// after the cursor, and the cursor of the next page, or "" on the last one.
+func pageKeys(keys []listKey, opts ListOptions) ([]int, string, error) {
+	after, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	before := func(a, b listKey) bool {
+		if opts.Desc {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return before(keys[order[i]], keys[order[j]]) })
+
+	start := 0
+	if after != nil {
+		for start < len(order) && !before(*after, keys[order[start]]) {
+			start++
+		}
+	}
+
+	end := start + opts.perPage()
+	if end >= len(order) {
+		return order[start:], "", nil
+	}
+
+	return order[start:end], encodeCursor(keys[order[end-1]]), nil
+}
+
+// The helpers below page complete views in memory, for the stores that can only
+// build them whole.
+
+func listUserBadges(all []*badgesmodel.UserBadge, opts ListOptions) ([]*badgesmodel.UserBadge, string, error) {
+	filtered := []*badgesmodel.UserBadge{}
+	keys := []listKey{}
+	for _, b := range all {
+		if !opts.matchesGrant(b.Ownership) || (opts.TypeID != "" && b.Type != opts.TypeID) {
+			continue
+		}
+
+		switch opts.Sort {
+		case "", ListSortTime:
+			keys = append(keys, grantKey(b.Ownership))
+		case ListSortName:
+			keys = append(keys, nameKey(b.Name, b.GrantID))
+		default:
+			return nil, "", errInvalidSort
+		}
+		filtered = append(filtered, b)
+	}
+
+	page, next, err := pageKeys(keys, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out := []*badgesmodel.UserBadge{}
+	for _, i := range page {
+		out = append(out, filtered[i])
+	}
+	return out, next, nil
+}
+
+func listAllBadges(all []*badgesmodel.AllBadgesBadge, opts ListOptions) ([]*badgesmodel.AllBadgesBadge, string, error) {
+	filtered := []*badgesmodel.AllBadgesBadge{}
+	keys := []listKey{}
+	for _, b := range all {
+		if opts.TypeID != "" && b.Type != opts.TypeID {
+			continue
+		}
+
+		switch opts.Sort {
+		case "", ListSortName:
+			keys = append(keys, nameKey(b.Name, string(b.ID)))
+		case ListSortPopularity:
+			keys = append(keys, listKey{Num: int64(b.Granted), ID: string(b.ID)})
+		default:
+			return nil, "", errInvalidSort
+		}
+		filtered = append(filtered, b)
+	}
+
+	page, next, err := pageKeys(keys, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out := []*badgesmodel.AllBadgesBadge{}
+	for _, i := range page {
+		out = append(out, filtered[i])
+	}
+	return out, next, nil
+}
+
+func listOwners(all badgesmodel.OwnershipList, opts ListOptions) (badgesmodel.OwnershipList, string, error) {
+	if opts.Sort != "" && opts.Sort != ListSortTime {
+		return nil, "", errInvalidSort
+	}
+
+	filtered := badgesmodel.OwnershipList{}
+	keys := []listKey{}
+	for _, o := range all {
+		if !opts.matchesGrant(o) {
+			continue
+		}
+		keys = append(keys, grantKey(o))
+		filtered = append(filtered, o)
+	}
+
+	page, next, err := pageKeys(keys, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	out := badgesmodel.OwnershipList{}
+	for _, i := range page {
+		out = append(out, filtered[i])
+	}
+	return out, next, nil
+}
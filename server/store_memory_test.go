@@ -0,0 +1,726 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// memStore is an in-memory Store for tests. It follows the same contract as the
+// KV store, and every value it returns is a copy, like values decoded from KV.
+type memStore struct {
+	mu            sync.Mutex
+	types         badgesmodel.BadgeTypeList
+	badges        []*badgesmodel.Badge
+	ownership     badgesmodel.OwnershipList
+	subscriptions []badgesmodel.Subscription
+	badgeVersions map[badgesmodel.BadgeID][]*badgesmodel.Badge
+	typeVersions  map[badgesmodel.BadgeType]badgesmodel.BadgeTypeList
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		types:         badgesmodel.BadgeTypeList{},
+		badges:        []*badgesmodel.Badge{},
+		ownership:     badgesmodel.OwnershipList{},
+		subscriptions: []badgesmodel.Subscription{},
+		badgeVersions: map[badgesmodel.BadgeID][]*badgesmodel.Badge{},
+		typeVersions:  map[badgesmodel.BadgeType]badgesmodel.BadgeTypeList{},
+	}
+}
+
+func (m *memStore) copy(in, out interface{}) {
+	err := cloneJSON(in, out)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (m *memStore) getBadge(badgeID badgesmodel.BadgeID) *badgesmodel.Badge {
+	for _, b := range m.badges {
+		if b.ID == badgeID {
+			return b
+		}
+	}
+	return nil
+}
+
+func (m *memStore) typeName(tID badgesmodel.BadgeType) string {
+	t := m.types.GetType(tID)
+	if t == nil {
+		return "unknown"
+	}
+	return t.Name
+}
+
+func (m *memStore) GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []*badgesmodel.UserBadge{}
+	for _, o := range m.ownership {
+		if o.User != userID {
+			continue
+		}
+		badge := m.getBadge(o.Badge)
+		if badge == nil {
+			continue
+		}
+		out = append([]*badgesmodel.UserBadge{{
+			Badge:             *badge,
+			Ownership:         o,
+			GrantedByUsername: "unknown",
+			TypeName:          m.typeName(badge.Type),
+		}}, out...)
+	}
+
+	result := []*badgesmodel.UserBadge{}
+	m.copy(out, &result)
+	return result, nil
+}
+
+func (m *memStore) GetAllBadges() ([]*badgesmodel.AllBadgesBadge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []*badgesmodel.AllBadgesBadge{}
+	for _, b := range m.badges {
+		badge := &badgesmodel.AllBadgesBadge{Badge: *b, TypeName: m.typeName(b.Type)}
+		grantedTo := map[string]bool{}
+		for _, o := range m.ownership {
+			if o.Badge != b.ID {
+				continue
+			}
+			badge.GrantedTimes++
+			if !grantedTo[o.User] {
+				badge.Granted++
+				grantedTo[o.User] = true
+			}
+		}
+		out = append(out, badge)
+	}
+
+	result := []*badgesmodel.AllBadgesBadge{}
+	m.copy(out, &result)
+	return result, nil
+}
+
+func (m *memStore) GetBadgeDetails(badgeID badgesmodel.BadgeID) (*badgesmodel.BadgeDetails, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	badge := m.getBadge(badgeID)
+	if badge == nil {
+		return nil, errBadgeNotFound
+	}
+
+	owners := badgesmodel.OwnershipList{}
+	for _, o := range m.ownership {
+		if o.Badge == badgeID {
+			owners = append(owners, o)
+		}
+	}
+
+	result := &badgesmodel.BadgeDetails{}
+	m.copy(&badgesmodel.BadgeDetails{
+		Badge:             *badge,
+		Owners:            owners,
+		CreatedByUsername: "unknown",
+		TypeName:          m.typeName(badge.Type),
+	}, result)
+	return result, nil
+}
+
+func (m *memStore) GetRawBadges() ([]*badgesmodel.Badge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := []*badgesmodel.Badge{}
+	m.copy(m.badges, &result)
+	return result, nil
+}
+
+func (m *memStore) GetRawTypes() (badgesmodel.BadgeTypeList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := badgesmodel.BadgeTypeList{}
+	m.copy(m.types, &result)
+	return result, nil
+}
+
+func (m *memStore) addBadge(b *badgesmodel.Badge) (*badgesmodel.Badge, error) {
+	if !b.IsValid() {
+		return nil, errInvalidBadge
+	}
+
+	if m.types.GetType(b.Type) == nil {
+		return nil, errors.New("missing badge type")
+	}
+
+	b.ID = badgesmodel.BadgeID(model.NewId())
+	setFirstBadgeVersion(b)
+	stored := &badgesmodel.Badge{}
+	m.copy(b, stored)
+	m.badges = append(m.badges, stored)
+	return b, nil
+}
+
+func (m *memStore) AddBadge(b *badgesmodel.Badge) (*badgesmodel.Badge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addBadge(b)
+}
+
+func (m *memStore) GrantBadge(badgeID badgesmodel.BadgeID, userID string, grantedBy string, reason string) (*badgesmodel.Ownership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	badge := m.getBadge(badgeID)
+	if badge == nil {
+		return nil, errBadgeNotFound
+	}
+
+	badgeType := m.types.GetType(badge.Type)
+	if badgeType == nil {
+		return nil, errors.New("badge type not found")
+	}
+
+	if badge.Archived || badgeType.Archived {
+		return nil, errBadgeArchived
+	}
+
+	if !badge.Multiple && m.ownership.IsOwned(userID, badgeID) {
+		return nil, nil
+	}
+
+	now := time.Now()
+	ownership := badgesmodel.Ownership{
+		GrantID:      model.NewId(),
+		User:         userID,
+		Badge:        badgeID,
+		Time:         now,
+		Reason:       reason,
+		GrantedBy:    grantedBy,
+		BadgeVersion: badge.GetVersion(),
+		ExpiresAt:    badge.GetExpiry(now),
+	}
+	m.ownership = append(m.ownership, ownership)
+
+	out := &badgesmodel.Ownership{}
+	m.copy(ownership, out)
+	return out, nil
+}
+
+func (m *memStore) RevokeBadge(badgeID badgesmodel.BadgeID, userID string, all bool) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.getBadge(badgeID) == nil {
+		return 0, errBadgeNotFound
+	}
+
+	removed := 0
+	for i := len(m.ownership) - 1; i >= 0; i-- {
+		o := m.ownership[i]
+		if o.Badge != badgeID || o.User != userID {
+			continue
+		}
+		m.ownership = append(m.ownership[:i], m.ownership[i+1:]...)
+		removed++
+		if !all {
+			break
+		}
+	}
+
+	return removed, nil
+}
+
+func (m *memStore) ListUserBadges(userID string, opts ListOptions) ([]*badgesmodel.UserBadge, string, error) {
+	all, err := m.GetUserBadges(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listUserBadges(all, opts)
+}
+
+func (m *memStore) ListAllBadges(opts ListOptions) ([]*badgesmodel.AllBadgesBadge, string, error) {
+	all, err := m.GetAllBadges()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listAllBadges(all, opts)
+}
+
+func (m *memStore) ListBadgeOwners(badgeID badgesmodel.BadgeID, opts ListOptions) (badgesmodel.OwnershipList, string, error) {
+	details, err := m.GetBadgeDetails(badgeID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listOwners(details.Owners, opts)
+}
+
+func (m *memStore) GetGrant(grantID string) (*badgesmodel.Ownership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, o := range m.ownership {
+		if o.GrantID == grantID {
+			out := &badgesmodel.Ownership{}
+			m.copy(o, out)
+			return out, nil
+		}
+	}
+
+	return nil, errGrantNotFound
+}
+
+func (m *memStore) RenewBadge(badgeID badgesmodel.BadgeID, userID string) (*badgesmodel.Ownership, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	badge := m.getBadge(badgeID)
+	if badge == nil {
+		return nil, errBadgeNotFound
+	}
+
+	badgeType := m.types.GetType(badge.Type)
+	if badgeType == nil {
+		return nil, errors.New("badge type not found")
+	}
+
+	if badge.Archived || badgeType.Archived {
+		return nil, errBadgeArchived
+	}
+
+	expiresAt := badge.GetExpiry(time.Now())
+	if expiresAt == nil {
+		return nil, errBadgeNotTimeLimited
+	}
+
+	for i := len(m.ownership) - 1; i >= 0; i-- {
+		if m.ownership[i].Badge == badgeID && m.ownership[i].User == userID {
+			m.ownership[i].ExpiresAt = expiresAt
+			out := &badgesmodel.Ownership{}
+			m.copy(m.ownership[i], out)
+			return out, nil
+		}
+	}
+
+	return nil, errOwnershipNotFound
+}
+
+func (m *memStore) GetExpiringOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiring := badgesmodel.OwnershipList{}
+	for _, o := range m.ownership {
+		if o.IsExpired(before) {
+			expiring = append(expiring, o)
+		}
+	}
+
+	out := badgesmodel.OwnershipList{}
+	m.copy(expiring, &out)
+	sortByExpiry(out)
+	return out, nil
+}
+
+func (m *memStore) GetOwnerships(since time.Time) (badgesmodel.OwnershipList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	found := badgesmodel.OwnershipList{}
+	for _, o := range m.ownership {
+		if m.getBadge(o.Badge) != nil && !o.Time.Before(since) {
+			found = append(found, o)
+		}
+	}
+
+	out := badgesmodel.OwnershipList{}
+	m.copy(found, &out)
+	sortByGrantTime(out)
+	return out, nil
+}
+
+func (m *memStore) ExpireOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := badgesmodel.OwnershipList{}
+	expired := badgesmodel.OwnershipList{}
+	for _, o := range m.ownership {
+		if o.IsExpired(before) {
+			expired = append(expired, o)
+			continue
+		}
+		kept = append(kept, o)
+	}
+	m.ownership = kept
+
+	out := badgesmodel.OwnershipList{}
+	m.copy(expired, &out)
+	sortByExpiry(out)
+	return out, nil
+}
+
+func (m *memStore) addType(t *badgesmodel.BadgeTypeDefinition) *badgesmodel.BadgeTypeDefinition {
+	t.ID = badgesmodel.BadgeType(model.NewId())
+	setFirstTypeVersion(t)
+	stored := &badgesmodel.BadgeTypeDefinition{}
+	m.copy(t, stored)
+	m.types = append(m.types, stored)
+	return t
+}
+
+func (m *memStore) AddType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeTypeDefinition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addType(t), nil
+}
+
+func (m *memStore) GetType(tID badgesmodel.BadgeType) (*badgesmodel.BadgeTypeDefinition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.types.GetType(tID)
+	if t == nil {
+		return nil, errors.New("not found")
+	}
+
+	result := &badgesmodel.BadgeTypeDefinition{}
+	m.copy(t, result)
+	return result, nil
+}
+
+func (m *memStore) GetBadge(badgeID badgesmodel.BadgeID) (*badgesmodel.Badge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	badge := m.getBadge(badgeID)
+	if badge == nil {
+		return nil, errBadgeNotFound
+	}
+
+	result := &badgesmodel.Badge{}
+	m.copy(badge, result)
+	return result, nil
+}
+
+func (m *memStore) UpdateType(t *badgesmodel.BadgeTypeDefinition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, old := range m.types {
+		if old.ID == t.ID {
+			setNextTypeVersion(t, old)
+			kept := badgesmodel.BadgeTypeList{}
+			for _, v := range m.typeVersions[t.ID] {
+				if v.GetVersion() < old.GetVersion() {
+					kept = append(kept, v)
+				}
+			}
+			m.typeVersions[t.ID] = append(kept, old)
+			stored := &badgesmodel.BadgeTypeDefinition{}
+			m.copy(t, stored)
+			m.types[i] = stored
+			return nil
+		}
+	}
+
+	return errors.New("not found")
+}
+
+func (m *memStore) UpdateBadge(b *badgesmodel.Badge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, old := range m.badges {
+		if old.ID == b.ID {
+			setNextBadgeVersion(b, old)
+			kept := []*badgesmodel.Badge{}
+			for _, v := range m.badgeVersions[b.ID] {
+				if v.GetVersion() < old.GetVersion() {
+					kept = append(kept, v)
+				}
+			}
+			m.badgeVersions[b.ID] = append(kept, old)
+			stored := &badgesmodel.Badge{}
+			m.copy(b, stored)
+			m.badges[i] = stored
+			return nil
+		}
+	}
+
+	return errors.New("not found")
+}
+
+func (m *memStore) deleteBadge(bID badgesmodel.BadgeID) {
+	badges := []*badgesmodel.Badge{}
+	for _, b := range m.badges {
+		if b.ID != bID {
+			badges = append(badges, b)
+		}
+	}
+	m.badges = badges
+
+	ownership := badgesmodel.OwnershipList{}
+	for _, o := range m.ownership {
+		if o.Badge != bID {
+			ownership = append(ownership, o)
+		}
+	}
+	m.ownership = ownership
+}
+
+func (m *memStore) DeleteType(tID badgesmodel.BadgeType) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	types := badgesmodel.BadgeTypeList{}
+	for _, t := range m.types {
+		if t.ID != tID {
+			types = append(types, t)
+		}
+	}
+	m.types = types
+
+	for _, b := range append([]*badgesmodel.Badge{}, m.badges...) {
+		if b.Type == tID {
+			m.deleteBadge(b.ID)
+		}
+	}
+
+	subs := []badgesmodel.Subscription{}
+	for _, sub := range m.subscriptions {
+		if sub.TypeID != tID {
+			subs = append(subs, sub)
+		}
+	}
+	m.subscriptions = subs
+
+	return nil
+}
+
+func (m *memStore) DeleteBadge(bID badgesmodel.BadgeID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteBadge(bID)
+	return nil
+}
+
+func (m *memStore) GetBadgeHistory(badgeID badgesmodel.BadgeID) ([]*badgesmodel.Badge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	badge := m.getBadge(badgeID)
+	if badge == nil {
+		return nil, errBadgeNotFound
+	}
+
+	versions := []*badgesmodel.Badge{}
+	m.copy(m.badgeVersions[badgeID], &versions)
+	current := &badgesmodel.Badge{}
+	m.copy(badge, current)
+	return badgeHistory(versions, current), nil
+}
+
+func (m *memStore) GetTypeHistory(tID badgesmodel.BadgeType) (badgesmodel.BadgeTypeList, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.types.GetType(tID)
+	if t == nil {
+		return nil, errors.New("not found")
+	}
+
+	versions := badgesmodel.BadgeTypeList{}
+	m.copy(m.typeVersions[tID], &versions)
+	current := &badgesmodel.BadgeTypeDefinition{}
+	m.copy(t, current)
+	return typeHistory(versions, current), nil
+}
+
+func (m *memStore) AddSubscription(tID badgesmodel.BadgeType, cID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, sub := range m.subscriptions {
+		if sub.TypeID == tID && sub.ChannelID == cID {
+			return nil
+		}
+	}
+
+	m.subscriptions = append(m.subscriptions, badgesmodel.Subscription{TypeID: tID, ChannelID: cID})
+	return nil
+}
+
+func (m *memStore) RemoveSubscriptions(tID badgesmodel.BadgeType, cID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, sub := range m.subscriptions {
+		if sub.TypeID == tID && sub.ChannelID == cID {
+			m.subscriptions = append(m.subscriptions[:i], m.subscriptions[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *memStore) GetTypeSubscriptions(tID badgesmodel.BadgeType) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []string{}
+	for _, sub := range m.subscriptions {
+		if sub.TypeID == tID {
+			out = append(out, sub.ChannelID)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *memStore) GetChannelSubscriptions(cID string) ([]*badgesmodel.BadgeTypeDefinition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := []*badgesmodel.BadgeTypeDefinition{}
+	for _, sub := range m.subscriptions {
+		if sub.ChannelID != cID {
+			continue
+		}
+		t := m.types.GetType(sub.TypeID)
+		if t != nil {
+			out = append(out, t)
+		}
+	}
+
+	result := []*badgesmodel.BadgeTypeDefinition{}
+	m.copy(out, &result)
+	return result, nil
+}
+
+func (m *memStore) EnsureBadges(badges []*badgesmodel.Badge, pluginID, botID string) ([]*badgesmodel.Badge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tDef *badgesmodel.BadgeTypeDefinition
+	for _, t := range m.types {
+		if t.CreatedBy == botID {
+			tDef = t
+			break
+		}
+	}
+	if tDef == nil {
+		tDef = m.addType(&badgesmodel.BadgeTypeDefinition{
+			Name:      "Plugin badges: " + pluginID,
+			CreatedBy: botID,
+		})
+	}
+
+	out := []*badgesmodel.Badge{}
+	for _, pb := range badges {
+		var found *badgesmodel.Badge
+		for _, b := range m.badges {
+			if b.CreatedBy == botID && b.Name == pb.Name {
+				found = &badgesmodel.Badge{}
+				m.copy(b, found)
+				break
+			}
+		}
+		if found == nil {
+			pb.Type = tDef.ID
+			pb.CreatedBy = botID
+			var err error
+			found, err = m.addBadge(pb)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, found)
+	}
+
+	return out, nil
+}
+
+func (m *memStore) Dump() (*StoreDump, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dump := &StoreDump{}
+	m.copy(&StoreDump{
+		Types:         m.types,
+		Badges:        m.badges,
+		Ownerships:    m.ownership,
+		Subscriptions: m.subscriptions,
+	}, dump)
+	sort.SliceStable(dump.Ownerships, func(i, j int) bool { return dump.Ownerships[i].Time.Before(dump.Ownerships[j].Time) })
+	return dump, nil
+}
+
+func (m *memStore) RemoveOrphans(badges []badgesmodel.BadgeID, ownerships badgesmodel.OwnershipList, subscriptions []badgesmodel.Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := badgesmodel.OwnershipList{}
+	for _, o := range m.ownership {
+		if !ownerships.Contains(o) {
+			kept = append(kept, o)
+		}
+	}
+	m.ownership = kept
+
+	for _, bID := range badges {
+		m.deleteBadge(bID)
+	}
+
+	for _, toRemove := range subscriptions {
+		for i, sub := range m.subscriptions {
+			if sub.TypeID == toRemove.TypeID && sub.ChannelID == toRemove.ChannelID {
+				m.subscriptions = append(m.subscriptions[:i], m.subscriptions[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *memStore) Restore(dump *StoreDump) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restored := &StoreDump{}
+	m.copy(dump, restored)
+	m.types = restored.Types
+	m.badges = restored.Badges
+	m.ownership = withGrantIDs(restored.Ownerships)
+	m.subscriptions = restored.Subscriptions
+	if m.types == nil {
+		m.types = badgesmodel.BadgeTypeList{}
+	}
+	if m.badges == nil {
+		m.badges = []*badgesmodel.Badge{}
+	}
+	if m.ownership == nil {
+		m.ownership = badgesmodel.OwnershipList{}
+	}
+	if m.subscriptions == nil {
+		m.subscriptions = []badgesmodel.Subscription{}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+)
+
+// migrateShardOwnership moves the legacy single ownership list into the per-user
+// and per-badge keys. Rows already present on the new keys are kept, so a
+// partially migrated store is merged instead of duplicated.
+func migrateShardOwnership(tx *migrationTx) error {
+	legacy := badgesmodel.OwnershipList{}
+	found, err := tx.get(KVKeyOwnership, &legacy)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return nil
+	}
+
+	byUser := map[string]badgesmodel.OwnershipList{}
+	byBadge := map[badgesmodel.BadgeID]badgesmodel.OwnershipList{}
+	for _, o := range legacy {
+		byUser[o.User] = append(byUser[o.User], o)
+		byBadge[o.Badge] = append(byBadge[o.Badge], o)
+	}
+
+	merge := func(key string, toMerge badgesmodel.OwnershipList) error {
+		existing := badgesmodel.OwnershipList{}
+		_, err := tx.get(key, &existing)
+		if err != nil {
+			return err
+		}
+
+		for _, o := range existing {
+			if !toMerge.Contains(o) {
+				toMerge = append(toMerge, o)
+			}
+		}
+
+		return tx.set(key, toMerge)
+	}
+
+	for badgeID, ownership := range byBadge {
+		err = merge(getBadgeOwnershipKey(badgeID), ownership)
+		if err != nil {
+			return err
+		}
+	}
+
+	for userID, ownership := range byUser {
+		err = merge(getUserOwnershipKey(userID), ownership)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx.delete(KVKeyOwnership)
+	return nil
+}
+
+// migrateGrantIDs gives an ID to every grant made before grant IDs existed. The
+// badge keys get new IDs first, and each grant on the user keys takes the ID of
+// the same grant on its badge key, so both sides keep referring to one grant.
+func migrateGrantIDs(tx *migrationTx) error {
+	badgeKeys, err := tx.keys(KVKeyBadgeOwnershipPrefix)
+	if err != nil {
+		return err
+	}
+
+	byBadge := map[badgesmodel.BadgeID]badgesmodel.OwnershipList{}
+	for _, key := range badgeKeys {
+		ownership := badgesmodel.OwnershipList{}
+		_, err = tx.get(key, &ownership)
+		if err != nil {
+			return err
+		}
+		if len(ownership) == 0 {
+			continue
+		}
+
+		withIDs := withGrantIDs(ownership)
+		byBadge[withIDs[0].Badge] = withIDs
+		if !hasMissingGrantIDs(ownership) {
+			continue
+		}
+		err = tx.set(key, withIDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	userKeys, err := tx.keys(KVKeyUserOwnershipPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range userKeys {
+		ownership := badgesmodel.OwnershipList{}
+		_, err = tx.get(key, &ownership)
+		if err != nil {
+			return err
+		}
+		if !hasMissingGrantIDs(ownership) {
+			continue
+		}
+
+		used := map[string]bool{}
+		for _, o := range ownership {
+			used[o.GrantID] = true
+		}
+		for i, o := range ownership {
+			if o.GrantID != "" {
+				continue
+			}
+			for _, badgeGrant := range byBadge[o.Badge] {
+				if !used[badgeGrant.GrantID] && o.IsSameGrant(badgeGrant) {
+					ownership[i].GrantID = badgeGrant.GrantID
+					break
+				}
+			}
+			used[ownership[i].GrantID] = true
+		}
+
+		// Grants missing from their badge key are left for fsck to report,
+		// but still get an ID.
+		err = tx.set(key, withGrantIDs(ownership))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func hasMissingGrantIDs(l badgesmodel.OwnershipList) bool {
+	for _, o := range l {
+		if o.GrantID == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateGrantIDs(t *testing.T) {
+	api := newFakeAPI()
+	s := NewStore(api).(*store)
+	badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+	userID := model.NewId()
+	granterID := model.NewId()
+
+	now := time.Now()
+	legacy := badgesmodel.OwnershipList{
+		{User: userID, Badge: badge.ID, GrantedBy: granterID, Time: now},
+		{User: userID, Badge: badge.ID, GrantedBy: granterID, Time: now.Add(time.Second)},
+	}
+	for _, key := range []string{getBadgeOwnershipKey(badge.ID), getUserOwnershipKey(userID)} {
+		data, err := json.Marshal(legacy)
+		require.NoError(t, err)
+		require.Nil(t, api.KVSet(key, data))
+	}
+
+	tx := newMigrationTx(api, nil)
+	require.NoError(t, migrateGrantIDs(tx))
+	require.NoError(t, tx.commit())
+
+	badgeOwnership, _, err := s.getBadgeOwnershipList(badge.ID)
+	require.NoError(t, err)
+	userOwnership, _, err := s.getUserOwnershipList(userID)
+	require.NoError(t, err)
+	require.Len(t, badgeOwnership, 2)
+	require.Len(t, userOwnership, 2)
+	for i := range badgeOwnership {
+		assert.NotEmpty(t, badgeOwnership[i].GrantID)
+		assert.Equal(t, badgeOwnership[i].GrantID, userOwnership[i].GrantID, "both keys refer to the same grant")
+	}
+	assert.NotEqual(t, badgeOwnership[0].GrantID, badgeOwnership[1].GrantID)
+
+	tx = newMigrationTx(api, nil)
+	require.NoError(t, migrateGrantIDs(tx))
+	assert.Empty(t, tx.changes(), "running it again changes nothing")
+}
+
+func TestMigrationsDryRunOnLegacyStore(t *testing.T) {
+	api := newFakeAPI()
+	api.On("KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything).Return(true, nil)
+	s := NewStore(api).(*store)
+	badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+	userID := model.NewId()
+
+	now := time.Now()
+	legacy := badgesmodel.OwnershipList{
+		{User: userID, Badge: badge.ID, GrantedBy: model.NewId(), Time: now},
+		{User: userID, Badge: badge.ID, GrantedBy: model.NewId(), Time: now.Add(time.Second)},
+	}
+	data, err := json.Marshal(legacy)
+	require.NoError(t, err)
+	require.Nil(t, api.KVSet(KVKeyOwnership, data))
+
+	records, err := runMigrations(api, true)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, 3, records[0].Changes, "both shard keys are set and the legacy key deleted")
+	assert.ElementsMatch(t, []string{"set " + getBadgeOwnershipKey(badge.ID), "set " + getUserOwnershipKey(userID)}, records[1].ChangeList,
+		"grant IDs are added to the shard keys the first migration would create")
+
+	stored, appErr := api.KVGet(KVKeyOwnership)
+	require.Nil(t, appErr)
+	assert.Equal(t, data, stored, "a dry run writes nothing")
+	keys, err := listKVKeys(api, KVKeyBadgeOwnershipPrefix, KVKeyUserOwnershipPrefix)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+type snapshotInfo struct {
+	ID            string    `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Reason        string    `json:"reason"`
+	Types         int       `json:"types"`
+	Badges        int       `json:"badges"`
+	Ownerships    int       `json:"ownerships"`
+	Subscriptions int       `json:"subscriptions"`
+	// Chunks is how many keys the dump is split across. Snapshots taken before
+	// they were split have none, and keep the dump on Data.
+	Chunks int `json:"chunks,omitempty"`
+}
+
+type snapshot struct {
+	snapshotInfo
+	Data *StoreDump `json:"data,omitempty"`
+}
+
+// Snapshots are always kept on the KV store, whatever the store backend is. The
+// index key lists them from oldest to newest. The dump of each snapshot is split
+// in chunks of up to SnapshotChunkSize bytes, so large stores fit the KV store.
+func getSnapshotKey(id string) string {
+	return KVKeySnapshotPrefix + id
+}
+
+func getSnapshotChunkKey(id string, chunk int) string {
+	return fmt.Sprintf("%s%s_%d", KVKeySnapshotPrefix, id, chunk)
+}
+
+func getSnapshotIndex(api plugin.API) ([]snapshotInfo, []byte, error) {
+	data, appErr := api.KVGet(KVKeySnapshots)
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+
+	index := []snapshotInfo{}
+	if data != nil {
+		err := json.Unmarshal(data, &index)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return index, data, nil
+}
+
+func listSnapshots(api plugin.API) ([]snapshotInfo, error) {
+	index, _, err := getSnapshotIndex(api)
+	return index, err
+}
+
+func getSnapshot(api plugin.API, id string) (*snapshot, error) {
+	data, appErr := api.KVGet(getSnapshotKey(id))
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if data == nil {
+		return nil, errSnapshotNotFound
+	}
+
+	s := &snapshot{}
+	err := json.Unmarshal(data, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Chunks == 0 {
+		return s, nil
+	}
+
+	dumpData := []byte{}
+	for i := 0; i < s.Chunks; i++ {
+		chunk, appErr := api.KVGet(getSnapshotChunkKey(id, i))
+		if appErr != nil {
+			return nil, appErr
+		}
+		if chunk == nil {
+			return nil, errors.New("snapshot " + id + " is missing a chunk")
+		}
+		dumpData = append(dumpData, chunk...)
+	}
+
+	s.Data = &StoreDump{}
+	err = json.Unmarshal(dumpData, s.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// deleteSnapshot removes the keys of the snapshot. The index is left to the
+// caller.
+func deleteSnapshot(api plugin.API, info snapshotInfo) *model.AppError {
+	for i := 0; i < info.Chunks; i++ {
+		appErr := api.KVDelete(getSnapshotChunkKey(info.ID, i))
+		if appErr != nil {
+			return appErr
+		}
+	}
+	return api.KVDelete(getSnapshotKey(info.ID))
+}
+
+// takeSnapshot saves the whole content of the store, and removes the oldest
+// snapshots so only the last keep ones are left.
+func takeSnapshot(api plugin.API, s Store, reason string, keep int) (*snapshotInfo, error) {
+	dump, err := s.Dump()
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &snapshot{
+		snapshotInfo: snapshotInfo{
+			ID:            model.NewId(),
+			CreatedAt:     time.Now(),
+			Reason:        reason,
+			Types:         len(dump.Types),
+			Badges:        len(dump.Badges),
+			Ownerships:    len(dump.Ownerships),
+			Subscriptions: len(dump.Subscriptions),
+		},
+	}
+
+	dumpData, err := json.Marshal(dump)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(dumpData); start += SnapshotChunkSize {
+		end := start + SnapshotChunkSize
+		if end > len(dumpData) {
+			end = len(dumpData)
+		}
+		appErr := api.KVSet(getSnapshotChunkKey(snap.ID, snap.Chunks), dumpData[start:end])
+		if appErr != nil {
+			_ = deleteSnapshot(api, snap.snapshotInfo)
+			return nil, appErr
+		}
+		snap.Chunks++
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+
+	appErr := api.KVSet(getSnapshotKey(snap.ID), data)
+	if appErr != nil {
+		_ = deleteSnapshot(api, snap.snapshotInfo)
+		return nil, appErr
+	}
+
+	var toDelete []snapshotInfo
+	done := false
+	for i := 0; i < ATOMICRETRIES && !done; i++ {
+		index, old, err := getSnapshotIndex(api)
+		if err != nil {
+			return nil, err
+		}
+
+		index = append(index, snap.snapshotInfo)
+		sort.SliceStable(index, func(i, j int) bool { return index[i].CreatedAt.Before(index[j].CreatedAt) })
+		toDelete = nil
+		if len(index) > keep {
+			toDelete = index[:len(index)-keep]
+			index = index[len(index)-keep:]
+		}
+
+		newData, err := json.Marshal(index)
+		if err != nil {
+			return nil, err
+		}
+
+		done, appErr = api.KVCompareAndSet(KVKeySnapshots, old, newData)
+		if appErr != nil {
+			return nil, appErr
+		}
+	}
+	if !done {
+		return nil, errors.New("too many attempts on atomic retry")
+	}
+
+	for _, info := range toDelete {
+		appErr = deleteSnapshot(api, info)
+		if appErr != nil {
+			api.LogWarn("Cannot delete old snapshot", "id", info.ID, "err", appErr)
+		}
+	}
+
+	return &snap.snapshotInfo, nil
+}
+
+// snapshotStore takes a snapshot before every destructive store operation. Only
+// those operations are overridden; everything else goes straight to the
+// wrapped store.
+type snapshotStore struct {
+	Store
+	api  plugin.API
+	keep int
+}
+
+func NewSnapshotStore(s Store, api plugin.API, keep int) Store {
+	return &snapshotStore{
+		Store: s,
+		api:   api,
+		keep:  keep,
+	}
+}
+
+func (s *snapshotStore) DeleteType(tID badgesmodel.BadgeType) error {
+	reason := "Delete type " + string(tID)
+	t, err := s.Store.GetType(tID)
+	if err == nil {
+		reason = "Delete type " + t.Name
+	}
+
+	_, err = takeSnapshot(s.api, s.Store, reason, s.keep)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.DeleteType(tID)
+}
+
+func (s *snapshotStore) DeleteBadge(bID badgesmodel.BadgeID) error {
+	reason := "Delete badge " + string(bID)
+	b, err := s.Store.GetBadge(bID)
+	if err == nil {
+		reason = "Delete badge " + b.Name
+	}
+
+	_, err = takeSnapshot(s.api, s.Store, reason, s.keep)
+	if err != nil {
+		return err
+	}
+
+	return s.Store.DeleteBadge(bID)
+}
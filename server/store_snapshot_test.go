@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotStore(t *testing.T) {
+	api := newFakeAPI()
+	s := NewSnapshotStore(NewStore(api), api, 2)
+	badgeType := addTestType(t, s)
+	badge := addTestBadge(t, s, badgeType.ID, false)
+	userID := model.NewId()
+	_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeleteBadge(badge.ID))
+
+	snapshots, err := listSnapshots(api)
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, "Delete badge "+badge.Name, snapshots[0].Reason)
+	assert.Equal(t, 1, snapshots[0].Ownerships)
+
+	snap, err := getSnapshot(api, snapshots[0].ID)
+	require.NoError(t, err)
+	require.NoError(t, s.Restore(snap.Data))
+
+	userBadges, err := s.GetUserBadges(userID)
+	require.NoError(t, err)
+	require.Len(t, userBadges, 1)
+	assert.Equal(t, badge.ID, userBadges[0].ID)
+
+	t.Run("only the last ones are kept", func(t *testing.T) {
+		require.NoError(t, s.DeleteBadge(badge.ID))
+		require.NoError(t, s.DeleteType(badgeType.ID))
+
+		snapshots, err := listSnapshots(api)
+		require.NoError(t, err)
+		require.Len(t, snapshots, 2)
+		assert.Equal(t, "Delete badge "+badge.Name, snapshots[0].Reason)
+		assert.Equal(t, "Delete type "+badgeType.Name, snapshots[1].Reason)
+
+		_, err = getSnapshot(api, snap.ID)
+		assert.Equal(t, errSnapshotNotFound, err)
+	})
+}
+
+func TestSnapshotChunks(t *testing.T) {
+	api := newFakeAPI()
+	s := newMemStore()
+	for i := 0; i < 3*SnapshotChunkSize/1000; i++ {
+		_, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: strings.Repeat("x", 1000)})
+		require.NoError(t, err)
+	}
+
+	info, err := takeSnapshot(api, s, "Big", 1)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, info.Chunks, 3)
+
+	snap, err := getSnapshot(api, info.ID)
+	require.NoError(t, err)
+	assert.Len(t, snap.Data.Types, 3*SnapshotChunkSize/1000)
+
+	t.Run("old snapshots are removed with their chunks", func(t *testing.T) {
+		_, err := takeSnapshot(api, newMemStore(), "Small", 1)
+		require.NoError(t, err)
+		for i := 0; i < info.Chunks; i++ {
+			data, appErr := api.KVGet(getSnapshotChunkKey(info.ID, i))
+			require.Nil(t, appErr)
+			assert.Nil(t, data)
+		}
+	})
+
+	t.Run("snapshots kept on a single key still load", func(t *testing.T) {
+		legacy := &snapshot{snapshotInfo: snapshotInfo{ID: model.NewId()}, Data: &StoreDump{Types: badgesmodel.BadgeTypeList{{ID: "legacy"}}}}
+		data, err := json.Marshal(legacy)
+		require.NoError(t, err)
+		require.Nil(t, api.KVSet(getSnapshotKey(legacy.ID), data))
+
+		snap, err := getSnapshot(api, legacy.ID)
+		require.NoError(t, err)
+		require.Len(t, snap.Data.Types, 1)
+		assert.Equal(t, badgesmodel.BadgeType("legacy"), snap.Data.Types[0].ID)
+	})
+}
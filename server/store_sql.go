@@ -0,0 +1,1292 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	sqlTableTypes         = "badges_types"
+	sqlTableBadges        = "badges_badges"
+	sqlTableOwnerships    = "badges_ownerships"
+	sqlTableSubscriptions = "badges_subscriptions"
+	sqlTableBadgeVersions = "badges_badge_versions"
+	sqlTableTypeVersions  = "badges_type_versions"
+
+	// sqlDriverSQLite is not used by the server. It lets the tests run the
+	// store on a local database.
+	sqlDriverSQLite = "sqlite3"
+)
+
+// sqlStore keeps badges on dedicated tables of the server database. Each row has
+// the columns needed to filter and join, plus the JSON encoded model on data, so
+// new model fields do not need a schema change.
+type sqlStore struct {
+	db     *sql.DB
+	driver string
+	api    plugin.API
+}
+
+func NewSQLStore(db *sql.DB, driver string, api plugin.API) (Store, error) {
+	if driver != model.DATABASE_DRIVER_POSTGRES && driver != model.DATABASE_DRIVER_MYSQL && driver != sqlDriverSQLite {
+		return nil, errors.New("unsupported database driver " + driver)
+	}
+
+	s := &sqlStore{
+		db:     db,
+		driver: driver,
+		api:    api,
+	}
+
+	err := s.createSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.backfillGrantIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *sqlStore) createSchema() error {
+	type table struct {
+		name    string
+		columns string
+		indexes map[string]string
+	}
+
+	tables := []table{
+		{
+			name:    sqlTableTypes,
+			columns: "id VARCHAR(26) PRIMARY KEY, name VARCHAR(255), created_by VARCHAR(26), created_at BIGINT, data TEXT",
+			indexes: map[string]string{"idx_badges_types_created_by": "created_by"},
+		},
+		{
+			name:    sqlTableBadges,
+			columns: "id VARCHAR(26) PRIMARY KEY, type_id VARCHAR(26), name VARCHAR(255), created_by VARCHAR(26), created_at BIGINT, data TEXT",
+			indexes: map[string]string{
+				"idx_badges_badges_type_id":    "type_id",
+				"idx_badges_badges_created_by": "created_by",
+			},
+		},
+		{
+			name:    sqlTableOwnerships,
+			columns: "id VARCHAR(26) PRIMARY KEY, badge_id VARCHAR(26), user_id VARCHAR(26), granted_by VARCHAR(26), granted_at BIGINT, data TEXT",
+			indexes: map[string]string{
+				"idx_badges_ownerships_user_id":    "user_id, granted_at",
+				"idx_badges_ownerships_badge_id":   "badge_id, granted_at",
+				"idx_badges_ownerships_granted_at": "granted_at",
+			},
+		},
+		{
+			name:    sqlTableSubscriptions,
+			columns: "type_id VARCHAR(26), channel_id VARCHAR(26), PRIMARY KEY (type_id, channel_id)",
+			indexes: map[string]string{"idx_badges_subscriptions_channel_id": "channel_id"},
+		},
+		{
+			name:    sqlTableBadgeVersions,
+			columns: "badge_id VARCHAR(26), version INTEGER, data TEXT, PRIMARY KEY (badge_id, version)",
+		},
+		{
+			name:    sqlTableTypeVersions,
+			columns: "type_id VARCHAR(26), version INTEGER, data TEXT, PRIMARY KEY (type_id, version)",
+		},
+	}
+
+	for _, t := range tables {
+		if s.driver == model.DATABASE_DRIVER_MYSQL {
+			// MySQL has no CREATE INDEX IF NOT EXISTS, so indexes are declared with the table.
+			columns := t.columns
+			for name, cols := range t.indexes {
+				columns += ", INDEX " + name + " (" + cols + ")"
+			}
+			_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + t.name + " (" + columns + ")")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err := s.db.Exec("CREATE TABLE IF NOT EXISTS " + t.name + " (" + t.columns + ")")
+		if err != nil {
+			return err
+		}
+		for name, cols := range t.indexes {
+			_, err = s.db.Exec("CREATE INDEX IF NOT EXISTS " + name + " ON " + t.name + " (" + cols + ")")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// forUpdate locks the rows read by a query until the end of the transaction.
+// SQLite has no row locks, as its write transactions lock the whole database.
+func (s *sqlStore) forUpdate(query string) string {
+	if s.driver == sqlDriverSQLite {
+		return query
+	}
+	return query + " FOR UPDATE"
+}
+
+// rebind turns the ? placeholders into the ones expected by the driver.
+func (s *sqlStore) rebind(query string) string {
+	if s.driver != model.DATABASE_DRIVER_POSTGRES {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s *sqlStore) withTx(f func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = f(tx)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			s.api.LogWarn("Cannot roll back transaction", "err", rollbackErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (s *sqlStore) queryData(q sqlQueryer, out func(data []byte) error, query string, args ...interface{}) error {
+	rows, err := q.Query(s.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+		err = out([]byte(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *sqlStore) getTypes(q sqlQueryer, where string, args ...interface{}) (badgesmodel.BadgeTypeList, error) {
+	out := badgesmodel.BadgeTypeList{}
+	err := s.queryData(q, func(data []byte) error {
+		t := &badgesmodel.BadgeTypeDefinition{}
+		err := json.Unmarshal(data, t)
+		out = append(out, t)
+		return err
+	}, "SELECT data FROM "+sqlTableTypes+" "+where+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *sqlStore) getBadges(q sqlQueryer, where string, args ...interface{}) ([]*badgesmodel.Badge, error) {
+	out := []*badgesmodel.Badge{}
+	err := s.queryData(q, func(data []byte) error {
+		b := &badgesmodel.Badge{}
+		err := json.Unmarshal(data, b)
+		out = append(out, b)
+		return err
+	}, "SELECT data FROM "+sqlTableBadges+" "+where+" ORDER BY created_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// lockBadge reads a badge and locks its row until the end of the transaction.
+func (s *sqlStore) lockBadge(tx *sql.Tx, badgeID badgesmodel.BadgeID) (*badgesmodel.Badge, error) {
+	var data string
+	err := tx.QueryRow(s.rebind(s.forUpdate("SELECT data FROM "+sqlTableBadges+" WHERE id = ?")), string(badgeID)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errBadgeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := &badgesmodel.Badge{}
+	return b, json.Unmarshal([]byte(data), b)
+}
+
+// lockType reads a type and locks its row until the end of the transaction.
+func (s *sqlStore) lockType(tx *sql.Tx, tID badgesmodel.BadgeType) (*badgesmodel.BadgeTypeDefinition, error) {
+	var data string
+	err := tx.QueryRow(s.rebind(s.forUpdate("SELECT data FROM "+sqlTableTypes+" WHERE id = ?")), string(tID)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := &badgesmodel.BadgeTypeDefinition{}
+	return t, json.Unmarshal([]byte(data), t)
+}
+
+// backfillGrantIDs gives the row ID as grant ID to the rows inserted before grant
+// IDs existed. Rows written since always have the key on their data, so only the
+// first run after upgrading has anything to update.
+func (s *sqlStore) backfillGrantIDs() error {
+	return s.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id, data FROM " + sqlTableOwnerships + " WHERE data NOT LIKE '%\"grant_id\"%'")
+		if err != nil {
+			return err
+		}
+
+		updated := map[string]string{}
+		for rows.Next() {
+			var id, data string
+			if err = rows.Scan(&id, &data); err != nil {
+				rows.Close()
+				return err
+			}
+
+			o := badgesmodel.Ownership{}
+			if err = json.Unmarshal([]byte(data), &o); err != nil {
+				rows.Close()
+				return err
+			}
+			o.GrantID = id
+
+			newData, err := json.Marshal(o)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			updated[id] = string(newData)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for id, data := range updated {
+			_, err = tx.Exec(s.rebind("UPDATE "+sqlTableOwnerships+" SET data = ? WHERE id = ?"), data, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(updated) > 0 {
+			s.api.LogInfo("Backfilled grant IDs", "grants", len(updated))
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) getOwnerships(q sqlQueryer, where string, args ...interface{}) (badgesmodel.OwnershipList, error) {
+	out := badgesmodel.OwnershipList{}
+	err := s.queryData(q, func(data []byte) error {
+		o := badgesmodel.Ownership{}
+		err := json.Unmarshal(data, &o)
+		out = append(out, o)
+		return err
+	}, "SELECT data FROM "+sqlTableOwnerships+" "+where+" ORDER BY granted_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// granted_at only keeps milliseconds, so the grants made within the same
+	// millisecond are put back in the order they were made.
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+
+	return out, nil
+}
+
+func (s *sqlStore) insertType(q sqlQueryer, t *badgesmodel.BadgeTypeDefinition, createdAt int64) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(s.rebind("INSERT INTO "+sqlTableTypes+" (id, name, created_by, created_at, data) VALUES (?, ?, ?, ?, ?)"),
+		string(t.ID), t.Name, t.CreatedBy, createdAt, string(data))
+	return err
+}
+
+func (s *sqlStore) insertBadge(q sqlQueryer, b *badgesmodel.Badge, createdAt int64) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(s.rebind("INSERT INTO "+sqlTableBadges+" (id, type_id, name, created_by, created_at, data) VALUES (?, ?, ?, ?, ?, ?)"),
+		string(b.ID), string(b.Type), b.Name, b.CreatedBy, createdAt, string(data))
+	return err
+}
+
+func (s *sqlStore) insertOwnership(q sqlQueryer, o badgesmodel.Ownership) error {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(s.rebind("INSERT INTO "+sqlTableOwnerships+" (id, badge_id, user_id, granted_by, granted_at, data) VALUES (?, ?, ?, ?, ?, ?)"),
+		o.GrantID, string(o.Badge), o.User, o.GrantedBy, toMillis(o.Time), string(data))
+	return err
+}
+
+func (s *sqlStore) insertSubscription(q sqlQueryer, sub badgesmodel.Subscription) error {
+	_, err := q.Exec(s.rebind("INSERT INTO "+sqlTableSubscriptions+" (type_id, channel_id) VALUES (?, ?)"), string(sub.TypeID), sub.ChannelID)
+	return err
+}
+
+func (s *sqlStore) getDisplayName(userID string, format *string, names map[string]string) string {
+	if name, ok := names[userID]; ok {
+		return name
+	}
+
+	name := "unknown"
+	u, appErr := s.api.GetUser(userID)
+	if appErr == nil && format != nil {
+		name = u.GetDisplayName(*format)
+	}
+	names[userID] = name
+	return name
+}
+
+func (s *sqlStore) getNameFormat() *string {
+	conf := s.api.GetConfig()
+	if conf == nil {
+		return nil
+	}
+	return conf.TeamSettings.TeammateNameDisplay
+}
+
+func (s *sqlStore) GetUserBadges(userID string) ([]*badgesmodel.UserBadge, error) {
+	ownership, err := s.getOwnerships(s.db, "WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first.
+	for i, j := 0, len(ownership)-1; i < j; i, j = i+1, j-1 {
+		ownership[i], ownership[j] = ownership[j], ownership[i]
+	}
+
+	return s.userBadgeViews(ownership)
+}
+
+// userBadgeViews joins the grants with their badges, keeping their order.
+func (s *sqlStore) userBadgeViews(ownership badgesmodel.OwnershipList) ([]*badgesmodel.UserBadge, error) {
+	badges, err := s.getBadges(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := s.getTypes(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	format := s.getNameFormat()
+	names := map[string]string{}
+	out := []*badgesmodel.UserBadge{}
+	for _, o := range ownership {
+		var badge *badgesmodel.Badge
+		for _, b := range badges {
+			if b.ID == o.Badge {
+				badge = b
+				break
+			}
+		}
+		if badge == nil {
+			s.api.LogDebug("Badge not found while getting user badges", "badgeID", o.Badge, "userID", o.User)
+			continue
+		}
+
+		typeName := "unknown"
+		t := types.GetType(badge.Type)
+		if t != nil {
+			typeName = t.Name
+		}
+
+		out = append(out, &badgesmodel.UserBadge{
+			Badge:             *badge,
+			Ownership:         o,
+			GrantedByUsername: s.getDisplayName(o.GrantedBy, format, names),
+			TypeName:          typeName,
+		})
+	}
+
+	return out, nil
+}
+
+// grantFilters turns the grant filters of the options into conditions on the
+// ownerships table.
+func (s *sqlStore) grantFilters(opts ListOptions, conditions []string, args []interface{}) ([]string, []interface{}) {
+	if opts.GrantedBy != "" {
+		conditions = append(conditions, "granted_by = ?")
+		args = append(args, opts.GrantedBy)
+	}
+	if !opts.Since.IsZero() {
+		conditions = append(conditions, "granted_at >= ?")
+		args = append(args, toMillis(opts.Since))
+	}
+	if !opts.Until.IsZero() {
+		conditions = append(conditions, "granted_at < ?")
+		args = append(args, toMillis(opts.Until))
+	}
+	if opts.TypeID != "" {
+		conditions = append(conditions, "badge_id IN (SELECT id FROM "+sqlTableBadges+" WHERE type_id = ?)")
+		args = append(args, string(opts.TypeID))
+	}
+	return conditions, args
+}
+
+// listOwnerships pages the ownerships matching the conditions by grant time, on
+// the database.
+func (s *sqlStore) listOwnerships(opts ListOptions, conditions []string, args []interface{}) (badgesmodel.OwnershipList, string, error) {
+	after, err := decodeCursor(opts.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	order, cmp := "ASC", ">"
+	if opts.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if after != nil {
+		conditions = append(conditions, "(granted_at "+cmp+" ? OR (granted_at = ? AND id "+cmp+" ?))")
+		args = append(args, after.Num, after.Num, after.ID)
+	}
+
+	perPage := opts.perPage()
+	out := badgesmodel.OwnershipList{}
+	err = s.queryData(s.db, func(data []byte) error {
+		o := badgesmodel.Ownership{}
+		err := json.Unmarshal(data, &o)
+		out = append(out, o)
+		return err
+	}, "SELECT data FROM "+sqlTableOwnerships+" WHERE "+strings.Join(conditions, " AND ")+
+		" ORDER BY granted_at "+order+", id "+order+" LIMIT "+strconv.Itoa(perPage+1), args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(out) <= perPage {
+		return out, "", nil
+	}
+
+	out = out[:perPage]
+	return out, encodeCursor(grantKey(out[perPage-1])), nil
+}
+
+func (s *sqlStore) ListUserBadges(userID string, opts ListOptions) ([]*badgesmodel.UserBadge, string, error) {
+	conditions, args := s.grantFilters(opts, []string{"user_id = ?"}, []interface{}{userID})
+
+	switch opts.Sort {
+	case "", ListSortTime:
+		ownership, next, err := s.listOwnerships(opts, conditions, args)
+		if err != nil {
+			return nil, "", err
+		}
+
+		views, err := s.userBadgeViews(ownership)
+		if err != nil {
+			return nil, "", err
+		}
+		return views, next, nil
+	case ListSortName:
+		// Names live on the data of the badges, so the filtered grants are
+		// sorted in memory.
+		ownership, err := s.getOwnerships(s.db, "WHERE "+strings.Join(conditions, " AND "), args...)
+		if err != nil {
+			return nil, "", err
+		}
+
+		views, err := s.userBadgeViews(ownership)
+		if err != nil {
+			return nil, "", err
+		}
+		return listUserBadges(views, opts)
+	default:
+		return nil, "", errInvalidSort
+	}
+}
+
+func (s *sqlStore) ListAllBadges(opts ListOptions) ([]*badgesmodel.AllBadgesBadge, string, error) {
+	all, err := s.GetAllBadges()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return listAllBadges(all, opts)
+}
+
+func (s *sqlStore) ListBadgeOwners(badgeID badgesmodel.BadgeID, opts ListOptions) (badgesmodel.OwnershipList, string, error) {
+	_, err := s.GetBadge(badgeID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opts.Sort != "" && opts.Sort != ListSortTime {
+		return nil, "", errInvalidSort
+	}
+
+	opts.TypeID = ""
+	conditions, args := s.grantFilters(opts, []string{"badge_id = ?"}, []interface{}{string(badgeID)})
+	return s.listOwnerships(opts, conditions, args)
+}
+
+func (s *sqlStore) GetAllBadges() ([]*badgesmodel.AllBadgesBadge, error) {
+	badges, err := s.getBadges(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := s.getTypes(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	type counts struct {
+		granted      int
+		grantedTimes int
+	}
+	byBadge := map[badgesmodel.BadgeID]counts{}
+	rows, err := s.db.Query("SELECT badge_id, COUNT(*), COUNT(DISTINCT user_id) FROM " + sqlTableOwnerships + " GROUP BY badge_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var badgeID string
+		var c counts
+		err = rows.Scan(&badgeID, &c.grantedTimes, &c.granted)
+		if err != nil {
+			return nil, err
+		}
+		byBadge[badgesmodel.BadgeID(badgeID)] = c
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := []*badgesmodel.AllBadgesBadge{}
+	for _, b := range badges {
+		c := byBadge[b.ID]
+		badge := &badgesmodel.AllBadgesBadge{
+			Badge:        *b,
+			Granted:      c.granted,
+			GrantedTimes: c.grantedTimes,
+			TypeName:     "unknown",
+		}
+		t := types.GetType(b.Type)
+		if t != nil {
+			badge.TypeName = t.Name
+		}
+		out = append(out, badge)
+	}
+
+	return out, nil
+}
+
+func (s *sqlStore) GetBadgeDetails(badgeID badgesmodel.BadgeID) (*badgesmodel.BadgeDetails, error) {
+	badge, err := s.GetBadge(badgeID)
+	if err != nil {
+		return nil, err
+	}
+
+	owners, err := s.getOwnerships(s.db, "WHERE badge_id = ?", string(badgeID))
+	if err != nil {
+		return nil, err
+	}
+
+	typeName := "unknown"
+	t, err := s.GetType(badge.Type)
+	if err == nil {
+		typeName = t.Name
+	}
+
+	return &badgesmodel.BadgeDetails{
+		Badge:             *badge,
+		Owners:            owners,
+		CreatedByUsername: s.getDisplayName(badge.CreatedBy, s.getNameFormat(), map[string]string{}),
+		TypeName:          typeName,
+	}, nil
+}
+
+func (s *sqlStore) GetRawBadges() ([]*badgesmodel.Badge, error) {
+	return s.getBadges(s.db, "")
+}
+
+func (s *sqlStore) GetRawTypes() (badgesmodel.BadgeTypeList, error) {
+	return s.getTypes(s.db, "")
+}
+
+func (s *sqlStore) AddBadge(b *badgesmodel.Badge) (*badgesmodel.Badge, error) {
+	if !b.IsValid() {
+		return nil, errInvalidBadge
+	}
+
+	_, err := s.GetType(b.Type)
+	if err != nil {
+		return nil, errors.New("missing badge type")
+	}
+
+	b.ID = badgesmodel.BadgeID(model.NewId())
+	setFirstBadgeVersion(b)
+	err = s.insertBadge(s.db, b, model.GetMillis())
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *sqlStore) GrantBadge(badgeID badgesmodel.BadgeID, userID string, grantedBy string, reason string) (*badgesmodel.Ownership, error) {
+	var granted *badgesmodel.Ownership
+	err := s.withTx(func(tx *sql.Tx) error {
+		// Locking the badge row serializes grants of the same badge, so the
+		// Multiple check cannot race.
+		badge, err := s.lockBadge(tx, badgeID)
+		if err != nil {
+			return err
+		}
+
+		types, err := s.getTypes(tx, "WHERE id = ?", string(badge.Type))
+		if err != nil {
+			return err
+		}
+		if len(types) == 0 {
+			return errors.New("badge type not found")
+		}
+		if badge.Archived || types[0].Archived {
+			return errBadgeArchived
+		}
+
+		if !badge.Multiple {
+			var count int
+			err = tx.QueryRow(s.rebind("SELECT COUNT(*) FROM "+sqlTableOwnerships+" WHERE badge_id = ? AND user_id = ?"), string(badgeID), userID).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+		}
+
+		now := time.Now()
+		ownership := badgesmodel.Ownership{
+			GrantID:      model.NewId(),
+			User:         userID,
+			Badge:        badge.ID,
+			Time:         now,
+			Reason:       reason,
+			GrantedBy:    grantedBy,
+			BadgeVersion: badge.GetVersion(),
+			ExpiresAt:    badge.GetExpiry(now),
+		}
+		err = s.insertOwnership(tx, ownership)
+		if err != nil {
+			return err
+		}
+
+		granted = &ownership
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return granted, nil
+}
+
+func (s *sqlStore) RevokeBadge(badgeID badgesmodel.BadgeID, userID string, all bool) (int, error) {
+	_, err := s.GetBadge(badgeID)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	err = s.withTx(func(tx *sql.Tx) error {
+		owned, err := s.getOwnerships(tx, "WHERE badge_id = ? AND user_id = ?", string(badgeID), userID)
+		if err != nil {
+			return err
+		}
+		if !all && len(owned) > 1 {
+			owned = owned[len(owned)-1:]
+		}
+
+		for _, o := range owned {
+			_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableOwnerships+" WHERE id = ?"), o.GrantID)
+			if err != nil {
+				return err
+			}
+		}
+
+		removed = len(owned)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+func (s *sqlStore) RenewBadge(badgeID badgesmodel.BadgeID, userID string) (*badgesmodel.Ownership, error) {
+	var renewed *badgesmodel.Ownership
+	err := s.withTx(func(tx *sql.Tx) error {
+		badge, err := s.lockBadge(tx, badgeID)
+		if err != nil {
+			return err
+		}
+
+		types, err := s.getTypes(tx, "WHERE id = ?", string(badge.Type))
+		if err != nil {
+			return err
+		}
+		if len(types) == 0 {
+			return errors.New("badge type not found")
+		}
+		if badge.Archived || types[0].Archived {
+			return errBadgeArchived
+		}
+
+		expiresAt := badge.GetExpiry(time.Now())
+		if expiresAt == nil {
+			return errBadgeNotTimeLimited
+		}
+
+		owned, err := s.getOwnerships(tx, "WHERE badge_id = ? AND user_id = ?", string(badgeID), userID)
+		if err != nil {
+			return err
+		}
+		if len(owned) == 0 {
+			return errOwnershipNotFound
+		}
+
+		o := owned[len(owned)-1]
+		o.ExpiresAt = expiresAt
+
+		data, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.rebind("UPDATE "+sqlTableOwnerships+" SET data = ? WHERE id = ?"), string(data), o.GrantID)
+		if err != nil {
+			return err
+		}
+
+		renewed = &o
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return renewed, nil
+}
+
+// expiredOwnerships reads the ownerships expired at before, with their row ids.
+// The expiry only lives in the data column, so every ownership is read.
+func (s *sqlStore) expiredOwnerships(q sqlQueryer, before time.Time) ([]string, badgesmodel.OwnershipList, error) {
+	rows, err := q.Query("SELECT id, data FROM " + sqlTableOwnerships)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	out := badgesmodel.OwnershipList{}
+	for rows.Next() {
+		var id, data string
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		o := badgesmodel.Ownership{}
+		err = json.Unmarshal([]byte(data), &o)
+		if err != nil {
+			return nil, nil, err
+		}
+		if o.IsExpired(before) {
+			ids = append(ids, id)
+			out = append(out, o)
+		}
+	}
+
+	return ids, out, rows.Err()
+}
+
+func (s *sqlStore) GetExpiringOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	_, out, err := s.expiredOwnerships(s.db, before)
+	if err != nil {
+		return nil, err
+	}
+
+	sortByExpiry(out)
+	return out, nil
+}
+
+func (s *sqlStore) ExpireOwnerships(before time.Time) (badgesmodel.OwnershipList, error) {
+	var expired badgesmodel.OwnershipList
+	err := s.withTx(func(tx *sql.Tx) error {
+		ids, out, err := s.expiredOwnerships(tx, before)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableOwnerships+" WHERE id = ?"), id)
+			if err != nil {
+				return err
+			}
+		}
+
+		expired = out
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortByExpiry(expired)
+	return expired, nil
+}
+
+func (s *sqlStore) GetOwnerships(since time.Time) (badgesmodel.OwnershipList, error) {
+	if since.IsZero() {
+		return s.getOwnerships(s.db, "")
+	}
+	return s.getOwnerships(s.db, "WHERE granted_at >= ?", toMillis(since))
+}
+
+func (s *sqlStore) GetGrant(grantID string) (*badgesmodel.Ownership, error) {
+	ownership, err := s.getOwnerships(s.db, "WHERE id = ?", grantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(ownership) == 0 {
+		return nil, errGrantNotFound
+	}
+
+	return &ownership[0], nil
+}
+
+func (s *sqlStore) AddType(t *badgesmodel.BadgeTypeDefinition) (*badgesmodel.BadgeTypeDefinition, error) {
+	t.ID = badgesmodel.BadgeType(model.NewId())
+	setFirstTypeVersion(t)
+	err := s.insertType(s.db, t, model.GetMillis())
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (s *sqlStore) GetType(tID badgesmodel.BadgeType) (*badgesmodel.BadgeTypeDefinition, error) {
+	types, err := s.getTypes(s.db, "WHERE id = ?", string(tID))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(types) == 0 {
+		return nil, errors.New("not found")
+	}
+
+	return types[0], nil
+}
+
+func (s *sqlStore) GetBadge(badgeID badgesmodel.BadgeID) (*badgesmodel.Badge, error) {
+	badges, err := s.getBadges(s.db, "WHERE id = ?", string(badgeID))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(badges) == 0 {
+		return nil, errBadgeNotFound
+	}
+
+	return badges[0], nil
+}
+
+// UpdateType and UpdateBadge lock the row, so concurrent updates get
+// consecutive versions.
+func (s *sqlStore) UpdateType(t *badgesmodel.BadgeTypeDefinition) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		previous, err := s.lockType(tx, t.ID)
+		if err != nil {
+			return err
+		}
+		setNextTypeVersion(t, previous)
+
+		err = s.insertVersion(tx, sqlTableTypeVersions, "type_id", string(t.ID), previous.GetVersion(), previous)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("UPDATE "+sqlTableTypes+" SET name = ?, created_by = ?, data = ? WHERE id = ?"), t.Name, t.CreatedBy, string(data), string(t.ID))
+		return err
+	})
+}
+
+func (s *sqlStore) UpdateBadge(b *badgesmodel.Badge) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		previous, err := s.lockBadge(tx, b.ID)
+		if err != nil {
+			return err
+		}
+		setNextBadgeVersion(b, previous)
+
+		err = s.insertVersion(tx, sqlTableBadgeVersions, "badge_id", string(b.ID), previous.GetVersion(), previous)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("UPDATE "+sqlTableBadges+" SET type_id = ?, name = ?, created_by = ?, data = ? WHERE id = ?"), string(b.Type), b.Name, b.CreatedBy, string(data), string(b.ID))
+		return err
+	})
+}
+
+// insertVersion saves a replaced version of a badge or a type. A version left
+// over from restoring an older state is overwritten.
+func (s *sqlStore) insertVersion(tx *sql.Tx, table, idColumn, id string, version int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind("DELETE FROM "+table+" WHERE "+idColumn+" = ? AND version = ?"), id, version)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(s.rebind("INSERT INTO "+table+" ("+idColumn+", version, data) VALUES (?, ?, ?)"), id, version, string(data))
+	return err
+}
+
+func (s *sqlStore) GetBadgeHistory(badgeID badgesmodel.BadgeID) ([]*badgesmodel.Badge, error) {
+	current, err := s.GetBadge(badgeID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*badgesmodel.Badge{}
+	err = s.queryData(s.db, func(data []byte) error {
+		b := &badgesmodel.Badge{}
+		err := json.Unmarshal(data, b)
+		versions = append(versions, b)
+		return err
+	}, "SELECT data FROM "+sqlTableBadgeVersions+" WHERE badge_id = ? ORDER BY version", string(badgeID))
+	if err != nil {
+		return nil, err
+	}
+
+	return badgeHistory(versions, current), nil
+}
+
+func (s *sqlStore) GetTypeHistory(tID badgesmodel.BadgeType) (badgesmodel.BadgeTypeList, error) {
+	current, err := s.GetType(tID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := badgesmodel.BadgeTypeList{}
+	err = s.queryData(s.db, func(data []byte) error {
+		t := &badgesmodel.BadgeTypeDefinition{}
+		err := json.Unmarshal(data, t)
+		versions = append(versions, t)
+		return err
+	}, "SELECT data FROM "+sqlTableTypeVersions+" WHERE type_id = ? ORDER BY version", string(tID))
+	if err != nil {
+		return nil, err
+	}
+
+	return typeHistory(versions, current), nil
+}
+
+func (s *sqlStore) DeleteType(tID badgesmodel.BadgeType) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.rebind("DELETE FROM "+sqlTableOwnerships+" WHERE badge_id IN (SELECT id FROM "+sqlTableBadges+" WHERE type_id = ?)"), string(tID))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableBadges+" WHERE type_id = ?"), string(tID))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableSubscriptions+" WHERE type_id = ?"), string(tID))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableTypes+" WHERE id = ?"), string(tID))
+		return err
+	})
+}
+
+func (s *sqlStore) DeleteBadge(bID badgesmodel.BadgeID) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.rebind("DELETE FROM "+sqlTableOwnerships+" WHERE badge_id = ?"), string(bID))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableBadges+" WHERE id = ?"), string(bID))
+		return err
+	})
+}
+
+func (s *sqlStore) AddSubscription(tID badgesmodel.BadgeType, cID string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRow(s.rebind("SELECT COUNT(*) FROM "+sqlTableSubscriptions+" WHERE type_id = ? AND channel_id = ?"), string(tID), cID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		return s.insertSubscription(tx, badgesmodel.Subscription{TypeID: tID, ChannelID: cID})
+	})
+}
+
+func (s *sqlStore) RemoveSubscriptions(tID badgesmodel.BadgeType, cID string) error {
+	_, err := s.db.Exec(s.rebind("DELETE FROM "+sqlTableSubscriptions+" WHERE type_id = ? AND channel_id = ?"), string(tID), cID)
+	return err
+}
+
+func (s *sqlStore) GetTypeSubscriptions(tID badgesmodel.BadgeType) ([]string, error) {
+	rows, err := s.db.Query(s.rebind("SELECT channel_id FROM "+sqlTableSubscriptions+" WHERE type_id = ?"), string(tID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []string{}
+	for rows.Next() {
+		var channelID string
+		if err = rows.Scan(&channelID); err != nil {
+			return nil, err
+		}
+		out = append(out, channelID)
+	}
+
+	return out, rows.Err()
+}
+
+func (s *sqlStore) GetChannelSubscriptions(cID string) ([]*badgesmodel.BadgeTypeDefinition, error) {
+	return s.getTypes(s.db, "WHERE id IN (SELECT type_id FROM "+sqlTableSubscriptions+" WHERE channel_id = ?)", cID)
+}
+
+func (s *sqlStore) EnsureBadges(badges []*badgesmodel.Badge, pluginID, botID string) ([]*badgesmodel.Badge, error) {
+	types, err := s.getTypes(s.db, "WHERE created_by = ?", botID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tDef *badgesmodel.BadgeTypeDefinition
+	if len(types) > 0 {
+		tDef = types[0]
+	} else {
+		tDef, err = s.AddType(&badgesmodel.BadgeTypeDefinition{
+			Name:      "Plugin badges: " + pluginID,
+			CreatedBy: botID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := s.getBadges(s.db, "WHERE created_by = ?", botID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*badgesmodel.Badge{}
+	for _, pb := range badges {
+		found := false
+		for _, b := range existing {
+			if b.Name == pb.Name {
+				found = true
+				out = append(out, b)
+				break
+			}
+		}
+		if !found {
+			pb.Type = tDef.ID
+			pb.CreatedBy = botID
+			newBadge, err := s.AddBadge(pb)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, newBadge)
+		}
+	}
+
+	return out, nil
+}
+
+func (s *sqlStore) Dump() (*StoreDump, error) {
+	dump := &StoreDump{}
+	var err error
+
+	dump.Types, err = s.getTypes(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	dump.Badges, err = s.getBadges(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	dump.Ownerships, err = s.getOwnerships(s.db, "")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query("SELECT type_id, channel_id FROM " + sqlTableSubscriptions + " ORDER BY type_id, channel_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	dump.Subscriptions = []badgesmodel.Subscription{}
+	for rows.Next() {
+		var typeID, channelID string
+		if err = rows.Scan(&typeID, &channelID); err != nil {
+			return nil, err
+		}
+		dump.Subscriptions = append(dump.Subscriptions, badgesmodel.Subscription{TypeID: badgesmodel.BadgeType(typeID), ChannelID: channelID})
+	}
+
+	return dump, rows.Err()
+}
+
+func (s *sqlStore) RemoveOrphans(badges []badgesmodel.BadgeID, ownerships badgesmodel.OwnershipList, subscriptions []badgesmodel.Subscription) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		for _, o := range ownerships {
+			_, err := tx.Exec(s.rebind("DELETE FROM "+sqlTableOwnerships+" WHERE id = ?"), o.GrantID)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, bID := range badges {
+			_, err := tx.Exec(s.rebind("DELETE FROM "+sqlTableOwnerships+" WHERE badge_id = ?"), string(bID))
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(s.rebind("DELETE FROM "+sqlTableBadges+" WHERE id = ?"), string(bID))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, sub := range subscriptions {
+			_, err := tx.Exec(s.rebind("DELETE FROM "+sqlTableSubscriptions+" WHERE type_id = ? AND channel_id = ?"), string(sub.TypeID), sub.ChannelID)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqlStore) Restore(dump *StoreDump) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		for _, table := range []string{sqlTableOwnerships, sqlTableSubscriptions, sqlTableBadges, sqlTableTypes} {
+			_, err := tx.Exec("DELETE FROM " + table)
+			if err != nil {
+				return err
+			}
+		}
+
+		// created_at only keeps the original ordering, as the models have no creation time.
+		createdAt := model.GetMillis()
+		for i, t := range dump.Types {
+			if err := s.insertType(tx, t, createdAt+int64(i)); err != nil {
+				return err
+			}
+		}
+
+		for i, b := range dump.Badges {
+			if err := s.insertBadge(tx, b, createdAt+int64(i)); err != nil {
+				return err
+			}
+		}
+
+		for _, o := range withGrantIDs(dump.Ownerships) {
+			if err := s.insertOwnership(tx, o); err != nil {
+				return err
+			}
+		}
+
+		for _, sub := range dump.Subscriptions {
+			if err := s.insertSubscription(tx, sub); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSQLStore connects to the database given by the same variables the server
+// uses, so the test runs against both Postgres and MySQL in CI. Without them it
+// runs against a local SQLite database.
+func setupSQLStore(t *testing.T) *sqlStore {
+	driver := os.Getenv("MM_SQLSETTINGS_DRIVERNAME")
+	dataSource := os.Getenv("MM_SQLSETTINGS_DATASOURCE")
+	if driver == "" || dataSource == "" {
+		// Transactions take the write lock when they begin, as the rows they
+		// read cannot be locked, and wait for each other instead of failing.
+		driver = sqlDriverSQLite
+		dataSource = "file:" + filepath.Join(t.TempDir(), "badges.db") + "?_busy_timeout=10000&_txlock=immediate"
+	}
+
+	db, err := sql.Open(driver, dataSource)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	api := &plugintest.API{}
+	api.On("GetUser", mock.Anything).Return(nil, model.NewAppError("GetUser", "not_found", nil, "", 404)).Maybe()
+	api.On("GetConfig").Return(&model.Config{}).Maybe()
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	s, err := NewSQLStore(db, driver, api)
+	require.NoError(t, err)
+
+	for _, table := range []string{sqlTableOwnerships, sqlTableSubscriptions, sqlTableBadges, sqlTableTypes} {
+		_, err = db.Exec("DELETE FROM " + table)
+		require.NoError(t, err)
+	}
+
+	return s.(*sqlStore)
+}
+
+func TestSQLStore(t *testing.T) {
+	s := setupSQLStore(t)
+	userID := model.NewId()
+	granterID := model.NewId()
+
+	badgeType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "type", CreatedBy: granterID})
+	require.NoError(t, err)
+
+	single, err := s.AddBadge(&badgesmodel.Badge{Name: "single", Image: "smile", ImageType: badgesmodel.ImageTypeEmoji, Type: badgeType.ID, CreatedBy: granterID})
+	require.NoError(t, err)
+	multiple, err := s.AddBadge(&badgesmodel.Badge{Name: "multiple", Image: "star", ImageType: badgesmodel.ImageTypeEmoji, Type: badgeType.ID, CreatedBy: granterID, Multiple: true})
+	require.NoError(t, err)
+
+	t.Run("grant", func(t *testing.T) {
+		granted, err := s.GrantBadge(single.ID, userID, granterID, "")
+		require.NoError(t, err)
+		assert.NotNil(t, granted)
+
+		granted, err = s.GrantBadge(single.ID, userID, granterID, "")
+		require.NoError(t, err)
+		assert.Nil(t, granted, "a single badge cannot be granted twice")
+
+		for i := 0; i < 2; i++ {
+			granted, err = s.GrantBadge(multiple.ID, userID, granterID, "")
+			require.NoError(t, err)
+			assert.NotNil(t, granted)
+		}
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		assert.Len(t, userBadges, 3)
+		assert.Equal(t, "type", userBadges[0].TypeName)
+
+		all, err := s.GetAllBadges()
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		for _, b := range all {
+			if b.ID == multiple.ID {
+				assert.Equal(t, 1, b.Granted)
+				assert.Equal(t, 2, b.GrantedTimes)
+			}
+		}
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		removed, err := s.RevokeBadge(multiple.ID, userID, false)
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+
+		details, err := s.GetBadgeDetails(multiple.ID)
+		require.NoError(t, err)
+		assert.Len(t, details.Owners, 1)
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		channelID := model.NewId()
+		require.NoError(t, s.AddSubscription(badgeType.ID, channelID))
+		require.NoError(t, s.AddSubscription(badgeType.ID, channelID))
+
+		channels, err := s.GetTypeSubscriptions(badgeType.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{channelID}, channels)
+
+		types, err := s.GetChannelSubscriptions(channelID)
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Equal(t, badgeType.ID, types[0].ID)
+	})
+
+	t.Run("dump and restore", func(t *testing.T) {
+		dump, err := s.Dump()
+		require.NoError(t, err)
+		assert.Len(t, dump.Ownerships, 2)
+
+		require.NoError(t, s.Restore(dump))
+
+		restored, err := s.Dump()
+		require.NoError(t, err)
+		assert.Equal(t, dump, restored)
+	})
+
+	t.Run("delete type cascades", func(t *testing.T) {
+		require.NoError(t, s.DeleteType(badgeType.ID))
+
+		badges, err := s.GetRawBadges()
+		require.NoError(t, err)
+		assert.Empty(t, badges)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		assert.Empty(t, userBadges)
+	})
+}
@@ -0,0 +1,847 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeImplementations returns a constructor for every Store, so the same
+// contract runs against all of them.
+func storeImplementations() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return newMemStore()
+		},
+		"kv": func(t *testing.T) Store {
+			return NewStore(newFakeAPI())
+		},
+		"cached kv": func(t *testing.T) Store {
+			api := newFakeAPI()
+			return NewCachedStore(NewStore(api), api)
+		},
+		"snapshot kv": func(t *testing.T) Store {
+			api := newFakeAPI()
+			return NewSnapshotStore(NewStore(api), api, SnapshotsToKeep)
+		},
+		"audit kv": func(t *testing.T) Store {
+			api := newFakeAPI()
+			return NewAuditStore(NewStore(api), api, model.NewId())
+		},
+		"sql": func(t *testing.T) Store {
+			return setupSQLStore(t)
+		},
+	}
+}
+
+func addTestType(t *testing.T, s Store) *badgesmodel.BadgeTypeDefinition {
+	badgeType, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "type", CreatedBy: model.NewId()})
+	require.NoError(t, err)
+	return badgeType
+}
+
+func addTestBadge(t *testing.T, s Store, tID badgesmodel.BadgeType, multiple bool) *badgesmodel.Badge {
+	badge, err := s.AddBadge(&badgesmodel.Badge{
+		Name:      "badge " + model.NewId()[:6],
+		Image:     "smile",
+		ImageType: badgesmodel.ImageTypeEmoji,
+		Type:      tID,
+		CreatedBy: model.NewId(),
+		Multiple:  multiple,
+	})
+	require.NoError(t, err)
+	return badge
+}
+
+func TestStoreContract(t *testing.T) {
+	for name, newStore := range storeImplementations() {
+		newStore := newStore
+		t.Run(name, func(t *testing.T) {
+			testStoreContract(t, newStore)
+		})
+	}
+}
+
+func testStoreContract(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("add, update and delete type", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		assert.NotEmpty(t, badgeType.ID)
+
+		badgeType.Name = "renamed"
+		require.NoError(t, s.UpdateType(badgeType))
+
+		got, err := s.GetType(badgeType.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "renamed", got.Name)
+
+		err = s.UpdateType(&badgesmodel.BadgeTypeDefinition{ID: "missing", Name: "missing"})
+		assert.Error(t, err)
+
+		require.NoError(t, s.DeleteType(badgeType.ID))
+		_, err = s.GetType(badgeType.ID)
+		assert.Error(t, err)
+
+		types, err := s.GetRawTypes()
+		require.NoError(t, err)
+		assert.Empty(t, types)
+	})
+
+	t.Run("add and update badge", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+
+		_, err := s.AddBadge(&badgesmodel.Badge{Name: "no image", Type: badgeType.ID})
+		assert.Equal(t, errInvalidBadge, err)
+
+		_, err = s.AddBadge(&badgesmodel.Badge{Name: "no type", Image: "smile", ImageType: badgesmodel.ImageTypeEmoji, Type: "missing"})
+		assert.Error(t, err)
+
+		badge := addTestBadge(t, s, badgeType.ID, false)
+		badge.Description = "updated"
+		badge.Tags = []string{"sales", "onboarding"}
+		require.NoError(t, s.UpdateBadge(badge))
+
+		got, err := s.GetBadge(badge.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "updated", got.Description)
+		assert.Equal(t, []string{"sales", "onboarding"}, got.Tags)
+
+		_, err = s.GetBadge("missing")
+		assert.Error(t, err)
+		assert.Error(t, s.UpdateBadge(&badgesmodel.Badge{ID: "missing"}))
+	})
+
+	t.Run("returned values are copies", func(t *testing.T) {
+		s := newStore(t)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+
+		got, err := s.GetBadge(badge.ID)
+		require.NoError(t, err)
+		got.Name = "changed"
+
+		badges, err := s.GetRawBadges()
+		require.NoError(t, err)
+		require.Len(t, badges, 1)
+		assert.Equal(t, badge.Name, badges[0].Name)
+	})
+
+	t.Run("single badges are granted once", func(t *testing.T) {
+		s := newStore(t)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+		userID := model.NewId()
+
+		granted, err := s.GrantBadge(badge.ID, userID, model.NewId(), "reason")
+		require.NoError(t, err)
+		assert.NotNil(t, granted)
+
+		granted, err = s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		assert.Nil(t, granted)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1)
+		assert.Equal(t, "reason", userBadges[0].Reason)
+		assert.Equal(t, "type", userBadges[0].TypeName)
+
+		_, err = s.GrantBadge("missing", userID, model.NewId(), "")
+		assert.Error(t, err)
+	})
+
+	t.Run("multiple badges are granted many times", func(t *testing.T) {
+		s := newStore(t)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+		userID := model.NewId()
+
+		for i := 0; i < 3; i++ {
+			granted, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+			require.NoError(t, err)
+			assert.NotNil(t, granted)
+		}
+		granted, err := s.GrantBadge(badge.ID, model.NewId(), model.NewId(), "")
+		require.NoError(t, err)
+		assert.NotNil(t, granted)
+
+		all, err := s.GetAllBadges()
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, 2, all[0].Granted)
+		assert.Equal(t, 4, all[0].GrantedTimes)
+
+		details, err := s.GetBadgeDetails(badge.ID)
+		require.NoError(t, err)
+		assert.Len(t, details.Owners, 4)
+	})
+
+	t.Run("user badges are newest first", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		first := addTestBadge(t, s, badgeType.ID, false)
+		second := addTestBadge(t, s, badgeType.ID, false)
+		userID := model.NewId()
+
+		_, err := s.GrantBadge(first.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(second.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 2)
+		assert.Equal(t, second.ID, userBadges[0].ID)
+		assert.Equal(t, first.ID, userBadges[1].ID)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		s := newStore(t)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+		userID := model.NewId()
+		for _, reason := range []string{"first", "second", "third"} {
+			_, err := s.GrantBadge(badge.ID, userID, model.NewId(), reason)
+			require.NoError(t, err)
+		}
+
+		removed, err := s.RevokeBadge(badge.ID, model.NewId(), true)
+		require.NoError(t, err)
+		assert.Equal(t, 0, removed, "users without the badge have nothing to revoke")
+
+		removed, err = s.RevokeBadge(badge.ID, userID, false)
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+
+		details, err := s.GetBadgeDetails(badge.ID)
+		require.NoError(t, err)
+		require.Len(t, details.Owners, 2)
+		assert.Equal(t, "first", details.Owners[0].Reason)
+		assert.Equal(t, "second", details.Owners[1].Reason)
+
+		otherUserID := model.NewId()
+		_, err = s.GrantBadge(badge.ID, otherUserID, model.NewId(), "other")
+		require.NoError(t, err)
+
+		removed, err = s.RevokeBadge(badge.ID, userID, true)
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+
+		removed, err = s.RevokeBadge(badge.ID, userID, true)
+		require.NoError(t, err)
+		assert.Equal(t, 0, removed)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		assert.Empty(t, userBadges)
+		userBadges, err = s.GetUserBadges(otherUserID)
+		require.NoError(t, err)
+		assert.Len(t, userBadges, 1, "other users keep their grants")
+
+		_, err = s.RevokeBadge("missing", userID, true)
+		assert.Error(t, err)
+	})
+
+	t.Run("delete badge removes its grants", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		badge := addTestBadge(t, s, badgeType.ID, false)
+		kept := addTestBadge(t, s, badgeType.ID, false)
+		userID := model.NewId()
+		_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(kept.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		require.NoError(t, s.DeleteBadge(badge.ID))
+
+		_, err = s.GetBadgeDetails(badge.ID)
+		assert.Error(t, err)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1)
+		assert.Equal(t, kept.ID, userBadges[0].ID)
+	})
+
+	t.Run("delete type removes its badges and grants", func(t *testing.T) {
+		s := newStore(t)
+		deleted := addTestType(t, s)
+		kept := addTestType(t, s)
+		badge := addTestBadge(t, s, deleted.ID, false)
+		keptBadge := addTestBadge(t, s, kept.ID, false)
+		userID := model.NewId()
+		channelID := model.NewId()
+		_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(keptBadge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		require.NoError(t, s.AddSubscription(deleted.ID, channelID))
+
+		require.NoError(t, s.DeleteType(deleted.ID))
+
+		badges, err := s.GetRawBadges()
+		require.NoError(t, err)
+		require.Len(t, badges, 1)
+		assert.Equal(t, keptBadge.ID, badges[0].ID)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1)
+		assert.Equal(t, keptBadge.ID, userBadges[0].ID)
+
+		types, err := s.GetChannelSubscriptions(channelID)
+		require.NoError(t, err)
+		assert.Empty(t, types)
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		channelID := model.NewId()
+
+		require.NoError(t, s.AddSubscription(badgeType.ID, channelID))
+		require.NoError(t, s.AddSubscription(badgeType.ID, channelID))
+
+		channels, err := s.GetTypeSubscriptions(badgeType.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{channelID}, channels)
+
+		types, err := s.GetChannelSubscriptions(channelID)
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Equal(t, badgeType.ID, types[0].ID)
+
+		require.NoError(t, s.RemoveSubscriptions(badgeType.ID, channelID))
+		require.NoError(t, s.RemoveSubscriptions(badgeType.ID, channelID))
+
+		channels, err = s.GetTypeSubscriptions(badgeType.ID)
+		require.NoError(t, err)
+		assert.Empty(t, channels)
+	})
+
+	t.Run("ensure badges is idempotent", func(t *testing.T) {
+		s := newStore(t)
+		botID := model.NewId()
+		toEnsure := func() []*badgesmodel.Badge {
+			return []*badgesmodel.Badge{
+				{Name: "first", Image: "smile", ImageType: badgesmodel.ImageTypeEmoji},
+				{Name: "second", Image: "star", ImageType: badgesmodel.ImageTypeEmoji},
+			}
+		}
+
+		first, err := s.EnsureBadges(toEnsure(), "plugin", botID)
+		require.NoError(t, err)
+		require.Len(t, first, 2)
+
+		second, err := s.EnsureBadges(toEnsure(), "plugin", botID)
+		require.NoError(t, err)
+		require.Len(t, second, 2)
+		assert.Equal(t, first[0].ID, second[0].ID)
+		assert.Equal(t, first[1].ID, second[1].ID)
+
+		types, err := s.GetRawTypes()
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Equal(t, botID, types[0].CreatedBy)
+
+		badges, err := s.GetRawBadges()
+		require.NoError(t, err)
+		assert.Len(t, badges, 2)
+	})
+
+	t.Run("dump and restore", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		badge := addTestBadge(t, s, badgeType.ID, true)
+		userID := model.NewId()
+		for i := 0; i < 2; i++ {
+			_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.AddSubscription(badgeType.ID, model.NewId()))
+
+		dump, err := s.Dump()
+		require.NoError(t, err)
+		assert.Len(t, dump.Types, 1)
+		assert.Len(t, dump.Badges, 1)
+		assert.Len(t, dump.Ownerships, 2)
+		assert.Len(t, dump.Subscriptions, 1)
+
+		require.NoError(t, s.DeleteType(badgeType.ID))
+		require.NoError(t, s.Restore(dump))
+
+		restored, err := s.Dump()
+		require.NoError(t, err)
+		assert.Equal(t, dump, restored)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		assert.Len(t, userBadges, 2)
+	})
+
+	t.Run("remove orphans", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		removed := addTestBadge(t, s, badgeType.ID, true)
+		kept := addTestBadge(t, s, badgeType.ID, true)
+		userID := model.NewId()
+		removedGrant, err := s.GrantBadge(kept.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(kept.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(removed.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		channelID := model.NewId()
+		require.NoError(t, s.AddSubscription(badgeType.ID, channelID))
+		require.NoError(t, s.AddSubscription(badgeType.ID, model.NewId()))
+
+		require.NoError(t, s.RemoveOrphans(
+			[]badgesmodel.BadgeID{removed.ID},
+			badgesmodel.OwnershipList{*removedGrant},
+			[]badgesmodel.Subscription{{TypeID: badgeType.ID, ChannelID: channelID}},
+		))
+
+		dump, err := s.Dump()
+		require.NoError(t, err)
+		require.Len(t, dump.Badges, 1)
+		assert.Equal(t, kept.ID, dump.Badges[0].ID)
+		require.Len(t, dump.Ownerships, 1)
+		assert.NotEqual(t, removedGrant.GrantID, dump.Ownerships[0].GrantID)
+		require.Len(t, dump.Subscriptions, 1)
+		assert.NotEqual(t, channelID, dump.Subscriptions[0].ChannelID)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		assert.Len(t, userBadges, 1)
+	})
+
+	t.Run("badge and type history", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		badge := addTestBadge(t, s, badgeType.ID, true)
+		assert.Equal(t, badgesmodel.FirstVersion, badge.Version)
+		userID := model.NewId()
+		_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		originalName := badge.Name
+		editorID := model.NewId()
+		badge.Name = "renamed"
+		badge.UpdatedBy = editorID
+		require.NoError(t, s.UpdateBadge(badge))
+		_, err = s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		history, err := s.GetBadgeHistory(badge.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, 1, history[0].Version)
+		assert.Equal(t, originalName, history[0].Name)
+		assert.Equal(t, 2, history[1].Version)
+		assert.Equal(t, "renamed", history[1].Name)
+		assert.Equal(t, editorID, history[1].UpdatedBy)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 2)
+		assert.Equal(t, 2, userBadges[0].BadgeVersion)
+		assert.Equal(t, 1, userBadges[1].BadgeVersion)
+
+		badgeType.Name = "renamed"
+		require.NoError(t, s.UpdateType(badgeType))
+		typeHistory, err := s.GetTypeHistory(badgeType.ID)
+		require.NoError(t, err)
+		require.Len(t, typeHistory, 2)
+		assert.Equal(t, "type", typeHistory[0].Name)
+		assert.Equal(t, 2, typeHistory[1].Version)
+
+		_, err = s.GetBadgeHistory("missing")
+		assert.Error(t, err)
+	})
+
+	t.Run("archived badges cannot be granted", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		badge := addTestBadge(t, s, badgeType.ID, true)
+		userID := model.NewId()
+		_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		badge.Archived = true
+		require.NoError(t, s.UpdateBadge(badge))
+		_, err = s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		assert.Equal(t, errBadgeArchived, err)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1, "archived badges stay on the profiles")
+		assert.True(t, userBadges[0].Archived)
+
+		badge.Archived = false
+		require.NoError(t, s.UpdateBadge(badge))
+		badgeType.Archived = true
+		require.NoError(t, s.UpdateType(badgeType))
+		_, err = s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		assert.Equal(t, errBadgeArchived, err)
+
+		badgeType.Archived = false
+		require.NoError(t, s.UpdateType(badgeType))
+		_, err = s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("grants have their own ID", func(t *testing.T) {
+		s := newStore(t)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, true)
+		userID := model.NewId()
+
+		first, err := s.GrantBadge(badge.ID, userID, model.NewId(), "first")
+		require.NoError(t, err)
+		second, err := s.GrantBadge(badge.ID, userID, model.NewId(), "second")
+		require.NoError(t, err)
+		require.NotEmpty(t, first.GrantID)
+		assert.NotEqual(t, first.GrantID, second.GrantID)
+
+		found, err := s.GetGrant(first.GrantID)
+		require.NoError(t, err)
+		assert.Equal(t, "first", found.Reason)
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 2)
+		assert.Equal(t, second.GrantID, userBadges[0].GrantID)
+
+		_, err = s.RevokeBadge(badge.ID, userID, false)
+		require.NoError(t, err)
+		_, err = s.GetGrant(second.GrantID)
+		assert.Equal(t, errGrantNotFound, err)
+
+		dump, err := s.Dump()
+		require.NoError(t, err)
+		dump.Ownerships[0].GrantID = ""
+		require.NoError(t, s.Restore(dump))
+		userBadges, err = s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1)
+		assert.NotEmpty(t, userBadges[0].GrantID, "restored grants without ID get one")
+	})
+
+	t.Run("listings are paged, sorted and filtered", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		otherType := addTestType(t, s)
+		popular := addTestBadge(t, s, badgeType.ID, true)
+		popular.Name = "b popular"
+		require.NoError(t, s.UpdateBadge(popular))
+		other := addTestBadge(t, s, otherType.ID, true)
+		other.Name = "a other"
+		require.NoError(t, s.UpdateBadge(other))
+		userID := model.NewId()
+		granterID := model.NewId()
+
+		grantIDs := map[string]bool{}
+		for i := 0; i < 5; i++ {
+			granted, err := s.GrantBadge(popular.ID, userID, granterID, "")
+			require.NoError(t, err)
+			grantIDs[granted.GrantID] = true
+		}
+		_, err := s.GrantBadge(popular.ID, model.NewId(), model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(other.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		opts := ListOptions{PerPage: 2, Desc: true, TypeID: badgeType.ID, GrantedBy: granterID}
+		seen := map[string]bool{}
+		// Grants are ordered by millisecond, like the database columns.
+		var last int64
+		for page := 0; ; page++ {
+			require.Less(t, page, 5)
+			badges, next, err := s.ListUserBadges(userID, opts)
+			require.NoError(t, err)
+			for _, b := range badges {
+				assert.False(t, seen[b.GrantID], "grants are not repeated across pages")
+				seen[b.GrantID] = true
+				if last != 0 {
+					assert.LessOrEqual(t, toMillis(b.Time), last, "newest first")
+				}
+				last = toMillis(b.Time)
+			}
+			if next == "" {
+				break
+			}
+			assert.Len(t, badges, 2)
+			opts.Cursor = next
+		}
+		assert.Equal(t, grantIDs, seen)
+
+		byName, _, err := s.ListUserBadges(userID, ListOptions{Sort: ListSortName, PerPage: 1})
+		require.NoError(t, err)
+		require.Len(t, byName, 1)
+		assert.Equal(t, other.ID, byName[0].ID)
+
+		_, _, err = s.ListUserBadges(userID, ListOptions{Sort: ListSortPopularity})
+		assert.Equal(t, errInvalidSort, err)
+		_, _, err = s.ListUserBadges(userID, ListOptions{Cursor: "not a cursor"})
+		assert.Equal(t, errInvalidCursor, err)
+
+		all, next, err := s.ListAllBadges(ListOptions{Sort: ListSortPopularity, Desc: true, PerPage: 1})
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, popular.ID, all[0].ID)
+		all, next, err = s.ListAllBadges(ListOptions{Sort: ListSortPopularity, Desc: true, PerPage: 1, Cursor: next})
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, other.ID, all[0].ID)
+		assert.Empty(t, next)
+
+		all, _, err = s.ListAllBadges(ListOptions{TypeID: otherType.ID})
+		require.NoError(t, err)
+		require.Len(t, all, 1)
+		assert.Equal(t, other.ID, all[0].ID)
+
+		owners, next, err := s.ListBadgeOwners(popular.ID, ListOptions{PerPage: 4})
+		require.NoError(t, err)
+		assert.Len(t, owners, 4)
+		owners, next, err = s.ListBadgeOwners(popular.ID, ListOptions{PerPage: 4, Cursor: next})
+		require.NoError(t, err)
+		assert.Len(t, owners, 2)
+		assert.Empty(t, next)
+
+		owners, _, err = s.ListBadgeOwners(popular.ID, ListOptions{Until: time.Now().Add(-time.Hour)})
+		require.NoError(t, err)
+		assert.Empty(t, owners)
+	})
+
+	t.Run("time-limited badges expire and can be renewed", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		limited := addTestBadge(t, s, badgeType.ID, false)
+		limited.ValidityDays = 1
+		require.NoError(t, s.UpdateBadge(limited))
+		permanent := addTestBadge(t, s, badgeType.ID, false)
+		userID := model.NewId()
+		now := time.Now()
+
+		_, err := s.RenewBadge(limited.ID, userID)
+		assert.Equal(t, errOwnershipNotFound, err)
+
+		_, err = s.GrantBadge(limited.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(permanent.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		_, err = s.RenewBadge(permanent.ID, userID)
+		assert.Equal(t, errBadgeNotTimeLimited, err)
+
+		expiring, err := s.GetExpiringOwnerships(now)
+		require.NoError(t, err)
+		assert.Empty(t, expiring)
+		expiring, err = s.GetExpiringOwnerships(now.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		require.Len(t, expiring, 1)
+		assert.Equal(t, limited.ID, expiring[0].Badge)
+
+		limited.ValidityDays = 10
+		require.NoError(t, s.UpdateBadge(limited))
+		renewed, err := s.RenewBadge(limited.ID, userID)
+		require.NoError(t, err)
+		require.NotNil(t, renewed.ExpiresAt)
+		assert.True(t, renewed.ExpiresAt.After(now.AddDate(0, 0, 9)))
+
+		expiring, err = s.GetExpiringOwnerships(now.AddDate(0, 0, 2))
+		require.NoError(t, err)
+		assert.Empty(t, expiring, "renewing extends the grant")
+
+		userBadges, err := s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 2, "renewing does not grant the badge again")
+
+		expired, err := s.ExpireOwnerships(now.AddDate(0, 0, 11))
+		require.NoError(t, err)
+		require.Len(t, expired, 1)
+		assert.Equal(t, userID, expired[0].User)
+
+		userBadges, err = s.GetUserBadges(userID)
+		require.NoError(t, err)
+		require.Len(t, userBadges, 1)
+		assert.Equal(t, permanent.ID, userBadges[0].ID)
+		details, err := s.GetBadgeDetails(limited.ID)
+		require.NoError(t, err)
+		assert.Empty(t, details.Owners)
+	})
+
+	t.Run("ownerships since a time", func(t *testing.T) {
+		s := newStore(t)
+		badgeType := addTestType(t, s)
+		badge := addTestBadge(t, s, badgeType.ID, true)
+		deleted := addTestBadge(t, s, badgeType.ID, false)
+		userID := model.NewId()
+
+		first, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		_, err = s.GrantBadge(deleted.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		require.NoError(t, s.DeleteBadge(deleted.ID))
+		time.Sleep(5 * time.Millisecond)
+		second, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+
+		all, err := s.GetOwnerships(time.Time{})
+		require.NoError(t, err)
+		require.Len(t, all, 2, "grants of deleted badges are left out")
+		assert.True(t, first.IsSameGrant(all[0]))
+		assert.True(t, second.IsSameGrant(all[1]))
+
+		recent, err := s.GetOwnerships(second.Time)
+		require.NoError(t, err)
+		require.Len(t, recent, 1)
+		assert.True(t, second.IsSameGrant(recent[0]))
+	})
+
+	t.Run("history after restoring an older state", func(t *testing.T) {
+		s := newStore(t)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, false)
+		dump, err := s.Dump()
+		require.NoError(t, err)
+
+		for _, name := range []string{"second", "third"} {
+			badge.Name = name
+			require.NoError(t, s.UpdateBadge(badge))
+		}
+		require.NoError(t, s.Restore(dump))
+
+		history, err := s.GetBadgeHistory(badge.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 1, "versions newer than the restored one are dropped")
+
+		badge.Name = "fourth"
+		require.NoError(t, s.UpdateBadge(badge))
+		history, err = s.GetBadgeHistory(badge.ID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, "fourth", history[1].Name)
+		assert.Equal(t, 2, history[1].Version)
+	})
+}
+
+func TestKVStoreCompareAndSetConflicts(t *testing.T) {
+	setup := func(t *testing.T, multiple bool) (*fakeAPI, *store, *badgesmodel.Badge) {
+		api := newFakeAPI()
+		s := NewStore(api).(*store)
+		badge := addTestBadge(t, s, addTestType(t, s).ID, multiple)
+		return api, s, badge
+	}
+
+	t.Run("single badge granted by another server meanwhile", func(t *testing.T) {
+		api, s, badge := setup(t, false)
+		userID := model.NewId()
+
+		// The first write to the badge key loses the race against an identical grant.
+		api.beforeCompareAndSet = func(key string) {
+			if key != getBadgeOwnershipKey(badge.ID) {
+				return
+			}
+			api.beforeCompareAndSet = nil
+			granted, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+			require.NoError(t, err)
+			require.NotNil(t, granted)
+		}
+
+		granted, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		assert.Nil(t, granted)
+
+		userOwnership, _, err := s.getUserOwnershipList(userID)
+		require.NoError(t, err)
+		assert.Len(t, userOwnership, 1)
+		badgeOwnership, _, err := s.getBadgeOwnershipList(badge.ID)
+		require.NoError(t, err)
+		assert.Len(t, badgeOwnership, 1)
+	})
+
+	t.Run("multiple badge granted by another server meanwhile", func(t *testing.T) {
+		api, s, badge := setup(t, true)
+		userID := model.NewId()
+		otherUserID := model.NewId()
+
+		api.beforeCompareAndSet = func(key string) {
+			if key != getBadgeOwnershipKey(badge.ID) {
+				return
+			}
+			api.beforeCompareAndSet = nil
+			granted, err := s.GrantBadge(badge.ID, otherUserID, model.NewId(), "")
+			require.NoError(t, err)
+			require.NotNil(t, granted)
+		}
+
+		granted, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		require.NoError(t, err)
+		assert.NotNil(t, granted)
+
+		badgeOwnership, _, err := s.getBadgeOwnershipList(badge.ID)
+		require.NoError(t, err)
+		assert.Len(t, badgeOwnership, 2)
+	})
+
+	t.Run("grant undone when the user key keeps conflicting", func(t *testing.T) {
+		api, s, badge := setup(t, false)
+		userID := model.NewId()
+
+		// Another server writes the user key before every attempt.
+		conflicts := 0
+		api.beforeCompareAndSet = func(key string) {
+			if key == getUserOwnershipKey(userID) {
+				conflicts++
+				_ = api.KVSet(key, []byte(`[]`+strings.Repeat(" ", conflicts)))
+			}
+		}
+
+		_, err := s.GrantBadge(badge.ID, userID, model.NewId(), "")
+		assert.Error(t, err)
+
+		badgeOwnership, _, err := s.getBadgeOwnershipList(badge.ID)
+		require.NoError(t, err)
+		assert.Empty(t, badgeOwnership)
+	})
+
+	t.Run("concurrent grants", func(t *testing.T) {
+		_, s, badge := setup(t, true)
+
+		const grants = 10
+		userIDs := make([]string, grants)
+		errs := make([]error, grants)
+		var wg sync.WaitGroup
+		for i := 0; i < grants; i++ {
+			userIDs[i] = model.NewId()
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = s.GrantBadge(badge.ID, userIDs[i], model.NewId(), "")
+			}(i)
+		}
+		wg.Wait()
+
+		// Grants may give up after too many conflicts, but both keys must agree
+		// on which ones succeeded.
+		succeeded := 0
+		for i, userID := range userIDs {
+			userOwnership, _, err := s.getUserOwnershipList(userID)
+			require.NoError(t, err)
+			if errs[i] != nil {
+				assert.Empty(t, userOwnership)
+				continue
+			}
+			succeeded++
+			assert.Len(t, userOwnership, 1)
+		}
+		assert.NotZero(t, succeeded)
+
+		badgeOwnership, _, err := s.getBadgeOwnershipList(badge.ID)
+		require.NoError(t, err)
+		assert.Len(t, badgeOwnership, succeeded)
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// canCreateSubscription tells whether the user can add and remove the
+// subscriptions of the channel. Besides badge admins, the users who can manage
+// the roles of the channel can, which are its channel admins and the admins of
+// its team.
+func (p *Plugin) canCreateSubscription(user *model.User, channelID string) bool {
+	if p.getBadgeAdmins().isAdmin(user) {
+		return true
+	}
+	return channelID != "" && p.API.HasPermissionToChannel(user.Id, channelID, model.PERMISSION_MANAGE_CHANNEL_ROLES)
+}
+
+// canSubscribeType tells whether the user can subscribe the channel to the
+// type. Badge admins can subscribe it to any type. Channel and team admins only
+// to the announceable types whose grants can be posted on the channel.
+func (p *Plugin) canSubscribeType(user *model.User, t *badgesmodel.BadgeTypeDefinition, channelID string) bool {
+	if p.getBadgeAdmins().isAdmin(user) {
+		return true
+	}
+	return t.Announceable && !t.Archived && p.isChannelInTypeScope(channelID, t)
+}
+
+func (p *Plugin) filterSubscribableTypes(user *model.User, channelID string) (badgesmodel.BadgeTypeList, error) {
+	types, err := p.store.GetRawTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := badgesmodel.BadgeTypeList{}
+	for _, t := range types {
+		if p.canSubscribeType(user, t, channelID) {
+			out = append(out, t)
+		}
+	}
+
+	return out, nil
+}
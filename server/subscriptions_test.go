@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionPermissions(t *testing.T) {
+	s := newMemStore()
+	p := setupTestPlugin(s)
+	api := p.API.(*fakeAPI)
+
+	teamID := model.NewId()
+	channelID := model.NewId()
+	otherChannelID := model.NewId()
+	channelAdmin := &model.User{Id: model.NewId(), Username: "channeladmin", Roles: model.SYSTEM_USER_ROLE_ID}
+	member := &model.User{Id: model.NewId(), Username: "member", Roles: model.SYSTEM_USER_ROLE_ID}
+	sysadmin := &model.User{Id: model.NewId(), Username: "sysadmin", Roles: model.SYSTEM_ADMIN_ROLE_ID}
+	api.addUser(channelAdmin)
+	api.addUser(member)
+
+	api.On("HasPermissionToChannel", channelAdmin.Id, channelID, model.PERMISSION_MANAGE_CHANNEL_ROLES).Return(true)
+	api.On("HasPermissionToChannel", mock.Anything, mock.Anything, mock.Anything).Return(false)
+	api.On("GetChannel", channelID).Return(&model.Channel{Id: channelID, TeamId: teamID, Type: model.CHANNEL_OPEN}, nil)
+	api.On("GetTeam", teamID).Return(&model.Team{Id: teamID, DisplayName: "Sales"}, nil)
+
+	announceable, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "kudos", Announceable: true})
+	require.NoError(t, err)
+	otherTeam, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "elsewhere", Announceable: true, TeamID: model.NewId()})
+	require.NoError(t, err)
+	private, err := s.AddType(&badgesmodel.BadgeTypeDefinition{Name: "private", TeamID: teamID})
+	require.NoError(t, err)
+
+	t.Run("channel admins manage their own channels", func(t *testing.T) {
+		assert.True(t, p.canCreateSubscription(channelAdmin, channelID))
+		assert.False(t, p.canCreateSubscription(channelAdmin, otherChannelID))
+		assert.False(t, p.canCreateSubscription(member, channelID))
+		assert.True(t, p.canCreateSubscription(sysadmin, otherChannelID))
+	})
+
+	t.Run("only announceable types in scope", func(t *testing.T) {
+		types, err := p.filterSubscribableTypes(channelAdmin, channelID)
+		require.NoError(t, err)
+		require.Len(t, types, 1)
+		assert.Equal(t, announceable.ID, types[0].ID)
+
+		assert.False(t, p.canSubscribeType(channelAdmin, otherTeam, channelID))
+		assert.False(t, p.canSubscribeType(channelAdmin, private, channelID))
+		assert.True(t, p.canSubscribeType(sysadmin, private, channelID))
+	})
+
+	t.Run("create and list from the command", func(t *testing.T) {
+		var message string
+		api.On("SendEphemeralPost", channelAdmin.Id, mock.Anything).Return(&model.Post{}).Run(func(args mock.Arguments) {
+			message = args.Get(1).(*model.Post).Message
+		})
+		extra := &model.CommandArgs{UserId: channelAdmin.Id, ChannelId: channelID}
+
+		_, _, err := p.runCreateSubscription([]string{"--type", string(private.ID)}, extra)
+		require.Error(t, err)
+		_, _, err = p.runCreateSubscription([]string{"--type", string(announceable.ID)}, extra)
+		require.NoError(t, err)
+
+		_, _, err = p.runListSubscription(nil, extra)
+		require.NoError(t, err)
+		assert.Contains(t, message, "kudos")
+		assert.NotContains(t, message, "private")
+
+		_, _, err = p.runDeleteSubscription([]string{"--type", string(announceable.ID)}, &model.CommandArgs{UserId: member.Id, ChannelId: channelID})
+		assert.Error(t, err)
+	})
+}
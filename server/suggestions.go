@@ -16,6 +16,10 @@ func (p *Plugin) filterGrantBadges(user *model.User) ([]*badgesmodel.Badge, erro
 		return nil, err
 	}
 
+	// The scope and the memberships are checked once per type, as they need
+	// the team, channel and group memberships of the user.
+	inScope := map[badgesmodel.BadgeType]bool{}
+	memberships := map[badgesmodel.BadgeType]*typeMembership{}
 	out := []*badgesmodel.Badge{}
 	for _, b := range badges {
 		badgeType := types.GetType(b.Type)
@@ -23,7 +27,46 @@ func (p *Plugin) filterGrantBadges(user *model.User) ([]*badgesmodel.Badge, erro
 			p.mm.Log.Debug("Badge with missing type", "badge", b)
 			continue
 		}
-		if canGrantBadge(user, p.badgeAdminUserID, b, badgeType) {
+		membership, ok := memberships[badgeType.ID]
+		if !ok {
+			membership = p.getTypeMembership(user, badgeType)
+			memberships[badgeType.ID] = membership
+		}
+		if !canGrantBadge(user, p.getBadgeAdmins(), membership, b, badgeType) {
+			continue
+		}
+		allowed, ok := inScope[badgeType.ID]
+		if !ok {
+			allowed = p.isInTypeScope(user.Id, badgeType)
+			inScope[badgeType.ID] = allowed
+		}
+		if allowed {
+			out = append(out, b)
+		}
+	}
+
+	return out, nil
+}
+
+func (p *Plugin) filterRevokeBadges(user *model.User) ([]*badgesmodel.Badge, error) {
+	badges, err := p.store.GetRawBadges()
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := p.store.GetRawTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*badgesmodel.Badge{}
+	for _, b := range badges {
+		badgeType := types.GetType(b.Type)
+		if badgeType == nil {
+			p.mm.Log.Debug("Badge with missing type", "badge", b)
+			continue
+		}
+		if canRevokeBadge(user, p.getBadgeAdmins(), b, badgeType) {
 			out = append(out, b)
 		}
 	}
@@ -39,7 +82,7 @@ func (p *Plugin) filterCreateBadgeTypes(user *model.User) (badgesmodel.BadgeType
 
 	out := badgesmodel.BadgeTypeList{}
 	for _, t := range types {
-		if canCreateBadge(user, p.badgeAdminUserID, t) {
+		if p.canCreateScopedBadge(user, t) {
 			out = append(out, t)
 		}
 	}
@@ -55,7 +98,7 @@ func (p *Plugin) filterEditTypes(user *model.User) (badgesmodel.BadgeTypeList, e
 
 	out := badgesmodel.BadgeTypeList{}
 	for _, t := range types {
-		if canEditType(user, p.badgeAdminUserID, t) {
+		if p.canManageType(user, t) {
 			out = append(out, t)
 		}
 	}
@@ -69,9 +112,18 @@ func (p *Plugin) filterEditBadges(user *model.User) ([]*badgesmodel.Badge, error
 		return nil, err
 	}
 
+	types, err := p.store.GetRawTypes()
+	if err != nil {
+		return nil, err
+	}
+
 	out := []*badgesmodel.Badge{}
 	for _, b := range bb {
-		if canEditBadge(user, p.badgeAdminUserID, b) {
+		badgeType := types.GetType(b.Type)
+		if badgeType == nil {
+			badgeType = &badgesmodel.BadgeTypeDefinition{ID: b.Type}
+		}
+		if p.canEditScopedBadge(user, b, badgeType) {
 			out = append(out, b)
 		}
 	}
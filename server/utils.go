@@ -3,124 +3,81 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/larkox/mattermost-plugin-badges/badgesmodel"
 	"github.com/mattermost/mattermost-server/v5/model"
 )
 
 func areRolesAllowed(userRoles []string, allowedRoles map[string]bool) bool {
+	return matchRole(userRoles, allowedRoles) != ""
+}
+
+// matchRole returns an allowed role the user has, or an empty string.
+func matchRole(userRoles []string, allowedRoles map[string]bool) string {
 	for ar, b := range allowedRoles {
 		if !b {
 			continue
 		}
 		for _, ur := range userRoles {
 			if ar == ur {
-				return true
+				return ar
 			}
 		}
 	}
 
-	return false
+	return ""
 }
 
-func canGrantBadge(user *model.User, badgeAdminID string, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) bool {
-	if badgeAdminID != "" && user.Id == badgeAdminID {
-		return true
-	}
-
-	if user.IsSystemAdmin() {
-		return true
-	}
-
-	if badgeType.CreatedBy == user.Id {
-		return true
-	}
-
-	if badge.CreatedBy == user.Id {
-		return true
-	}
-
-	blocked := badgeType.CanGrant.BlockList[user.Id]
-	if blocked {
-		return false
-	}
-
-	if areRolesAllowed(user.GetRoles(), badgeType.CanGrant.Roles) {
-		return true
-	}
-
-	allowed := badgeType.CanGrant.AllowList[user.Id]
-	if allowed {
-		return true
-	}
-
-	return badgeType.CanGrant.Everyone
+// typeMembership is what the permission schemes of a type check besides the user
+// ID: the system roles of the user and its roles on the team and channel of the
+// type scope, and the IDs of the groups it is in. GroupsUnknown is set when the
+// groups could not be looked up.
+type typeMembership struct {
+	Roles         []string
+	Groups        map[string]bool
+	GroupsUnknown bool
 }
 
-func canCreateBadge(user *model.User, badgeAdminID string, badgeType *badgesmodel.BadgeTypeDefinition) bool {
-	if badgeAdminID != "" && user.Id == badgeAdminID {
-		return true
-	}
-
-	if user.IsSystemAdmin() {
-		return true
-	}
-
-	if badgeType.CreatedBy == user.Id {
-		return true
-	}
-
-	blocked := badgeType.CanCreate.BlockList[user.Id]
-	if blocked {
-		return false
-	}
-
-	if areRolesAllowed(user.GetRoles(), badgeType.CanCreate.Roles) {
-		return true
-	}
-
-	allowed := badgeType.CanCreate.AllowList[user.Id]
-	if allowed {
-		return true
+func isAnyGroupListed(listed map[string]bool, groups map[string]bool) bool {
+	for id, b := range listed {
+		if b && groups[id] {
+			return true
+		}
 	}
+	return false
+}
 
-	return badgeType.CanCreate.Everyone
+func canGrantBadge(user *model.User, admins *badgeAdminSet, membership *typeMembership, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) bool {
+	return decideGrantBadge(user, admins, membership, badge, badgeType).Allowed
 }
 
-func canEditType(user *model.User, badgeAdminID string, badgeType *badgesmodel.BadgeTypeDefinition) bool {
-	if badgeAdminID != "" && user.Id == badgeAdminID {
-		return true
-	}
+func canRevokeBadge(user *model.User, admins *badgeAdminSet, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) bool {
+	return decideRevokeBadge(user, admins, badge, badgeType).Allowed
+}
 
-	return user.IsSystemAdmin()
+func canCreateBadge(user *model.User, admins *badgeAdminSet, membership *typeMembership, badgeType *badgesmodel.BadgeTypeDefinition) bool {
+	return decideCreateBadge(user, admins, membership, badgeType).Allowed
 }
 
-func canEditBadge(user *model.User, badgeAdminID string, badge *badgesmodel.Badge) bool {
-	if badgeAdminID != "" && user.Id == badgeAdminID {
-		return true
-	}
+func canEditType(user *model.User, admins *badgeAdminSet, membership *typeMembership, badgeType *badgesmodel.BadgeTypeDefinition) bool {
+	return decideEditType(user, admins, membership, badgeType).Allowed
+}
 
-	return user.IsSystemAdmin() || user.Id == badge.CreatedBy
+func canEditBadge(user *model.User, admins *badgeAdminSet, membership *typeMembership, badge *badgesmodel.Badge, badgeType *badgesmodel.BadgeTypeDefinition) bool {
+	return decideEditBadge(user, admins, membership, badge, badgeType).Allowed
 }
 
-func canCreateType(user *model.User, badgeAdminID string, isPlugin bool) bool {
+func canCreateType(user *model.User, admins *badgeAdminSet, isPlugin bool) bool {
 	if isPlugin {
 		return true
 	}
 
-	if badgeAdminID != "" && user.Id == badgeAdminID {
-		return true
-	}
-
-	return user.IsSystemAdmin()
+	return admins.isAdmin(user)
 }
 
-func canCreateSubscription(user *model.User, badgeAdminID string, channelID string) bool {
-	if badgeAdminID != "" && user.Id == badgeAdminID {
-		return true
-	}
-
-	return user.IsSystemAdmin()
+func isBadgesAdmin(user *model.User, admins *badgeAdminSet) bool {
+	return admins.isAdmin(user)
 }
 
 func dumpObject(o interface{}) {
@@ -144,13 +101,7 @@ func (p *Plugin) notifyGrant(badgeID badgesmodel.BadgeID, granter string, grante
 	subs, _ := p.store.GetTypeSubscriptions(b.Type)
 
 	if errBadge == nil && errUser == nil {
-		image := ""
-		switch b.ImageType {
-		case badgesmodel.ImageTypeEmoji:
-			image = fmt.Sprintf(":%s: ", b.Image)
-		case badgesmodel.ImageTypeAbsoluteURL:
-			image = fmt.Sprintf("![icon](%s) ", b.Image)
-		}
+		image := p.getBadgeImageText(&b.Badge)
 
 		dmPost := &model.Post{}
 		dmText := fmt.Sprintf("@%s granted you the %s`%s` badge.", granterUser.Username, image, b.Name)
@@ -180,7 +131,12 @@ func (p *Plugin) notifyGrant(badgeID badgesmodel.BadgeID, granter string, grante
 			Text:  text,
 		}
 		model.ParseSlackAttachment(&basePost, []*model.SlackAttachment{&attachment})
+		// Grants of scoped types are not posted outside of their scope.
+		t, errType := p.store.GetType(b.Type)
 		for _, sub := range subs {
+			if errType != nil || !p.isChannelInTypeScope(sub, t) {
+				continue
+			}
 			post := basePost.Clone()
 			post.ChannelId = sub
 			err := p.mm.Post.CreatePost(post)
@@ -203,6 +159,95 @@ func (p *Plugin) notifyGrant(badgeID badgesmodel.BadgeID, granter string, grante
 	}
 }
 
+func (p *Plugin) notifyRevoke(badgeID badgesmodel.BadgeID, revoker string, revoked *model.User, reason string) {
+	b, err := p.store.GetBadge(badgeID)
+	if err != nil {
+		p.mm.Log.Debug("badge error", "err", err)
+		return
+	}
+
+	revokerUser, err := p.mm.User.Get(revoker)
+	if err != nil {
+		p.mm.Log.Debug("user error", "err", err)
+		return
+	}
+
+	image := p.getBadgeImageText(b)
+
+	dmPost := &model.Post{}
+	dmText := fmt.Sprintf("@%s revoked your %s`%s` badge.", revokerUser.Username, image, b.Name)
+	if reason != "" {
+		dmText += "\nWhy? " + reason
+	}
+	dmAttachment := model.SlackAttachment{
+		Title: fmt.Sprintf("%sbadge revoked", image),
+		Text:  dmText,
+	}
+	model.ParseSlackAttachment(dmPost, []*model.SlackAttachment{&dmAttachment})
+	err = p.mm.Post.DM(p.BotUserID, revoked.Id, dmPost)
+	if err != nil {
+		p.mm.Log.Debug("dm error", "err", err)
+	}
+}
+
+func (p *Plugin) notifyRenew(renewed *badgesmodel.Ownership, renewer string) {
+	b, err := p.store.GetBadge(renewed.Badge)
+	if err != nil {
+		p.mm.Log.Debug("badge error", "err", err)
+		return
+	}
+
+	renewerUser, err := p.mm.User.Get(renewer)
+	if err != nil {
+		p.mm.Log.Debug("user error", "err", err)
+		return
+	}
+
+	image := p.getBadgeImageText(b)
+	dmPost := &model.Post{}
+	dmText := fmt.Sprintf("@%s renewed your %s`%s` badge. It now expires on %s.", renewerUser.Username, image, b.Name, renewed.ExpiresAt.Format(ExpiryDateFormat))
+	dmAttachment := model.SlackAttachment{
+		Title: fmt.Sprintf("%sbadge renewed!", image),
+		Text:  dmText,
+	}
+	model.ParseSlackAttachment(dmPost, []*model.SlackAttachment{&dmAttachment})
+	err = p.mm.Post.DM(p.BotUserID, renewed.User, dmPost)
+	if err != nil {
+		p.mm.Log.Debug("dm error", "err", err)
+	}
+}
+
+// getBadgeImageText renders the badge image in front of its name on posts.
+func (p *Plugin) getBadgeImageText(b *badgesmodel.Badge) string {
+	if t, err := p.store.GetType(b.Type); err == nil && t.Frame != "" && canFrame(b) {
+		return fmt.Sprintf("![icon](%s%s =16x16) ", p.getPluginURL(), getFramedImagePath(b, t.Frame))
+	}
+
+	switch b.ImageType {
+	case badgesmodel.ImageTypeEmoji:
+		return fmt.Sprintf(":%s: ", b.Image)
+	case badgesmodel.ImageTypeAbsoluteURL:
+		return fmt.Sprintf("![icon](%s) ", b.Image)
+	case badgesmodel.ImageTypeUpload:
+		return fmt.Sprintf("![icon](%s%s =16x16) ", p.getPluginURL(), getBadgeImagePath(b))
+	}
+	return ""
+}
+
+func getValidityDaysString(days int) string {
+	if days <= 0 {
+		return ""
+	}
+	return strconv.Itoa(days)
+}
+
+func getPointsString(points *int) string {
+	if points == nil {
+		return ""
+	}
+	return strconv.Itoa(*points)
+}
+
 func getBooleanString(in bool) string {
 	if in {
 		return TrueString
@@ -121,6 +121,9 @@ class BadgeDetailsComponent extends React.PureComponent<Props, State> {
                         <div className='badge-name'>{badge.name}</div>
                         <div className='badge-description'>{markdown(badge.description)}</div>
                         <div className='badge-type'>{'Type: ' + badge.type_name}</div>
+                        {badge.tags && badge.tags.length > 0 && (
+                            <div className='badge-tags'>{badge.tags.map((tag) => '#' + tag).join(' ')}</div>
+                        )}
                         <div className='created-by'>{`Created by: ${badge.created_by_username}`}</div>
                     </div>
                 </div>
@@ -5,6 +5,7 @@ import Client4 from 'mattermost-redux/client/client4';
 import {UserBadge} from '../../types/badges';
 import BadgeImage from '../utils/badge_image';
 import {markdown} from 'utils/markdown';
+import {expiryText, isExpired} from 'utils/badges';
 
 import './user_badge_row.scss';
 
@@ -20,8 +21,14 @@ const UserBadgeRow: React.FC<Props> = ({badge, onClick, isCurrentUser}: Props) =
     if (badge.reason) {
         reason = (<div className='badge-user-reason'>{'Why? ' + badge.reason}</div>);
     }
+    let expiry = null;
+    const expiryLabel = expiryText(badge);
+    if (expiryLabel) {
+        expiry = (<div className='user-badge-expiry'>{expiryLabel}</div>);
+    }
+    const expired = isExpired(badge);
     let setStatus = null;
-    if (isCurrentUser && badge.image_type === 'emoji') {
+    if (isCurrentUser && badge.image_type === 'emoji' && !expired) {
         setStatus = (
             <div className='user-badge-set-status'>
                 <a
@@ -36,7 +43,7 @@ const UserBadgeRow: React.FC<Props> = ({badge, onClick, isCurrentUser}: Props) =
         );
     }
     return (
-        <div className='UserBadgesRow'>
+        <div className={expired ? 'UserBadgesRow expired' : 'UserBadgesRow'}>
             <a onClick={() => onClick(badge)}>
                 <span className='user-badge-icon'>
                     <BadgeImage
@@ -52,6 +59,7 @@ const UserBadgeRow: React.FC<Props> = ({badge, onClick, isCurrentUser}: Props) =
                 <div className='user-badge-type'>{'Type: ' + badge.type_name}</div>
                 <div className='user-badge-granted-by'>{`Granted by: ${badge.granted_by_name}`}</div>
                 <div className='user-badge-granted-at'>{`Granted at: ${time.toDateString()}`}</div>
+                {expiry}
                 {setStatus}
             </div>
         </div>
@@ -13,6 +13,7 @@ import BadgeImage from '../utils/badge_image';
 import {RHSState} from 'types/general';
 import {IMAGE_TYPE_EMOJI, RHS_STATE_DETAIL, RHS_STATE_MY, RHS_STATE_OTHER} from '../../constants';
 import {markdown} from 'utils/markdown';
+import {expiryText, isExpired} from 'utils/badges';
 
 import './badge_list.scss';
 
@@ -115,6 +116,11 @@ class BadgeList extends React.PureComponent<Props, State> {
             if (badge.reason) {
                 reason = (<div>{'Why? ' + badge.reason}</div>);
             }
+            let expiry = null;
+            const expiryLabel = expiryText(badge);
+            if (expiryLabel) {
+                expiry = (<div>{expiryLabel}</div>);
+            }
             const badgeComponent = (
                 <OverlayTrigger
                     overlay={<Tooltip id='badgeTooltip'>
@@ -123,9 +129,10 @@ class BadgeList extends React.PureComponent<Props, State> {
                         {reason}
                         <div>{`Granted by: ${badge.granted_by_name}`}</div>
                         <div>{`Granted at: ${time.toDateString()}`}</div>
+                        {expiry}
                     </Tooltip>}
                 >
-                    <span>
+                    <span className={isExpired(badge) ? 'expiredBadge' : undefined}>
                         <a onClick={() => this.onBadgeClick(badge)}>
                             <BadgeImage
                                 badge={badge}
@@ -2,7 +2,7 @@ import React from 'react';
 
 import {Badge} from '../../types/badges';
 import RenderEmoji from '../utils/emoji';
-import {IMAGE_TYPE_ABSOLUTE_URL, IMAGE_TYPE_EMOJI} from '../../constants';
+import {IMAGE_TYPE_ABSOLUTE_URL, IMAGE_TYPE_EMOJI, IMAGE_TYPE_RELATIVE_URL} from '../../constants';
 
 type Props = {
     badge: Badge;
@@ -19,6 +19,7 @@ const BadgeImage: React.FC<Props> = ({badge, size}: Props) => {
             />
         );
     case IMAGE_TYPE_ABSOLUTE_URL:
+    case IMAGE_TYPE_RELATIVE_URL:
         return (
             <img
                 src={badge.image}
@@ -7,6 +7,14 @@ export type Badge = {
     multiple: boolean;
     type: BadgeType;
     created_by: string;
+    archived: boolean;
+    version: number;
+    updated_by: string;
+    updated_at: number;
+    validity_days?: number;
+    tags?: string[];
+    points?: number;
+    co_owners?: string[];
 }
 
 export type Ownership = {
@@ -15,6 +23,9 @@ export type Ownership = {
     badge: BadgeID;
     reason: string;
     time: number;
+    badge_version?: number;
+    grant_id: string;
+    expires_at?: string;
 }
 
 export type BadgeID = number;
@@ -42,4 +53,9 @@ export type BadgeTypeDefinition = {
     id: BadgeType;
     name: string;
     frame: string;
+    archived: boolean;
+    default_points?: number;
+    announceable?: boolean;
+    team_id?: string;
+    channel_id?: string;
 }
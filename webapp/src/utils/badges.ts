@@ -0,0 +1,17 @@
+import {Ownership} from 'types/badges';
+
+export function isExpired(ownership: Ownership): boolean {
+    return Boolean(ownership.expires_at) && new Date(ownership.expires_at!).getTime() <= Date.now();
+}
+
+export function expiryText(ownership: Ownership): string | null {
+    if (!ownership.expires_at) {
+        return null;
+    }
+
+    const expiresAt = new Date(ownership.expires_at);
+    if (isExpired(ownership)) {
+        return `Expired on: ${expiresAt.toDateString()}`;
+    }
+    return `Expires on: ${expiresAt.toDateString()}`;
+}